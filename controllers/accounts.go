@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/database"
+	"github.com/grey/middlewares"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/grey/utils"
+)
+
+func (repository *PaymentGroup) OpenAccount(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	var form structs.OpenAccountRequest
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		utils.ErrorResponse(c, "Please provide the currency of the account.")
+		return
+	}
+
+	user, err := service.Caller(c.Request.Context(), database.Db, JwtSessionPayload.UserID)
+	if err != nil {
+		authorizationError(c, err)
+		return
+	}
+
+	account, err := service.OpenAccount(c.Request.Context(), database.Db, user.ID, form.Currency)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrUnsupportedCurrency):
+			utils.ErrorResponse(c, "This currency is not supported")
+		case errors.Is(err, service.ErrAccountExists):
+			c.JSON(http.StatusConflict, gin.H{
+				"message": "You already have an account in this currency",
+				"status":  http.StatusConflict,
+			})
+		default:
+			utils.ErrorResponse(c, "error creating account")
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "success",
+		"data":    account,
+		"status":  http.StatusCreated,
+	})
+}
+
+func (repository *PaymentGroup) ListAccounts(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	user, err := service.Caller(c.Request.Context(), database.Db, JwtSessionPayload.UserID)
+	if err != nil {
+		authorizationError(c, err)
+		return
+	}
+
+	accounts, err := models.UserAccounts(c.Request.Context(), database.Db, user.ID)
+	if err != nil {
+		utils.ErrorResponse(c, "We couldn't retrieve your accounts at this time. Please try again later.")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"data":    accounts,
+	})
+}
+
+func (repository *PaymentGroup) Currencies(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"data":    models.SupportedCurrencies(),
+	})
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/database"
+	"github.com/grey/middlewares"
+	"github.com/grey/models"
+	"github.com/grey/service"
+)
+
+// authorizeAccount writes the error response and returns false unless the
+// account exists and belongs to the session user.
+func authorizeAccount(c *gin.Context, session middlewares.JwtSessionPayload, accountID string) (*models.Account, bool) {
+	account, err := service.AuthorizeAccount(c.Request.Context(), database.Db, session.UserID, accountID)
+	if errors.Is(err, service.ErrAccountNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Account not found",
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	if err != nil {
+		authorizationError(c, err)
+		return nil, false
+	}
+	return account, true
+}
+
+// ownedAccount is authorizeAccount for the account in the path of read-only
+// endpoints. Accounts of other users are reported as missing so their ids
+// cannot be probed.
+func ownedAccount(c *gin.Context, session middlewares.JwtSessionPayload) (models.Account, bool) {
+	account, err := service.AuthorizeAccount(c.Request.Context(), database.Db, session.UserID, c.Param("account_id"))
+	if errors.Is(err, service.ErrNotAccountOwner) {
+		err = service.ErrAccountNotFound
+	}
+	if err != nil {
+		authorizationError(c, err)
+		return models.Account{}, false
+	}
+	return *account, true
+}
+
+func authorizationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrUnknownCaller):
+		c.AbortWithStatus(http.StatusUnauthorized)
+	case errors.Is(err, service.ErrAccountNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Account not found",
+			"status":  http.StatusNotFound,
+		})
+	case errors.Is(err, service.ErrPaymentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Payment not found",
+			"status":  http.StatusNotFound,
+		})
+	case errors.Is(err, service.ErrNotAccountOwner):
+		c.JSON(http.StatusForbidden, gin.H{
+			"message": "You are not allowed to use this account",
+			"status":  http.StatusForbidden,
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to authorize request",
+			"error":   err.Error(),
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/utils"
+	"github.com/shopspring/decimal"
+)
+
+func (repository *PaymentGroup) PreviewFee(c *gin.Context) {
+	_, exists := c.Get("x-claim-payload")
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	transactionType := models.TransactionType(c.Query("transaction_type"))
+	switch transactionType {
+	case models.InternalTransfer, models.BankTransfer, models.MobileMoney, models.TopUpTransaction:
+	default:
+		utils.ErrorResponse(c, "transaction_type must be INTERNAL, BANK_TRANSFER, MOBILE_MONEY or TOPUP")
+		return
+	}
+
+	amount, err := decimal.NewFromString(c.Query("amount"))
+	if err != nil {
+		utils.ErrorResponse(c, "amount must be a number")
+		return
+	}
+
+	quote, err := service.PreviewFee(transactionType, amount, c.DefaultQuery("currency", "USD"))
+	if errors.Is(err, service.ErrInvalidAmount) {
+		utils.ErrorResponse(c, "amount must be greater than zero")
+		return
+	}
+	if errors.Is(err, models.ErrUnsupportedCurrency) {
+		utils.ErrorResponse(c, "This currency is not supported")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(c, "We couldn't price this payment at this time. Please try again later.")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"data":    quote,
+	})
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/database"
+	"github.com/grey/fx"
+	"github.com/grey/middlewares"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/grey/utils"
+	"github.com/shopspring/decimal"
+)
+
+func (repository *PaymentGroup) CreateFXQuote(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	var form structs.FXQuoteRequest
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		utils.ErrorResponse(c, "Please check your conversion details and ensure all required fields are filled correctly.")
+		return
+	}
+
+	fromID, ok := authorizeAccount(c, JwtSessionPayload, form.FromAccount)
+	if !ok {
+		return
+	}
+
+	quote, err := service.QuoteFX(c.Request.Context(), database.Db, fx.Default, fromID.UserID, fromID.AccountID, form.ToAccount, decimal.NewFromFloat(form.Amount))
+	if err != nil {
+		switch {
+		case errors.Is(err, fx.ErrRateUnavailable):
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"message": "No exchange rate is available for this currency pair right now",
+				"status":  http.StatusServiceUnavailable,
+			})
+		case errors.Is(err, service.ErrSameCurrency):
+			utils.ErrorResponse(c, "Both accounts hold the same currency, no conversion is needed")
+		case errors.Is(err, service.ErrAccountNotFound):
+			utils.ErrorResponse(c, "Account does not exist")
+		case errors.Is(err, service.ErrInvalidAmount), errors.Is(err, models.ErrUnsupportedCurrency):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid amount",
+				"error":   err.Error(),
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to create quote",
+				"error":   err.Error(),
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "success",
+		"data":    quote,
+		"status":  http.StatusCreated,
+	})
+}
+
+// fxInternalPayment is the InternalPayment path for a transfer that converts
+// currencies at the rate of a quote.
+func fxInternalPayment(c *gin.Context, session middlewares.JwtSessionPayload, fromID *models.Account, form structs.InternalPaymentRequest) {
+	// the amount of a conversion is the one locked in the quote
+	quote, err := models.FindFXQuote(c.Request.Context(), database.Db, form.QuoteID)
+	if err == nil && !stepUp(c, session, quote.FromCurrency, quote.FromAmount) {
+		return
+	}
+
+	payment, quote, err := service.ProcessFXInternalPayment(c.Request.Context(), database.Db, fromID.UserID, form.QuoteID, fromID.AccountID, form.ToAccount)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrQuoteNotFound), errors.Is(err, service.ErrQuoteMismatch):
+			utils.ErrorResponse(c, "The quote does not exist or was issued for other accounts")
+		case errors.Is(err, models.ErrFXQuoteUnavailable):
+			c.JSON(http.StatusConflict, gin.H{
+				"message": "The quote has expired or was already used, please request a new one",
+				"status":  http.StatusConflict,
+			})
+		case errors.Is(err, service.ErrInsufficientBalance):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Insufficient balance",
+				"status":  http.StatusBadRequest,
+			})
+		case isCurrencyError(err):
+			currencyErrorResponse(c, err)
+		case isLimitError(err):
+			limitErrorResponse(c, err)
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to process payment",
+				"error":   err.Error(),
+			})
+		}
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"response": payment,
+		"quote":    quote,
+		"message":  "Payment created successfully",
+	})
+}
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/database"
+	"github.com/grey/middlewares"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/grey/utils"
+	"github.com/shopspring/decimal"
+)
+
+func (repository *PaymentGroup) PlaceHold(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	var form structs.HoldRequest
+	err := c.ShouldBindJSON(&form)
+	if err != nil || form.ExpiresIn < 0 {
+		utils.ErrorResponse(c, "Please check your hold details and ensure all required fields are filled correctly.")
+		return
+	}
+
+	account, ok := authorizeAccount(c, JwtSessionPayload, form.Account)
+	if !ok {
+		return
+	}
+	if !confirmPIN(c, JwtSessionPayload) {
+		return
+	}
+	if form.Currency == "" {
+		form.Currency = account.Currency
+	}
+	if !stepUp(c, JwtSessionPayload, form.Currency, decimal.NewFromFloat(form.Amount)) {
+		return
+	}
+
+	ttl := time.Duration(form.ExpiresIn) * time.Second
+	hold, err := service.PlaceHold(c.Request.Context(), database.Db, account.AccountID, form.PayeeAccount, decimal.NewFromFloat(form.Amount), form.Currency, ttl, form.Description)
+	if err != nil {
+		holdError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Hold placed successfully",
+		"data":    hold,
+		"status":  http.StatusCreated,
+	})
+}
+
+func (repository *PaymentGroup) GetHold(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	hold, err := service.AuthorizeHold(c.Request.Context(), database.Db, JwtSessionPayload.UserID, c.Param("hold_id"), false)
+	if errors.Is(err, service.ErrNotAccountOwner) {
+		// holds of other users are reported as missing so their ids cannot be probed
+		err = service.ErrHoldNotFound
+	}
+	if err != nil {
+		holdError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"data":    hold,
+		"status":  http.StatusOK,
+	})
+}
+
+func (repository *PaymentGroup) CaptureHold(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	var form structs.CaptureHoldRequest
+	// an empty body captures the whole hold
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&form); err != nil {
+			utils.ErrorResponse(c, "Please check your capture details and ensure all fields are filled correctly.")
+			return
+		}
+	}
+
+	hold, err := service.AuthorizeHold(c.Request.Context(), database.Db, JwtSessionPayload.UserID, c.Param("hold_id"), true)
+	if err != nil {
+		holdError(c, err)
+		return
+	}
+	if !confirmPIN(c, JwtSessionPayload) {
+		return
+	}
+	amount := decimal.NewFromFloat(form.Amount)
+	if amount.IsZero() {
+		amount = hold.Amount
+	}
+	if !stepUp(c, JwtSessionPayload, hold.Currency, amount) {
+		return
+	}
+
+	payment, hold, err := service.CaptureHold(c.Request.Context(), database.Db, c.Param("hold_id"), decimal.NewFromFloat(form.Amount))
+	if err != nil {
+		holdError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": payment,
+		"hold":     hold,
+		"message":  "Hold captured successfully",
+	})
+}
+
+func (repository *PaymentGroup) VoidHold(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	_, err := service.AuthorizeHold(c.Request.Context(), database.Db, JwtSessionPayload.UserID, c.Param("hold_id"), false)
+	if err != nil {
+		holdError(c, err)
+		return
+	}
+
+	hold, err := service.VoidHold(c.Request.Context(), database.Db, c.Param("hold_id"))
+	if err != nil {
+		holdError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Hold voided successfully",
+		"data":    hold,
+		"status":  http.StatusOK,
+	})
+}
+
+func holdError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrHoldNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Hold not found",
+			"status":  http.StatusNotFound,
+		})
+	case errors.Is(err, models.ErrHoldNotActive), errors.Is(err, service.ErrHoldExpired):
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "The hold was already captured, voided or has expired",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, service.ErrInsufficientBalance):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Insufficient balance",
+			"status":  http.StatusBadRequest,
+		})
+	case errors.Is(err, service.ErrCaptureExceedsHold), errors.Is(err, service.ErrInvalidAmount):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid amount",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, service.ErrAccountNotFound):
+		utils.ErrorResponse(c, "Account does not exist")
+	case isCurrencyError(err):
+		currencyErrorResponse(c, err)
+	case isLimitError(err):
+		limitErrorResponse(c, err)
+	case errors.Is(err, service.ErrUnknownCaller), errors.Is(err, service.ErrNotAccountOwner):
+		authorizationError(c, err)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to process hold",
+			"error":   err.Error(),
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/keys"
+)
+
+// JWKS publishes the public keys session tokens are verified with, so other
+// services can check tokens without sharing a secret. Verifiers may cache the
+// set for a few minutes; new keys are published before they start signing.
+func (repository *UserGroup) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, keys.Default.JWKS())
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/database"
+	"github.com/grey/middlewares"
+	"github.com/grey/service"
+)
+
+// RemainingLimits shows the limits of the user's KYC tier and what is left of
+// them today and this month.
+func (repository *PaymentGroup) RemainingLimits(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	user, err := service.Caller(c.Request.Context(), database.Db, JwtSessionPayload.UserID)
+	if err != nil {
+		authorizationError(c, err)
+		return
+	}
+
+	statuses, err := service.RemainingLimits(c.Request.Context(), database.Db, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to load limits",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"data": gin.H{
+			"kyc_tier": user.KYCTier,
+			"limits":   statuses,
+		},
+		"status": http.StatusOK,
+	})
+}
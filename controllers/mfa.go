@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/database"
+	"github.com/grey/middlewares"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/grey/utils"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// StepUpHeader carries the verification code of a payment above the step-up
+// amount.
+const StepUpHeader = "X-MFA-Code"
+
+// EnrollMFA starts authenticator enrollment and returns the secret to scan.
+func (repository *UserGroup) EnrollMFA(c *gin.Context) {
+	user, ok := sessionUser(c)
+	if !ok {
+		return
+	}
+
+	enrollment, err := service.EnrollTOTP(c.Request.Context(), database.Db, user)
+	if err != nil {
+		mfaError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Scan the code with your authenticator app, then confirm with the code it shows",
+		"data":    enrollment,
+	})
+}
+
+// ConfirmMFA turns two-factor authentication on and returns the recovery
+// codes, which are never shown again.
+func (repository *UserGroup) ConfirmMFA(c *gin.Context) {
+	var form structs.MFACodeRequest
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		utils.ErrorResponse(c, "Provide the code from your authenticator app")
+		return
+	}
+	user, ok := sessionUser(c)
+	if !ok {
+		return
+	}
+
+	codes, err := service.ConfirmTOTP(c.Request.Context(), database.Db, user, form.Code)
+	if err != nil {
+		mfaError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Two-factor authentication is enabled. Store the recovery codes somewhere safe.",
+		"data": gin.H{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// DisableMFA turns two-factor authentication off after checking a code.
+func (repository *UserGroup) DisableMFA(c *gin.Context) {
+	var form structs.MFACodeRequest
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		utils.ErrorResponse(c, "Provide a code from your authenticator app or a recovery code")
+		return
+	}
+	user, ok := sessionUser(c)
+	if !ok {
+		return
+	}
+
+	err = service.DisableTOTP(c.Request.Context(), database.Db, user, form.Code)
+	if err != nil {
+		mfaError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Two-factor authentication is disabled",
+		"status":  http.StatusOK,
+	})
+}
+
+// VerifyLogin completes a login that needs a second factor. The mfa_pending
+// token works once: it is revoked when the code is accepted, or when too many
+// wrong codes were tried.
+func (repository *UserGroup) VerifyLogin(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	var form structs.MFACodeRequest
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		utils.ErrorResponse(c, "Provide a code from your authenticator app or a recovery code")
+		return
+	}
+
+	user, err := service.Caller(c.Request.Context(), database.Db, JwtSessionPayload.UserID)
+	if err != nil {
+		authorizationError(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	expiresAt := time.Unix(int64(JwtSessionPayload.Exp), 0)
+	err = service.VerifyMFA(ctx, database.Db, user, form.Code)
+	if errors.Is(err, service.ErrTooManyMFAAttempts) {
+		if err := models.RevokeToken(ctx, database.Db, JwtSessionPayload.JTI, expiresAt); err != nil {
+			logrus.WithField("user_id", user.UserId).WithError(err).Error("Error revoking mfa token")
+		}
+	}
+	if err != nil {
+		mfaError(c, err)
+		return
+	}
+
+	err = models.RevokeToken(ctx, database.Db, JwtSessionPayload.JTI, expiresAt)
+	if err != nil {
+		utils.ErrorResponse(c, "We couldn't log you in at this time. Please try again later.")
+		return
+	}
+	tokens, err := service.StartSession(ctx, database.Db, user)
+	if err != nil {
+		utils.ErrorResponse(c, "We couldn't log you in at this time. Please try again later.")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Welcome back! You have successfully logged in.",
+		"data":    tokens,
+	})
+}
+
+// stepUp writes the error response and returns false when the payment needs
+// a verification code in the StepUpHeader and did not carry a valid one.
+func stepUp(c *gin.Context, session middlewares.JwtSessionPayload, currency string, amount decimal.Decimal) bool {
+	err := service.StepUp(c.Request.Context(), database.Db, session.UserID, currency, amount, c.GetHeader(StepUpHeader))
+	if err != nil {
+		mfaError(c, err)
+		return false
+	}
+	return true
+}
+
+// sessionUser loads the user of the session token.
+func sessionUser(c *gin.Context) (*models.User, bool) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return nil, false
+	}
+
+	user, err := service.Caller(c.Request.Context(), database.Db, JwtSessionPayload.UserID)
+	if err != nil {
+		authorizationError(c, err)
+		return nil, false
+	}
+	return user, true
+}
+
+func mfaError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidMFACode):
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "The verification code is invalid or was already used",
+			"status":  http.StatusUnauthorized,
+		})
+	case errors.Is(err, service.ErrTooManyMFAAttempts):
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"message": "Too many invalid codes. Please try again later.",
+			"status":  http.StatusTooManyRequests,
+		})
+	case errors.Is(err, service.ErrStepUpRequired):
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Enter the code from your authenticator app in the " + StepUpHeader + " header to approve this payment",
+			"code":    "MFA_REQUIRED",
+			"status":  http.StatusUnauthorized,
+		})
+	case errors.Is(err, service.ErrMFAEnrollmentRequired):
+		c.JSON(http.StatusForbidden, gin.H{
+			"message": "Enable two-factor authentication to make payments of this amount",
+			"code":    "MFA_ENROLLMENT_REQUIRED",
+			"status":  http.StatusForbidden,
+		})
+	case errors.Is(err, service.ErrMFAAlreadyEnabled), errors.Is(err, service.ErrMFANotEnrolled), errors.Is(err, service.ErrMFANotEnabled):
+		c.JSON(http.StatusConflict, gin.H{
+			"message": err.Error(),
+			"status":  http.StatusConflict,
+		})
+	case errors.Is(err, service.ErrUnknownCaller):
+		authorizationError(c, err)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to verify the code",
+			"error":   err.Error(),
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/database"
+	"github.com/grey/middlewares"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/grey/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// ChangePassword replaces the password of the logged in user and logs out
+// their other sessions.
+func (repository *UserGroup) ChangePassword(c *gin.Context) {
+	var form structs.ChangePasswordRequest
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		utils.ErrorResponse(c, "Provide your current password and the new password")
+		return
+	}
+	claimPayload, _ := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	user, ok := sessionUser(c)
+	if !ok {
+		return
+	}
+
+	err = service.ChangePassword(c.Request.Context(), database.Db, user, form.CurrentPassword, form.Password, JwtSessionPayload.SessionID, service.LoginClient{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+	if err != nil {
+		passwordError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Your password was changed. Your other sessions have been logged out.",
+		"status":  http.StatusOK,
+	})
+}
+
+// ForgotPassword emails a password reset token. The answer is the same
+// whether or not the email is registered, the request is over the rate limit
+// or the email could not be sent.
+func (repository *UserGroup) ForgotPassword(c *gin.Context) {
+	var form structs.ForgotPasswordRequest
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		utils.ErrorResponse(c, "Provide the email of your account")
+		return
+	}
+
+	err = service.RequestPasswordReset(c.Request.Context(), database.Db, form.Email, c.ClientIP())
+	if err != nil {
+		logrus.WithField("email", form.Email).WithError(err).Error("Error requesting password reset")
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "If an account uses that email, we have sent it a link to reset the password.",
+		"status":  http.StatusOK,
+	})
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword and
+// logs out every session of the user.
+func (repository *UserGroup) ResetPassword(c *gin.Context) {
+	var form structs.ResetPasswordRequest
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		utils.ErrorResponse(c, "Provide the reset token and the new password")
+		return
+	}
+
+	err = service.ResetPassword(c.Request.Context(), database.Db, form.Token, form.Password)
+	if err != nil {
+		passwordError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Your password was reset. Please log in with the new password.",
+		"status":  http.StatusOK,
+	})
+}
+
+func passwordError(c *gin.Context, err error) {
+	var throttled *service.ThrottleError
+	switch {
+	case errors.As(err, &throttled):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"message": "Too many failed attempts. Please try again later.",
+			"status":  http.StatusTooManyRequests,
+		})
+	case errors.Is(err, service.ErrWrongPassword):
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid password",
+			"status":  http.StatusUnauthorized,
+		})
+	case errors.Is(err, service.ErrInvalidResetToken):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "The reset link is invalid or has expired. Please request a new one.",
+			"status":  http.StatusBadRequest,
+		})
+	case errors.Is(err, service.ErrWeakPassword):
+		utils.ErrorResponse(c, err.Error())
+	case errors.Is(err, service.ErrUnknownCaller):
+		authorizationError(c, err)
+	default:
+		logrus.WithError(err).Error("Error updating password")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "We couldn't update your password at this time. Please try again later.",
+			"status":  http.StatusInternalServerError,
+		})
+	}
+}
@@ -1,12 +1,15 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/grey/database"
+	"github.com/grey/limits"
 	"github.com/grey/middlewares"
 	"github.com/grey/models"
+	"github.com/grey/providers"
 	"github.com/grey/service"
 	"github.com/grey/structs"
 	"github.com/grey/utils"
@@ -18,7 +21,7 @@ type PaymentGroup struct{}
 func (repository *PaymentGroup) InternalPayment(c *gin.Context) {
 
 	claimPayload, exists := c.Get("x-claim-payload")
-	_, _ = claimPayload.(middlewares.JwtSessionPayload)
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
 	if !exists {
 		c.AbortWithStatus(401)
 		return
@@ -31,11 +34,24 @@ func (repository *PaymentGroup) InternalPayment(c *gin.Context) {
 		return
 	}
 
-	fromID, err := models.IsAccountExists(c.Request.Context(), database.Db, form.FromAccount)
-	if err != nil {
+	fromID, err := service.AuthorizeAccount(c.Request.Context(), database.Db, JwtSessionPayload.UserID, form.FromAccount)
+	if errors.Is(err, service.ErrAccountNotFound) {
 		utils.ErrorResponse(c, "Account does not exist")
 		return
 	}
+	if err != nil {
+		authorizationError(c, err)
+		return
+	}
+
+	if !confirmPIN(c, JwtSessionPayload) {
+		return
+	}
+
+	if form.QuoteID != "" {
+		fxInternalPayment(c, JwtSessionPayload, fromID, form)
+		return
+	}
 
 	proceesedAmount := decimal.NewFromFloat(form.Amount)
 	if fromID.Balance.Cmp(proceesedAmount) < 0 {
@@ -53,13 +69,26 @@ func (repository *PaymentGroup) InternalPayment(c *gin.Context) {
 	}
 
 	processedAmount := decimal.NewFromFloat(form.Amount)
+	if !stepUp(c, JwtSessionPayload, form.Currency, processedAmount) {
+		return
+	}
+
 	response, err := service.ProcessInternalPayment(c.Request.Context(), database.Db, fromID.AccountID, toID.AccountID, processedAmount, form.Currency)
 	if err != nil {
-		if err.Error() == "invalid amount" {
+		if errors.Is(err, service.ErrInvalidAmount) {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"message": "Invalid amount",
 				"error":   err.Error(),
 			})
+		} else if errors.Is(err, service.ErrInsufficientBalance) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Insufficient balance",
+				"status":  http.StatusBadRequest,
+			})
+		} else if isCurrencyError(err) {
+			currencyErrorResponse(c, err)
+		} else if isLimitError(err) {
+			limitErrorResponse(c, err)
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "Failed to process payment",
@@ -77,7 +106,7 @@ func (repository *PaymentGroup) InternalPayment(c *gin.Context) {
 
 func (repository *PaymentGroup) ExternalPayment(c *gin.Context) {
 	claimPayload, exists := c.Get("x-claim-payload")
-	_, _ = claimPayload.(middlewares.JwtSessionPayload)
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
 	if !exists {
 		c.AbortWithStatus(401)
 		return
@@ -90,12 +119,14 @@ func (repository *PaymentGroup) ExternalPayment(c *gin.Context) {
 		return
 	}
 
-	fromID, err := models.IsAccountExists(c.Request.Context(), database.Db, form.Account)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "Account not found",
-			"error":   err.Error(),
-		})
+	fromID, ok := authorizeAccount(c, JwtSessionPayload, form.Account)
+	if !ok {
+		return
+	}
+	if !requireVerifiedEmail(c, JwtSessionPayload) {
+		return
+	}
+	if !confirmPIN(c, JwtSessionPayload) {
 		return
 	}
 
@@ -108,46 +139,59 @@ func (repository *PaymentGroup) ExternalPayment(c *gin.Context) {
 		return
 	}
 
-	switch form.TransactionType {
-	case "BANK_TRANSFER":
-		response, err := service.ProcessExternalPayment(c.Request.Context(), database.Db, form.Recipient, fromID.AccountID, decimal.NewFromFloat(form.Amount), form.Currency)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Failed to process payment",
-				"error":   err.Error(),
-			})
-			return
-		}
+	if !stepUp(c, JwtSessionPayload, form.Currency, proceesedAmount) {
+		return
+	}
 
-		c.JSON(200, gin.H{
-			"response": response,
-			"message":  "Payment created successfully",
+	transactionType := models.TransactionType(form.TransactionType)
+	provider, err := providers.Lookup(transactionType)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid transaction type support BANK_TRANSFER or MOBILE_MONEY",
 		})
-	case "MOBILE_MONEY":
-		response, err := service.ProcessExternalPayment(c.Request.Context(), database.Db, form.Recipient, fromID.AccountID, decimal.NewFromFloat(form.Amount), form.Currency)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Failed to process payment",
-				"error":   err.Error(),
-			})
-			return
-		}
+		return
+	}
 
-		c.JSON(200, gin.H{
-			"response": response,
-			"message":  "Payment created successfully",
+	response, err := service.ProcessExternalPayment(c.Request.Context(), database.Db, provider, transactionType, form.Recipient, fromID.AccountID, decimal.NewFromFloat(form.Amount), form.Currency)
+	if isCurrencyError(err) {
+		currencyErrorResponse(c, err)
+		return
+	}
+	if isLimitError(err) {
+		limitErrorResponse(c, err)
+		return
+	}
+	if errors.Is(err, service.ErrInsufficientBalance) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Insufficient balance",
+			"status":  http.StatusBadRequest,
 		})
-
-	default:
+		return
+	}
+	if errors.Is(err, service.ErrPayoutFailed) {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "Invalid transaction type support BANK_TRANSFER or MOBILE_MONEY",
+			"response": response,
+			"message":  "Payout was declined by the provider",
 		})
+		return
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to process payment",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"response": response,
+		"message":  "Payment created successfully",
+	})
 }
 
 func (repository *PaymentGroup) TopUp(c *gin.Context) {
 	claimPayload, exists := c.Get("x-claim-payload")
-	_, _ = claimPayload.(middlewares.JwtSessionPayload)
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
 	if !exists {
 		c.AbortWithStatus(401)
 		return
@@ -160,16 +204,30 @@ func (repository *PaymentGroup) TopUp(c *gin.Context) {
 		return
 	}
 
-	fromID, err := models.IsAccountExists(c.Request.Context(), database.Db, form.Account)
-	if err != nil {
+	fromID, ok := authorizeAccount(c, JwtSessionPayload, form.Account)
+	if !ok {
+		return
+	}
+	if !confirmPIN(c, JwtSessionPayload) {
+		return
+	}
+
+	response, err := service.TopUpProcess(c.Request.Context(), database.Db, fromID.AccountID, decimal.NewFromFloat(form.Amount), form.Currency)
+	if isCurrencyError(err) {
+		currencyErrorResponse(c, err)
+		return
+	}
+	if isLimitError(err) {
+		limitErrorResponse(c, err)
+		return
+	}
+	if errors.Is(err, service.ErrFeeExceedsAmount) {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "Account not found",
+			"message": "The amount does not cover the top-up fee",
 			"error":   err.Error(),
 		})
 		return
 	}
-
-	response, err := service.TopUpProcess(c.Request.Context(), database.Db, fromID.AccountID, decimal.NewFromFloat(form.Amount), form.Currency)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Failed to process payment",
@@ -184,3 +242,92 @@ func (repository *PaymentGroup) TopUp(c *gin.Context) {
 	})
 
 }
+
+func (repository *PaymentGroup) Refund(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	var form structs.RefundRequest
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		utils.ErrorResponse(c, "Please check your refund details and ensure all fields are filled correctly.")
+		return
+	}
+
+	err = service.AuthorizeRefund(c.Request.Context(), database.Db, JwtSessionPayload.UserID, c.Param("id"))
+	if err != nil {
+		authorizationError(c, err)
+		return
+	}
+
+	refund, err := service.RefundPayment(c.Request.Context(), database.Db, c.Param("id"), decimal.NewFromFloat(form.Amount), form.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrPaymentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Payment not found",
+				"status":  http.StatusNotFound,
+			})
+		case errors.Is(err, service.ErrInvalidAmount), errors.Is(err, service.ErrRefundExceedsAmount), errors.Is(err, service.ErrNotRefundable):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Refund could not be processed",
+				"error":   err.Error(),
+			})
+		case errors.Is(err, service.ErrInsufficientBalance):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Insufficient balance",
+				"status":  http.StatusBadRequest,
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to process refund",
+				"error":   err.Error(),
+			})
+		}
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"response": refund,
+		"message":  "Refund processed successfully",
+	})
+}
+
+func isCurrencyError(err error) bool {
+	return errors.Is(err, service.ErrCurrencyMismatch) || errors.Is(err, models.ErrUnsupportedCurrency)
+}
+
+func currencyErrorResponse(c *gin.Context, err error) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"message": "The payment currency must match the account currency",
+		"error":   err.Error(),
+	})
+}
+
+func isLimitError(err error) bool {
+	return errors.Is(err, limits.ErrLimitExceeded)
+}
+
+// limitErrorResponse reports the limit that stopped the payment by its code.
+// Velocity limits pass with time, so they answer 429.
+func limitErrorResponse(c *gin.Context, err error) {
+	status := http.StatusUnprocessableEntity
+	response := gin.H{
+		"message": "Transaction limit exceeded",
+		"error":   err.Error(),
+	}
+	var limitErr *limits.Error
+	if errors.As(err, &limitErr) {
+		response["code"] = limitErr.Code
+		if limitErr.Code == limits.VelocityCode {
+			status = http.StatusTooManyRequests
+		} else {
+			response["remaining"] = limitErr.Remaining
+		}
+	}
+	c.JSON(status, response)
+}
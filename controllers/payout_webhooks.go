@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/database"
+	"github.com/grey/providers"
+	"github.com/grey/service"
+	"github.com/grey/utils"
+)
+
+// ProviderWebhook receives payout status callbacks. Providers authenticate
+// with the signature checked by their ParseWebhook, not with a session token.
+func (repository *PaymentGroup) ProviderWebhook(c *gin.Context) {
+	provider, err := providers.LookupByName(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Unknown payout provider",
+			"status":  http.StatusNotFound,
+		})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		utils.ErrorResponse(c, "Could not read webhook body")
+		return
+	}
+
+	result, err := provider.ParseWebhook(c.Request.Header, body)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid webhook signature",
+			"status":  http.StatusUnauthorized,
+		})
+		return
+	}
+
+	payment, err := service.SettleExternalPayment(c.Request.Context(), database.Db, provider, result)
+	if errors.Is(err, service.ErrPaymentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Payment not found",
+			"status":  http.StatusNotFound,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to process webhook",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "webhook processed",
+		"data": gin.H{
+			"payment_id": payment.PaymentID,
+			"status":     payment.Status,
+		},
+	})
+}
@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/database"
+	"github.com/grey/middlewares"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/grey/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// CreatePayoutBatch accepts a JSON batch, or a CSV or JSON file uploaded as
+// multipart form data in the "file" field alongside from_account, currency
+// and transaction_type.
+func (repository *PaymentGroup) CreatePayoutBatch(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	var (
+		form structs.PayoutBatchRequest
+		rows []service.PayoutRow
+	)
+	if c.ContentType() == "multipart/form-data" {
+		form.FromAccount = c.PostForm("from_account")
+		form.Currency = c.PostForm("currency")
+		form.TransactionType = c.PostForm("transaction_type")
+
+		file, err := c.FormFile("file")
+		if err != nil || form.FromAccount == "" {
+			utils.ErrorResponse(c, "Please upload the payout file and name the account to pay from.")
+			return
+		}
+		format := service.PayoutFileFormat(strings.TrimPrefix(strings.ToLower(filepath.Ext(file.Filename)), "."))
+		if value := c.PostForm("format"); value != "" {
+			format = service.PayoutFileFormat(value)
+		}
+
+		content, err := file.Open()
+		if err != nil {
+			utils.ErrorResponse(c, "The payout file could not be read.")
+			return
+		}
+		defer content.Close()
+
+		rows, err = service.ReadPayoutFile(content, format)
+		if err != nil {
+			payoutBatchError(c, err)
+			return
+		}
+	} else {
+		err := c.ShouldBindJSON(&form)
+		if err != nil {
+			utils.ErrorResponse(c, "Please check your batch details and ensure all required fields are filled correctly.")
+			return
+		}
+		rows = service.PayoutRowsFromRequest(form.Items)
+	}
+
+	fromID, ok := authorizeAccount(c, JwtSessionPayload, form.FromAccount)
+	if !ok {
+		return
+	}
+	if !requireVerifiedEmail(c, JwtSessionPayload) {
+		return
+	}
+	if !confirmPIN(c, JwtSessionPayload) {
+		return
+	}
+	if form.Currency == "" {
+		form.Currency = fromID.Currency
+	}
+	// the batch pays out on its own later, so the whole of it is approved now
+	if !stepUp(c, JwtSessionPayload, form.Currency, service.PayoutRowsTotal(rows)) {
+		return
+	}
+
+	batch, err := service.CreatePayoutBatch(c.Request.Context(), database.Db, fromID.UserID, fromID.AccountID, form.Currency, models.TransactionType(form.TransactionType), rows)
+	if err != nil {
+		payoutBatchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Payout batch accepted",
+		"data":    batch,
+		"status":  http.StatusAccepted,
+	})
+}
+
+func (repository *PaymentGroup) GetPayoutBatch(c *gin.Context) {
+	batch, ok := ownedPayoutBatch(c)
+	if !ok {
+		return
+	}
+
+	items, err := models.PayoutBatchItems(c.Request.Context(), database.Db, batch.BatchID, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to load payout batch items",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"data":    batch,
+		"items":   items,
+		"status":  http.StatusOK,
+	})
+}
+
+// PayoutBatchResults downloads the per-item outcome of a batch as CSV.
+func (repository *PaymentGroup) PayoutBatchResults(c *gin.Context) {
+	batch, ok := ownedPayoutBatch(c)
+	if !ok {
+		return
+	}
+
+	items, err := models.PayoutBatchItems(c.Request.Context(), database.Db, batch.BatchID, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to load payout batch items",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", `attachment; filename="payouts-`+batch.BatchID+`.csv"`)
+	c.Status(http.StatusOK)
+
+	err = service.WritePayoutResults(c.Writer, items)
+	if err != nil {
+		logrus.WithField("batch_id", batch.BatchID).WithError(err).Error("Error writing payout results")
+	}
+}
+
+// ownedPayoutBatch loads the batch in the path. Batches of other users are
+// reported as missing.
+func ownedPayoutBatch(c *gin.Context) (models.PayoutBatch, bool) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return models.PayoutBatch{}, false
+	}
+
+	batch, err := service.AuthorizePayoutBatch(c.Request.Context(), database.Db, JwtSessionPayload.UserID, c.Param("batch_id"))
+	if errors.Is(err, service.ErrNotAccountOwner) {
+		err = service.ErrBatchNotFound
+	}
+	if err != nil {
+		payoutBatchError(c, err)
+		return models.PayoutBatch{}, false
+	}
+	return batch, true
+}
+
+func payoutBatchError(c *gin.Context, err error) {
+	var invalid *service.BatchValidationError
+	switch {
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "The batch has invalid rows, nothing was paid",
+			"errors":  invalid.Rows,
+		})
+	case errors.Is(err, service.ErrBatchNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Payout batch not found",
+			"status":  http.StatusNotFound,
+		})
+	case errors.Is(err, service.ErrBatchEmpty), errors.Is(err, service.ErrBatchTooLarge),
+		errors.Is(err, service.ErrUnsupportedPayoutFormat), errors.Is(err, service.ErrMalformedPayoutFile):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid payout batch",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, service.ErrInsufficientBalance):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Insufficient balance",
+			"status":  http.StatusBadRequest,
+		})
+	case errors.Is(err, service.ErrAccountNotFound):
+		utils.ErrorResponse(c, "Account does not exist")
+	case isCurrencyError(err):
+		currencyErrorResponse(c, err)
+	case errors.Is(err, service.ErrUnknownCaller):
+		authorizationError(c, err)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to process payout batch",
+			"error":   err.Error(),
+		})
+	}
+}
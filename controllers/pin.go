@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/database"
+	"github.com/grey/middlewares"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/grey/utils"
+)
+
+// PINHeader carries the transaction PIN that confirms a payment.
+const PINHeader = "X-Transaction-PIN"
+
+// SetPIN sets the first transaction PIN.
+func (repository *UserGroup) SetPIN(c *gin.Context) {
+	var form structs.SetPINRequest
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		utils.ErrorResponse(c, "Provide your password and the new PIN")
+		return
+	}
+	user, ok := sessionUser(c)
+	if !ok {
+		return
+	}
+
+	err = service.SetPIN(c.Request.Context(), database.Db, user, form.Password, form.PIN)
+	if err != nil {
+		pinError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Your transaction PIN is set",
+		"status":  http.StatusCreated,
+	})
+}
+
+// ChangePIN replaces the transaction PIN.
+func (repository *UserGroup) ChangePIN(c *gin.Context) {
+	var form structs.ChangePINRequest
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		utils.ErrorResponse(c, "Provide your current PIN and the new PIN")
+		return
+	}
+	user, ok := sessionUser(c)
+	if !ok {
+		return
+	}
+
+	err = service.ChangePIN(c.Request.Context(), database.Db, user, form.CurrentPIN, form.PIN)
+	if err != nil {
+		pinError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Your transaction PIN was changed",
+		"status":  http.StatusOK,
+	})
+}
+
+// confirmPIN writes the error response and returns false unless the request
+// carries the user's transaction PIN in the PINHeader.
+func confirmPIN(c *gin.Context, session middlewares.JwtSessionPayload) bool {
+	err := service.VerifyPIN(c.Request.Context(), database.Db, session.UserID, c.GetHeader(PINHeader))
+	if err != nil {
+		pinError(c, err)
+		return false
+	}
+	return true
+}
+
+func pinError(c *gin.Context, err error) {
+	var pinErr *service.PINError
+	switch {
+	case errors.Is(err, service.ErrPINLocked) && errors.As(err, &pinErr):
+		c.JSON(http.StatusLocked, gin.H{
+			"message":      "Payments are locked after too many incorrect PINs. Please try again later.",
+			"code":         "PIN_LOCKED",
+			"locked_until": pinErr.LockedUntil,
+			"status":       http.StatusLocked,
+		})
+	case errors.Is(err, service.ErrIncorrectPIN) && errors.As(err, &pinErr):
+		c.JSON(http.StatusForbidden, gin.H{
+			"message":            "Incorrect PIN",
+			"code":               "PIN_INCORRECT",
+			"attempts_remaining": pinErr.AttemptsRemaining,
+			"status":             http.StatusForbidden,
+		})
+	case errors.Is(err, service.ErrPINNotSet):
+		c.JSON(http.StatusForbidden, gin.H{
+			"message": "Set a transaction PIN before making payments",
+			"code":    "PIN_NOT_SET",
+			"status":  http.StatusForbidden,
+		})
+	case errors.Is(err, service.ErrPINAlreadySet):
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "A transaction PIN is already set, change it instead",
+			"status":  http.StatusConflict,
+		})
+	case errors.Is(err, service.ErrWeakPIN):
+		utils.ErrorResponse(c, err.Error())
+	case errors.Is(err, service.ErrWrongPassword):
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid password",
+			"status":  http.StatusUnauthorized,
+		})
+	case errors.Is(err, service.ErrUnknownCaller):
+		authorizationError(c, err)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to check the PIN",
+			"error":   err.Error(),
+		})
+	}
+}
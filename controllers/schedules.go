@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/database"
+	"github.com/grey/middlewares"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/grey/utils"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+func (repository *PaymentGroup) CreateSchedule(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	var form structs.ScheduleRequest
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		utils.ErrorResponse(c, "Please check your schedule details and ensure all required fields are filled correctly.")
+		return
+	}
+
+	fromID, ok := authorizeAccount(c, JwtSessionPayload, form.FromAccount)
+	if !ok {
+		return
+	}
+	// scheduled payouts run later without a session, so the check is made now
+	transactionType := models.TransactionType(form.TransactionType)
+	if (transactionType == models.BankTransfer || transactionType == models.MobileMoney) && !requireVerifiedEmail(c, JwtSessionPayload) {
+		return
+	}
+	if !confirmPIN(c, JwtSessionPayload) {
+		return
+	}
+	if form.Currency == "" {
+		form.Currency = fromID.Currency
+	}
+	if !stepUp(c, JwtSessionPayload, form.Currency, decimal.NewFromFloat(form.Amount)) {
+		return
+	}
+
+	schedule := models.PaymentSchedule{
+		UserID:          fromID.UserID,
+		Type:            transactionType,
+		FromAccount:     fromID.AccountID,
+		ToAccount:       form.ToAccount,
+		RecipientNumber: form.Recipient.RecipientNumber,
+		RecipientName:   form.Recipient.RecipientName,
+		Amount:          decimal.NewFromFloat(form.Amount),
+		Currency:        form.Currency,
+		Description:     form.Description,
+		Frequency:       models.ScheduleFrequency(form.Frequency),
+		CronExpr:        form.Cron,
+		EndAt:           form.EndAt,
+	}
+	if form.StartAt != nil {
+		schedule.StartAt = *form.StartAt
+	}
+
+	err = service.CreateSchedule(c.Request.Context(), database.Db, &schedule)
+	if err != nil {
+		scheduleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Schedule created successfully",
+		"data":    schedule,
+		"status":  http.StatusCreated,
+	})
+}
+
+func (repository *PaymentGroup) ListSchedules(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	user, err := service.Caller(c.Request.Context(), database.Db, JwtSessionPayload.UserID)
+	if err != nil {
+		authorizationError(c, err)
+		return
+	}
+
+	schedules, err := models.UserSchedules(c.Request.Context(), database.Db, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to list schedules",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"data":    schedules,
+		"status":  http.StatusOK,
+	})
+}
+
+// GetSchedule returns the schedule with the outcome of its latest runs.
+func (repository *PaymentGroup) GetSchedule(c *gin.Context) {
+	schedule, ok := ownedSchedule(c)
+	if !ok {
+		return
+	}
+
+	runs, err := models.ScheduleRuns(c.Request.Context(), database.Db, schedule.ScheduleID, 100)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to load schedule runs",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"data":    schedule,
+		"runs":    runs,
+		"status":  http.StatusOK,
+	})
+}
+
+func (repository *PaymentGroup) SkipSchedule(c *gin.Context) {
+	changeSchedule(c, service.SkipSchedule, "Next payment skipped")
+}
+
+func (repository *PaymentGroup) PauseSchedule(c *gin.Context) {
+	changeSchedule(c, service.PauseSchedule, "Schedule paused")
+}
+
+func (repository *PaymentGroup) ResumeSchedule(c *gin.Context) {
+	changeSchedule(c, service.ResumeSchedule, "Schedule resumed")
+}
+
+func (repository *PaymentGroup) CancelSchedule(c *gin.Context) {
+	changeSchedule(c, service.CancelSchedule, "Schedule cancelled")
+}
+
+func changeSchedule(c *gin.Context, change func(context.Context, *gorm.DB, *models.PaymentSchedule) error, message string) {
+	schedule, ok := ownedSchedule(c)
+	if !ok {
+		return
+	}
+
+	err := change(c.Request.Context(), database.Db, &schedule)
+	if err != nil {
+		scheduleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"data":    schedule,
+		"status":  http.StatusOK,
+	})
+}
+
+// ownedSchedule loads the schedule in the path. Schedules of other users are
+// reported as missing.
+func ownedSchedule(c *gin.Context) (models.PaymentSchedule, bool) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return models.PaymentSchedule{}, false
+	}
+
+	schedule, err := service.AuthorizeSchedule(c.Request.Context(), database.Db, JwtSessionPayload.UserID, c.Param("schedule_id"))
+	if errors.Is(err, service.ErrNotAccountOwner) {
+		err = service.ErrScheduleNotFound
+	}
+	if err != nil {
+		scheduleError(c, err)
+		return models.PaymentSchedule{}, false
+	}
+	return schedule, true
+}
+
+func scheduleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrScheduleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Schedule not found",
+			"status":  http.StatusNotFound,
+		})
+	case errors.Is(err, service.ErrScheduleNotActive), errors.Is(err, service.ErrScheduleNotPaused):
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "The schedule cannot be changed in its current status",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, service.ErrInvalidSchedule):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid schedule",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, service.ErrInvalidAmount):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid amount",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, service.ErrAccountNotFound):
+		utils.ErrorResponse(c, "Account does not exist")
+	case isCurrencyError(err):
+		currencyErrorResponse(c, err)
+	case errors.Is(err, service.ErrUnknownCaller):
+		authorizationError(c, err)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to process schedule",
+			"error":   err.Error(),
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/database"
+	"github.com/grey/middlewares"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/utils"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+func (repository *PaymentGroup) AccountTransactions(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	account, ok := ownedAccount(c, JwtSessionPayload)
+	if !ok {
+		return
+	}
+
+	filter, err := ledgerFilterFromQuery(c, account.AccountID)
+	if err != nil {
+		utils.ErrorResponse(c, err.Error())
+		return
+	}
+
+	page, err := service.AccountTransactions(c.Request.Context(), database.Db, filter)
+	if err != nil {
+		utils.ErrorResponse(c, "We couldn't retrieve your transactions at this time. Please try again later.")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "success",
+		"data":        page.Data,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+func (repository *PaymentGroup) AccountStatement(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	account, ok := ownedAccount(c, JwtSessionPayload)
+	if !ok {
+		return
+	}
+
+	format := service.StatementFormat(c.DefaultQuery("format", string(service.StatementCSV)))
+	if _, err := service.NewStatementWriter(format, io.Discard); err != nil {
+		utils.ErrorResponse(c, "format must be csv, ofx or camt053")
+		return
+	}
+
+	// the statement covers the current month unless a period is given
+	now := time.Now().UTC()
+	from, err := parseTimeQuery(c.Query("from"), false)
+	if err != nil {
+		utils.ErrorResponse(c, "from must be a date (2006-01-02) or an RFC 3339 timestamp")
+		return
+	}
+	if from.IsZero() {
+		from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	to, err := parseTimeQuery(c.Query("to"), true)
+	if err != nil {
+		utils.ErrorResponse(c, "to must be a date (2006-01-02) or an RFC 3339 timestamp")
+		return
+	}
+	if to.IsZero() {
+		to = now
+	}
+	if !from.Before(to) {
+		utils.ErrorResponse(c, "from must be before to")
+		return
+	}
+
+	filename := fmt.Sprintf("statement-%s-%s.%s", account.AccountID, from.Format("20060102"), format.FileExtension())
+	c.Header("Content-Type", format.ContentType())
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+
+	// the status line is already sent, a failure can only cut the body short
+	err = service.WriteStatement(c.Request.Context(), database.Db, c.Writer, format, account, from, to)
+	if err != nil {
+		logrus.WithField("account_id", account.AccountID).WithError(err).Error("Error writing statement")
+	}
+}
+
+func ledgerFilterFromQuery(c *gin.Context, accountID string) (models.LedgerFilter, error) {
+	filter := models.LedgerFilter{AccountID: accountID}
+
+	beforeID, err := service.DecodeCursor(c.Query("cursor"))
+	if err != nil {
+		return filter, errors.New("Invalid cursor")
+	}
+	filter.BeforeID = beforeID
+
+	if limit := c.Query("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit <= 0 || filter.Limit > service.MaxPageSize {
+			return filter, errors.New("limit must be between 1 and " + strconv.Itoa(service.MaxPageSize))
+		}
+	}
+
+	filter.From, err = parseTimeQuery(c.Query("from"), false)
+	if err != nil {
+		return filter, errors.New("from must be a date (2006-01-02) or an RFC 3339 timestamp")
+	}
+	filter.To, err = parseTimeQuery(c.Query("to"), true)
+	if err != nil {
+		return filter, errors.New("to must be a date (2006-01-02) or an RFC 3339 timestamp")
+	}
+
+	if status := c.Query("status"); status != "" {
+		filter.Status = models.PaymentStatus(status)
+	}
+
+	switch direction := models.LedgerDirection(c.Query("direction")); direction {
+	case "", models.Debit, models.Credit:
+		filter.Direction = direction
+	default:
+		return filter, errors.New("direction must be debit or credit")
+	}
+
+	filter.MinAmount, err = parseAmountQuery(c.Query("min_amount"))
+	if err != nil {
+		return filter, errors.New("min_amount must be a number")
+	}
+	filter.MaxAmount, err = parseAmountQuery(c.Query("max_amount"))
+	if err != nil {
+		return filter, errors.New("max_amount must be a number")
+	}
+
+	return filter, nil
+}
+
+// parseTimeQuery accepts a date or an RFC 3339 timestamp. A date used as the
+// end of a range includes the whole day.
+func parseTimeQuery(value string, endOfRange bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	day, err := time.Parse(time.DateOnly, value)
+	if err == nil {
+		if endOfRange {
+			return day.AddDate(0, 0, 1), nil
+		}
+		return day, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func parseAmountQuery(value string) (decimal.NullDecimal, error) {
+	if value == "" {
+		return decimal.NullDecimal{}, nil
+	}
+	amount, err := decimal.NewFromString(value)
+	if err != nil {
+		return decimal.NullDecimal{}, err
+	}
+	return decimal.NewNullDecimal(amount), nil
+}
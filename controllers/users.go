@@ -1,15 +1,21 @@
 package controllers
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/grey/database"
 	"github.com/grey/middlewares"
 	"github.com/grey/models"
+	"github.com/grey/service"
 	"github.com/grey/structs"
 	"github.com/grey/utils"
 	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -78,9 +84,15 @@ func (repository *UserGroup) CreateUser(c *gin.Context) {
 		return
 	}
 
+	// the user can ask for another email if this one fails
+	err = service.SendVerificationEmail(c.Request.Context(), database.Db, user)
+	if err != nil {
+		logrus.WithField("user_id", user.UserId).WithError(err).Error("Error sending verification email")
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "success",
-		"data":    "user registered successfully",
+		"data":    "user registered successfully, check your email to verify your address",
 		"status":  http.StatusCreated,
 	})
 }
@@ -93,36 +105,114 @@ func (repository *UserGroup) Login(c *gin.Context) {
 		return
 	}
 
-	user, err := models.IsEmailExists(c, database.Db, form.Email)
+	user, err := service.Authenticate(c.Request.Context(), database.Db, form.Email, form.Password, service.LoginClient{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+	var throttled *service.ThrottleError
+	if errors.As(err, &throttled) {
+		// round up so the client never retries a moment too early
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"message": "Too many failed login attempts. Please try again later.",
+			"status":  http.StatusTooManyRequests,
+		})
+		return
+	}
+	if errors.Is(err, service.ErrInvalidCredentials) {
+		utils.ErrorResponse(c, "Invalid email or password")
+		return
+	}
 	if err != nil {
-		utils.ErrorResponse(c, "We couldn't check your email at this time. Please try again later.")
+		utils.ErrorResponse(c, "We couldn't log you in at this time. Please try again later.")
 		return
 	}
 
-	if user.Email == "" {
-		utils.ErrorResponse(c, "We couldn't find an account with that email. Please check your email address or sign up for a new account.")
+	if user.MFAEnabled {
+		// the session is only opened once the second factor is verified, and
+		// wrong codes keep counting across password logins
+		pending, err := utils.IssueMFAToken(user.UserId, user.Email)
+		if err != nil {
+			utils.ErrorResponse(c, "We couldn't log you in at this time. Please try again later.")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Enter the code from your authenticator app to finish logging in.",
+			"data": gin.H{
+				"mfa_required": true,
+				"mfa_token":    pending.Token,
+				"expires_at":   pending.ExpiresAt,
+			},
+		})
 		return
 	}
 
-	err = models.PasswordCompare(form.Password, user.Password)
+	tokens, err := service.StartSession(c.Request.Context(), database.Db, user)
 	if err != nil {
-		utils.ErrorResponse(c, "Invalid email or password")
+		utils.ErrorResponse(c, "We couldn't log you in at this time. Please try again later.")
 		return
 	}
 
-	token, err := utils.GenerateToken(user.UserId, user.Email)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Welcome back! You have successfully logged in.",
+		"data":    tokens,
+	})
+
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh token.
+// The old refresh token stops working.
+func (repository *UserGroup) Refresh(c *gin.Context) {
+	var form structs.RefreshRequest
+	err := c.ShouldBindJSON(&form)
 	if err != nil {
-		utils.ErrorResponse(c, "We couldn't log you in at this time. Please try again later.")
+		utils.ErrorResponse(c, "Provide the refresh token")
+		return
+	}
+
+	tokens, err := service.RefreshSession(c.Request.Context(), database.Db, form.RefreshToken)
+	if errors.Is(err, service.ErrInvalidRefreshToken) || errors.Is(err, service.ErrRefreshTokenReused) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Your session has expired. Please log in again.",
+			"error":   err.Error(),
+			"status":  http.StatusUnauthorized,
+		})
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Error refreshing session")
+		utils.ErrorResponse(c, "We couldn't refresh your session at this time. Please try again later.")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Welcome back! You have successfully logged in.",
-		"data": gin.H{
-			"token": token,
-		},
+		"message": "success",
+		"data":    tokens,
 	})
+}
 
+// Logout revokes the session of the access token, along with every token
+// issued under it.
+func (repository *UserGroup) Logout(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	expiresAt := time.Unix(int64(JwtSessionPayload.Exp), 0)
+	err := service.EndSession(c.Request.Context(), database.Db, JwtSessionPayload.SessionID, JwtSessionPayload.JTI, expiresAt)
+	if err != nil {
+		logrus.WithField("user_id", JwtSessionPayload.UserID).WithError(err).Error("Error ending session")
+		utils.ErrorResponse(c, "We couldn't log you out at this time. Please try again later.")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "You have been logged out.",
+		"status":  http.StatusOK,
+	})
 }
 
 func (repository *UserGroup) UserProfile(c *gin.Context) {
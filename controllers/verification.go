@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/database"
+	"github.com/grey/middlewares"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/grey/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// ResendVerification emails a new verification token to the logged in user.
+func (repository *UserGroup) ResendVerification(c *gin.Context) {
+	user, ok := sessionUser(c)
+	if !ok {
+		return
+	}
+
+	err := service.ResendVerificationEmail(c.Request.Context(), database.Db, user)
+	if err != nil {
+		verificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "We have sent you a new verification email.",
+		"status":  http.StatusOK,
+	})
+}
+
+// VerifyEmail verifies the email of the user the token was sent to. It needs
+// no session, since the token comes from a link in the email.
+func (repository *UserGroup) VerifyEmail(c *gin.Context) {
+	var form structs.VerifyEmailRequest
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		utils.ErrorResponse(c, "Provide the verification token")
+		return
+	}
+
+	err = service.VerifyEmail(c.Request.Context(), database.Db, form.Token)
+	if err != nil {
+		verificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Your email address is verified.",
+		"status":  http.StatusOK,
+	})
+}
+
+// requireVerifiedEmail writes the error response and returns false unless the
+// session user has verified their email.
+func requireVerifiedEmail(c *gin.Context, session middlewares.JwtSessionPayload) bool {
+	err := service.RequireVerifiedEmail(c.Request.Context(), database.Db, session.UserID)
+	if err != nil {
+		verificationError(c, err)
+		return false
+	}
+	return true
+}
+
+func verificationError(c *gin.Context, err error) {
+	var resend *service.ResendError
+	switch {
+	case errors.As(err, &resend):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(resend.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"message": "A verification email was sent recently. Please wait before asking for another.",
+			"status":  http.StatusTooManyRequests,
+		})
+	case errors.Is(err, service.ErrEmailNotVerified):
+		c.JSON(http.StatusForbidden, gin.H{
+			"message": "Verify your email address to send payouts",
+			"code":    "EMAIL_NOT_VERIFIED",
+			"status":  http.StatusForbidden,
+		})
+	case errors.Is(err, service.ErrEmailAlreadyVerified):
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "Your email address is already verified",
+			"status":  http.StatusConflict,
+		})
+	case errors.Is(err, service.ErrInvalidVerificationToken):
+		utils.ErrorResponse(c, "The verification link is invalid or has expired. Please request a new one.")
+	case errors.Is(err, service.ErrUnknownCaller):
+		authorizationError(c, err)
+	default:
+		logrus.WithError(err).Error("Error verifying email")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "We couldn't verify your email at this time. Please try again later.",
+			"status":  http.StatusInternalServerError,
+		})
+	}
+}
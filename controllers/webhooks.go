@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/database"
+	"github.com/grey/middlewares"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/grey/utils"
+	"gorm.io/gorm"
+)
+
+type WebhookGroup struct{}
+
+func (repository *WebhookGroup) CreateSubscription(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	var form structs.WebhookSubscriptionRequest
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		utils.ErrorResponse(c, "Provide a webhook url and at least one event type")
+		return
+	}
+
+	err = service.ValidateWebhookURL(c.Request.Context(), form.URL)
+	if err != nil {
+		utils.ErrorResponse(c, "Webhook url must be an absolute https url on a public host")
+		return
+	}
+
+	for _, eventType := range form.EventTypes {
+		if eventType != "*" && !slices.Contains(service.WebhookEventTypes, eventType) {
+			utils.ErrorResponse(c, "Unknown event type "+eventType+", supported: "+strings.Join(service.WebhookEventTypes, ", "))
+			return
+		}
+	}
+
+	secret := form.Secret
+	if secret == "" {
+		secret, err = generateWebhookSecret()
+		if err != nil {
+			utils.ErrorResponse(c, "We couldn't create your webhook at this time. Please try again later.")
+			return
+		}
+	}
+	if len(secret) < 16 {
+		utils.ErrorResponse(c, "Webhook secret must be at least 16 characters")
+		return
+	}
+
+	subscription := &models.WebhookSubscription{
+		UserID:     JwtSessionPayload.UserID,
+		URL:        form.URL,
+		EventTypes: strings.Join(form.EventTypes, ","),
+		Secret:     secret,
+	}
+	err = models.CreateWebhookSubscription(c.Request.Context(), database.Db, subscription)
+	if err != nil {
+		utils.ErrorResponse(c, "We couldn't create your webhook at this time. Please try again later.")
+		return
+	}
+
+	// the secret is only ever shown on creation
+	response := subscriptionResponse(*subscription)
+	response.Secret = secret
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "success",
+		"data":    response,
+		"status":  http.StatusCreated,
+	})
+}
+
+func (repository *WebhookGroup) ListSubscriptions(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	subscriptions, err := models.ListWebhookSubscriptions(c.Request.Context(), database.Db, JwtSessionPayload.UserID)
+	if err != nil {
+		utils.ErrorResponse(c, "We couldn't retrieve your webhooks at this time. Please try again later.")
+		return
+	}
+
+	data := make([]structs.WebhookSubscriptionResponse, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		data = append(data, subscriptionResponse(subscription))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"data":    data,
+	})
+}
+
+func (repository *WebhookGroup) DeleteSubscription(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	subscription, ok := findSubscription(c, JwtSessionPayload.UserID)
+	if !ok {
+		return
+	}
+
+	err := models.DeleteWebhookSubscription(c.Request.Context(), database.Db, subscription)
+	if err != nil {
+		utils.ErrorResponse(c, "We couldn't delete your webhook at this time. Please try again later.")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "webhook deleted",
+	})
+}
+
+func (repository *WebhookGroup) ListDeliveries(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	subscription, ok := findSubscription(c, JwtSessionPayload.UserID)
+	if !ok {
+		return
+	}
+
+	deliveries, err := models.ListWebhookDeliveries(c.Request.Context(), database.Db, subscription.SubscriptionID, 100)
+	if err != nil {
+		utils.ErrorResponse(c, "We couldn't retrieve your webhook deliveries at this time. Please try again later.")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "success",
+		"data":    deliveries,
+	})
+}
+
+func (repository *WebhookGroup) Redeliver(c *gin.Context) {
+	claimPayload, exists := c.Get("x-claim-payload")
+	JwtSessionPayload, _ := claimPayload.(middlewares.JwtSessionPayload)
+	if !exists {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	delivery, err := models.FindUserWebhookDelivery(c.Request.Context(), database.Db, JwtSessionPayload.UserID, c.Param("delivery_id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Webhook delivery not found",
+			"status":  http.StatusNotFound,
+		})
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(c, "We couldn't retrieve your webhook delivery at this time. Please try again later.")
+		return
+	}
+
+	err = models.RequeueWebhookDelivery(c.Request.Context(), database.Db, delivery)
+	if err != nil {
+		utils.ErrorResponse(c, "We couldn't schedule the redelivery at this time. Please try again later.")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "redelivery scheduled",
+		"data":    delivery,
+		"status":  http.StatusAccepted,
+	})
+}
+
+func findSubscription(c *gin.Context, userID string) (*models.WebhookSubscription, bool) {
+	subscription, err := models.FindWebhookSubscription(c.Request.Context(), database.Db, userID, c.Param("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Webhook not found",
+			"status":  http.StatusNotFound,
+		})
+		return nil, false
+	}
+	if err != nil {
+		utils.ErrorResponse(c, "We couldn't retrieve your webhook at this time. Please try again later.")
+		return nil, false
+	}
+	return subscription, true
+}
+
+func subscriptionResponse(subscription models.WebhookSubscription) structs.WebhookSubscriptionResponse {
+	return structs.WebhookSubscriptionResponse{
+		SubscriptionID: subscription.SubscriptionID,
+		URL:            subscription.URL,
+		EventTypes:     subscription.Events(),
+		CreatedAt:      subscription.CreatedAt,
+	}
+}
+
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
@@ -0,0 +1,157 @@
+// Package cron parses standard five-field cron expressions
+// (minute hour day-of-month month day-of-week) and finds their next match.
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidExpression = errors.New("invalid cron expression")
+
+// bounds of each field, in expression order
+var fields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// set is a bitmask of the values a field matches.
+type set uint64
+
+func (s set) has(value int) bool {
+	return s&(1<<uint(value)) != 0
+}
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+	minute, hour, dom, month, dow set
+	// restricted day fields; when both are, a day matching either matches
+	domAny, dowAny bool
+}
+
+// Parse reads an expression like "30 9 * * 1-5". Fields accept *, values,
+// ranges (a-b), steps (*/n, a-b/n) and comma separated lists. Day of week
+// runs from 0 (Sunday) to 6, and 7 is Sunday too.
+func Parse(expr string) (*Schedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("%w: want %d fields, got %d", ErrInvalidExpression, len(fields), len(parts))
+	}
+
+	sets := make([]set, len(fields))
+	for i, part := range parts {
+		parsed, err := parseField(part, fields[i].min, fields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s %q", ErrInvalidExpression, fields[i].name, part)
+		}
+		sets[i] = parsed
+	}
+
+	dow := sets[4]
+	if dow.has(7) {
+		dow |= 1
+	}
+	return &Schedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    dow,
+		domAny: parts[2] == "*",
+		dowAny: parts[4] == "*",
+	}, nil
+}
+
+func parseField(field string, min, max int) (set, error) {
+	var result set
+	for _, item := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			parsed, err := strconv.Atoi(stepPart)
+			if err != nil || parsed <= 0 {
+				return 0, ErrInvalidExpression
+			}
+			step = parsed
+		}
+
+		low, high := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			lowPart, highPart, _ := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = strconv.Atoi(lowPart); err != nil {
+				return 0, ErrInvalidExpression
+			}
+			if high, err = strconv.Atoi(highPart); err != nil {
+				return 0, ErrInvalidExpression
+			}
+		default:
+			value, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, ErrInvalidExpression
+			}
+			low = value
+			if !hasStep {
+				high = value
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, ErrInvalidExpression
+		}
+
+		for value := low; value <= high; value += step {
+			result |= 1 << uint(value)
+		}
+	}
+	return result, nil
+}
+
+// Next returns the first time after the given one that the schedule matches,
+// at minute precision in the location of after. It returns the zero time when
+// nothing matches within five years, e.g. for "0 0 30 2 *".
+func (s *Schedule) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !s.month.has(int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.hour.has(t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if !s.minute.has(t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	dom := s.dom.has(t.Day())
+	dow := s.dow.has(int(t.Weekday()))
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
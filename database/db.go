@@ -4,7 +4,6 @@ import (
 	"os"
 
 	"github.com/sirupsen/logrus"
-	"go.uber.org/zap"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -37,7 +36,7 @@ func RunMigrations(migrations Migrations) {
 	for _, model := range migrations.Models {
 		err := migrations.DB.AutoMigrate(model)
 		if err != nil {
-			logrus.Error("Error in migration", zap.Error(err))
+			logrus.WithError(err).Error("Error in migration")
 
 		}
 	}
@@ -0,0 +1,143 @@
+package fees
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/grey/models"
+	"github.com/shopspring/decimal"
+)
+
+var hundred = decimal.NewFromInt(100)
+
+// Tier prices the amounts up to UpTo. Tiers are checked in order and the
+// first one the amount fits in is used; a zero UpTo has no upper bound.
+type Tier struct {
+	UpTo       decimal.Decimal `json:"up_to"`
+	Percentage decimal.Decimal `json:"percentage"`
+	Fixed      decimal.Decimal `json:"fixed"`
+}
+
+// Rule is the pricing of one transaction type. The fee is Percentage percent
+// of the amount plus Fixed, or the matching tier's when Tiers is set, and is
+// then held between Min and Max. A zero Max leaves the fee uncapped. Fixed,
+// Min and Max are in units of the payment currency.
+type Rule struct {
+	Percentage decimal.Decimal `json:"percentage"`
+	Fixed      decimal.Decimal `json:"fixed"`
+	Min        decimal.Decimal `json:"min"`
+	Max        decimal.Decimal `json:"max"`
+	Tiers      []Tier          `json:"tiers,omitempty"`
+}
+
+func (rule Rule) Fee(amount decimal.Decimal) decimal.Decimal {
+	percentage, fixed := rule.Percentage, rule.Fixed
+	for _, tier := range rule.Tiers {
+		if tier.UpTo.IsZero() || amount.LessThanOrEqual(tier.UpTo) {
+			percentage, fixed = tier.Percentage, tier.Fixed
+			break
+		}
+	}
+
+	fee := amount.Mul(percentage).Div(hundred).Add(fixed)
+	if fee.LessThan(rule.Min) {
+		fee = rule.Min
+	}
+	if rule.Max.IsPositive() && fee.GreaterThan(rule.Max) {
+		fee = rule.Max
+	}
+	if fee.IsNegative() {
+		return decimal.Zero
+	}
+	return fee
+}
+
+// Schedule maps transaction types to their pricing. Types without a rule are
+// free.
+type Schedule map[models.TransactionType]Rule
+
+// LoadSchedule reads a schedule from a JSON file keyed by transaction type.
+func LoadSchedule(path string) (Schedule, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var schedule Schedule
+	err = json.Unmarshal(raw, &schedule)
+	if err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// Quote is the fee for one payment. Total is what the payer is charged and
+// Credited what reaches the recipient. Top-ups take the fee out of the amount
+// added, every other payment adds it on top of the amount sent.
+type Quote struct {
+	TransactionType models.TransactionType `json:"transaction_type"`
+	Amount          decimal.Decimal        `json:"amount"`
+	Fee             decimal.Decimal        `json:"fee"`
+	Total           decimal.Decimal        `json:"total"`
+	Credited        decimal.Decimal        `json:"credited"`
+	Currency        string                 `json:"currency"`
+}
+
+type Engine struct {
+	mu       sync.RWMutex
+	schedule Schedule
+}
+
+func NewEngine(schedule Schedule) *Engine {
+	if schedule == nil {
+		schedule = Schedule{}
+	}
+	return &Engine{schedule: schedule}
+}
+
+func (engine *Engine) SetSchedule(schedule Schedule) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	engine.schedule = schedule
+}
+
+func (engine *Engine) SetRule(transactionType models.TransactionType, rule Rule) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	engine.schedule[transactionType] = rule
+}
+
+// Quote prices a payment. The fee is rounded to the minor units of the
+// currency.
+func (engine *Engine) Quote(transactionType models.TransactionType, amount decimal.Decimal, currency string) (Quote, error) {
+	registered, err := models.LookupCurrency(currency)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	engine.mu.RLock()
+	rule, ok := engine.schedule[transactionType]
+	engine.mu.RUnlock()
+
+	fee := decimal.Zero
+	if ok {
+		fee = rule.Fee(amount).Round(registered.MinorUnits)
+	}
+	quote := Quote{
+		TransactionType: transactionType,
+		Amount:          amount,
+		Fee:             fee,
+		Total:           amount.Add(fee),
+		Credited:        amount,
+		Currency:        currency,
+	}
+	if transactionType == models.TopUpTransaction {
+		quote.Total = amount
+		quote.Credited = amount.Sub(fee)
+	}
+	return quote, nil
+}
+
+// Default is the engine used to price payments. It starts with an empty
+// schedule, so payments are free until one is loaded.
+var Default = NewEngine(nil)
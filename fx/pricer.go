@@ -0,0 +1,53 @@
+package fx
+
+import (
+	"context"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Price is the rate offered for converting base into quote.
+type Price struct {
+	Base      string
+	Quote     string
+	MidRate   decimal.Decimal
+	Rate      decimal.Decimal
+	SpreadBps int64
+	Source    string
+}
+
+// Pricer turns mid-market rates into customer rates. The spread, in basis
+// points, is taken off the mid rate so the customer always receives a little
+// less than the mid rate would give.
+type Pricer struct {
+	Source    RateSource
+	SpreadBps int64
+	// QuoteTTL is how long a quoted rate stays locked.
+	QuoteTTL time.Duration
+}
+
+func NewPricer(source RateSource, spreadBps int64, quoteTTL time.Duration) *Pricer {
+	return &Pricer{Source: source, SpreadBps: spreadBps, QuoteTTL: quoteTTL}
+}
+
+func (pricer *Pricer) Price(ctx context.Context, base, quote string) (Price, error) {
+	mid, err := pricer.Source.Rate(ctx, base, quote)
+	if err != nil {
+		return Price{}, err
+	}
+
+	markdown := decimal.NewFromInt(10000 - pricer.SpreadBps).Div(decimal.NewFromInt(10000))
+	return Price{
+		Base:      base,
+		Quote:     quote,
+		MidRate:   mid,
+		Rate:      mid.Mul(markdown).Round(rateScale),
+		SpreadBps: pricer.SpreadBps,
+		Source:    pricer.Source.Name(),
+	}, nil
+}
+
+// Default is the pricer used by the HTTP handlers. Without configuration it
+// has no rates and every conversion is refused.
+var Default = NewPricer(NewStaticRates("USD", nil), 0, time.Minute)
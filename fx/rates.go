@@ -0,0 +1,134 @@
+package fx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var ErrRateUnavailable = errors.New("exchange rate unavailable")
+
+// rateScale is the number of decimals kept on exchange rates.
+const rateScale = 10
+
+// RateSource supplies mid-market exchange rates. Rate returns how many units
+// of quote one unit of base buys.
+type RateSource interface {
+	Name() string
+	Rate(ctx context.Context, base, quote string) (decimal.Decimal, error)
+}
+
+// RateTable lists rates against a single base currency, e.g. base USD with
+// GHS 15.5 means 1 USD buys 15.5 GHS. Cross rates go through the base.
+type RateTable struct {
+	Base  string                     `json:"base"`
+	Rates map[string]decimal.Decimal `json:"rates"`
+}
+
+func (table RateTable) rate(base, quote string) (decimal.Decimal, error) {
+	if base == quote {
+		return decimal.NewFromInt(1), nil
+	}
+	baseRate, ok := table.unitsPerBase(base)
+	if !ok {
+		return decimal.Zero, fmt.Errorf("%w: %s/%s", ErrRateUnavailable, base, quote)
+	}
+	quoteRate, ok := table.unitsPerBase(quote)
+	if !ok {
+		return decimal.Zero, fmt.Errorf("%w: %s/%s", ErrRateUnavailable, base, quote)
+	}
+	return quoteRate.DivRound(baseRate, rateScale), nil
+}
+
+func (table RateTable) unitsPerBase(currency string) (decimal.Decimal, bool) {
+	if currency == table.Base {
+		return decimal.NewFromInt(1), true
+	}
+	rate, ok := table.Rates[currency]
+	if !ok || !rate.IsPositive() {
+		return decimal.Zero, false
+	}
+	return rate, true
+}
+
+// StaticRates serves a fixed rate table, for tests and for running without a
+// feed.
+type StaticRates struct {
+	table RateTable
+}
+
+func NewStaticRates(base string, rates map[string]decimal.Decimal) *StaticRates {
+	return &StaticRates{table: RateTable{Base: base, Rates: rates}}
+}
+
+func (source *StaticRates) Name() string {
+	return "static"
+}
+
+func (source *StaticRates) Rate(ctx context.Context, base, quote string) (decimal.Decimal, error) {
+	return source.table.rate(base, quote)
+}
+
+// FileRates serves the rate table from a JSON file written by an external
+// feed. The file is read again whenever it changes, and rates older than
+// MaxAge are refused rather than quoted.
+type FileRates struct {
+	path   string
+	MaxAge time.Duration
+
+	mu       sync.Mutex
+	table    RateTable
+	modified time.Time
+}
+
+func NewFileRates(path string, maxAge time.Duration) *FileRates {
+	return &FileRates{path: path, MaxAge: maxAge}
+}
+
+func (source *FileRates) Name() string {
+	return "file"
+}
+
+func (source *FileRates) Rate(ctx context.Context, base, quote string) (decimal.Decimal, error) {
+	table, err := source.load()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return table.rate(base, quote)
+}
+
+func (source *FileRates) load() (RateTable, error) {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+
+	info, err := os.Stat(source.path)
+	if err != nil {
+		return RateTable{}, fmt.Errorf("%w: %v", ErrRateUnavailable, err)
+	}
+	if source.MaxAge > 0 && time.Since(info.ModTime()) > source.MaxAge {
+		return RateTable{}, fmt.Errorf("%w: rate feed is stale", ErrRateUnavailable)
+	}
+	if info.ModTime().Equal(source.modified) {
+		return source.table, nil
+	}
+
+	raw, err := os.ReadFile(source.path)
+	if err != nil {
+		return RateTable{}, fmt.Errorf("%w: %v", ErrRateUnavailable, err)
+	}
+	var table RateTable
+	err = json.Unmarshal(raw, &table)
+	if err != nil || table.Base == "" {
+		return RateTable{}, fmt.Errorf("%w: malformed rate feed", ErrRateUnavailable)
+	}
+
+	source.table = table
+	source.modified = info.ModTime()
+	return table, nil
+}
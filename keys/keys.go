@@ -0,0 +1,402 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/sirupsen/logrus"
+)
+
+// Algorithms tokens may be signed with. Anything else, HS256 and "none"
+// included, is refused before a key is even looked up.
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ErrNoSigningKey         = errors.New("no signing key is active")
+	ErrUnknownKey           = errors.New("token is signed with an unknown or retired key")
+	ErrAlgorithmMismatch    = errors.New("token algorithm does not match its key")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+)
+
+// DefaultOverlap is how long a key keeps verifying tokens after a newer key
+// took over signing. It must outlast the tokens the key signed.
+const DefaultOverlap = time.Hour
+
+// reloadInterval bounds how often a file keyring checks its file for changes.
+const reloadInterval = time.Minute
+
+// Key is a signing key identified in token headers by its kid. Private is nil
+// for keys that only verify, such as a retired key whose private half has
+// been destroyed.
+type Key struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	Public    crypto.PublicKey
+	// ActivateAt is when the key starts signing. Keys are published in the
+	// JWKS before then so verifiers can fetch them ahead of the rotation.
+	ActivateAt time.Time
+}
+
+// Generate creates a key for the algorithm.
+func Generate(kid, algorithm string, activateAt time.Time) (Key, error) {
+	key := Key{ID: kid, Algorithm: algorithm, ActivateAt: activateAt}
+	switch algorithm {
+	case RS256:
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return Key{}, err
+		}
+		key.Private, key.Public = private, &private.PublicKey
+	case EdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return Key{}, err
+		}
+		key.Private, key.Public = private, public
+	default:
+		return Key{}, ErrUnsupportedAlgorithm
+	}
+	return key, nil
+}
+
+// Keyring holds the keys tokens are signed and verified with. The newest key
+// whose ActivateAt has passed signs; older keys verify for the overlap window
+// after they were replaced, then drop out.
+type Keyring struct {
+	path string
+
+	mu       sync.RWMutex
+	keys     []Key
+	overlap  time.Duration
+	modified time.Time
+	checked  time.Time
+}
+
+func NewKeyring(keys []Key, overlap time.Duration) *Keyring {
+	ring := &Keyring{}
+	ring.SetKeys(keys, overlap)
+	return ring
+}
+
+// NewFileKeyring loads the keyring from a JSON manifest. The file is read
+// again when it changes, so a key for the next rotation can be added ahead of
+// its activate_at without a restart.
+func NewFileKeyring(path string) (*Keyring, error) {
+	ring := &Keyring{path: path}
+	err := ring.reload()
+	if err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+func (ring *Keyring) SetKeys(keys []Key, overlap time.Duration) {
+	sorted := append([]Key(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ActivateAt.Before(sorted[j].ActivateAt) })
+	if overlap <= 0 {
+		overlap = DefaultOverlap
+	}
+
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	ring.keys = sorted
+	ring.overlap = overlap
+}
+
+// Sign signs the claims with the active key and names it in the kid header.
+func (ring *Keyring) Sign(claims jwt.Claims) (string, error) {
+	key, err := ring.signingKey(time.Now())
+	if err != nil {
+		return "", err
+	}
+	method := jwt.GetSigningMethod(key.Algorithm)
+	if method == nil {
+		return "", ErrUnsupportedAlgorithm
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Parse verifies the token against the key named by its kid. The algorithm
+// in the header must be one the keyring signs with and must match the key,
+// so a token cannot pick how it is verified.
+func (ring *Keyring) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	parser := jwt.Parser{ValidMethods: []string{RS256, EdDSA}}
+	return parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ring.verificationKey(kid, time.Now())
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, ErrAlgorithmMismatch
+		}
+		return key.Public, nil
+	})
+}
+
+// Keys returns the keys that currently verify tokens, including keys
+// scheduled to sign later.
+func (ring *Keyring) Keys() []Key {
+	ring.refresh()
+	now := time.Now()
+
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	var published []Key
+	for i, key := range ring.keys {
+		if ring.verifies(i, now) {
+			published = append(published, key)
+		}
+	}
+	return published
+}
+
+func (ring *Keyring) signingKey(now time.Time) (Key, error) {
+	ring.refresh()
+
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	for i := len(ring.keys) - 1; i >= 0; i-- {
+		key := ring.keys[i]
+		if key.Private != nil && !key.ActivateAt.After(now) {
+			return key, nil
+		}
+	}
+	return Key{}, ErrNoSigningKey
+}
+
+func (ring *Keyring) verificationKey(kid string, now time.Time) (Key, bool) {
+	if kid == "" {
+		return Key{}, false
+	}
+	ring.refresh()
+
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	for i, key := range ring.keys {
+		if key.ID == kid {
+			return key, ring.verifies(i, now)
+		}
+	}
+	return Key{}, false
+}
+
+// verifies reports whether the i-th key is still accepted: it was not
+// replaced by a newer active key, or was replaced less than the overlap ago.
+// Callers hold the read lock.
+func (ring *Keyring) verifies(i int, now time.Time) bool {
+	for _, newer := range ring.keys[i+1:] {
+		if newer.Private != nil && !newer.ActivateAt.After(now) {
+			return now.Before(newer.ActivateAt.Add(ring.overlap))
+		}
+	}
+	return true
+}
+
+// refresh reloads a file keyring when its file changed. A file that no
+// longer loads keeps the previous keys in service.
+func (ring *Keyring) refresh() {
+	if ring.path == "" {
+		return
+	}
+	ring.mu.RLock()
+	due := time.Since(ring.checked) >= reloadInterval
+	ring.mu.RUnlock()
+	if !due {
+		return
+	}
+	if err := ring.reload(); err != nil {
+		logrus.WithField("path", ring.path).WithError(err).Error("Error reloading signing keys")
+	}
+}
+
+func (ring *Keyring) reload() error {
+	info, err := os.Stat(ring.path)
+	if err != nil {
+		ring.markChecked()
+		return err
+	}
+
+	ring.mu.RLock()
+	unchanged := info.ModTime().Equal(ring.modified)
+	ring.mu.RUnlock()
+	if unchanged {
+		ring.markChecked()
+		return nil
+	}
+
+	keys, overlap, err := loadManifest(ring.path)
+	if err != nil {
+		ring.markChecked()
+		return err
+	}
+	ring.SetKeys(keys, overlap)
+
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	ring.modified = info.ModTime()
+	ring.checked = time.Now()
+	return nil
+}
+
+func (ring *Keyring) markChecked() {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	ring.checked = time.Now()
+}
+
+type manifest struct {
+	Overlap string          `json:"overlap"`
+	Keys    []manifestEntry `json:"keys"`
+}
+
+type manifestEntry struct {
+	ID         string    `json:"kid"`
+	Algorithm  string    `json:"alg"`
+	PrivateKey string    `json:"private_key"`
+	PublicKey  string    `json:"public_key"`
+	ActivateAt time.Time `json:"activate_at"`
+}
+
+// loadManifest reads the keys listed in a manifest. Key paths are relative
+// to the manifest and hold PEM encoded PKCS#8 private keys or PKIX public
+// keys.
+func loadManifest(path string) ([]Key, time.Duration, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	var file manifest
+	err = json.Unmarshal(raw, &file)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	overlap := DefaultOverlap
+	if file.Overlap != "" {
+		overlap, err = time.ParseDuration(file.Overlap)
+		if err != nil {
+			return nil, 0, fmt.Errorf("overlap: %w", err)
+		}
+	}
+
+	dir := filepath.Dir(path)
+	seen := map[string]bool{}
+	keys := make([]Key, 0, len(file.Keys))
+	for _, entry := range file.Keys {
+		if entry.ID == "" || seen[entry.ID] {
+			return nil, 0, fmt.Errorf("key %q: kid must be set and unique", entry.ID)
+		}
+		seen[entry.ID] = true
+
+		key := Key{ID: entry.ID, Algorithm: entry.Algorithm, ActivateAt: entry.ActivateAt}
+		switch {
+		case entry.PrivateKey != "":
+			key.Private, err = readPrivateKey(resolve(dir, entry.PrivateKey))
+			if err == nil {
+				key.Public = key.Private.Public()
+			}
+		case entry.PublicKey != "":
+			key.Public, err = readPublicKey(resolve(dir, entry.PublicKey))
+		default:
+			err = errors.New("private_key or public_key is required")
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("key %q: %w", entry.ID, err)
+		}
+		if !matchesAlgorithm(key.Algorithm, key.Public) {
+			return nil, 0, fmt.Errorf("key %q: %w", entry.ID, ErrAlgorithmMismatch)
+		}
+		keys = append(keys, key)
+	}
+	return keys, overlap, nil
+}
+
+func resolve(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+func readPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	return signer, nil
+}
+
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	return block, nil
+}
+
+func matchesAlgorithm(algorithm string, public crypto.PublicKey) bool {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return algorithm == RS256
+	case ed25519.PublicKey:
+		return algorithm == EdDSA
+	}
+	return false
+}
+
+// Default is the keyring session tokens are signed with. It starts with a key
+// generated at startup, so tokens do not survive a restart and cannot be
+// verified by other instances until a keyring file is loaded.
+var Default = NewKeyring(nil, DefaultOverlap)
+
+func init() {
+	key, err := Generate("ephemeral", EdDSA, time.Now())
+	if err != nil {
+		panic(err)
+	}
+	Default.SetKeys([]Key{key}, DefaultOverlap)
+}
@@ -0,0 +1,208 @@
+package limits
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/grey/models"
+	"github.com/shopspring/decimal"
+)
+
+var ErrLimitExceeded = errors.New("transaction limit exceeded")
+
+// Code tells clients which limit stopped a payment.
+type Code string
+
+const (
+	PerTransactionCode Code = "LIMIT_PER_TRANSACTION"
+	DailyCode          Code = "LIMIT_DAILY"
+	MonthlyCode        Code = "LIMIT_MONTHLY"
+	VelocityCode       Code = "LIMIT_VELOCITY"
+)
+
+// Error is a payment refused by a limit. Remaining is what the limit still
+// allows: an amount for amount limits, a number of payments for velocity.
+type Error struct {
+	Code            Code
+	TransactionType models.TransactionType
+	Currency        string
+	Limit           decimal.Decimal
+	Remaining       decimal.Decimal
+	Window          time.Duration
+}
+
+func (err *Error) Error() string {
+	if err.Code == VelocityCode {
+		return fmt.Sprintf("%s: at most %s %s payments per %s", ErrLimitExceeded, err.Limit, err.TransactionType, err.Window)
+	}
+	if err.Code == PerTransactionCode {
+		return fmt.Sprintf("%s: at most %s %s per %s payment", ErrLimitExceeded, err.Limit, err.Currency, err.TransactionType)
+	}
+	period := "daily"
+	if err.Code == MonthlyCode {
+		period = "monthly"
+	}
+	return fmt.Sprintf("%s: %s %s limit is %s %s, %s remaining", ErrLimitExceeded, period, err.TransactionType, err.Limit, err.Currency, err.Remaining)
+}
+
+func (err *Error) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// Duration reads "10m" style durations from JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(raw []byte) error {
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if parsed <= 0 {
+		return fmt.Errorf("window must be positive, got %s", value)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// AmountLimit caps the amounts of one currency. Daily and Monthly add up the
+// payments since the start of the UTC day and month. Zero means no limit.
+type AmountLimit struct {
+	PerTransaction decimal.Decimal `json:"per_transaction"`
+	Daily          decimal.Decimal `json:"daily"`
+	Monthly        decimal.Decimal `json:"monthly"`
+}
+
+// Velocity allows at most Count payments in any Window, in any currency.
+type Velocity struct {
+	Count  int      `json:"count"`
+	Window Duration `json:"window"`
+}
+
+// Rule is what one KYC tier may do with one transaction type. Amounts are
+// keyed by currency; currencies without an entry are not capped.
+type Rule struct {
+	Amounts  map[string]AmountLimit `json:"amounts"`
+	Velocity []Velocity             `json:"velocity,omitempty"`
+}
+
+// Usage is what the user already did that counts against a rule: the amounts
+// paid today and this month in the payment currency, and the number of
+// payments in each velocity window.
+type Usage struct {
+	Daily   decimal.Decimal
+	Monthly decimal.Decimal
+	Counts  map[time.Duration]int64
+}
+
+// Check returns an *Error for the first limit the payment would break.
+func (rule Rule) Check(transactionType models.TransactionType, amount decimal.Decimal, currency string, usage Usage) error {
+	if limit, ok := rule.Amounts[currency]; ok {
+		refuse := func(code Code, max, used decimal.Decimal) error {
+			return &Error{
+				Code:            code,
+				TransactionType: transactionType,
+				Currency:        currency,
+				Limit:           max,
+				Remaining:       decimal.Max(max.Sub(used), decimal.Zero),
+			}
+		}
+		if limit.PerTransaction.IsPositive() && amount.GreaterThan(limit.PerTransaction) {
+			return refuse(PerTransactionCode, limit.PerTransaction, decimal.Zero)
+		}
+		if limit.Daily.IsPositive() && usage.Daily.Add(amount).GreaterThan(limit.Daily) {
+			return refuse(DailyCode, limit.Daily, usage.Daily)
+		}
+		if limit.Monthly.IsPositive() && usage.Monthly.Add(amount).GreaterThan(limit.Monthly) {
+			return refuse(MonthlyCode, limit.Monthly, usage.Monthly)
+		}
+	}
+
+	for _, velocity := range rule.Velocity {
+		window := time.Duration(velocity.Window)
+		if usage.Counts[window] >= int64(velocity.Count) {
+			return &Error{
+				Code:            VelocityCode,
+				TransactionType: transactionType,
+				Limit:           decimal.NewFromInt(int64(velocity.Count)),
+				Remaining:       decimal.Zero,
+				Window:          window,
+			}
+		}
+	}
+	return nil
+}
+
+// Policy maps KYC tiers to the rules of each transaction type. Types without
+// a rule in the user's tier are not limited.
+type Policy map[int]map[models.TransactionType]Rule
+
+// LoadPolicy reads a policy from a JSON file keyed by tier, then by
+// transaction type.
+func LoadPolicy(path string) (Policy, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy Policy
+	err = json.Unmarshal(raw, &policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+type Engine struct {
+	mu     sync.RWMutex
+	policy Policy
+}
+
+func NewEngine(policy Policy) *Engine {
+	if policy == nil {
+		policy = Policy{}
+	}
+	return &Engine{policy: policy}
+}
+
+func (engine *Engine) SetPolicy(policy Policy) {
+	if policy == nil {
+		policy = Policy{}
+	}
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	engine.policy = policy
+}
+
+// Rule returns the rule of the transaction type for the tier.
+func (engine *Engine) Rule(tier int, transactionType models.TransactionType) (Rule, bool) {
+	engine.mu.RLock()
+	defer engine.mu.RUnlock()
+	rule, ok := engine.policy[tier][transactionType]
+	return rule, ok
+}
+
+// Rules returns every rule of the tier.
+func (engine *Engine) Rules(tier int) map[models.TransactionType]Rule {
+	engine.mu.RLock()
+	defer engine.mu.RUnlock()
+	rules := make(map[models.TransactionType]Rule, len(engine.policy[tier]))
+	for transactionType, rule := range engine.policy[tier] {
+		rules[transactionType] = rule
+	}
+	return rules
+}
+
+// Default is the engine payments are checked against. It starts with an
+// empty policy, so nothing is limited until one is loaded.
+var Default = NewEngine(nil)
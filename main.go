@@ -6,12 +6,20 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/grey/database"
+	"github.com/grey/fees"
+	"github.com/grey/fx"
+	"github.com/grey/keys"
+	"github.com/grey/limits"
 	"github.com/grey/models"
+	"github.com/grey/notify"
+	"github.com/grey/providers"
 	"github.com/grey/routers"
+	"github.com/grey/service"
 )
 
 func main() {
@@ -27,9 +35,141 @@ func main() {
 			&models.Account{},
 			&models.Payment{},
 			&models.LedgerEntry{},
+			&models.PaymentStatusHistory{},
+			&models.IdempotencyKey{},
+			&models.OutboxEvent{},
+			&models.WebhookSubscription{},
+			&models.WebhookDelivery{},
+			&models.WebhookDeliveryAttempt{},
+			&models.FXQuote{},
+			&models.Hold{},
+			&models.PaymentSchedule{},
+			&models.ScheduleRun{},
+			&models.PayoutBatch{},
+			&models.PayoutBatchItem{},
+			&models.Session{},
+			&models.RefreshToken{},
+			&models.RevokedToken{},
+			&models.RecoveryCode{},
+			&models.LoginAttempt{},
+			&models.UserToken{},
 		},
 	}
+	// users who registered before emails were verified are grandfathered in
+	verifyExistingEmails := !db.Migrator().HasColumn(&models.User{}, "EmailVerifiedAt")
 	database.RunMigrations(migrations)
+	if verifyExistingEmails {
+		err := models.VerifyExistingEmails(context.Background(), db)
+		if err != nil {
+			log.Fatalf("Failed to verify existing emails: %v", err)
+		}
+	}
+
+	// money that moved before the ledger gets balanced journals
+	err := service.MigrateLegacyLedger(context.Background(), db)
+	if err != nil {
+		log.Fatalf("Failed to migrate the legacy ledger: %v", err)
+	}
+
+	// payout providers, simulated until real integrations are configured
+	outcome, ok := providers.ParsePayoutStatus(os.Getenv("PAYOUT_SIMULATOR_OUTCOME"))
+	if !ok {
+		outcome = providers.PayoutSucceeded
+	}
+	webhookSecret := os.Getenv("PAYOUT_WEBHOOK_SECRET")
+	providers.Register(models.BankTransfer, providers.NewSimulator("bank_simulator", outcome, webhookSecret))
+	providers.Register(models.MobileMoney, providers.NewSimulator("mobile_money_simulator", outcome, webhookSecret))
+
+	// exchange rates come from a feed file; without one conversions are refused
+	spreadBps, err := strconv.ParseInt(os.Getenv("FX_SPREAD_BPS"), 10, 64)
+	if err != nil {
+		spreadBps = 50
+	}
+	quoteTTL, err := time.ParseDuration(os.Getenv("FX_QUOTE_TTL"))
+	if err != nil {
+		quoteTTL = time.Minute
+	}
+	if ratesFile := os.Getenv("FX_RATES_FILE"); ratesFile != "" {
+		fx.Default = fx.NewPricer(fx.NewFileRates(ratesFile, 24*time.Hour), spreadBps, quoteTTL)
+	}
+
+	// payments are free unless a fee schedule is configured
+	if scheduleFile := os.Getenv("FEE_SCHEDULE_FILE"); scheduleFile != "" {
+		schedule, err := fees.LoadSchedule(scheduleFile)
+		if err != nil {
+			log.Fatalf("Failed to load fee schedule: %v", err)
+		}
+		fees.Default.SetSchedule(schedule)
+	}
+
+	// nothing is limited unless a limits policy is configured
+	if limitsFile := os.Getenv("LIMITS_FILE"); limitsFile != "" {
+		policy, err := limits.LoadPolicy(limitsFile)
+		if err != nil {
+			log.Fatalf("Failed to load limits policy: %v", err)
+		}
+		limits.Default.SetPolicy(policy)
+	}
+
+	if holdTTL, err := time.ParseDuration(os.Getenv("HOLD_TTL")); err == nil && holdTTL > 0 {
+		service.DefaultHoldTTL = holdTTL
+	}
+
+	// session tokens are signed with a throwaway key unless a keyring is configured
+	if keysFile := os.Getenv("JWT_KEYS_FILE"); keysFile != "" {
+		keyring, err := keys.NewFileKeyring(keysFile)
+		if err != nil {
+			log.Fatalf("Failed to load signing keys: %v", err)
+		}
+		keys.Default = keyring
+	}
+
+	// payments above these amounts need a verification code
+	if stepUp := os.Getenv("MFA_STEP_UP_AMOUNTS"); stepUp != "" {
+		amounts, err := service.ParseStepUpAmounts(stepUp)
+		if err != nil {
+			log.Fatalf("Failed to parse step-up amounts: %v", err)
+		}
+		service.StepUpAmounts = amounts
+	}
+
+	if pinLock, err := time.ParseDuration(os.Getenv("PIN_LOCK_DURATION")); err == nil && pinLock > 0 {
+		service.PINLockDuration = pinLock
+	}
+
+	if mfaLock, err := time.ParseDuration(os.Getenv("MFA_LOCK_DURATION")); err == nil && mfaLock > 0 {
+		service.MFALockDuration = mfaLock
+	}
+
+	if resetTTL, err := time.ParseDuration(os.Getenv("PASSWORD_RESET_TTL")); err == nil && resetTTL > 0 {
+		service.PasswordResetTTL = resetTTL
+	}
+	service.PasswordResetURL = os.Getenv("PASSWORD_RESET_URL")
+
+	if verifyTTL, err := time.ParseDuration(os.Getenv("EMAIL_VERIFICATION_TTL")); err == nil && verifyTTL > 0 {
+		service.EmailVerificationTTL = verifyTTL
+	}
+	service.EmailVerificationURL = os.Getenv("EMAIL_VERIFICATION_URL")
+
+	// emails go out over SMTP when configured, to files or the log otherwise
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		notify.Default = notify.SMTPMailer{
+			Addr:     smtpAddr,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+	} else if mailDir := os.Getenv("MAIL_DIR"); mailDir != "" {
+		notify.Default = notify.FileMailer{Dir: mailDir}
+	}
+
+	if refreshTTL, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil && refreshTTL > 0 {
+		service.RefreshTokenTTL = refreshTTL
+	}
+
+	if workers, err := strconv.Atoi(os.Getenv("PAYOUT_BATCH_WORKERS")); err == nil && workers > 0 {
+		service.PayoutBatchWorkers = workers
+	}
 
 	port := os.Getenv("SERVER_PORT")
 	if port == "" {
@@ -40,6 +180,33 @@ func main() {
 		Handler: routers.NewRouter(),
 	}
 
+	// background workers, stopped on shutdown
+	workers, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	// settle payouts whose webhook never arrived
+	go service.RunPayoutReconciler(workers, db, time.Minute)
+
+	// release holds nobody captured in time
+	go service.RunHoldExpirer(workers, db, time.Minute)
+
+	// pay scheduled and recurring payments as they fall due
+	go service.RunScheduler(workers, db, time.Minute)
+
+	// pay out accepted bulk payout batches
+	go service.RunPayoutBatcher(workers, db, 5*time.Second)
+
+	// forget revoked tokens once they have expired anyway
+	go service.RunRevocationPruner(workers, db, time.Hour)
+
+	// publish payment events and send reset emails written to the outbox
+	dispatcher := service.NewOutboxDispatcher(db, service.LogSink{}, service.NewWebhookSink(db), service.NewPasswordResetSink(db))
+	go dispatcher.Run(workers)
+
+	// send queued webhooks to subscriber endpoints
+	service.AllowPrivateWebhooks = os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS") == "true"
+	go service.NewWebhookDeliverer(db).Run(workers)
+
 	go func() {
 		// service connections
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -51,6 +218,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutdown Server ...")
+	stopWorkers()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
@@ -2,48 +2,71 @@ package middlewares
 
 import (
 	"encoding/json"
-	"os"
+	"errors"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
+	"github.com/grey/database"
+	"github.com/grey/keys"
+	"github.com/grey/models"
 )
 
 func SessionMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		bearerToken := c.Request.Header.Get("Authorization")
-		bearerToken = strings.ReplaceAll(bearerToken, "Bearer ", "")
+		authenticate(c, false)
+	}
+}
+
+// MFAPendingMiddleware accepts only the token issued after the password check
+// of a login that still needs its second factor.
+func MFAPendingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authenticate(c, true)
+	}
+}
 
-		if bearerToken == "" {
-			c.JSON(401, gin.H{"error": "Authorization token required"})
-			c.Next()
-			return
-		}
+func authenticate(c *gin.Context, mfaPending bool) {
+	bearerToken := c.Request.Header.Get("Authorization")
+	bearerToken = strings.ReplaceAll(bearerToken, "Bearer ", "")
 
-		claimPayload, err := ValidateSessionToken(bearerToken)
-		if err != nil {
-			c.JSON(401, gin.H{"error": "Invalid or expired token"})
-			return
-		}
+	if bearerToken == "" {
+		c.AbortWithStatusJSON(401, gin.H{"error": "Authorization token required"})
+		return
+	}
 
-		c.Set("x-claim-payload", claimPayload)
-		c.Set("x-token", bearerToken)
+	claimPayload, err := ValidateSessionToken(bearerToken)
+	if err != nil || claimPayload.JTI == "" || claimPayload.MFAPending != mfaPending {
+		c.AbortWithStatusJSON(401, gin.H{"error": "Invalid or expired token"})
+		return
+	}
 
-		c.Next()
+	// tokens revoked by logout or a compromised session are refused until
+	// they expire; when the list cannot be read the token is refused too
+	revoked, err := models.IsTokenRevoked(c.Request.Context(), database.Db, claimPayload.JTI)
+	if err != nil || revoked {
+		c.AbortWithStatusJSON(401, gin.H{"error": "Invalid or expired token"})
+		return
 	}
+
+	c.Set("x-claim-payload", claimPayload)
+	c.Set("x-token", bearerToken)
+
+	c.Next()
 }
 
+// ValidateSessionToken verifies the token with the key its kid names and
+// refuses tokens without an expiry.
 func ValidateSessionToken(tokenString string) (JwtSessionPayload, error) {
 
-	var jwtKey = []byte(os.Getenv("SECRET_JWT"))
 	claims := jwt.MapClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-
+	token, err := keys.Default.Parse(tokenString, claims)
 	if err != nil {
 		return JwtSessionPayload{}, err
 	}
+	if _, ok := claims["exp"]; !ok {
+		return JwtSessionPayload{}, errors.New("token has no expiry")
+	}
 
 	claims = token.Claims.(jwt.MapClaims)
 
@@ -0,0 +1,187 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grey/database"
+	"github.com/grey/models"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const IdempotencyHeader = "Idempotency-Key"
+
+// IdempotencyReservationTTL is how long a request can hold its key before a
+// retry with the same key and body may take it over, in case the request's
+// instance died before finishing it.
+var IdempotencyReservationTTL = 5 * time.Minute
+
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// IdempotencyMiddleware replays the stored response when a client retries a
+// request with the same Idempotency-Key header. Keys are scoped to the user in
+// the session claims, so it must run after SessionMiddleware. Requests without
+// the header pass through untouched.
+func IdempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		claimPayload, exists := c.Get("x-claim-payload")
+		session, _ := claimPayload.(JwtSessionPayload)
+		if !exists {
+			c.Next()
+			return
+		}
+
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "Idempotency-Key must be at most 255 characters",
+				"status":  http.StatusBadRequest,
+			})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "Could not read request body",
+				"status":  http.StatusBadRequest,
+			})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		requestHash := hashRequest(c.Request.Method, c.FullPath(), body)
+
+		record, err := models.FindIdempotencyKey(ctx, database.Db, session.UserID, key)
+		switch {
+		case err == nil:
+			reclaimed, err := reclaimIdempotencyKey(ctx, record, requestHash)
+			if err != nil {
+				abortIdempotencyFailure(c)
+				return
+			}
+			if !reclaimed {
+				replayIdempotentResponse(c, record, requestHash)
+				return
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			record = &models.IdempotencyKey{
+				UserID:      session.UserID,
+				Key:         key,
+				RequestHash: requestHash,
+			}
+			err = models.ReserveIdempotencyKey(ctx, database.Db, record)
+			if err != nil {
+				// a concurrent request with the same key got there first
+				existing, findErr := models.FindIdempotencyKey(ctx, database.Db, session.UserID, key)
+				if findErr != nil {
+					abortIdempotencyFailure(c)
+					return
+				}
+				replayIdempotentResponse(c, existing, requestHash)
+				return
+			}
+		default:
+			abortIdempotencyFailure(c)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		// the outcome is stored even when the client has gone away, since
+		// that client is the one that will retry
+		ctx = context.WithoutCancel(ctx)
+
+		// server errors are not final, let the client retry with the same key
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError || status == http.StatusUnauthorized {
+			err = models.ReleaseIdempotencyKey(ctx, database.Db, record)
+			if err != nil {
+				logrus.WithField("idempotency_key", key).WithError(err).Error("Error releasing idempotency key")
+			}
+			return
+		}
+		err = models.CompleteIdempotencyKey(ctx, database.Db, record, status, recorder.body.String())
+		if err != nil {
+			logrus.WithField("idempotency_key", key).WithError(err).Error("Error storing idempotent response")
+		}
+	}
+}
+
+// reclaimIdempotencyKey takes over the key when its request has been in
+// flight for longer than IdempotencyReservationTTL and this is a retry of it.
+func reclaimIdempotencyKey(ctx context.Context, record *models.IdempotencyKey, requestHash string) (bool, error) {
+	if record.ResponseStatus != 0 || record.RequestHash != requestHash {
+		return false, nil
+	}
+	staleBefore := time.Now().Add(-IdempotencyReservationTTL)
+	if !record.UpdatedAt.Before(staleBefore) {
+		return false, nil
+	}
+	return models.ReclaimIdempotencyKey(ctx, database.Db, record, staleBefore)
+}
+
+func replayIdempotentResponse(c *gin.Context, record *models.IdempotencyKey, requestHash string) {
+	if record.RequestHash != requestHash {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "Idempotency-Key was already used with a different request",
+			"status":  http.StatusUnprocessableEntity,
+		})
+		return
+	}
+
+	if record.ResponseStatus == 0 {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"message": "A request with this Idempotency-Key is still being processed",
+			"status":  http.StatusConflict,
+		})
+		return
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(record.ResponseStatus, "application/json; charset=utf-8", []byte(record.ResponseBody))
+	c.Abort()
+}
+
+func abortIdempotencyFailure(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+		"message": "We couldn't check your Idempotency-Key at this time. Please try again later.",
+		"status":  http.StatusInternalServerError,
+	})
+}
+
+func hashRequest(method, path string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method + " " + path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -9,4 +9,9 @@ type JwtSessionPayload struct {
 	Authorized bool   `json:"authorized" binding:"required"`
 	Exp        int    `json:"exp" binding:"required"`
 	UserID     string `json:"user_id" binding:"required"`
+	JTI        string `json:"jti" binding:"required"`
+	SessionID  string `json:"sid"`
+	// MFAPending marks the token issued between the password check and the
+	// second factor. Only MFAPendingMiddleware accepts it.
+	MFAPending bool `json:"mfa_pending"`
 }
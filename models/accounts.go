@@ -7,22 +7,99 @@ import (
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Account struct {
 	ID        int             `json:"id" gorm:"type:integer;primaryKey"`
-	UserID    int             `json:"user_id" gorm:"not null;index"`
-	AccountID string          `json:"account_id" gorm:"type:uuid;not null;index"` // account number
-	Currency  string          `json:"currency" gorm:"type:varchar(3);not null"`
+	UserID    int             `json:"user_id" gorm:"not null;index;uniqueIndex:idx_accounts_user_currency,where:user_id <> 0"`
+	AccountID string          `json:"account_id" gorm:"type:uuid;not null;uniqueIndex:idx_accounts_account_id_unique"` // account number
+	Currency  string          `json:"currency" gorm:"type:varchar(3);not null;uniqueIndex:idx_accounts_user_currency"`
 	Balance   decimal.Decimal `json:"balance" gorm:"type:numeric(18,2);not null;default:0"`
-	CreatedAt time.Time
+	// HeldBalance is the part of Balance reserved by active authorization
+	// holds. Balance is the ledger balance, Balance - HeldBalance what can be
+	// spent.
+	HeldBalance      decimal.Decimal `json:"held_balance" gorm:"type:numeric(18,2);not null;default:0"`
+	AvailableBalance decimal.Decimal `json:"available_balance" gorm:"-"`
+	CreatedAt        time.Time
 }
 
+type SystemAccountKind string
+
+const (
+	// ExternalClearing holds funds sent out through bank and mobile money payouts.
+	ExternalClearing SystemAccountKind = "external_clearing"
+	// TopUpFunding is the counterpart of every top-up credited to a wallet.
+	TopUpFunding SystemAccountKind = "topup_funding"
+	// FXPosition takes one currency in and pays the other out on every
+	// conversion, so its balances show the exposure per currency.
+	FXPosition SystemAccountKind = "fx_position"
+	// FeeRevenue collects the fees charged on payments.
+	FeeRevenue SystemAccountKind = "fee_revenue"
+	// OpeningEquity is the counterpart of the opening balances of accounts
+	// that held money before the ledger existed.
+	OpeningEquity SystemAccountKind = "opening_equity"
+)
+
+// systemAccountNamespace seeds the deterministic ids of system accounts so
+// every instance resolves the same account for a kind and currency.
+var systemAccountNamespace = uuid.MustParse("6f1c3c52-5d0e-4d8a-9a43-0d6f3b1b6a10")
+
 func (account *Account) BeforeCreate(tx *gorm.DB) error {
-	account.AccountID = uuid.NewString()
+	if account.AccountID == "" {
+		account.AccountID = uuid.NewString()
+	}
+	return nil
+}
+
+func (account *Account) AfterFind(tx *gorm.DB) error {
+	account.AvailableBalance = account.Balance.Sub(account.HeldBalance)
 	return nil
 }
 
+func (account *Account) AfterCreate(tx *gorm.DB) error {
+	return account.AfterFind(tx)
+}
+
+func SystemAccountID(kind SystemAccountKind, currency string) string {
+	return uuid.NewSHA1(systemAccountNamespace, []byte(string(kind)+":"+currency)).String()
+}
+
+var systemAccountKinds = []SystemAccountKind{ExternalClearing, TopUpFunding, FXPosition, FeeRevenue, OpeningEquity}
+
+func IsSystemAccount(accountID, currency string) bool {
+	for _, kind := range systemAccountKinds {
+		if SystemAccountID(kind, currency) == accountID {
+			return true
+		}
+	}
+	return false
+}
+
+// EnsureSystemAccount returns the id of the system account for the kind and
+// currency, creating it on first use. System accounts belong to no user and
+// may run a negative balance. Concurrent first uses insert the same id, the
+// unique account id lets all but one of them do nothing.
+func EnsureSystemAccount(ctx context.Context, db *gorm.DB, kind SystemAccountKind, currency string) (string, error) {
+	account := Account{
+		AccountID: SystemAccountID(kind, currency),
+		Currency:  currency,
+		Balance:   decimal.Zero,
+	}
+	err := db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "account_id"}},
+		DoNothing: true,
+	}).Create(&account).Error
+	if err != nil {
+		return "", err
+	}
+	err = db.WithContext(ctx).Where("account_id = ?", account.AccountID).First(&account).Error
+	if err != nil {
+		return "", err
+	}
+	return account.AccountID, nil
+}
+
 func CreateAccount(ctx context.Context, db *gorm.DB, account *Account) (err error) {
 	err = db.WithContext(ctx).Create(&account).Error
 	if err != nil {
@@ -31,6 +108,16 @@ func CreateAccount(ctx context.Context, db *gorm.DB, account *Account) (err erro
 	return nil
 }
 
+// CreateUserAccount creates the customer account unless its user already holds
+// one in the currency, in which case it reports false and creates nothing.
+func CreateUserAccount(ctx context.Context, db *gorm.DB, account *Account) (bool, error) {
+	result := db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(account)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
 func IsAccountExists(ctx context.Context, db *gorm.DB, uuid string) (*Account, error) {
 	var account Account
 	err := db.Debug().WithContext(ctx).Model(&account).Where("account_id = ?", uuid).First(&account).Error
@@ -40,6 +127,37 @@ func IsAccountExists(ctx context.Context, db *gorm.DB, uuid string) (*Account, e
 	return &account, nil
 }
 
+// LockAccount reads the account and locks its row until the transaction ends.
+func LockAccount(ctx context.Context, tx *gorm.DB, accountID string) (*Account, error) {
+	var account Account
+	err := tx.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Where("account_id = ?", accountID).First(&account).Error
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
 func UpdateAccountBalance(ctx context.Context, db *gorm.DB, accountID string, newBalance decimal.Decimal) error {
 	return db.WithContext(ctx).Model(&Account{}).Where("account_id = ?", accountID).Update("balance", newBalance).Error
 }
+
+// UserAccounts returns the user's accounts, oldest first.
+func UserAccounts(ctx context.Context, db *gorm.DB, userID int) ([]Account, error) {
+	var accounts []Account
+	err := db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&accounts).Error
+	if err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// FindUserAccount returns the user's account in the currency, or
+// gorm.ErrRecordNotFound when the user has none.
+func FindUserAccount(ctx context.Context, db *gorm.DB, userID int, currency string) (*Account, error) {
+	var account Account
+	err := db.WithContext(ctx).Where("user_id = ? AND currency = ?", userID, currency).First(&account).Error
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
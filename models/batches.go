@@ -0,0 +1,186 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+type PayoutBatchStatus string
+
+const (
+	BatchProcessing PayoutBatchStatus = "processing"
+	BatchCompleted  PayoutBatchStatus = "completed"
+	// BatchCompletedWithErrors batches finished with at least one failed item
+	BatchCompletedWithErrors PayoutBatchStatus = "completed_with_errors"
+)
+
+type PayoutItemStatus string
+
+const (
+	PayoutItemPending    PayoutItemStatus = "pending"
+	PayoutItemProcessing PayoutItemStatus = "processing"
+	PayoutItemSucceeded  PayoutItemStatus = "succeeded"
+	PayoutItemFailed     PayoutItemStatus = "failed"
+)
+
+// PayoutBatch is a set of external payouts from one account. The amounts and
+// fees of all items are reserved in the account's HeldBalance when the batch
+// is accepted and released item by item as the payouts are made.
+type PayoutBatch struct {
+	ID          int               `json:"-" gorm:"type:integer;primaryKey"`
+	BatchID     string            `json:"batch_id" gorm:"type:uuid;not null;uniqueIndex"`
+	UserID      int               `json:"-" gorm:"not null;index"`
+	FromAccount string            `json:"from_account" gorm:"type:uuid;not null"`
+	Currency    string            `json:"currency" gorm:"type:varchar(3);not null"`
+	ItemCount   int               `json:"item_count"`
+	TotalAmount decimal.Decimal   `json:"total_amount" gorm:"type:numeric(18,2);not null"`
+	TotalFees   decimal.Decimal   `json:"total_fees" gorm:"type:numeric(18,2);not null;default:0"`
+	Succeeded   int               `json:"succeeded"`
+	Failed      int               `json:"failed"`
+	Status      PayoutBatchStatus `json:"status" gorm:"type:varchar(30);not null;index"`
+	CreatedAt   time.Time         `json:"created_at"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+}
+
+// PayoutBatchItem is one row of a batch. Reserved is what the batch holds for
+// it: the amount plus the fee quoted when the batch was accepted.
+type PayoutBatchItem struct {
+	ID              int              `json:"-" gorm:"type:integer;primaryKey"`
+	BatchID         string           `json:"-" gorm:"type:uuid;not null;index"`
+	Line            int              `json:"line"`
+	Type            TransactionType  `json:"transaction_type" gorm:"type:varchar(20);not null"`
+	RecipientNumber string           `json:"recipient_number" gorm:"type:varchar(64);not null"`
+	RecipientName   string           `json:"recipient_name" gorm:"type:varchar(255)"`
+	Reference       string           `json:"reference,omitempty" gorm:"type:varchar(255)"`
+	Amount          decimal.Decimal  `json:"amount" gorm:"type:numeric(18,2);not null"`
+	Fee             decimal.Decimal  `json:"fee" gorm:"type:numeric(18,2);not null;default:0"`
+	Reserved        decimal.Decimal  `json:"-" gorm:"type:numeric(18,2);not null"`
+	Status          PayoutItemStatus `json:"status" gorm:"type:varchar(20);not null"`
+	PaymentID       string           `json:"payment_id,omitempty" gorm:"type:varchar(36)"`
+	Error           string           `json:"error,omitempty" gorm:"type:text"`
+	UpdatedAt       time.Time        `json:"updated_at"`
+}
+
+func (batch *PayoutBatch) BeforeCreate(tx *gorm.DB) error {
+	if batch.BatchID == "" {
+		batch.BatchID = uuid.NewString()
+	}
+	return nil
+}
+
+// CreatePayoutBatch stores the batch and its items as pending.
+func CreatePayoutBatch(ctx context.Context, db *gorm.DB, batch *PayoutBatch, items []PayoutBatchItem) error {
+	batch.Status = BatchProcessing
+	batch.ItemCount = len(items)
+	err := db.WithContext(ctx).Create(batch).Error
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		items[i].BatchID = batch.BatchID
+		items[i].Status = PayoutItemPending
+	}
+	return db.WithContext(ctx).CreateInBatches(items, 500).Error
+}
+
+func FindPayoutBatch(ctx context.Context, db *gorm.DB, batchID string) (PayoutBatch, error) {
+	var batch PayoutBatch
+	err := db.WithContext(ctx).Where("batch_id = ?", batchID).First(&batch).Error
+	return batch, err
+}
+
+// ProcessingPayoutBatches returns the batches that still have work, oldest first.
+func ProcessingPayoutBatches(ctx context.Context, db *gorm.DB, limit int) ([]PayoutBatch, error) {
+	var batches []PayoutBatch
+	err := db.WithContext(ctx).
+		Where("status = ?", BatchProcessing).
+		Order("id").
+		Limit(limit).
+		Find(&batches).Error
+	if err != nil {
+		return nil, err
+	}
+	return batches, nil
+}
+
+// PayoutBatchItems returns the items of a batch in file order, optionally
+// only those in the given status.
+func PayoutBatchItems(ctx context.Context, db *gorm.DB, batchID string, status PayoutItemStatus) ([]PayoutBatchItem, error) {
+	query := db.WithContext(ctx).Where("batch_id = ?", batchID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var items []PayoutBatchItem
+	err := query.Order("line").Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ClaimPayoutItem moves a pending item to processing. It reports false when
+// another worker took it first.
+func ClaimPayoutItem(ctx context.Context, db *gorm.DB, item *PayoutBatchItem) (bool, error) {
+	result := db.WithContext(ctx).Model(&PayoutBatchItem{}).
+		Where("id = ? AND status = ?", item.ID, PayoutItemPending).
+		Update("status", PayoutItemProcessing)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	item.Status = PayoutItemProcessing
+	return result.RowsAffected == 1, nil
+}
+
+func FinishPayoutItem(ctx context.Context, db *gorm.DB, item *PayoutBatchItem, status PayoutItemStatus, paymentID, cause string) error {
+	item.Status = status
+	item.PaymentID = paymentID
+	item.Error = cause
+	return db.WithContext(ctx).Model(item).Updates(map[string]interface{}{
+		"status":     status,
+		"payment_id": paymentID,
+		"error":      cause,
+	}).Error
+}
+
+// CompletePayoutBatch counts the outcome of the items and closes the batch.
+func CompletePayoutBatch(ctx context.Context, db *gorm.DB, batch *PayoutBatch) error {
+	var counts []struct {
+		Status PayoutItemStatus
+		Count  int
+	}
+	err := db.WithContext(ctx).Model(&PayoutBatchItem{}).
+		Select("status, count(*) as count").
+		Where("batch_id = ?", batch.BatchID).
+		Group("status").
+		Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+
+	batch.Succeeded, batch.Failed = 0, 0
+	for _, count := range counts {
+		switch count.Status {
+		case PayoutItemSucceeded:
+			batch.Succeeded = count.Count
+		case PayoutItemFailed:
+			batch.Failed = count.Count
+		}
+	}
+	batch.Status = BatchCompleted
+	if batch.Failed > 0 {
+		batch.Status = BatchCompletedWithErrors
+	}
+	now := time.Now()
+	batch.CompletedAt = &now
+
+	return db.WithContext(ctx).Model(batch).Updates(map[string]interface{}{
+		"succeeded":    batch.Succeeded,
+		"failed":       batch.Failed,
+		"status":       batch.Status,
+		"completed_at": now,
+	}).Error
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+var ErrUnsupportedCurrency = errors.New("unsupported currency")
+
+// Currency is an ISO 4217 currency the wallet can hold. MinorUnits is the
+// number of decimals an amount in the currency may carry.
+type Currency struct {
+	Code       string `json:"code"`
+	Name       string `json:"name"`
+	MinorUnits int32  `json:"minor_units"`
+}
+
+// currencies lists the currencies accounts can be opened in. Amounts are
+// stored as numeric(18,2), so currencies with three minor units (BHD, KWD,
+// ...) are left out until the columns are widened.
+var currencies = map[string]Currency{
+	"USD": {Code: "USD", Name: "US Dollar", MinorUnits: 2},
+	"EUR": {Code: "EUR", Name: "Euro", MinorUnits: 2},
+	"GBP": {Code: "GBP", Name: "Pound Sterling", MinorUnits: 2},
+	"CHF": {Code: "CHF", Name: "Swiss Franc", MinorUnits: 2},
+	"CAD": {Code: "CAD", Name: "Canadian Dollar", MinorUnits: 2},
+	"AUD": {Code: "AUD", Name: "Australian Dollar", MinorUnits: 2},
+	"JPY": {Code: "JPY", Name: "Yen", MinorUnits: 0},
+	"KES": {Code: "KES", Name: "Kenyan Shilling", MinorUnits: 2},
+	"NGN": {Code: "NGN", Name: "Naira", MinorUnits: 2},
+	"GHS": {Code: "GHS", Name: "Ghana Cedi", MinorUnits: 2},
+	"ZAR": {Code: "ZAR", Name: "Rand", MinorUnits: 2},
+	"UGX": {Code: "UGX", Name: "Uganda Shilling", MinorUnits: 0},
+	"TZS": {Code: "TZS", Name: "Tanzanian Shilling", MinorUnits: 2},
+	"XOF": {Code: "XOF", Name: "CFA Franc BCEAO", MinorUnits: 0},
+}
+
+func LookupCurrency(code string) (Currency, error) {
+	currency, ok := currencies[code]
+	if !ok {
+		return Currency{}, ErrUnsupportedCurrency
+	}
+	return currency, nil
+}
+
+// SupportedCurrencies returns the registry sorted by code.
+func SupportedCurrencies() []Currency {
+	list := make([]Currency, 0, len(currencies))
+	for _, currency := range currencies {
+		list = append(list, currency)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+	return list
+}
+
+// ValidAmount reports whether the amount has no more decimals than the
+// currency's minor units allow.
+func (currency Currency) ValidAmount(amount decimal.Decimal) bool {
+	return amount.Equal(amount.Round(currency.MinorUnits))
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+var ErrFXQuoteUnavailable = errors.New("fx quote expired or already used")
+
+// FXQuote locks an exchange rate for a conversion between two accounts until
+// ExpiresAt. A quote pays for exactly one payment.
+type FXQuote struct {
+	ID           int             `json:"-" gorm:"type:integer;primaryKey"`
+	QuoteID      string          `json:"quote_id" gorm:"type:uuid;not null;uniqueIndex"`
+	UserID       int             `json:"-" gorm:"not null;index"`
+	FromAccount  string          `json:"from_account" gorm:"type:uuid;not null"`
+	ToAccount    string          `json:"to_account" gorm:"type:uuid;not null"`
+	FromCurrency string          `json:"from_currency" gorm:"type:varchar(3);not null"`
+	ToCurrency   string          `json:"to_currency" gorm:"type:varchar(3);not null"`
+	FromAmount   decimal.Decimal `json:"from_amount" gorm:"type:numeric(18,2);not null"`
+	ToAmount     decimal.Decimal `json:"to_amount" gorm:"type:numeric(18,2);not null"`
+	MidRate      decimal.Decimal `json:"mid_rate" gorm:"type:numeric(20,10);not null"`
+	Rate         decimal.Decimal `json:"rate" gorm:"type:numeric(20,10);not null"`
+	SpreadBps    int64           `json:"spread_bps"`
+	Source       string          `json:"source" gorm:"type:varchar(32)"`
+	ExpiresAt    time.Time       `json:"expires_at"`
+	UsedAt       *time.Time      `json:"used_at,omitempty"`
+	PaymentID    string          `json:"payment_id,omitempty" gorm:"type:varchar(36)"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+func (quote *FXQuote) BeforeCreate(tx *gorm.DB) error {
+	if quote.QuoteID == "" {
+		quote.QuoteID = uuid.NewString()
+	}
+	return nil
+}
+
+func CreateFXQuote(ctx context.Context, db *gorm.DB, quote *FXQuote) error {
+	return db.WithContext(ctx).Create(quote).Error
+}
+
+func FindFXQuote(ctx context.Context, db *gorm.DB, quoteID string) (FXQuote, error) {
+	var quote FXQuote
+	err := db.WithContext(ctx).Where("quote_id = ?", quoteID).First(&quote).Error
+	return quote, err
+}
+
+// ConsumeFXQuote marks the quote used by the payment. It fails when the quote
+// has expired or another payment got to it first.
+func ConsumeFXQuote(ctx context.Context, db *gorm.DB, quote *FXQuote, paymentID string) error {
+	now := time.Now()
+	result := db.WithContext(ctx).Model(&FXQuote{}).
+		Where("quote_id = ? AND used_at IS NULL AND expires_at > ?", quote.QuoteID, now).
+		Updates(map[string]interface{}{"used_at": now, "payment_id": paymentID})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return ErrFXQuoteUnavailable
+	}
+	quote.UsedAt = &now
+	quote.PaymentID = paymentID
+	return nil
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+type HoldStatus string
+
+const (
+	HoldActive   HoldStatus = "active"
+	HoldCaptured HoldStatus = "captured"
+	HoldVoided   HoldStatus = "voided"
+	HoldExpired  HoldStatus = "expired"
+)
+
+var ErrHoldNotActive = errors.New("hold is no longer active")
+
+// Hold reserves funds on an account for a later capture by the payee. The
+// reserved amount counts in the account's HeldBalance until the hold is
+// captured, voided or expires; it never touches the ledger on its own.
+type Hold struct {
+	ID             int             `json:"-" gorm:"type:integer;primaryKey"`
+	HoldID         string          `json:"hold_id" gorm:"type:uuid;not null;uniqueIndex"`
+	AccountID      string          `json:"account_id" gorm:"type:uuid;not null;index"`
+	PayeeAccount   string          `json:"payee_account" gorm:"type:uuid;not null;index"`
+	Currency       string          `json:"currency" gorm:"type:varchar(3);not null"`
+	Amount         decimal.Decimal `json:"amount" gorm:"type:numeric(18,2);not null"`
+	CapturedAmount decimal.Decimal `json:"captured_amount" gorm:"type:numeric(18,2);not null;default:0"`
+	Status         HoldStatus      `json:"status" gorm:"type:varchar(20);not null;index:idx_hold_expiry"`
+	Description    string          `json:"description" gorm:"type:text"`
+	PaymentID      string          `json:"payment_id,omitempty" gorm:"type:varchar(36)"`
+	ExpiresAt      time.Time       `json:"expires_at" gorm:"index:idx_hold_expiry"`
+	ClosedAt       *time.Time      `json:"closed_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+func (hold *Hold) BeforeCreate(tx *gorm.DB) error {
+	if hold.HoldID == "" {
+		hold.HoldID = uuid.NewString()
+	}
+	return nil
+}
+
+func CreateHold(ctx context.Context, db *gorm.DB, hold *Hold) error {
+	hold.Status = HoldActive
+	return db.WithContext(ctx).Create(hold).Error
+}
+
+func FindHold(ctx context.Context, db *gorm.DB, holdID string) (Hold, error) {
+	var hold Hold
+	err := db.WithContext(ctx).Where("hold_id = ?", holdID).First(&hold).Error
+	return hold, err
+}
+
+// CloseHold moves an active hold to its final status. Only one caller can
+// close a hold, everyone else gets ErrHoldNotActive.
+func CloseHold(ctx context.Context, db *gorm.DB, hold *Hold, to HoldStatus, captured decimal.Decimal, paymentID string) error {
+	now := time.Now()
+	result := db.WithContext(ctx).Model(&Hold{}).
+		Where("hold_id = ? AND status = ?", hold.HoldID, HoldActive).
+		Updates(map[string]interface{}{
+			"status":          to,
+			"captured_amount": captured,
+			"payment_id":      paymentID,
+			"closed_at":       now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return ErrHoldNotActive
+	}
+	hold.Status = to
+	hold.CapturedAmount = captured
+	hold.PaymentID = paymentID
+	hold.ClosedAt = &now
+	return nil
+}
+
+// ExpiredHolds returns active holds whose time ran out, oldest first.
+func ExpiredHolds(ctx context.Context, db *gorm.DB, limit int) ([]Hold, error) {
+	var holds []Hold
+	err := db.WithContext(ctx).
+		Where("status = ? AND expires_at <= ?", HoldActive, time.Now()).
+		Order("expires_at").
+		Limit(limit).
+		Find(&holds).Error
+	if err != nil {
+		return nil, err
+	}
+	return holds, nil
+}
+
+// userOpenHolds selects the active holds on any account of the user placed
+// since the given time.
+func userOpenHolds(ctx context.Context, db *gorm.DB, userID int, since time.Time) *gorm.DB {
+	return db.WithContext(ctx).Model(&Hold{}).
+		Where("account_id IN (?)", db.Model(&Account{}).Select("account_id").Where("user_id = ?", userID)).
+		Where("status = ? AND created_at >= ?", HoldActive, since)
+}
+
+// OpenHoldVolume adds up the amounts of the user's active holds in the
+// currency placed since the given time.
+func OpenHoldVolume(ctx context.Context, db *gorm.DB, userID int, currency string, since time.Time) (decimal.Decimal, error) {
+	var total struct {
+		Total decimal.NullDecimal
+	}
+	err := userOpenHolds(ctx, db, userID, since).
+		Where("currency = ?", currency).
+		Select("SUM(amount) AS total").
+		Scan(&total).Error
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if !total.Total.Valid {
+		return decimal.Zero, nil
+	}
+	return total.Total.Decimal, nil
+}
+
+// OpenHoldCount counts the user's active holds placed since the given time.
+func OpenHoldCount(ctx context.Context, db *gorm.DB, userID int, since time.Time) (int64, error) {
+	var count int64
+	err := userOpenHolds(ctx, db, userID, since).Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// IdempotencyKey remembers the response of a request so a client retrying
+// with the same Idempotency-Key header gets it back instead of a new payment.
+// A ResponseStatus of zero means the original request is still in flight.
+type IdempotencyKey struct {
+	ID             int    `json:"id" gorm:"type:integer;primaryKey"`
+	UserID         string `json:"user_id" gorm:"type:varchar(64);not null;uniqueIndex:idx_idempotency_user_key"`
+	Key            string `json:"key" gorm:"column:idempotency_key;type:varchar(255);not null;uniqueIndex:idx_idempotency_user_key"`
+	RequestHash    string `json:"request_hash" gorm:"type:varchar(64);not null"`
+	ResponseStatus int    `json:"response_status" gorm:"not null;default:0"`
+	ResponseBody   string `json:"response_body" gorm:"type:text"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func FindIdempotencyKey(ctx context.Context, db *gorm.DB, userID, key string) (*IdempotencyKey, error) {
+	var record IdempotencyKey
+	err := db.WithContext(ctx).Where("user_id = ? AND idempotency_key = ?", userID, key).First(&record).Error
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// ReserveIdempotencyKey claims the key for a new request. It fails with a
+// unique constraint error when another request already holds the key.
+func ReserveIdempotencyKey(ctx context.Context, db *gorm.DB, record *IdempotencyKey) error {
+	return db.WithContext(ctx).Create(record).Error
+}
+
+// ReclaimIdempotencyKey takes over a key whose request has been in flight
+// since before staleBefore, such as one whose instance died. It reports false
+// when the key was finished or reclaimed by another request meanwhile.
+func ReclaimIdempotencyKey(ctx context.Context, db *gorm.DB, record *IdempotencyKey, staleBefore time.Time) (bool, error) {
+	result := db.WithContext(ctx).Model(&IdempotencyKey{}).
+		Where("id = ? AND response_status = 0 AND updated_at < ?", record.ID, staleBefore).
+		Update("updated_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+func CompleteIdempotencyKey(ctx context.Context, db *gorm.DB, record *IdempotencyKey, status int, body string) error {
+	return db.WithContext(ctx).Model(record).Updates(map[string]interface{}{
+		"response_status": status,
+		"response_body":   body,
+	}).Error
+}
+
+func ReleaseIdempotencyKey(ctx context.Context, db *gorm.DB, record *IdempotencyKey) error {
+	return db.WithContext(ctx).Delete(record).Error
+}
@@ -1,17 +1,279 @@
 package models
 
 import (
+	"context"
+	"errors"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
 )
 
+type LedgerDirection string
+
+const (
+	Debit  LedgerDirection = "debit"
+	Credit LedgerDirection = "credit"
+)
+
+var ErrUnbalancedJournal = errors.New("journal entries do not balance")
+
+// LedgerEntry is a single line of a journal. Debits carry a negative amount
+// and credits a positive one, so the entries of a journal always sum to zero.
 type LedgerEntry struct {
 	ID        int             `gorm:"primaryKey;autoIncrement"`
+	JournalID string          `gorm:"type:uuid;index"`
 	AccountID string          `gorm:"not null;index"`
 	Account   Account         `gorm:"foreignKey:AccountID;references:AccountID"`
 	PaymentID string          `gorm:"not null;index"`
 	Payment   Payment         `gorm:"foreignKey:PaymentID;references:PaymentID"`
+	Direction LedgerDirection `gorm:"type:varchar(6);not null;default:''"`
+	Currency  string          `gorm:"type:varchar(3)"`
 	Amount    decimal.Decimal `gorm:"type:numeric(18,2);not null"`
 	CreatedAt time.Time
 }
+
+func DebitEntry(accountID string, amount decimal.Decimal, currency string) LedgerEntry {
+	return LedgerEntry{
+		AccountID: accountID,
+		Direction: Debit,
+		Currency:  currency,
+		Amount:    amount.Abs().Neg(),
+	}
+}
+
+func CreditEntry(accountID string, amount decimal.Decimal, currency string) LedgerEntry {
+	return LedgerEntry{
+		AccountID: accountID,
+		Direction: Credit,
+		Currency:  currency,
+		Amount:    amount.Abs(),
+	}
+}
+
+// PostJournal writes the entries as one journal for the payment. The entries
+// must balance per currency, otherwise nothing is written.
+func PostJournal(ctx context.Context, db *gorm.DB, paymentID string, entries ...LedgerEntry) (string, error) {
+	totals := map[string]decimal.Decimal{}
+	for _, entry := range entries {
+		totals[entry.Currency] = totals[entry.Currency].Add(entry.Amount)
+	}
+	for _, total := range totals {
+		if !total.IsZero() {
+			return "", ErrUnbalancedJournal
+		}
+	}
+	if len(entries) < 2 {
+		return "", ErrUnbalancedJournal
+	}
+
+	journalID := uuid.NewString()
+	for i := range entries {
+		entries[i].JournalID = journalID
+		entries[i].PaymentID = paymentID
+	}
+
+	err := db.WithContext(ctx).Create(&entries).Error
+	if err != nil {
+		return "", err
+	}
+	return journalID, nil
+}
+
+func JournalEntries(ctx context.Context, db *gorm.DB, paymentID string) ([]LedgerEntry, error) {
+	var entries []LedgerEntry
+	err := db.WithContext(ctx).Where("payment_id = ?", paymentID).Order("id").Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// LedgerFilter selects ledger lines of one account, newest first. BeforeID is
+// the pagination cursor: only entries with a smaller id are returned.
+type LedgerFilter struct {
+	AccountID string
+	BeforeID  int
+	From      time.Time
+	To        time.Time
+	Status    PaymentStatus
+	Direction LedgerDirection
+	MinAmount decimal.NullDecimal
+	MaxAmount decimal.NullDecimal
+	Limit     int
+}
+
+// LedgerLine is a ledger entry together with the payment it belongs to.
+type LedgerLine struct {
+	ID            int
+	JournalID     string
+	AccountID     string
+	PaymentID     string
+	Direction     LedgerDirection
+	Currency      string
+	Amount        decimal.Decimal
+	CreatedAt     time.Time
+	PaymentStatus PaymentStatus
+	PaymentType   TransactionType
+	Description   string
+}
+
+func ledgerLinesQuery(ctx context.Context, db *gorm.DB, filter LedgerFilter) *gorm.DB {
+	query := db.WithContext(ctx).Table("ledger_entries").
+		Select("ledger_entries.*, payments.status AS payment_status, payments.type AS payment_type, payments.description AS description").
+		Joins("LEFT JOIN payments ON payments.payment_id = ledger_entries.payment_id").
+		Where("ledger_entries.account_id = ?", filter.AccountID)
+
+	if filter.BeforeID > 0 {
+		query = query.Where("ledger_entries.id < ?", filter.BeforeID)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("ledger_entries.created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("ledger_entries.created_at < ?", filter.To)
+	}
+	if filter.Status != "" {
+		query = query.Where("payments.status = ?", filter.Status)
+	}
+	if filter.Direction != "" {
+		query = query.Where("ledger_entries.direction = ?", filter.Direction)
+	}
+	if filter.MinAmount.Valid {
+		query = query.Where("ABS(ledger_entries.amount) >= CAST(? AS NUMERIC)", filter.MinAmount.Decimal)
+	}
+	if filter.MaxAmount.Valid {
+		query = query.Where("ABS(ledger_entries.amount) <= CAST(? AS NUMERIC)", filter.MaxAmount.Decimal)
+	}
+	return query
+}
+
+func ListLedgerLines(ctx context.Context, db *gorm.DB, filter LedgerFilter) ([]LedgerLine, error) {
+	var lines []LedgerLine
+	err := ledgerLinesQuery(ctx, db, filter).Order("ledger_entries.id desc").Limit(filter.Limit).Scan(&lines).Error
+	if err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// EachLedgerLine calls fn for every line matching the filter, oldest first,
+// reading one row at a time so long periods are never held in memory. The
+// filter's BeforeID and Limit are ignored.
+func EachLedgerLine(ctx context.Context, db *gorm.DB, filter LedgerFilter, fn func(LedgerLine) error) error {
+	filter.BeforeID = 0
+	rows, err := ledgerLinesQuery(ctx, db, filter).Order("ledger_entries.id").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line LedgerLine
+		if err := db.ScanRows(rows, &line); err != nil {
+			return err
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// LedgerBalance is the balance of the account according to its ledger after
+// the entry with the given id.
+func LedgerBalance(ctx context.Context, db *gorm.DB, accountID string, throughID int) (decimal.Decimal, error) {
+	var total decimal.NullDecimal
+	err := db.WithContext(ctx).Model(&LedgerEntry{}).
+		Select("SUM(amount)").
+		Where("account_id = ? AND id <= ?", accountID, throughID).
+		Scan(&total).Error
+	if err != nil {
+		return decimal.Zero, err
+	}
+	// amounts are stored with two decimals, rounding drops float noise from
+	// databases that sum numerics as floating point
+	return total.Decimal.Round(2), nil
+}
+
+// LedgerAmountsBetween returns the amounts of the account's entries with ids in
+// [fromID, throughID], newest first.
+func LedgerAmountsBetween(ctx context.Context, db *gorm.DB, accountID string, fromID, throughID int) ([]LedgerEntry, error) {
+	var entries []LedgerEntry
+	err := db.WithContext(ctx).
+		Select("id", "amount").
+		Where("account_id = ? AND id >= ? AND id <= ?", accountID, fromID, throughID).
+		Order("id desc").
+		Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// LedgerBalanceBefore is the balance of the account according to its ledger
+// at the given moment, counting only entries created strictly before it.
+func LedgerBalanceBefore(ctx context.Context, db *gorm.DB, accountID string, before time.Time) (decimal.Decimal, error) {
+	var total decimal.NullDecimal
+	err := db.WithContext(ctx).Model(&LedgerEntry{}).
+		Select("SUM(amount)").
+		Where("account_id = ? AND created_at < ?", accountID, before).
+		Scan(&total).Error
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return total.Decimal.Round(2), nil
+}
+
+// LedgerTotal is the balance of the account according to its whole ledger.
+func LedgerTotal(ctx context.Context, db *gorm.DB, accountID string) (decimal.Decimal, error) {
+	var total decimal.NullDecimal
+	err := db.WithContext(ctx).Model(&LedgerEntry{}).
+		Select("SUM(amount)").
+		Where("account_id = ?", accountID).
+		Scan(&total).Error
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return total.Decimal.Round(2), nil
+}
+
+// LegacyPayments returns the payments made before the ledger existed that have
+// no journal yet, oldest first. They are the only payments without a type.
+func LegacyPayments(ctx context.Context, db *gorm.DB) ([]Payment, error) {
+	var payments []Payment
+	err := db.WithContext(ctx).
+		Where("type IS NULL OR type = ''").
+		Where("NOT EXISTS (SELECT 1 FROM ledger_entries WHERE ledger_entries.payment_id = payments.payment_id AND ledger_entries.journal_id IS NOT NULL)").
+		Order("created_at, id").
+		Find(&payments).Error
+	if err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// DeleteLegacyEntries removes the single-sided entries written before ledger
+// entries belonged to a journal.
+func DeleteLegacyEntries(ctx context.Context, db *gorm.DB) error {
+	return db.WithContext(ctx).Where("journal_id IS NULL").Delete(&LedgerEntry{}).Error
+}
+
+// UnbackedAccounts returns the customer accounts whose stored balance is not
+// what their ledger adds up to and that have no opening balance journal yet.
+func UnbackedAccounts(ctx context.Context, db *gorm.DB) ([]string, error) {
+	var accountIDs []string
+	err := db.WithContext(ctx).Table("accounts").
+		Select("accounts.account_id").
+		Joins("LEFT JOIN ledger_entries ON ledger_entries.account_id = accounts.account_id").
+		Where("accounts.user_id <> 0").
+		Where("NOT EXISTS (SELECT 1 FROM payments WHERE payments.to_account = accounts.account_id AND payments.type = ?)", OpeningBalance).
+		Group("accounts.id, accounts.account_id, accounts.balance").
+		Having("accounts.balance <> COALESCE(SUM(ledger_entries.amount), 0)").
+		Pluck("accounts.account_id", &accountIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return accountIDs, nil
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Reasons a login attempt failed.
+const (
+	LoginUnknownEmail  = "unknown_email"
+	LoginWrongPassword = "wrong_password"
+	LoginThrottled     = "throttled"
+)
+
+// LoginAttempt is the audit record of a failed login. UserID is set when the
+// email belongs to an account.
+type LoginAttempt struct {
+	ID        int       `json:"id" gorm:"type:integer;primaryKey"`
+	Email     string    `json:"email" gorm:"type:varchar(255);not null;index"`
+	UserID    *int      `json:"user_id,omitempty" gorm:"index"`
+	IP        string    `json:"ip" gorm:"type:varchar(64);index"`
+	UserAgent string    `json:"user_agent" gorm:"type:varchar(255)"`
+	Reason    string    `json:"reason" gorm:"type:varchar(32);not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+func CreateLoginAttempt(ctx context.Context, db *gorm.DB, attempt *LoginAttempt) error {
+	return db.WithContext(ctx).Create(attempt).Error
+}
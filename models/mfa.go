@@ -0,0 +1,122 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a one-off code that stands in for a TOTP code when the
+// authenticator is lost. Only the SHA-256 of the code is stored.
+type RecoveryCode struct {
+	ID        int        `json:"-" gorm:"type:integer;primaryKey"`
+	UserID    int        `json:"-" gorm:"not null;index"`
+	CodeHash  string     `json:"-" gorm:"type:varchar(64);not null;index"`
+	UsedAt    *time.Time `json:"-"`
+	CreatedAt time.Time  `json:"-"`
+}
+
+// SetTOTPSecret starts an enrollment. The secret replaces any earlier
+// unconfirmed one and is not enforced until EnableMFA.
+func SetTOTPSecret(ctx context.Context, db *gorm.DB, userID int, secret string) error {
+	return db.WithContext(ctx).Model(&User{}).Where("id = ? AND mfa_enabled = ?", userID, false).
+		Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error
+}
+
+// EnableMFA confirms the enrollment and replaces the recovery codes.
+func EnableMFA(ctx context.Context, db *gorm.DB, userID int, step int64, codeHashes []string) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"mfa_enabled": true, "totp_last_step": step, "mfa_failures": 0, "mfa_locked_until": nil}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+		if err != nil {
+			return err
+		}
+		codes := make([]RecoveryCode, 0, len(codeHashes))
+		for _, hash := range codeHashes {
+			codes = append(codes, RecoveryCode{UserID: userID, CodeHash: hash})
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+func DisableMFA(ctx context.Context, db *gorm.DB, userID int) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"mfa_enabled": false, "totp_secret": "", "totp_last_step": 0, "mfa_failures": 0, "mfa_locked_until": nil}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+}
+
+// AdvanceTOTPStep records the step a code was accepted for. It reports false
+// when a code for the same or a later step was accepted first.
+func AdvanceTOTPStep(ctx context.Context, db *gorm.DB, userID int, step int64) (bool, error) {
+	result := db.WithContext(ctx).Model(&User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	return result.RowsAffected > 0, result.Error
+}
+
+// UseRecoveryCode spends the user's unused recovery code with the hash. It
+// reports false when there is none.
+func UseRecoveryCode(ctx context.Context, db *gorm.DB, userID int, codeHash string) (bool, error) {
+	result := db.WithContext(ctx).Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+func RemainingRecoveryCodes(ctx context.Context, db *gorm.DB, userID int) (int64, error) {
+	var count int64
+	err := db.WithContext(ctx).Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// ReserveMFAAttempt counts an attempt as a failure before the code is
+// checked. The check and the count are one conditional update, so parallel
+// requests cannot get more than maxAttempts guesses between locks. It
+// reports false when the second factor is locked or maxAttempts attempts are
+// already counted.
+func ReserveMFAAttempt(ctx context.Context, db *gorm.DB, userID, maxAttempts int, now time.Time) (bool, error) {
+	result := db.WithContext(ctx).Model(&User{}).
+		Where("id = ? AND mfa_failures < ? AND (mfa_locked_until IS NULL OR mfa_locked_until <= ?)", userID, maxAttempts, now).
+		Update("mfa_failures", gorm.Expr("mfa_failures + 1"))
+	return result.RowsAffected > 0, result.Error
+}
+
+// RecordMFAFailure settles a reserved attempt as a wrong code. The failure
+// that reaches maxAttempts locks the second factor until lockUntil and starts
+// the count again. It returns the failures so far and the lock, if the second
+// factor is locked.
+func RecordMFAFailure(ctx context.Context, db *gorm.DB, userID, maxAttempts int, now, lockUntil time.Time) (int, *time.Time, error) {
+	err := db.WithContext(ctx).Model(&User{}).
+		Where("id = ? AND mfa_failures >= ? AND (mfa_locked_until IS NULL OR mfa_locked_until <= ?)", userID, maxAttempts, now).
+		Updates(map[string]interface{}{"mfa_failures": 0, "mfa_locked_until": lockUntil}).Error
+	if err != nil {
+		return 0, nil, err
+	}
+	var user User
+	err = db.WithContext(ctx).Select("mfa_failures", "mfa_locked_until").Where("id = ?", userID).First(&user).Error
+	if err != nil {
+		return 0, nil, err
+	}
+	if user.MFALockedUntil != nil && !now.Before(*user.MFALockedUntil) {
+		return user.MFAFailures, nil, nil
+	}
+	return user.MFAFailures, user.MFALockedUntil, nil
+}
+
+// ResetMFAFailures forgives the failures after a correct code. A lock set
+// meanwhile by a wrong code running alongside stays in place.
+func ResetMFAFailures(ctx context.Context, db *gorm.DB, userID int, now time.Time) error {
+	return db.WithContext(ctx).Model(&User{}).
+		Where("id = ? AND (mfa_locked_until IS NULL OR mfa_locked_until <= ?)", userID, now).
+		Updates(map[string]interface{}{"mfa_failures": 0, "mfa_locked_until": nil}).Error
+}
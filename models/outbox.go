@@ -0,0 +1,134 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+type OutboxStatus string
+
+const (
+	OutboxPending    OutboxStatus = "pending"
+	OutboxDispatched OutboxStatus = "dispatched"
+	OutboxDead       OutboxStatus = "dead"
+)
+
+const (
+	PaymentCreatedEvent   = "PaymentCreated"
+	PaymentCompletedEvent = "PaymentCompleted"
+	PaymentFailedEvent    = "PaymentFailed"
+	AccountCreditedEvent  = "AccountCredited"
+	// PasswordResetRequestedEvent asks for a reset email to an address that
+	// may or may not belong to an account.
+	PasswordResetRequestedEvent = "PasswordResetRequested"
+)
+
+// OutboxEvent is a domain event written in the same transaction as the change
+// it describes, then delivered to the event sinks by the outbox dispatcher.
+type OutboxEvent struct {
+	ID            int          `json:"id" gorm:"type:integer;primaryKey"`
+	EventID       string       `json:"event_id" gorm:"type:uuid;not null;uniqueIndex"`
+	EventType     string       `json:"event_type" gorm:"type:varchar(64);not null"`
+	AggregateID   string       `json:"aggregate_id" gorm:"type:varchar(64);not null;index"`
+	Payload       string       `json:"payload" gorm:"type:text;not null"`
+	Status        OutboxStatus `json:"status" gorm:"type:varchar(20);not null;default:'pending';index:idx_outbox_due"`
+	Attempts      int          `json:"attempts" gorm:"not null;default:0"`
+	NextAttemptAt time.Time    `json:"next_attempt_at" gorm:"index:idx_outbox_due"`
+	LastError     string       `json:"last_error" gorm:"type:text"`
+	DispatchedAt  *time.Time   `json:"dispatched_at"`
+	CreatedAt     time.Time    `json:"created_at"`
+}
+
+type AccountCreditedPayload struct {
+	AccountID string          `json:"account_id"`
+	PaymentID string          `json:"payment_id"`
+	Amount    decimal.Decimal `json:"amount"`
+	Currency  string          `json:"currency"`
+}
+
+type PasswordResetRequestedPayload struct {
+	Email     string `json:"email"`
+	RequestIP string `json:"request_ip"`
+}
+
+func (e *OutboxEvent) BeforeCreate(tx *gorm.DB) error {
+	if e.EventID == "" {
+		e.EventID = uuid.NewString()
+	}
+	return nil
+}
+
+// EnqueueEvent adds an event to the outbox. Pass the transaction that makes the
+// change so the event is only published if the change commits.
+func EnqueueEvent(ctx context.Context, db *gorm.DB, eventType, aggregateID string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Create(&OutboxEvent{
+		EventType:     eventType,
+		AggregateID:   aggregateID,
+		Payload:       string(data),
+		Status:        OutboxPending,
+		NextAttemptAt: time.Now(),
+	}).Error
+}
+
+func DueOutboxEvents(ctx context.Context, db *gorm.DB, limit int) ([]OutboxEvent, error) {
+	var events []OutboxEvent
+	err := db.WithContext(ctx).
+		Where("status = ? AND next_attempt_at <= ?", OutboxPending, time.Now()).
+		Order("id").
+		Limit(limit).
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// ClaimOutboxEvent pushes the next attempt of the event out by the lease so no
+// other dispatcher picks it up meanwhile. It reports false when another
+// dispatcher claimed it first.
+func ClaimOutboxEvent(ctx context.Context, db *gorm.DB, event *OutboxEvent, lease time.Duration) (bool, error) {
+	now := time.Now()
+	leaseUntil := now.Add(lease)
+	result := db.WithContext(ctx).Model(&OutboxEvent{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", event.ID, OutboxPending, now).
+		Update("next_attempt_at", leaseUntil)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	event.NextAttemptAt = leaseUntil
+	return result.RowsAffected == 1, nil
+}
+
+func MarkOutboxDispatched(ctx context.Context, db *gorm.DB, event *OutboxEvent) error {
+	now := time.Now()
+	return db.WithContext(ctx).Model(event).Updates(map[string]interface{}{
+		"status":        OutboxDispatched,
+		"attempts":      event.Attempts + 1,
+		"dispatched_at": now,
+		"last_error":    "",
+	}).Error
+}
+
+// MarkOutboxFailed records a failed attempt. The event is retried at nextAttempt,
+// or given up on as dead when dead is set.
+func MarkOutboxFailed(ctx context.Context, db *gorm.DB, event *OutboxEvent, cause error, nextAttempt time.Time, dead bool) error {
+	status := OutboxPending
+	if dead {
+		status = OutboxDead
+	}
+	return db.WithContext(ctx).Model(event).Updates(map[string]interface{}{
+		"status":          status,
+		"attempts":        event.Attempts + 1,
+		"next_attempt_at": nextAttempt,
+		"last_error":      cause.Error(),
+	}).Error
+}
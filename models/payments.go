@@ -2,21 +2,53 @@ package models
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type PaymentStatus string
 
 const (
-	Pending   PaymentStatus = "pending"
-	Completed PaymentStatus = "completed"
-	Failed    PaymentStatus = "failed"
+	Pending           PaymentStatus = "pending"
+	Processing        PaymentStatus = "processing"
+	Completed         PaymentStatus = "completed"
+	Failed            PaymentStatus = "failed"
+	Reversed          PaymentStatus = "reversed"
+	PartiallyRefunded PaymentStatus = "partially_refunded"
 )
 
+// TransactionType says how money moved for a payment.
+type TransactionType string
+
+const (
+	InternalTransfer  TransactionType = "INTERNAL"
+	BankTransfer      TransactionType = "BANK_TRANSFER"
+	MobileMoney       TransactionType = "MOBILE_MONEY"
+	TopUpTransaction  TransactionType = "TOPUP"
+	RefundTransaction TransactionType = "REFUND"
+	// HoldCapture moves the captured part of an authorization hold.
+	HoldCapture TransactionType = "CAPTURE"
+	// OpeningBalance brings a balance that predates the ledger onto it.
+	OpeningBalance TransactionType = "OPENING_BALANCE"
+)
+
+var ErrInvalidTransition = errors.New("invalid payment status transition")
+
+// paymentTransitions lists the statuses each status may move to. Statuses
+// without an entry are final.
+var paymentTransitions = map[PaymentStatus][]PaymentStatus{
+	Pending:           {Processing, Failed},
+	Processing:        {Completed, Failed},
+	Completed:         {Reversed, PartiallyRefunded},
+	PartiallyRefunded: {PartiallyRefunded, Reversed},
+}
+
 type Payment struct {
 	ID          int             `json:"id" gorm:"type:integer;primaryKey"`
 	PaymentID   string          `json:"payment_id" gorm:"type:uuid;not null;index"`
@@ -24,10 +56,28 @@ type Payment struct {
 	ToAccount   string          `json:"to_account" gorm:"type:uuid"`
 	Currency    string          `json:"currency" gorm:"type:varchar(3);not null"`
 	Amount      decimal.Decimal `json:"amount" gorm:"type:numeric(18,2);not null"`
+	Fee         decimal.Decimal `json:"fee" gorm:"type:numeric(18,2);not null;default:0"`
 	Status      PaymentStatus   `json:"status" gorm:"type:varchar(20);default:'pending'"`
+	Type        TransactionType `json:"type" gorm:"type:varchar(20);index"`
 	Description string          `json:"description" gorm:"type:text"`
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	// OriginalPaymentID links a refund to the payment it gives money back for.
+	OriginalPaymentID string          `json:"original_payment_id,omitempty" gorm:"type:varchar(36);index"`
+	RefundedAmount    decimal.Decimal `json:"refunded_amount" gorm:"type:numeric(18,2);not null;default:0"`
+	// Provider and ProviderReference identify the payout of an external payment.
+	Provider          string `json:"provider,omitempty" gorm:"type:varchar(64)"`
+	ProviderReference string `json:"provider_reference,omitempty" gorm:"type:varchar(128);index"`
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+// PaymentStatusHistory records every status a payment has been in.
+type PaymentStatusHistory struct {
+	ID         int           `json:"id" gorm:"type:integer;primaryKey"`
+	PaymentID  string        `json:"payment_id" gorm:"type:uuid;not null;index"`
+	FromStatus PaymentStatus `json:"from_status" gorm:"type:varchar(20)"`
+	ToStatus   PaymentStatus `json:"to_status" gorm:"type:varchar(20);not null"`
+	Reason     string        `json:"reason" gorm:"type:text"`
+	CreatedAt  time.Time     `json:"created_at"`
 }
 
 func (p *Payment) BeforeCreate(tx *gorm.DB) error {
@@ -35,6 +85,125 @@ func (p *Payment) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// CreatePayment stores a new payment in the pending status and records the
+// start of its history.
 func CreatePayment(ctx context.Context, db *gorm.DB, payment *Payment) error {
-	return db.WithContext(ctx).Create(payment).Error
+	payment.Status = Pending
+	err := db.WithContext(ctx).Create(payment).Error
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Create(&PaymentStatusHistory{
+		PaymentID: payment.PaymentID,
+		ToStatus:  Pending,
+		Reason:    "payment created",
+	}).Error
+}
+
+func CanTransition(from, to PaymentStatus) bool {
+	for _, next := range paymentTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionTo moves the payment to a new status and records the change. The
+// update only applies while the stored status still matches, so two writers
+// cannot both move the same payment.
+func (p *Payment) TransitionTo(ctx context.Context, db *gorm.DB, to PaymentStatus, reason string) error {
+	from := p.Status
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+	}
+
+	result := db.WithContext(ctx).Model(&Payment{}).Where("payment_id = ? AND status = ?", p.PaymentID, from).Update("status", to)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: payment %s is no longer %s", ErrInvalidTransition, p.PaymentID, from)
+	}
+	p.Status = to
+
+	return db.WithContext(ctx).Create(&PaymentStatusHistory{
+		PaymentID:  p.PaymentID,
+		FromStatus: from,
+		ToStatus:   to,
+		Reason:     reason,
+	}).Error
+}
+
+func FindPayment(ctx context.Context, db *gorm.DB, paymentID string) (*Payment, error) {
+	var payment Payment
+	err := db.WithContext(ctx).Where("payment_id = ?", paymentID).First(&payment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// LockPayment reads the payment and locks its row until the transaction ends.
+func LockPayment(ctx context.Context, tx *gorm.DB, paymentID string) (*Payment, error) {
+	var payment Payment
+	err := tx.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Where("payment_id = ?", paymentID).First(&payment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func FindPaymentByProviderReference(ctx context.Context, db *gorm.DB, provider, providerReference string) (*Payment, error) {
+	var payment Payment
+	err := db.WithContext(ctx).Where("provider = ? AND provider_reference = ?", provider, providerReference).First(&payment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func PaymentHistory(ctx context.Context, db *gorm.DB, paymentID string) ([]PaymentStatusHistory, error) {
+	var history []PaymentStatusHistory
+	err := db.WithContext(ctx).Where("payment_id = ?", paymentID).Order("id").Find(&history).Error
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// userPayments selects the payments of one type sent from any account of the
+// user since the given time.
+func userPayments(ctx context.Context, db *gorm.DB, userID int, transactionType TransactionType, since time.Time) *gorm.DB {
+	return db.WithContext(ctx).Model(&Payment{}).
+		Where("from_account IN (?)", db.Model(&Account{}).Select("account_id").Where("user_id = ?", userID)).
+		Where("type = ? AND created_at >= ?", transactionType, since)
+}
+
+// PaymentVolume adds up the amounts of the user's payments of one type and
+// currency since the given time. Failed payments moved no money and are left
+// out; refunds do not give the volume back.
+func PaymentVolume(ctx context.Context, db *gorm.DB, userID int, transactionType TransactionType, currency string, since time.Time) (decimal.Decimal, error) {
+	var total struct {
+		Total decimal.NullDecimal
+	}
+	err := userPayments(ctx, db, userID, transactionType, since).
+		Where("currency = ? AND status <> ?", currency, Failed).
+		Select("SUM(amount) AS total").
+		Scan(&total).Error
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if !total.Total.Valid {
+		return decimal.Zero, nil
+	}
+	return total.Total.Decimal, nil
+}
+
+// PaymentCount counts the user's payments of one type since the given time,
+// failed attempts included.
+func PaymentCount(ctx context.Context, db *gorm.DB, userID int, transactionType TransactionType, since time.Time) (int64, error) {
+	var count int64
+	err := userPayments(ctx, db, userID, transactionType, since).Count(&count).Error
+	return count, err
 }
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SetPIN stores a new PIN hash and clears any lockout.
+func SetPIN(ctx context.Context, db *gorm.DB, userID int, hash string) error {
+	return db.WithContext(ctx).Model(&User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"pin_hash": hash, "pin_failures": 0, "pin_locked_until": nil}).Error
+}
+
+// ReservePINAttempt counts an attempt as a failure before the PIN is
+// compared. The check and the count are one conditional update, so parallel
+// requests cannot get more than maxAttempts guesses between locks. It
+// reports false, with the lock if there is one, when payments are locked or
+// maxAttempts attempts are already counted.
+func ReservePINAttempt(ctx context.Context, db *gorm.DB, userID, maxAttempts int, now time.Time) (bool, *time.Time, error) {
+	result := db.WithContext(ctx).Model(&User{}).
+		Where("id = ? AND pin_failures < ? AND (pin_locked_until IS NULL OR pin_locked_until <= ?)", userID, maxAttempts, now).
+		Update("pin_failures", gorm.Expr("pin_failures + 1"))
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.RowsAffected > 0, nil, result.Error
+	}
+	locked, _, err := pinLock(ctx, db, userID, now)
+	return false, locked, err
+}
+
+// RecordPINFailure settles a reserved attempt as a wrong PIN. The failure
+// that reaches maxAttempts locks payments until lockUntil and starts the
+// count again. It returns the failures so far and the lock, if payments are
+// locked.
+func RecordPINFailure(ctx context.Context, db *gorm.DB, userID, maxAttempts int, now, lockUntil time.Time) (int, *time.Time, error) {
+	err := db.WithContext(ctx).Model(&User{}).
+		Where("id = ? AND pin_failures >= ? AND (pin_locked_until IS NULL OR pin_locked_until <= ?)", userID, maxAttempts, now).
+		Updates(map[string]interface{}{"pin_failures": 0, "pin_locked_until": lockUntil}).Error
+	if err != nil {
+		return 0, nil, err
+	}
+	locked, failures, err := pinLock(ctx, db, userID, now)
+	return failures, locked, err
+}
+
+// ResetPINFailures forgives the failures after a correct PIN. A lock set
+// meanwhile by a wrong PIN running alongside stays in place.
+func ResetPINFailures(ctx context.Context, db *gorm.DB, userID int, now time.Time) error {
+	return db.WithContext(ctx).Model(&User{}).
+		Where("id = ? AND (pin_locked_until IS NULL OR pin_locked_until <= ?)", userID, now).
+		Updates(map[string]interface{}{"pin_failures": 0, "pin_locked_until": nil}).Error
+}
+
+// pinLock reads the active lock of the user, if any, and the failures
+// counted.
+func pinLock(ctx context.Context, db *gorm.DB, userID int, now time.Time) (*time.Time, int, error) {
+	var user User
+	err := db.WithContext(ctx).Select("pin_failures", "pin_locked_until").Where("id = ?", userID).First(&user).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	if user.PinLockedUntil != nil && !now.Before(*user.PinLockedUntil) {
+		return nil, user.PinFailures, nil
+	}
+	return user.PinLockedUntil, user.PinFailures, nil
+}
@@ -0,0 +1,174 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+type ScheduleFrequency string
+
+const (
+	ScheduleOnce    ScheduleFrequency = "once"
+	ScheduleDaily   ScheduleFrequency = "daily"
+	ScheduleWeekly  ScheduleFrequency = "weekly"
+	ScheduleMonthly ScheduleFrequency = "monthly"
+	ScheduleCron    ScheduleFrequency = "cron"
+)
+
+type ScheduleStatus string
+
+const (
+	ScheduleActive    ScheduleStatus = "active"
+	SchedulePaused    ScheduleStatus = "paused"
+	ScheduleCancelled ScheduleStatus = "cancelled"
+	// ScheduleCompleted schedules have no occurrence left
+	ScheduleCompleted ScheduleStatus = "completed"
+)
+
+type ScheduleRunStatus string
+
+const (
+	ScheduleRunSucceeded ScheduleRunStatus = "succeeded"
+	ScheduleRunFailed    ScheduleRunStatus = "failed"
+	ScheduleRunSkipped   ScheduleRunStatus = "skipped"
+)
+
+// PaymentSchedule is a future-dated or recurring internal or external
+// payment. NextRunAt is the occurrence the scheduler executes next.
+type PaymentSchedule struct {
+	ID              int               `json:"-" gorm:"type:integer;primaryKey"`
+	ScheduleID      string            `json:"schedule_id" gorm:"type:uuid;not null;uniqueIndex"`
+	UserID          int               `json:"-" gorm:"not null;index"`
+	Type            TransactionType   `json:"transaction_type" gorm:"type:varchar(20);not null"`
+	FromAccount     string            `json:"from_account" gorm:"type:uuid;not null;index"`
+	ToAccount       string            `json:"to_account,omitempty" gorm:"type:varchar(36)"`
+	RecipientNumber string            `json:"recipient_number,omitempty" gorm:"type:varchar(64)"`
+	RecipientName   string            `json:"recipient_name,omitempty" gorm:"type:varchar(255)"`
+	Amount          decimal.Decimal   `json:"amount" gorm:"type:numeric(18,2);not null"`
+	Currency        string            `json:"currency" gorm:"type:varchar(3);not null"`
+	Description     string            `json:"description" gorm:"type:text"`
+	Frequency       ScheduleFrequency `json:"frequency" gorm:"type:varchar(10);not null"`
+	CronExpr        string            `json:"cron,omitempty" gorm:"type:varchar(100)"`
+	StartAt         time.Time         `json:"start_at"`
+	EndAt           *time.Time        `json:"end_at,omitempty"`
+	NextRunAt       time.Time         `json:"next_run_at" gorm:"index:idx_schedule_due"`
+	LastRunAt       *time.Time        `json:"last_run_at,omitempty"`
+	Status          ScheduleStatus    `json:"status" gorm:"type:varchar(20);not null;index:idx_schedule_due"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+}
+
+// ScheduleRun records the outcome of one occurrence of a schedule.
+type ScheduleRun struct {
+	ID           int               `json:"id" gorm:"type:integer;primaryKey"`
+	ScheduleID   string            `json:"schedule_id" gorm:"type:uuid;not null;index"`
+	ScheduledFor time.Time         `json:"scheduled_for"`
+	Status       ScheduleRunStatus `json:"status" gorm:"type:varchar(20);not null"`
+	PaymentID    string            `json:"payment_id,omitempty" gorm:"type:varchar(36)"`
+	Error        string            `json:"error,omitempty" gorm:"type:text"`
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+func (schedule *PaymentSchedule) BeforeCreate(tx *gorm.DB) error {
+	if schedule.ScheduleID == "" {
+		schedule.ScheduleID = uuid.NewString()
+	}
+	return nil
+}
+
+func CreateSchedule(ctx context.Context, db *gorm.DB, schedule *PaymentSchedule) error {
+	schedule.Status = ScheduleActive
+	return db.WithContext(ctx).Create(schedule).Error
+}
+
+func FindSchedule(ctx context.Context, db *gorm.DB, scheduleID string) (PaymentSchedule, error) {
+	var schedule PaymentSchedule
+	err := db.WithContext(ctx).Where("schedule_id = ?", scheduleID).First(&schedule).Error
+	return schedule, err
+}
+
+func UserSchedules(ctx context.Context, db *gorm.DB, userID int) ([]PaymentSchedule, error) {
+	var schedules []PaymentSchedule
+	err := db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&schedules).Error
+	if err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// DueSchedules returns active schedules whose next occurrence is not after now.
+func DueSchedules(ctx context.Context, db *gorm.DB, now time.Time, limit int) ([]PaymentSchedule, error) {
+	var schedules []PaymentSchedule
+	err := db.WithContext(ctx).
+		Where("status = ? AND next_run_at <= ?", ScheduleActive, now).
+		Order("next_run_at").
+		Limit(limit).
+		Find(&schedules).Error
+	if err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// ClaimScheduleRun moves a due schedule on to its following occurrence, or to
+// status when it has none left, before the due occurrence is executed. It
+// reports false when another scheduler claimed the occurrence first, so every
+// occurrence is paid at most once.
+func ClaimScheduleRun(ctx context.Context, db *gorm.DB, schedule *PaymentSchedule, now, next time.Time, status ScheduleStatus) (bool, error) {
+	result := db.WithContext(ctx).Model(&PaymentSchedule{}).
+		Where("schedule_id = ? AND status = ? AND next_run_at <= ?", schedule.ScheduleID, ScheduleActive, now).
+		Updates(map[string]interface{}{
+			"next_run_at": next,
+			"last_run_at": now,
+			"status":      status,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	schedule.NextRunAt = next
+	schedule.LastRunAt = &now
+	schedule.Status = status
+	return result.RowsAffected == 1, nil
+}
+
+// UpdateSchedule sets the status and next occurrence of a schedule that is
+// still in the from status. It reports false when the schedule moved on.
+func UpdateSchedule(ctx context.Context, db *gorm.DB, schedule *PaymentSchedule, from ScheduleStatus, to ScheduleStatus, next time.Time) (bool, error) {
+	result := db.WithContext(ctx).Model(&PaymentSchedule{}).
+		Where("schedule_id = ? AND status = ?", schedule.ScheduleID, from).
+		Updates(map[string]interface{}{
+			"next_run_at": next,
+			"status":      to,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return false, nil
+	}
+	schedule.NextRunAt = next
+	schedule.Status = to
+	return true, nil
+}
+
+func RecordScheduleRun(ctx context.Context, db *gorm.DB, run *ScheduleRun) error {
+	return db.WithContext(ctx).Create(run).Error
+}
+
+// ScheduleRuns returns the most recent runs of a schedule first.
+func ScheduleRuns(ctx context.Context, db *gorm.DB, scheduleID string, limit int) ([]ScheduleRun, error) {
+	var runs []ScheduleRun
+	err := db.WithContext(ctx).
+		Where("schedule_id = ?", scheduleID).
+		Order("id desc").
+		Limit(limit).
+		Find(&runs).Error
+	if err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
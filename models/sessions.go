@@ -0,0 +1,161 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Session is one login: the family of refresh tokens that descend from a
+// single password check. Revoking the session revokes every refresh token in
+// the family and every access token issued alongside them.
+type Session struct {
+	ID            int        `json:"-" gorm:"type:integer;primaryKey"`
+	SessionID     string     `json:"session_id" gorm:"type:uuid;not null;uniqueIndex"`
+	UserID        int        `json:"-" gorm:"not null;index"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason string     `json:"revoked_reason,omitempty" gorm:"type:varchar(50)"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+func (session *Session) BeforeCreate(tx *gorm.DB) error {
+	if session.SessionID == "" {
+		session.SessionID = uuid.NewString()
+	}
+	return nil
+}
+
+// RefreshToken is one link in a session's rotation chain. Only the SHA-256 of
+// the token is stored. A token is spent once it has been exchanged, so seeing
+// it again means it was copied. AccessJTI is the access token issued with it,
+// kept so that revoking the session can revoke it as well.
+type RefreshToken struct {
+	ID              int        `json:"-" gorm:"type:integer;primaryKey"`
+	TokenHash       string     `json:"-" gorm:"type:varchar(64);not null;uniqueIndex"`
+	SessionID       string     `json:"-" gorm:"type:uuid;not null;index"`
+	AccessJTI       string     `json:"-" gorm:"type:varchar(36);not null"`
+	AccessExpiresAt time.Time  `json:"-"`
+	ExpiresAt       time.Time  `json:"-"`
+	UsedAt          *time.Time `json:"-"`
+	CreatedAt       time.Time  `json:"-"`
+}
+
+// RevokedToken lists an access token refused before it expires. Rows are of
+// no use once ExpiresAt has passed, since the token is refused anyway.
+type RevokedToken struct {
+	ID        int       `json:"-" gorm:"type:integer;primaryKey"`
+	JTI       string    `json:"jti" gorm:"type:varchar(36);not null;uniqueIndex"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func CreateSession(ctx context.Context, db *gorm.DB, session *Session) error {
+	return db.WithContext(ctx).Create(session).Error
+}
+
+func FindSession(ctx context.Context, db *gorm.DB, sessionID string) (Session, error) {
+	var session Session
+	err := db.WithContext(ctx).Where("session_id = ?", sessionID).First(&session).Error
+	return session, err
+}
+
+func CreateRefreshToken(ctx context.Context, db *gorm.DB, token *RefreshToken) error {
+	return db.WithContext(ctx).Create(token).Error
+}
+
+func FindRefreshToken(ctx context.Context, db *gorm.DB, tokenHash string) (RefreshToken, error) {
+	var token RefreshToken
+	err := db.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&token).Error
+	return token, err
+}
+
+// SpendRefreshToken marks the token used. It reports false when the token was
+// already spent, which only happens when two clients hold the same token.
+func SpendRefreshToken(ctx context.Context, db *gorm.DB, token *RefreshToken) (bool, error) {
+	now := time.Now()
+	result := db.WithContext(ctx).Model(&RefreshToken{}).
+		Where("id = ? AND used_at IS NULL", token.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	token.UsedAt = &now
+	return true, nil
+}
+
+// RevokeSession closes the session and puts every access token issued under
+// it that has not yet expired on the revocation list. Revoking a session twice
+// keeps the first reason.
+func RevokeSession(ctx context.Context, db *gorm.DB, sessionID, reason string) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Session{}).
+			Where("session_id = ? AND revoked_at IS NULL", sessionID).
+			Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason}).Error
+		if err != nil {
+			return err
+		}
+
+		var tokens []RefreshToken
+		err = tx.Where("session_id = ? AND access_expires_at > ?", sessionID, time.Now()).Find(&tokens).Error
+		if err != nil {
+			return err
+		}
+		if len(tokens) == 0 {
+			return nil
+		}
+
+		revoked := make([]RevokedToken, 0, len(tokens))
+		for _, token := range tokens {
+			revoked = append(revoked, RevokedToken{JTI: token.AccessJTI, ExpiresAt: token.AccessExpiresAt})
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error
+	})
+}
+
+// RevokeUserSessions revokes every open session of the user except the one
+// given, which may be empty.
+func RevokeUserSessions(ctx context.Context, db *gorm.DB, userID int, except, reason string) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var sessionIDs []string
+		query := tx.Model(&Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+		if except != "" {
+			query = query.Where("session_id <> ?", except)
+		}
+		err := query.Pluck("session_id", &sessionIDs).Error
+		if err != nil {
+			return err
+		}
+		for _, sessionID := range sessionIDs {
+			err = RevokeSession(ctx, tx, sessionID, reason)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RevokeToken puts a single access token on the revocation list.
+func RevokeToken(ctx context.Context, db *gorm.DB, jti string, expiresAt time.Time) error {
+	revoked := RevokedToken{JTI: jti, ExpiresAt: expiresAt}
+	return db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error
+}
+
+func IsTokenRevoked(ctx context.Context, db *gorm.DB, jti string) (bool, error) {
+	var count int64
+	err := db.WithContext(ctx).Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
+
+// PruneRevokedTokens drops revocation entries for tokens that have expired on
+// their own.
+func PruneRevokedTokens(ctx context.Context, db *gorm.DB, now time.Time) (int64, error) {
+	result := db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&RevokedToken{})
+	return result.RowsAffected, result.Error
+}
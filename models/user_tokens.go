@@ -0,0 +1,90 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Purposes of a UserToken.
+const (
+	PasswordResetToken     = "password_reset"
+	EmailVerificationToken = "email_verification"
+)
+
+// UserToken is a single-use secret sent to the user's email to prove they
+// read it. Only the SHA-256 of the token is stored, and a token is only good
+// for its purpose.
+type UserToken struct {
+	ID        int        `json:"-" gorm:"type:integer;primaryKey"`
+	UserID    int        `json:"-" gorm:"not null;index"`
+	Purpose   string     `json:"-" gorm:"type:varchar(32);not null"`
+	TokenHash string     `json:"-" gorm:"type:varchar(64);not null;uniqueIndex"`
+	RequestIP string     `json:"-" gorm:"type:varchar(64);index"`
+	ExpiresAt time.Time  `json:"-"`
+	UsedAt    *time.Time `json:"-"`
+	CreatedAt time.Time  `json:"-"`
+}
+
+// CreateUserToken stores a new token and spends the user's earlier unused
+// tokens for the same purpose, so only the latest one sent works.
+func CreateUserToken(ctx context.Context, db *gorm.DB, token *UserToken) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, token.Purpose).
+			Update("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+// RecentUserTokens lists the tokens issued to the user for the purpose since
+// the given time, newest first, used or not.
+func RecentUserTokens(ctx context.Context, db *gorm.DB, userID int, purpose string, since time.Time) ([]UserToken, error) {
+	var tokens []UserToken
+	err := db.WithContext(ctx).
+		Where("user_id = ? AND purpose = ? AND created_at > ?", userID, purpose, since).
+		Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// RecentUserTokensFromIP lists the tokens for the purpose requested from the
+// ip since the given time, newest first, whoever they were sent to.
+func RecentUserTokensFromIP(ctx context.Context, db *gorm.DB, ip, purpose string, since time.Time) ([]UserToken, error) {
+	var tokens []UserToken
+	err := db.WithContext(ctx).
+		Where("request_ip = ? AND purpose = ? AND created_at > ?", ip, purpose, since).
+		Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// DeleteUserToken removes a token that never reached the user.
+func DeleteUserToken(ctx context.Context, db *gorm.DB, token *UserToken) error {
+	return db.WithContext(ctx).Delete(&UserToken{}, token.ID).Error
+}
+
+func FindUserToken(ctx context.Context, db *gorm.DB, purpose, tokenHash string) (UserToken, error) {
+	var token UserToken
+	err := db.WithContext(ctx).Where("purpose = ? AND token_hash = ?", purpose, tokenHash).First(&token).Error
+	return token, err
+}
+
+// SpendUserToken marks the token used. It reports false when the token was
+// already used or has expired.
+func SpendUserToken(ctx context.Context, db *gorm.DB, token *UserToken) (bool, error) {
+	now := time.Now()
+	result := db.WithContext(ctx).Model(&UserToken{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", token.ID, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	token.UsedAt = &now
+	return true, nil
+}
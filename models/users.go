@@ -7,15 +7,41 @@ import (
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type User struct {
-	ID        int     `json:"id" gorm:"type:integer;primaryKey"`
-	UserId    string  `json:"user_id" gorm:"type:uuid;not null;index"`
-	Email     string  `json:"email" gorm:"uniqueIndex;not null"`
-	Password  string  `json:"-" gorm:"not null"`
-	Account   Account `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
-	CreatedAt time.Time
+	ID       int     `json:"id" gorm:"type:integer;primaryKey"`
+	UserId   string  `json:"user_id" gorm:"type:uuid;not null;index"`
+	Email    string  `json:"email" gorm:"uniqueIndex;not null"`
+	Password string  `json:"-" gorm:"not null"`
+	Account  Account `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	// Accounts holds every account of the user, one per currency. Account is
+	// the first of them, opened at registration.
+	Accounts []Account `json:"accounts" gorm:"foreignKey:UserID;references:ID"`
+	// KYCTier picks the transaction limits that apply to the user. New users
+	// start on tier 1.
+	KYCTier int `json:"kyc_tier" gorm:"not null;default:1"`
+	// TOTPSecret is set at enrollment and only checked once MFAEnabled is
+	// confirmed with a first code. TOTPLastStep is the last step a code was
+	// accepted for, so codes cannot be replayed. Wrong codes count in
+	// MFAFailures until MFALockedUntil is set.
+	TOTPSecret     string     `json:"-"`
+	MFAEnabled     bool       `json:"mfa_enabled" gorm:"not null;default:false"`
+	TOTPLastStep   int64      `json:"-" gorm:"not null;default:0"`
+	MFAFailures    int        `json:"-" gorm:"not null;default:0"`
+	MFALockedUntil *time.Time `json:"-"`
+	// PinHash is the bcrypt hash of the transaction PIN that confirms
+	// payments. Wrong PINs count in PinFailures until PinLockedUntil is set.
+	PinHash        string     `json:"-"`
+	PinFailures    int        `json:"-" gorm:"not null;default:0"`
+	PinLockedUntil *time.Time `json:"pin_locked_until,omitempty"`
+	PinSet         bool       `json:"pin_set" gorm:"-"`
+	// EmailVerifiedAt is set once the user follows the verification email.
+	// Unverified users cannot send money out of the wallet.
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	EmailVerified   bool       `json:"email_verified" gorm:"-"`
+	CreatedAt       time.Time
 }
 
 func (u *User) BeforeCreate(tx *gorm.DB) error {
@@ -23,6 +49,12 @@ func (u *User) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+func (u *User) AfterFind(tx *gorm.DB) error {
+	u.PinSet = u.PinHash != ""
+	u.EmailVerified = u.EmailVerifiedAt != nil
+	return nil
+}
+
 func CreateUser(ctx context.Context, db *gorm.DB, User *User) (err error) {
 	err = db.WithContext(ctx).Create(&User).Error
 	if err != nil {
@@ -46,9 +78,50 @@ func PasswordCompare(password, hashedPassword string) error {
 
 func UserProfile(ctx context.Context, db *gorm.DB, user_id string) (*User, error) {
 	var user User
-	err := db.Model(&User{}).Preload("Account").Where("user_id = ?", user_id).Find(&user).Error
+	err := db.Model(&User{}).Preload("Accounts", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Where("user_id = ?", user_id).Find(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(user.Accounts) > 0 {
+		user.Account = user.Accounts[0]
+	}
+	return &user, nil
+}
+
+// VerifyExistingEmails counts the email of every user without a verification
+// as verified at registration. It runs once, when the column is added, so
+// users who registered before emails were verified can still send money out.
+func VerifyExistingEmails(ctx context.Context, db *gorm.DB) error {
+	return db.WithContext(ctx).Model(&User{}).
+		Where("email_verified_at IS NULL").
+		Update("email_verified_at", gorm.Expr("created_at")).Error
+}
+
+// LockUser reads the user and locks its row until the transaction ends.
+func LockUser(ctx context.Context, tx *gorm.DB, userID int) (*User, error) {
+	var user User
+	err := tx.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", userID).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
+
+func FindUser(ctx context.Context, db *gorm.DB, id int) (User, error) {
+	var user User
+	err := db.WithContext(ctx).Where("id = ?", id).First(&user).Error
+	return user, err
+}
+
+func UpdatePassword(ctx context.Context, db *gorm.DB, userID int, hash string) error {
+	return db.WithContext(ctx).Model(&User{}).Where("id = ?", userID).Update("password", hash).Error
+}
+
+// MarkEmailVerified records the verification once; later calls keep the first
+// time.
+func MarkEmailVerified(ctx context.Context, db *gorm.DB, userID int, at time.Time) error {
+	return db.WithContext(ctx).Model(&User{}).Where("id = ? AND email_verified_at IS NULL", userID).
+		Update("email_verified_at", at).Error
+}
@@ -0,0 +1,222 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type WebhookDeliveryStatus string
+
+const (
+	DeliveryPending   WebhookDeliveryStatus = "pending"
+	DeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	DeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookSubscription is an endpoint a user wants payment events for their
+// accounts posted to. EventTypes is a comma separated list, "*" matches all.
+type WebhookSubscription struct {
+	ID             int            `json:"id" gorm:"type:integer;primaryKey"`
+	SubscriptionID string         `json:"subscription_id" gorm:"type:uuid;not null;uniqueIndex"`
+	UserID         string         `json:"user_id" gorm:"type:varchar(64);not null;index"`
+	URL            string         `json:"url" gorm:"type:text;not null"`
+	EventTypes     string         `json:"event_types" gorm:"type:text;not null"`
+	Secret         string         `json:"-" gorm:"type:varchar(128);not null"`
+	CreatedAt      time.Time      `json:"created_at"`
+	DeletedAt      gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// WebhookDelivery is one event sent to one subscription, retried until the
+// endpoint accepts it or the retry schedule runs out.
+type WebhookDelivery struct {
+	ID             int                      `json:"id" gorm:"type:integer;primaryKey"`
+	DeliveryID     string                   `json:"delivery_id" gorm:"type:uuid;not null;uniqueIndex"`
+	SubscriptionID string                   `json:"subscription_id" gorm:"type:uuid;not null;uniqueIndex:idx_delivery_subscription_event"`
+	EventID        string                   `json:"event_id" gorm:"type:uuid;not null;uniqueIndex:idx_delivery_subscription_event"`
+	EventType      string                   `json:"event_type" gorm:"type:varchar(64);not null"`
+	Payload        string                   `json:"payload" gorm:"type:text;not null"`
+	Status         WebhookDeliveryStatus    `json:"status" gorm:"type:varchar(20);not null;index:idx_delivery_due"`
+	Attempts       int                      `json:"attempts" gorm:"not null;default:0"`
+	NextAttemptAt  time.Time                `json:"next_attempt_at" gorm:"index:idx_delivery_due"`
+	ResponseStatus int                      `json:"response_status"`
+	LastError      string                   `json:"last_error" gorm:"type:text"`
+	DeliveredAt    *time.Time               `json:"delivered_at"`
+	AttemptLog     []WebhookDeliveryAttempt `json:"attempt_log,omitempty" gorm:"foreignKey:DeliveryID;references:DeliveryID"`
+	CreatedAt      time.Time                `json:"created_at"`
+	UpdatedAt      time.Time                `json:"updated_at"`
+}
+
+// WebhookDeliveryAttempt is the delivery log: one row per HTTP call made.
+type WebhookDeliveryAttempt struct {
+	ID             int       `json:"id" gorm:"type:integer;primaryKey"`
+	DeliveryID     string    `json:"delivery_id" gorm:"type:uuid;not null;index"`
+	ResponseStatus int       `json:"response_status"`
+	Error          string    `json:"error" gorm:"type:text"`
+	DurationMs     int64     `json:"duration_ms"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (s *WebhookSubscription) BeforeCreate(tx *gorm.DB) error {
+	s.SubscriptionID = uuid.NewString()
+	return nil
+}
+
+func (d *WebhookDelivery) BeforeCreate(tx *gorm.DB) error {
+	d.DeliveryID = uuid.NewString()
+	return nil
+}
+
+func (s *WebhookSubscription) Events() []string {
+	return strings.Split(s.EventTypes, ",")
+}
+
+func (s *WebhookSubscription) Subscribes(eventType string) bool {
+	for _, subscribed := range s.Events() {
+		if subscribed == "*" || subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func CreateWebhookSubscription(ctx context.Context, db *gorm.DB, subscription *WebhookSubscription) error {
+	return db.WithContext(ctx).Create(subscription).Error
+}
+
+func ListWebhookSubscriptions(ctx context.Context, db *gorm.DB, userID string) ([]WebhookSubscription, error) {
+	var subscriptions []WebhookSubscription
+	err := db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&subscriptions).Error
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+func FindWebhookSubscription(ctx context.Context, db *gorm.DB, userID, subscriptionID string) (*WebhookSubscription, error) {
+	var subscription WebhookSubscription
+	err := db.WithContext(ctx).Where("user_id = ? AND subscription_id = ?", userID, subscriptionID).First(&subscription).Error
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+func DeleteWebhookSubscription(ctx context.Context, db *gorm.DB, subscription *WebhookSubscription) error {
+	return db.WithContext(ctx).Delete(subscription).Error
+}
+
+// SubscriptionsForAccounts returns the subscriptions of the users owning any
+// of the given accounts.
+func SubscriptionsForAccounts(ctx context.Context, db *gorm.DB, accountIDs []string) ([]WebhookSubscription, error) {
+	var subscriptions []WebhookSubscription
+	err := db.WithContext(ctx).
+		Where("user_id IN (?)", db.Model(&User{}).Select("users.user_id").
+			Joins("JOIN accounts ON accounts.user_id = users.id").
+			Where("accounts.account_id IN ?", accountIDs)).
+		Find(&subscriptions).Error
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// CreateWebhookDeliveries queues the deliveries, skipping any that already
+// exist for the same subscription and event.
+func CreateWebhookDeliveries(ctx context.Context, db *gorm.DB, deliveries []WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error
+}
+
+func DueWebhookDeliveries(ctx context.Context, db *gorm.DB, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := db.WithContext(ctx).
+		Where("status = ? AND next_attempt_at <= ?", DeliveryPending, time.Now()).
+		Order("id").
+		Limit(limit).
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// ClaimWebhookDelivery hides the delivery from other workers for the lease.
+func ClaimWebhookDelivery(ctx context.Context, db *gorm.DB, delivery *WebhookDelivery, lease time.Duration) (bool, error) {
+	now := time.Now()
+	result := db.WithContext(ctx).Model(&WebhookDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", delivery.ID, DeliveryPending, now).
+		Update("next_attempt_at", now.Add(lease))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RecordWebhookAttempt logs the attempt and moves the delivery to its next
+// state in one transaction.
+func RecordWebhookAttempt(ctx context.Context, db *gorm.DB, delivery *WebhookDelivery, attempt WebhookDeliveryAttempt, status WebhookDeliveryStatus, nextAttempt time.Time) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		attempt.DeliveryID = delivery.DeliveryID
+		err := tx.Create(&attempt).Error
+		if err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{
+			"status":          status,
+			"attempts":        delivery.Attempts + 1,
+			"next_attempt_at": nextAttempt,
+			"response_status": attempt.ResponseStatus,
+			"last_error":      attempt.Error,
+		}
+		if status == DeliverySucceeded {
+			updates["delivered_at"] = time.Now()
+		}
+		return tx.Model(delivery).Updates(updates).Error
+	})
+}
+
+func ListWebhookDeliveries(ctx context.Context, db *gorm.DB, subscriptionID string, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := db.WithContext(ctx).
+		Preload("AttemptLog", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where("subscription_id = ?", subscriptionID).
+		Order("id desc").
+		Limit(limit).
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// FindUserWebhookDelivery loads a delivery only if it belongs to one of the
+// user's subscriptions.
+func FindUserWebhookDelivery(ctx context.Context, db *gorm.DB, userID, deliveryID string) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := db.WithContext(ctx).
+		Where("delivery_id = ? AND subscription_id IN (?)", deliveryID,
+			db.Model(&WebhookSubscription{}).Select("subscription_id").Where("user_id = ?", userID)).
+		First(&delivery).Error
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// RequeueWebhookDelivery schedules the delivery to be sent again right away
+// with a fresh retry schedule.
+func RequeueWebhookDelivery(ctx context.Context, db *gorm.DB, delivery *WebhookDelivery) error {
+	return db.WithContext(ctx).Model(delivery).Updates(map[string]interface{}{
+		"status":          DeliveryPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	}).Error
+}
@@ -0,0 +1,30 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileMailer writes each message to its own file in Dir instead of sending
+// it, for local development. Files are named after the time and recipient so
+// the latest mail for an address is easy to find.
+type FileMailer struct {
+	Dir string
+}
+
+func (mailer FileMailer) Send(ctx context.Context, message Message) error {
+	err := os.MkdirAll(mailer.Dir, 0o700)
+	if err != nil {
+		return err
+	}
+	recipient := strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(message.To)
+	name := fmt.Sprintf("%s-%s-%s.eml", time.Now().UTC().Format("20060102T150405"), recipient, uuid.NewString()[:8])
+	content := fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s\r\n", message.To, message.Subject, message.Body)
+	return os.WriteFile(filepath.Join(mailer.Dir, name), []byte(content), 0o600)
+}
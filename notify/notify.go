@@ -0,0 +1,38 @@
+// Package notify delivers messages to users, such as password reset and
+// email verification links.
+package notify
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Message is one email to a user.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages. SMTPMailer delivers them; FileMailer and LogMailer
+// are for local development.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// LogMailer writes messages to the log instead of sending them. The log then
+// holds the secrets in the messages, so it is not for production.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, message Message) error {
+	logrus.WithFields(logrus.Fields{
+		"to":      message.To,
+		"subject": message.Subject,
+		"body":    message.Body,
+	}).Info("Email not sent, logged instead")
+	return nil
+}
+
+// Default sends the messages of the service.
+var Default Mailer = LogMailer{}
@@ -0,0 +1,31 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+)
+
+// SMTPMailer sends messages through an SMTP server, authenticating with
+// PLAIN auth when a username is set.
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (mailer SMTPMailer) Send(ctx context.Context, message Message) error {
+	var auth smtp.Auth
+	if mailer.Username != "" {
+		host, _, err := net.SplitHostPort(mailer.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", mailer.Username, mailer.Password, host)
+	}
+	content := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		mailer.From, message.To, message.Subject, message.Body)
+	return smtp.SendMail(mailer.Addr, auth, mailer.From, []string{message.To}, []byte(content))
+}
@@ -0,0 +1,66 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+)
+
+type PayoutStatus string
+
+const (
+	PayoutPending   PayoutStatus = "pending"
+	PayoutSucceeded PayoutStatus = "success"
+	PayoutFailed    PayoutStatus = "failed"
+	PayoutCancelled PayoutStatus = "cancelled"
+)
+
+var (
+	ErrPayoutNotFound  = errors.New("payout not found")
+	ErrNotCancellable  = errors.New("payout can no longer be cancelled")
+	ErrUnknownProvider = errors.New("no payout provider for transaction type")
+	ErrInvalidWebhook  = errors.New("invalid webhook signature or payload")
+)
+
+type PayoutRequest struct {
+	// Reference is our payment id, sent along so the provider can echo it
+	// back. Providers treat it as the idempotency key of the payout.
+	Reference string
+	Amount    decimal.Decimal
+	Currency  string
+	Recipient structs.RecipientDetails
+}
+
+type PayoutResult struct {
+	ProviderReference string
+	Status            PayoutStatus
+	Message           string
+}
+
+// PayoutProvider sends money out of the platform to a bank account or mobile
+// money wallet. Payouts settle asynchronously: Submit only tells whether the
+// provider accepted the payout, the final status arrives through a webhook.
+type PayoutProvider interface {
+	Name() string
+	Submit(ctx context.Context, request PayoutRequest) (PayoutResult, error)
+	QueryStatus(ctx context.Context, providerReference string) (PayoutResult, error)
+	// QueryReference looks a payout up by the Reference it was submitted
+	// with, for submissions whose answer was lost. It returns
+	// ErrPayoutNotFound when the provider never received the payout.
+	QueryReference(ctx context.Context, reference string) (PayoutResult, error)
+	Cancel(ctx context.Context, providerReference string) (PayoutResult, error)
+	// ParseWebhook verifies the signature of a status callback and returns the
+	// payout it reports on. It returns ErrInvalidWebhook when verification fails.
+	ParseWebhook(header http.Header, body []byte) (PayoutResult, error)
+}
+
+func ParsePayoutStatus(value string) (PayoutStatus, bool) {
+	switch status := PayoutStatus(value); status {
+	case PayoutPending, PayoutSucceeded, PayoutFailed, PayoutCancelled:
+		return status, true
+	}
+	return "", false
+}
@@ -0,0 +1,61 @@
+package providers
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/grey/models"
+)
+
+// Registry maps each external transaction type to the provider that pays it out.
+type Registry struct {
+	mu        sync.RWMutex
+	providers map[models.TransactionType]PayoutProvider
+}
+
+func NewRegistry() *Registry {
+	return &Registry{providers: map[models.TransactionType]PayoutProvider{}}
+}
+
+func (r *Registry) Register(transactionType models.TransactionType, provider PayoutProvider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[transactionType] = provider
+}
+
+func (r *Registry) Lookup(transactionType models.TransactionType) (PayoutProvider, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	provider, ok := r.providers[transactionType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, transactionType)
+	}
+	return provider, nil
+}
+
+// LookupByName finds a registered provider by its name, as used in webhook URLs.
+func (r *Registry) LookupByName(name string) (PayoutProvider, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, provider := range r.providers {
+		if provider.Name() == name {
+			return provider, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+}
+
+// Default is the registry used by the HTTP handlers.
+var Default = NewRegistry()
+
+func Register(transactionType models.TransactionType, provider PayoutProvider) {
+	Default.Register(transactionType, provider)
+}
+
+func Lookup(transactionType models.TransactionType) (PayoutProvider, error) {
+	return Default.Lookup(transactionType)
+}
+
+func LookupByName(name string) (PayoutProvider, error) {
+	return Default.LookupByName(name)
+}
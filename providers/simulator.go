@@ -0,0 +1,183 @@
+package providers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const SimulatorSignatureHeader = "X-Simulator-Signature"
+
+// SimulatorWebhook is the body the simulator posts when a payout settles.
+type SimulatorWebhook struct {
+	ProviderReference string       `json:"provider_reference"`
+	Status            PayoutStatus `json:"status"`
+	Message           string       `json:"message"`
+}
+
+// Simulator is an in-process provider for local development and tests. Every
+// payout it accepts ends up in the configured outcome.
+type Simulator struct {
+	name          string
+	webhookSecret []byte
+	mu            sync.Mutex
+	outcome       PayoutStatus
+	payouts       map[string]PayoutResult
+	references    map[string]string
+	submitErr     error
+	submitAccepts bool
+}
+
+func NewSimulator(name string, outcome PayoutStatus, webhookSecret string) *Simulator {
+	return &Simulator{
+		name:          name,
+		webhookSecret: []byte(webhookSecret),
+		outcome:       outcome,
+		payouts:       map[string]PayoutResult{},
+		references:    map[string]string{},
+	}
+}
+
+func (s *Simulator) Name() string {
+	return s.name
+}
+
+// SetOutcome changes the status given to payouts submitted from now on.
+func (s *Simulator) SetOutcome(outcome PayoutStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outcome = outcome
+}
+
+// FailSubmit makes the next Submit return err, the way a timeout or dropped
+// connection would. When accepted is set the payout is still taken, as if
+// only the answer was lost.
+func (s *Simulator) FailSubmit(err error, accepted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.submitErr = err
+	s.submitAccepts = accepted
+}
+
+func (s *Simulator) Submit(ctx context.Context, request PayoutRequest) (PayoutResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	submitErr, accepted := s.submitErr, s.submitAccepts
+	s.submitErr, s.submitAccepts = nil, false
+	if submitErr != nil && !accepted {
+		return PayoutResult{}, submitErr
+	}
+	if providerReference, ok := s.references[request.Reference]; ok {
+		return s.payouts[providerReference], submitErr
+	}
+
+	result := PayoutResult{
+		ProviderReference: "sim_" + uuid.NewString(),
+		Status:            s.outcome,
+	}
+	if result.Status == PayoutFailed {
+		result.Message = "payout declined by simulator"
+	}
+	s.payouts[result.ProviderReference] = result
+	if request.Reference != "" {
+		s.references[request.Reference] = result.ProviderReference
+	}
+	if submitErr != nil {
+		return PayoutResult{}, submitErr
+	}
+	return result, nil
+}
+
+func (s *Simulator) QueryReference(ctx context.Context, reference string) (PayoutResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	providerReference, ok := s.references[reference]
+	if !ok {
+		return PayoutResult{}, ErrPayoutNotFound
+	}
+	return s.payouts[providerReference], nil
+}
+
+func (s *Simulator) QueryStatus(ctx context.Context, providerReference string) (PayoutResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, ok := s.payouts[providerReference]
+	if !ok {
+		return PayoutResult{}, ErrPayoutNotFound
+	}
+	return result, nil
+}
+
+func (s *Simulator) Cancel(ctx context.Context, providerReference string) (PayoutResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, ok := s.payouts[providerReference]
+	if !ok {
+		return PayoutResult{}, ErrPayoutNotFound
+	}
+	if result.Status != PayoutPending {
+		return result, ErrNotCancellable
+	}
+	result.Status = PayoutCancelled
+	s.payouts[providerReference] = result
+	return result, nil
+}
+
+// Settle moves a payout that is still pending to its final status, the way a
+// real provider would some time after accepting it.
+func (s *Simulator) Settle(providerReference string, status PayoutStatus) (PayoutResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, ok := s.payouts[providerReference]
+	if !ok {
+		return PayoutResult{}, ErrPayoutNotFound
+	}
+	result.Status = status
+	s.payouts[providerReference] = result
+	return result, nil
+}
+
+// SignWebhook returns the signature header value for a webhook body.
+func (s *Simulator) SignWebhook(body []byte) string {
+	mac := hmac.New(sha256.New, s.webhookSecret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Simulator) ParseWebhook(header http.Header, body []byte) (PayoutResult, error) {
+	signature, err := hex.DecodeString(header.Get(SimulatorSignatureHeader))
+	if err != nil || len(s.webhookSecret) == 0 {
+		return PayoutResult{}, ErrInvalidWebhook
+	}
+	mac := hmac.New(sha256.New, s.webhookSecret)
+	mac.Write(body)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return PayoutResult{}, ErrInvalidWebhook
+	}
+
+	var webhook SimulatorWebhook
+	err = json.Unmarshal(body, &webhook)
+	if err != nil || webhook.ProviderReference == "" {
+		return PayoutResult{}, ErrInvalidWebhook
+	}
+	if _, ok := ParsePayoutStatus(string(webhook.Status)); !ok {
+		return PayoutResult{}, ErrInvalidWebhook
+	}
+
+	return PayoutResult{
+		ProviderReference: webhook.ProviderReference,
+		Status:            webhook.Status,
+		Message:           webhook.Message,
+	}, nil
+}
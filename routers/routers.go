@@ -1,6 +1,10 @@
 package routers
 
 import (
+	"log"
+	"os"
+	"strings"
+
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/grey/controllers"
@@ -12,32 +16,87 @@ func NewRouter() *gin.Engine {
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
 
+	// the client IP counts failed logins, so only listed proxies may set it
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		err := router.SetTrustedProxies(strings.Split(proxies, ","))
+		if err != nil {
+			log.Fatalf("Failed to set trusted proxies: %v", err)
+		}
+	}
+
 	// Configure CORS
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "Idempotency-Key", "X-MFA-Code", "X-Transaction-PIN"}
 	router.Use(cors.New(config))
 
 	// Initialize repositories
 	userRepo := &controllers.UserGroup{}
 	paymentRepo := &controllers.PaymentGroup{}
+	webhookRepo := &controllers.WebhookGroup{}
 
 	// Stripe API endpoints
 	paymentGroup := router.Group("/payment/api")
 	{
 
-		paymentGroup.POST("/internal_payment", middlewares.SessionMiddleware(), paymentRepo.InternalPayment)
-		paymentGroup.POST("/external_payment", middlewares.SessionMiddleware(), paymentRepo.ExternalPayment)
-		paymentGroup.POST("/topup", middlewares.SessionMiddleware(), paymentRepo.TopUp)
+		paymentGroup.POST("/internal_payment", middlewares.SessionMiddleware(), middlewares.IdempotencyMiddleware(), paymentRepo.InternalPayment)
+		paymentGroup.POST("/external_payment", middlewares.SessionMiddleware(), middlewares.IdempotencyMiddleware(), paymentRepo.ExternalPayment)
+		paymentGroup.POST("/topup", middlewares.SessionMiddleware(), middlewares.IdempotencyMiddleware(), paymentRepo.TopUp)
+		paymentGroup.POST("/payments/:id/refund", middlewares.SessionMiddleware(), middlewares.IdempotencyMiddleware(), paymentRepo.Refund)
+		paymentGroup.POST("/webhooks/:provider", paymentRepo.ProviderWebhook)
+		paymentGroup.GET("/currencies", paymentRepo.Currencies)
+		paymentGroup.POST("/fx/quotes", middlewares.SessionMiddleware(), paymentRepo.CreateFXQuote)
+		paymentGroup.GET("/fees/preview", middlewares.SessionMiddleware(), paymentRepo.PreviewFee)
+		paymentGroup.GET("/limits", middlewares.SessionMiddleware(), paymentRepo.RemainingLimits)
+		paymentGroup.POST("/holds", middlewares.SessionMiddleware(), middlewares.IdempotencyMiddleware(), paymentRepo.PlaceHold)
+		paymentGroup.GET("/holds/:hold_id", middlewares.SessionMiddleware(), paymentRepo.GetHold)
+		paymentGroup.POST("/holds/:hold_id/capture", middlewares.SessionMiddleware(), middlewares.IdempotencyMiddleware(), paymentRepo.CaptureHold)
+		paymentGroup.POST("/holds/:hold_id/void", middlewares.SessionMiddleware(), paymentRepo.VoidHold)
+		paymentGroup.POST("/schedules", middlewares.SessionMiddleware(), middlewares.IdempotencyMiddleware(), paymentRepo.CreateSchedule)
+		paymentGroup.GET("/schedules", middlewares.SessionMiddleware(), paymentRepo.ListSchedules)
+		paymentGroup.GET("/schedules/:schedule_id", middlewares.SessionMiddleware(), paymentRepo.GetSchedule)
+		paymentGroup.POST("/schedules/:schedule_id/skip", middlewares.SessionMiddleware(), paymentRepo.SkipSchedule)
+		paymentGroup.POST("/schedules/:schedule_id/pause", middlewares.SessionMiddleware(), paymentRepo.PauseSchedule)
+		paymentGroup.POST("/schedules/:schedule_id/resume", middlewares.SessionMiddleware(), paymentRepo.ResumeSchedule)
+		paymentGroup.POST("/schedules/:schedule_id/cancel", middlewares.SessionMiddleware(), paymentRepo.CancelSchedule)
+		paymentGroup.POST("/payouts/batches", middlewares.SessionMiddleware(), middlewares.IdempotencyMiddleware(), paymentRepo.CreatePayoutBatch)
+		paymentGroup.GET("/payouts/batches/:batch_id", middlewares.SessionMiddleware(), paymentRepo.GetPayoutBatch)
+		paymentGroup.GET("/payouts/batches/:batch_id/results", middlewares.SessionMiddleware(), paymentRepo.PayoutBatchResults)
+		paymentGroup.POST("/accounts", middlewares.SessionMiddleware(), paymentRepo.OpenAccount)
+		paymentGroup.GET("/accounts", middlewares.SessionMiddleware(), paymentRepo.ListAccounts)
+		paymentGroup.GET("/accounts/:account_id/transactions", middlewares.SessionMiddleware(), paymentRepo.AccountTransactions)
+		paymentGroup.GET("/accounts/:account_id/statement", middlewares.SessionMiddleware(), paymentRepo.AccountStatement)
 
 	}
 
+	// public keys for verifying session tokens
+	router.GET("/.well-known/jwks.json", userRepo.JWKS)
+
 	userGroup := router.Group("/user/api")
 	{
 		userGroup.POST("/register", userRepo.CreateUser)
 		userGroup.POST("/login", userRepo.Login)
+		userGroup.POST("/login/mfa", middlewares.MFAPendingMiddleware(), userRepo.VerifyLogin)
+		userGroup.POST("/refresh", userRepo.Refresh)
+		userGroup.POST("/logout", middlewares.SessionMiddleware(), userRepo.Logout)
 		userGroup.GET("/profile", middlewares.SessionMiddleware(), userRepo.UserProfile)
+		userGroup.POST("/pin", middlewares.SessionMiddleware(), userRepo.SetPIN)
+		userGroup.PUT("/pin", middlewares.SessionMiddleware(), userRepo.ChangePIN)
+		userGroup.POST("/password/change", middlewares.SessionMiddleware(), userRepo.ChangePassword)
+		userGroup.POST("/password/forgot", userRepo.ForgotPassword)
+		userGroup.POST("/password/reset", userRepo.ResetPassword)
+		userGroup.POST("/email/verify", userRepo.VerifyEmail)
+		userGroup.POST("/email/resend", middlewares.SessionMiddleware(), userRepo.ResendVerification)
+		userGroup.POST("/mfa/enroll", middlewares.SessionMiddleware(), userRepo.EnrollMFA)
+		userGroup.POST("/mfa/confirm", middlewares.SessionMiddleware(), userRepo.ConfirmMFA)
+		userGroup.POST("/mfa/disable", middlewares.SessionMiddleware(), userRepo.DisableMFA)
+
+		userGroup.POST("/webhooks", middlewares.SessionMiddleware(), webhookRepo.CreateSubscription)
+		userGroup.GET("/webhooks", middlewares.SessionMiddleware(), webhookRepo.ListSubscriptions)
+		userGroup.DELETE("/webhooks/:id", middlewares.SessionMiddleware(), webhookRepo.DeleteSubscription)
+		userGroup.GET("/webhooks/:id/deliveries", middlewares.SessionMiddleware(), webhookRepo.ListDeliveries)
+		userGroup.POST("/webhooks/deliveries/:delivery_id/redeliver", middlewares.SessionMiddleware(), webhookRepo.Redeliver)
 	}
 
 	return router
@@ -0,0 +1,279 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+
+	"github.com/grey/models"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAccountExists    = errors.New("an account in this currency already exists")
+	ErrCurrencyMismatch = errors.New("payment currency does not match the account currency")
+)
+
+// OpenAccount opens an account for the user in the currency. A user holds at
+// most one account per currency, the unique index on the two settles
+// concurrent requests.
+func OpenAccount(ctx context.Context, DB *gorm.DB, userID int, currency string) (models.Account, error) {
+	if _, err := models.LookupCurrency(currency); err != nil {
+		return models.Account{}, err
+	}
+
+	account := models.Account{
+		UserID:   userID,
+		Currency: currency,
+		Balance:  decimal.Zero,
+	}
+	created, err := models.CreateUserAccount(ctx, DB, &account)
+	if err != nil {
+		return models.Account{}, err
+	}
+	if !created {
+		return models.Account{}, ErrAccountExists
+	}
+	return account, nil
+}
+
+// checkCurrency rejects a payment in a currency the registry does not know,
+// with more decimals than the currency allows, or touching an account held in
+// another currency.
+func checkCurrency(ctx context.Context, tx *gorm.DB, currency string, amount decimal.Decimal, accountIDs ...string) error {
+	registered, err := models.LookupCurrency(currency)
+	if err != nil {
+		return err
+	}
+	if !registered.ValidAmount(amount) {
+		return ErrInvalidAmount
+	}
+
+	for _, accountID := range accountIDs {
+		account, err := models.IsAccountExists(ctx, tx, accountID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAccountNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if account.Currency != currency {
+			return ErrCurrencyMismatch
+		}
+	}
+	return nil
+}
+
+// legacyTopUpDescription marks the top-ups among the payments made before
+// payments had a type.
+const legacyTopUpDescription = "Top up"
+
+// MigrateLegacyLedger brings the money that moved before the ledger existed
+// onto it. Every payment from before the ledger gets a balanced journal in
+// place of the single-sided entry it may have written, and every customer
+// account whose balance is still not what its ledger adds up to gets an
+// opening balance journal against the opening equity account, dated at the
+// account's creation. The journals are posted in one transaction, oldest
+// first, so entry ids follow the dates the journals carry and running
+// balances agree with statements. It runs at startup, before new payments
+// post to the ledger; once everything is migrated it posts nothing.
+func MigrateLegacyLedger(ctx context.Context, DB *gorm.DB) error {
+	tx := DB.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	// Defer rollback in case of error
+	defer tx.Rollback()
+
+	payments, err := models.LegacyPayments(ctx, tx)
+	if err != nil {
+		return err
+	}
+	err = models.DeleteLegacyEntries(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	var journals []legacyJournal
+	moved := map[string]decimal.Decimal{}
+	for i := range payments {
+		journal, err := legacyPaymentJournal(ctx, tx, &payments[i])
+		if err != nil {
+			return err
+		}
+		for _, entry := range journal.entries {
+			moved[entry.AccountID] = moved[entry.AccountID].Add(entry.Amount)
+		}
+		journals = append(journals, journal)
+	}
+
+	accountIDs, err := models.UnbackedAccounts(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for accountID := range moved {
+		if !slices.Contains(accountIDs, accountID) {
+			accountIDs = append(accountIDs, accountID)
+		}
+	}
+	var openings []legacyJournal
+	for _, accountID := range accountIDs {
+		journal, ok, err := openingBalanceJournal(ctx, tx, accountID, moved[accountID])
+		if err != nil {
+			return err
+		}
+		if ok {
+			openings = append(openings, journal)
+		}
+	}
+
+	// an opening balance sorts ahead of the payments made at the same moment
+	journals = append(openings, journals...)
+	sort.SliceStable(journals, func(i, j int) bool {
+		return journals[i].payment.CreatedAt.Before(journals[j].payment.CreatedAt)
+	})
+	for _, journal := range journals {
+		err = journal.post(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+// legacyJournal is a journal MigrateLegacyLedger posts for money that already
+// moved. Customer balances hold the money already, only the balance of the
+// system account on the other side moves.
+type legacyJournal struct {
+	payment *models.Payment
+	// opening journals also create their payment
+	opening       bool
+	systemAccount string
+	entries       []models.LedgerEntry
+}
+
+// legacyPaymentJournal is the journal for a payment made before the ledger:
+// a transfer between two accounts, a top-up or an external payment.
+func legacyPaymentJournal(ctx context.Context, tx *gorm.DB, payment *models.Payment) (legacyJournal, error) {
+	journal := legacyJournal{payment: payment}
+	if payment.FromAccount != payment.ToAccount {
+		journal.entries = []models.LedgerEntry{
+			models.DebitEntry(payment.FromAccount, payment.Amount, payment.Currency),
+			models.CreditEntry(payment.ToAccount, payment.Amount, payment.Currency),
+		}
+		return journal, nil
+	}
+
+	if payment.Description == legacyTopUpDescription {
+		fundingAccount, err := models.EnsureSystemAccount(ctx, tx, models.TopUpFunding, payment.Currency)
+		if err != nil {
+			return legacyJournal{}, err
+		}
+		journal.systemAccount = fundingAccount
+		journal.entries = []models.LedgerEntry{
+			models.DebitEntry(fundingAccount, payment.Amount, payment.Currency),
+			models.CreditEntry(payment.FromAccount, payment.Amount, payment.Currency),
+		}
+		return journal, nil
+	}
+
+	clearingAccount, err := models.EnsureSystemAccount(ctx, tx, models.ExternalClearing, payment.Currency)
+	if err != nil {
+		return legacyJournal{}, err
+	}
+	journal.systemAccount = clearingAccount
+	journal.entries = []models.LedgerEntry{
+		models.DebitEntry(payment.FromAccount, payment.Amount, payment.Currency),
+		models.CreditEntry(clearingAccount, payment.Amount, payment.Currency),
+	}
+	return journal, nil
+}
+
+// openingBalanceJournal is the opening balance journal of a customer account
+// whose balance is not what its ledger adds up to once the pending amount is
+// posted. It reports false when the account needs none.
+func openingBalanceJournal(ctx context.Context, tx *gorm.DB, accountID string, pending decimal.Decimal) (legacyJournal, bool, error) {
+	account, err := models.LockAccount(ctx, tx, accountID)
+	if err != nil {
+		return legacyJournal{}, false, err
+	}
+	if account.UserID == 0 {
+		return legacyJournal{}, false, nil
+	}
+	total, err := models.LedgerTotal(ctx, tx, accountID)
+	if err != nil {
+		return legacyJournal{}, false, err
+	}
+	opening := account.Balance.Sub(total).Sub(pending)
+	if opening.IsZero() {
+		return legacyJournal{}, false, nil
+	}
+
+	equityAccount, err := models.EnsureSystemAccount(ctx, tx, models.OpeningEquity, account.Currency)
+	if err != nil {
+		return legacyJournal{}, false, err
+	}
+
+	journal := legacyJournal{
+		payment: &models.Payment{
+			FromAccount: equityAccount,
+			ToAccount:   account.AccountID,
+			Currency:    account.Currency,
+			Amount:      opening.Abs(),
+			Type:        models.OpeningBalance,
+			Description: "Opening balance",
+			CreatedAt:   account.CreatedAt,
+		},
+		opening:       true,
+		systemAccount: equityAccount,
+		entries: []models.LedgerEntry{
+			models.DebitEntry(equityAccount, opening, account.Currency),
+			models.CreditEntry(account.AccountID, opening, account.Currency),
+		},
+	}
+	if opening.IsNegative() {
+		journal.entries = []models.LedgerEntry{
+			models.CreditEntry(equityAccount, opening, account.Currency),
+			models.DebitEntry(account.AccountID, opening, account.Currency),
+		}
+	}
+	return journal, true, nil
+}
+
+func (journal legacyJournal) post(ctx context.Context, tx *gorm.DB) error {
+	if journal.opening {
+		err := models.CreatePayment(ctx, tx, journal.payment)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, entry := range journal.entries {
+		journal.entries[i].CreatedAt = journal.payment.CreatedAt
+		if entry.AccountID != journal.systemAccount {
+			continue
+		}
+		err := creditAccount(tx, entry.AccountID, entry.Amount)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := models.PostJournal(ctx, tx, journal.payment.PaymentID, journal.entries...)
+	if err != nil {
+		return err
+	}
+
+	if !journal.opening {
+		return nil
+	}
+	for _, next := range []models.PaymentStatus{models.Processing, models.Completed} {
+		err = journal.payment.TransitionTo(ctx, tx, next, "opening balance backfilled")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
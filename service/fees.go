@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/grey/fees"
+	"github.com/grey/models"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+var ErrFeeExceedsAmount = errors.New("fee exceeds the amount")
+
+// PreviewFee prices a payment with the current fee schedule without moving
+// any money.
+func PreviewFee(transactionType models.TransactionType, amount decimal.Decimal, currency string) (fees.Quote, error) {
+	if amount.LessThanOrEqual(decimal.Zero) {
+		return fees.Quote{}, ErrInvalidAmount
+	}
+	return fees.Default.Quote(transactionType, amount, currency)
+}
+
+func paymentFee(transactionType models.TransactionType, amount decimal.Decimal, currency string) (decimal.Decimal, error) {
+	quote, err := fees.Default.Quote(transactionType, amount, currency)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return quote.Fee, nil
+}
+
+// collectFee credits the payment's fee to the fee revenue account and returns
+// the journal line for it, or nothing for a free payment.
+func collectFee(ctx context.Context, tx *gorm.DB, payment *models.Payment) ([]models.LedgerEntry, error) {
+	if !payment.Fee.IsPositive() {
+		return nil, nil
+	}
+	revenueAccount, err := models.EnsureSystemAccount(ctx, tx, models.FeeRevenue, payment.Currency)
+	if err != nil {
+		return nil, err
+	}
+	err = creditAccount(tx, revenueAccount, payment.Fee)
+	if err != nil {
+		return nil, err
+	}
+	return []models.LedgerEntry{models.CreditEntry(revenueAccount, payment.Fee, payment.Currency)}, nil
+}
+
+// returnFee takes the fee back out of the fee revenue account, for payments
+// that failed after it was collected.
+func returnFee(ctx context.Context, tx *gorm.DB, payment *models.Payment) ([]models.LedgerEntry, error) {
+	if !payment.Fee.IsPositive() {
+		return nil, nil
+	}
+	revenueAccount, err := models.EnsureSystemAccount(ctx, tx, models.FeeRevenue, payment.Currency)
+	if err != nil {
+		return nil, err
+	}
+	err = debitSystemAccount(tx, revenueAccount, payment.Fee)
+	if err != nil {
+		return nil, err
+	}
+	return []models.LedgerEntry{models.DebitEntry(revenueAccount, payment.Fee, payment.Currency)}, nil
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/grey/fx"
+	"github.com/grey/models"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSameCurrency  = errors.New("accounts hold the same currency")
+	ErrQuoteNotFound = errors.New("fx quote not found")
+	ErrQuoteMismatch = errors.New("fx quote does not match the payment")
+)
+
+// QuoteFX prices the conversion of amount from the source account's currency
+// into the destination account's currency and locks the rate for the
+// pricer's QuoteTTL. The converted amount is rounded down to the minor units
+// of the destination currency.
+func QuoteFX(ctx context.Context, DB *gorm.DB, pricer *fx.Pricer, userID int, fromAccount, toAccount string, amount decimal.Decimal) (models.FXQuote, error) {
+	if amount.LessThanOrEqual(decimal.Zero) {
+		return models.FXQuote{}, ErrInvalidAmount
+	}
+
+	from, err := models.IsAccountExists(ctx, DB, fromAccount)
+	if err != nil {
+		return models.FXQuote{}, ErrAccountNotFound
+	}
+	to, err := models.IsAccountExists(ctx, DB, toAccount)
+	if err != nil {
+		return models.FXQuote{}, ErrAccountNotFound
+	}
+	if from.Currency == to.Currency {
+		return models.FXQuote{}, ErrSameCurrency
+	}
+
+	fromCurrency, err := models.LookupCurrency(from.Currency)
+	if err != nil {
+		return models.FXQuote{}, err
+	}
+	toCurrency, err := models.LookupCurrency(to.Currency)
+	if err != nil {
+		return models.FXQuote{}, err
+	}
+	if !fromCurrency.ValidAmount(amount) {
+		return models.FXQuote{}, ErrInvalidAmount
+	}
+
+	price, err := pricer.Price(ctx, from.Currency, to.Currency)
+	if err != nil {
+		return models.FXQuote{}, err
+	}
+	converted := amount.Mul(price.Rate).RoundDown(toCurrency.MinorUnits)
+	if !converted.IsPositive() {
+		return models.FXQuote{}, ErrInvalidAmount
+	}
+
+	quote := models.FXQuote{
+		UserID:       userID,
+		FromAccount:  from.AccountID,
+		ToAccount:    to.AccountID,
+		FromCurrency: from.Currency,
+		ToCurrency:   to.Currency,
+		FromAmount:   amount,
+		ToAmount:     converted,
+		MidRate:      price.MidRate,
+		Rate:         price.Rate,
+		SpreadBps:    price.SpreadBps,
+		Source:       price.Source,
+		ExpiresAt:    time.Now().Add(pricer.QuoteTTL),
+	}
+	err = models.CreateFXQuote(ctx, DB, &quote)
+	if err != nil {
+		return models.FXQuote{}, err
+	}
+	return quote, nil
+}
+
+// ProcessFXInternalPayment moves money between accounts in different
+// currencies at the rate locked by the quote. The source currency goes into
+// the FX position account and the destination currency comes out of it, so
+// the journal balances in each currency.
+func ProcessFXInternalPayment(ctx context.Context, DB *gorm.DB, userID int, quoteID, fromAccount, toAccount string) (Payment models.Payment, quote models.FXQuote, err error) {
+	tx := DB.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return Payment, quote, tx.Error
+	}
+	// Defer rollback in case of error
+	defer tx.Rollback()
+
+	quote, err = models.FindFXQuote(ctx, tx, quoteID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Payment, quote, ErrQuoteNotFound
+	}
+	if err != nil {
+		return Payment, quote, err
+	}
+	if quote.UserID != userID || quote.FromAccount != fromAccount || quote.ToAccount != toAccount {
+		return Payment, quote, ErrQuoteMismatch
+	}
+
+	err = checkCurrency(ctx, tx, quote.FromCurrency, quote.FromAmount, fromAccount)
+	if err != nil {
+		return Payment, quote, err
+	}
+	err = checkLimits(ctx, tx, fromAccount, models.InternalTransfer, quote.FromAmount, quote.FromCurrency)
+	if err != nil {
+		return Payment, quote, err
+	}
+	err = checkCurrency(ctx, tx, quote.ToCurrency, quote.ToAmount, toAccount)
+	if err != nil {
+		return Payment, quote, err
+	}
+
+	fee, err := paymentFee(models.InternalTransfer, quote.FromAmount, quote.FromCurrency)
+	if err != nil {
+		return Payment, quote, err
+	}
+
+	response := models.Payment{
+		FromAccount: fromAccount,
+		ToAccount:   toAccount,
+		Currency:    quote.FromCurrency,
+		Amount:      quote.FromAmount,
+		Fee:         fee,
+		Type:        models.InternalTransfer,
+		Description: "FX Internal Payment",
+	}
+
+	err = startPayment(ctx, tx, &response)
+	if err != nil {
+		return Payment, quote, err
+	}
+
+	total := quote.FromAmount.Add(fee)
+	err = debitAccount(tx, fromAccount, total)
+	if errors.Is(err, ErrInsufficientBalance) {
+		return Payment, quote, failPayment(ctx, tx, &response, err)
+	}
+	if err != nil {
+		return Payment, quote, err
+	}
+
+	// the quote is only spent once the funds are there, so a declined
+	// payment can be retried with the same quote
+	err = models.ConsumeFXQuote(ctx, tx, &quote, response.PaymentID)
+	if err != nil {
+		return Payment, quote, err
+	}
+
+	fromPosition, err := models.EnsureSystemAccount(ctx, tx, models.FXPosition, quote.FromCurrency)
+	if err != nil {
+		return Payment, quote, err
+	}
+	toPosition, err := models.EnsureSystemAccount(ctx, tx, models.FXPosition, quote.ToCurrency)
+	if err != nil {
+		return Payment, quote, err
+	}
+
+	err = creditAccount(tx, fromPosition, quote.FromAmount)
+	if err != nil {
+		return Payment, quote, err
+	}
+	err = debitSystemAccount(tx, toPosition, quote.ToAmount)
+	if err != nil {
+		return Payment, quote, err
+	}
+	err = creditAccount(tx, toAccount, quote.ToAmount)
+	if err != nil {
+		return Payment, quote, err
+	}
+
+	// the fee is charged in the source currency on top of the converted amount
+	feeEntries, err := collectFee(ctx, tx, &response)
+	if err != nil {
+		return Payment, quote, err
+	}
+
+	err = postJournal(ctx, tx, &response, append([]models.LedgerEntry{
+		models.DebitEntry(fromAccount, total, quote.FromCurrency),
+		models.CreditEntry(fromPosition, quote.FromAmount, quote.FromCurrency),
+		models.DebitEntry(toPosition, quote.ToAmount, quote.ToCurrency),
+		models.CreditEntry(toAccount, quote.ToAmount, quote.ToCurrency),
+	}, feeEntries...)...)
+	if err != nil {
+		return Payment, quote, err
+	}
+
+	err = transitionPayment(ctx, tx, &response, models.Completed, "funds converted and transferred")
+	if err != nil {
+		return Payment, quote, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return Payment, quote, err
+	}
+	return response, quote, nil
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/grey/models"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrHoldNotFound       = errors.New("hold not found")
+	ErrHoldExpired        = errors.New("hold has expired")
+	ErrCaptureExceedsHold = errors.New("capture exceeds the held amount")
+)
+
+// DefaultHoldTTL is how long a hold lives when the request does not say,
+// configured from HOLD_TTL at startup.
+var DefaultHoldTTL = 7 * 24 * time.Hour
+
+const MaxHoldTTL = 30 * 24 * time.Hour
+
+// PlaceHold reserves amount on the account for a later capture by the payee.
+// The hold expires after ttl, DefaultHoldTTL when zero and at most MaxHoldTTL.
+func PlaceHold(ctx context.Context, DB *gorm.DB, accountID, payeeAccount string, amount decimal.Decimal, currency string, ttl time.Duration, description string) (hold models.Hold, err error) {
+	if amount.LessThanOrEqual(decimal.Zero) {
+		return hold, ErrInvalidAmount
+	}
+	if ttl <= 0 {
+		ttl = DefaultHoldTTL
+	}
+	if ttl > MaxHoldTTL {
+		ttl = MaxHoldTTL
+	}
+
+	tx := DB.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return hold, tx.Error
+	}
+	// Defer rollback in case of error
+	defer tx.Rollback()
+
+	err = checkCurrency(ctx, tx, currency, amount, accountID, payeeAccount)
+	if err != nil {
+		return hold, err
+	}
+
+	// a capture pays out without the payer, so the limits apply up front
+	err = checkLimits(ctx, tx, accountID, models.HoldCapture, amount, currency)
+	if err != nil {
+		return hold, err
+	}
+
+	err = reserveFunds(tx, accountID, amount)
+	if err != nil {
+		return hold, err
+	}
+
+	hold = models.Hold{
+		AccountID:    accountID,
+		PayeeAccount: payeeAccount,
+		Currency:     currency,
+		Amount:       amount,
+		Description:  description,
+		ExpiresAt:    time.Now().Add(ttl),
+	}
+	err = models.CreateHold(ctx, tx, &hold)
+	if err != nil {
+		return hold, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return models.Hold{}, err
+	}
+	return hold, nil
+}
+
+// CaptureHold pays all or part of the held amount to the payee and releases
+// the rest. A zero amount captures everything. A hold is captured once.
+func CaptureHold(ctx context.Context, DB *gorm.DB, holdID string, amount decimal.Decimal) (payment models.Payment, hold models.Hold, err error) {
+	if amount.LessThan(decimal.Zero) {
+		return payment, hold, ErrInvalidAmount
+	}
+
+	tx := DB.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return payment, hold, tx.Error
+	}
+	// Defer rollback in case of error
+	defer tx.Rollback()
+
+	hold, err = activeHold(ctx, tx, holdID)
+	if err != nil {
+		return payment, hold, err
+	}
+	if amount.IsZero() {
+		amount = hold.Amount
+	}
+	if amount.GreaterThan(hold.Amount) {
+		return payment, hold, ErrCaptureExceedsHold
+	}
+
+	payment = models.Payment{
+		FromAccount: hold.AccountID,
+		ToAccount:   hold.PayeeAccount,
+		Currency:    hold.Currency,
+		Amount:      amount,
+		Type:        models.HoldCapture,
+		Description: "Hold capture",
+	}
+	err = startPayment(ctx, tx, &payment)
+	if err != nil {
+		return payment, hold, err
+	}
+
+	err = models.CloseHold(ctx, tx, &hold, models.HoldCaptured, amount, payment.PaymentID)
+	if err != nil {
+		return payment, hold, err
+	}
+
+	// the whole hold is released and the captured part debited in the same
+	// transaction, so the funds are never spendable in between
+	err = releaseFunds(tx, hold.AccountID, hold.Amount)
+	if err != nil {
+		return payment, hold, err
+	}
+	err = debitAccount(tx, hold.AccountID, amount)
+	if err != nil {
+		return payment, hold, err
+	}
+	err = creditAccount(tx, hold.PayeeAccount, amount)
+	if err != nil {
+		return payment, hold, err
+	}
+
+	err = postJournal(ctx, tx, &payment,
+		models.DebitEntry(hold.AccountID, amount, hold.Currency),
+		models.CreditEntry(hold.PayeeAccount, amount, hold.Currency),
+	)
+	if err != nil {
+		return payment, hold, err
+	}
+
+	err = transitionPayment(ctx, tx, &payment, models.Completed, "hold captured")
+	if err != nil {
+		return payment, hold, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return models.Payment{}, models.Hold{}, err
+	}
+	return payment, hold, nil
+}
+
+// VoidHold releases the held amount without paying anything.
+func VoidHold(ctx context.Context, DB *gorm.DB, holdID string) (hold models.Hold, err error) {
+	tx := DB.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return hold, tx.Error
+	}
+	// Defer rollback in case of error
+	defer tx.Rollback()
+
+	hold, err = models.FindHold(ctx, tx, holdID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return hold, ErrHoldNotFound
+	}
+	if err != nil {
+		return hold, err
+	}
+
+	err = closeHold(ctx, tx, &hold, models.HoldVoided)
+	if err != nil {
+		return hold, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return models.Hold{}, err
+	}
+	return hold, nil
+}
+
+// ExpireHolds releases the funds of active holds that ran past their expiry
+// and returns how many it expired.
+func ExpireHolds(ctx context.Context, DB *gorm.DB) (int, error) {
+	holds, err := models.ExpiredHolds(ctx, DB, 100)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, hold := range holds {
+		err = DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return closeHold(ctx, tx, &hold, models.HoldExpired)
+		})
+		if errors.Is(err, models.ErrHoldNotActive) {
+			// captured or voided since it was read
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// RunHoldExpirer expires holds every interval until the context is cancelled.
+func RunHoldExpirer(ctx context.Context, DB *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := ExpireHolds(ctx, DB); err != nil {
+				logrus.WithError(err).Error("Error expiring holds")
+			}
+		}
+	}
+}
+
+// activeHold loads a hold that can still be captured.
+func activeHold(ctx context.Context, tx *gorm.DB, holdID string) (models.Hold, error) {
+	hold, err := models.FindHold(ctx, tx, holdID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return hold, ErrHoldNotFound
+	}
+	if err != nil {
+		return hold, err
+	}
+	if hold.Status != models.HoldActive {
+		return hold, models.ErrHoldNotActive
+	}
+	if !hold.ExpiresAt.After(time.Now()) {
+		return hold, ErrHoldExpired
+	}
+	return hold, nil
+}
+
+func closeHold(ctx context.Context, tx *gorm.DB, hold *models.Hold, to models.HoldStatus) error {
+	err := models.CloseHold(ctx, tx, hold, to, decimal.Zero, "")
+	if err != nil {
+		return err
+	}
+	return releaseFunds(tx, hold.AccountID, hold.Amount)
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/grey/limits"
+	"github.com/grey/models"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// checkLimits refuses a payment from the account that would break a limit of
+// its owner's KYC tier. It runs inside the payment transaction, before the
+// payment is recorded; system accounts are not limited. Limits count the
+// payments of every account of the owner, so the owner's row stays locked
+// until the transaction ends and concurrent payments from any of their
+// accounts are checked one after the other against each other's volume.
+func checkLimits(ctx context.Context, tx *gorm.DB, accountID string, transactionType models.TransactionType, amount decimal.Decimal, currency string) error {
+	account, err := models.IsAccountExists(ctx, tx, accountID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrAccountNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if account.UserID == 0 {
+		return nil
+	}
+
+	user, err := models.LockUser(ctx, tx, account.UserID)
+	if err != nil {
+		return err
+	}
+	rule, ok := limits.Default.Rule(user.KYCTier, transactionType)
+	if !ok {
+		return nil
+	}
+
+	usage, err := limitUsage(ctx, tx, account.UserID, transactionType, currency, rule, time.Now())
+	if err != nil {
+		return err
+	}
+	return rule.Check(transactionType, amount, currency, usage)
+}
+
+// limitUsage loads only the usage the rule looks at.
+func limitUsage(ctx context.Context, DB *gorm.DB, userID int, transactionType models.TransactionType, currency string, rule limits.Rule, now time.Time) (limits.Usage, error) {
+	usage := limits.Usage{
+		Daily:   decimal.Zero,
+		Monthly: decimal.Zero,
+		Counts:  map[time.Duration]int64{},
+	}
+
+	if _, ok := rule.Amounts[currency]; ok {
+		now := now.UTC()
+		dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+		var err error
+		usage.Daily, err = limitVolume(ctx, DB, userID, transactionType, currency, dayStart)
+		if err != nil {
+			return usage, err
+		}
+		usage.Monthly, err = limitVolume(ctx, DB, userID, transactionType, currency, monthStart)
+		if err != nil {
+			return usage, err
+		}
+	}
+
+	for _, velocity := range rule.Velocity {
+		window := time.Duration(velocity.Window)
+		count, err := limitCount(ctx, DB, userID, transactionType, now.Add(-window))
+		if err != nil {
+			return usage, err
+		}
+		usage.Counts[window] = count
+	}
+	return usage, nil
+}
+
+// limitVolume is the volume counted against an amount limit. Captures are
+// limited when the hold is placed, so open holds count as captured.
+func limitVolume(ctx context.Context, DB *gorm.DB, userID int, transactionType models.TransactionType, currency string, since time.Time) (decimal.Decimal, error) {
+	volume, err := models.PaymentVolume(ctx, DB, userID, transactionType, currency, since)
+	if err != nil || transactionType != models.HoldCapture {
+		return volume, err
+	}
+	held, err := models.OpenHoldVolume(ctx, DB, userID, currency, since)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return volume.Add(held), nil
+}
+
+// limitCount is limitVolume for velocity limits.
+func limitCount(ctx context.Context, DB *gorm.DB, userID int, transactionType models.TransactionType, since time.Time) (int64, error) {
+	count, err := models.PaymentCount(ctx, DB, userID, transactionType, since)
+	if err != nil || transactionType != models.HoldCapture {
+		return count, err
+	}
+	held, err := models.OpenHoldCount(ctx, DB, userID, since)
+	if err != nil {
+		return 0, err
+	}
+	return count + held, nil
+}
+
+// RemainingLimits shows every limit of the user's tier with what is left of
+// it, ordered by transaction type and currency.
+func RemainingLimits(ctx context.Context, DB *gorm.DB, user *models.User) ([]structs.LimitStatus, error) {
+	now := time.Now()
+	rules := limits.Default.Rules(user.KYCTier)
+
+	statuses := make([]structs.LimitStatus, 0, len(rules))
+	for transactionType, rule := range rules {
+		status := structs.LimitStatus{
+			TransactionType: string(transactionType),
+			Amounts:         []structs.AmountLimitStatus{},
+			Velocity:        []structs.VelocityStatus{},
+		}
+
+		for currency, limit := range rule.Amounts {
+			usage, err := limitUsage(ctx, DB, user.ID, transactionType, currency, limits.Rule{Amounts: rule.Amounts}, now)
+			if err != nil {
+				return nil, err
+			}
+			amounts := structs.AmountLimitStatus{
+				Currency: currency,
+				Daily:    allowance(limit.Daily, usage.Daily),
+				Monthly:  allowance(limit.Monthly, usage.Monthly),
+			}
+			if limit.PerTransaction.IsPositive() {
+				perTransaction := limit.PerTransaction
+				amounts.PerTransaction = &perTransaction
+			}
+			status.Amounts = append(status.Amounts, amounts)
+		}
+		sort.Slice(status.Amounts, func(i, j int) bool { return status.Amounts[i].Currency < status.Amounts[j].Currency })
+
+		usage, err := limitUsage(ctx, DB, user.ID, transactionType, "", limits.Rule{Velocity: rule.Velocity}, now)
+		if err != nil {
+			return nil, err
+		}
+		for _, velocity := range rule.Velocity {
+			window := time.Duration(velocity.Window)
+			used := usage.Counts[window]
+			status.Velocity = append(status.Velocity, structs.VelocityStatus{
+				Count:     velocity.Count,
+				Window:    window.String(),
+				Used:      used,
+				Remaining: max(int64(velocity.Count)-used, 0),
+			})
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].TransactionType < statuses[j].TransactionType })
+	return statuses, nil
+}
+
+func allowance(limit, used decimal.Decimal) *structs.Allowance {
+	if !limit.IsPositive() {
+		return nil
+	}
+	return &structs.Allowance{
+		Limit:     limit,
+		Used:      used,
+		Remaining: decimal.Max(limit.Sub(used), decimal.Zero),
+	}
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/grey/models"
+	"github.com/grey/throttle"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidCredentials is returned for an unknown email and for a wrong
+// password alike, so the answer does not tell which emails are registered.
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrLoginThrottled     = errors.New("too many failed login attempts")
+)
+
+// ThrottleError is a login refused before the password was checked.
+type ThrottleError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (err *ThrottleError) Error() string {
+	return ErrLoginThrottled.Error()
+}
+
+func (err *ThrottleError) Unwrap() error {
+	return ErrLoginThrottled
+}
+
+// LoginClient is where a login attempt came from, for throttling and audit.
+type LoginClient struct {
+	IP        string
+	UserAgent string
+}
+
+// dummyHash is compared against when the email is unknown, so that answer
+// takes as long as a wrong password.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not-a-password"), 10)
+	return hash
+})
+
+// Authenticate checks the email and password of a login. Failures count
+// towards throttle.Default for the email and the client IP and are written to
+// the login audit.
+func Authenticate(ctx context.Context, DB *gorm.DB, email, password string, client LoginClient) (*models.User, error) {
+	guard := throttle.Default
+	decision, err := guard.Check(ctx, email, client.IP)
+	if err != nil {
+		return nil, err
+	}
+	if !decision.Allowed {
+		auditLogin(ctx, DB, email, nil, client, models.LoginThrottled)
+		return nil, &ThrottleError{RetryAfter: decision.RetryAfter, Locked: decision.Locked}
+	}
+
+	user, err := models.IsEmailExists(ctx, DB, email)
+	if err != nil {
+		guard.Release(ctx, email, client.IP)
+		return nil, err
+	}
+
+	reason := ""
+	if user.Email == "" {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		reason = models.LoginUnknownEmail
+	} else if models.PasswordCompare(password, user.Password) != nil {
+		reason = models.LoginWrongPassword
+	}
+
+	if reason != "" {
+		var userID *int
+		if user.ID != 0 {
+			userID = &user.ID
+		}
+		auditLogin(ctx, DB, email, userID, client, reason)
+		err = guard.Fail(ctx, email, client.IP)
+		if err != nil {
+			logrus.WithField("email", email).WithError(err).Error("Error counting failed login")
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	err = guard.Succeed(ctx, email, client.IP)
+	if err != nil {
+		logrus.WithField("email", email).WithError(err).Error("Error clearing failed logins")
+	}
+	return user, nil
+}
+
+func auditLogin(ctx context.Context, DB *gorm.DB, email string, userID *int, client LoginClient, reason string) {
+	userAgent := client.UserAgent
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	err := models.CreateLoginAttempt(ctx, DB, &models.LoginAttempt{
+		Email:     email,
+		UserID:    userID,
+		IP:        client.IP,
+		UserAgent: userAgent,
+		Reason:    reason,
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"email": email, "reason": reason}).WithError(err).Error("Error recording failed login")
+	}
+}
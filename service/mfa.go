@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grey/models"
+	"github.com/grey/totp"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrMFAAlreadyEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnrolled        = errors.New("no authenticator enrollment is pending")
+	ErrMFANotEnabled         = errors.New("two-factor authentication is not enabled")
+	ErrInvalidMFACode        = errors.New("invalid verification code")
+	ErrTooManyMFAAttempts    = errors.New("too many invalid verification codes")
+	ErrStepUpRequired        = errors.New("a verification code is required for this amount")
+	ErrMFAEnrollmentRequired = errors.New("two-factor authentication must be enabled for this amount")
+)
+
+// MFAIssuer names the wallet in authenticator apps.
+var MFAIssuer = "Grey"
+
+// MaxMFAAttempts is how many wrong codes in a row lock the second factor.
+const MaxMFAAttempts = 5
+
+// MFALockDuration is how long the second factor stays locked. A new password
+// login does not lift the lock. Set from MFA_LOCK_DURATION at startup.
+var MFALockDuration = 15 * time.Minute
+
+const RecoveryCodeCount = 10
+
+// StepUpAmounts maps a currency to the amount above which a payment needs a
+// verification code. Currencies without an entry never need one. Set from
+// MFA_STEP_UP_AMOUNTS at startup.
+var StepUpAmounts = map[string]decimal.Decimal{}
+
+// ParseStepUpAmounts reads thresholds written as "USD=1000,EUR=900".
+func ParseStepUpAmounts(value string) (map[string]decimal.Decimal, error) {
+	amounts := map[string]decimal.Decimal{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		currency, amount, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("step-up amount %q: expected CURRENCY=AMOUNT", pair)
+		}
+		threshold, err := decimal.NewFromString(strings.TrimSpace(amount))
+		if err != nil || threshold.IsNegative() {
+			return nil, fmt.Errorf("step-up amount %q: invalid amount", pair)
+		}
+		amounts[strings.ToUpper(strings.TrimSpace(currency))] = threshold
+	}
+	return amounts, nil
+}
+
+// MFAEnrollment is what the user scans into an authenticator app.
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// EnrollTOTP generates a new authenticator secret for the user. It is not
+// enforced until ConfirmTOTP sees a code generated from it.
+func EnrollTOTP(ctx context.Context, DB *gorm.DB, user *models.User) (MFAEnrollment, error) {
+	if user.MFAEnabled {
+		return MFAEnrollment{}, ErrMFAAlreadyEnabled
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return MFAEnrollment{}, err
+	}
+	err = models.SetTOTPSecret(ctx, DB, user.ID, secret)
+	if err != nil {
+		return MFAEnrollment{}, err
+	}
+	return MFAEnrollment{Secret: secret, URI: totp.URI(MFAIssuer, user.Email, secret)}, nil
+}
+
+// ConfirmTOTP turns two-factor authentication on once the user proves the
+// authenticator works, and returns the recovery codes. They are shown only
+// this once.
+func ConfirmTOTP(ctx context.Context, DB *gorm.DB, user *models.User, code string) ([]string, error) {
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrMFANotEnrolled
+	}
+	step, ok := totp.Verify(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes := make([]string, 0, RecoveryCodeCount)
+	hashes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	err := models.EnableMFA(ctx, DB, user.ID, step, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off. It takes a valid code so a
+// stolen session alone cannot remove the second factor.
+func DisableTOTP(ctx context.Context, DB *gorm.DB, user *models.User, code string) error {
+	err := VerifyMFA(ctx, DB, user, code)
+	if err != nil {
+		return err
+	}
+	return models.DisableMFA(ctx, DB, user.ID)
+}
+
+// VerifyMFA accepts a current TOTP code or an unused recovery code. Wrong
+// codes are counted, and after MaxMFAAttempts in a row every code is refused
+// for MFALockDuration. Only a correct code clears the count.
+func VerifyMFA(ctx context.Context, DB *gorm.DB, user *models.User, code string) error {
+	if !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+	// the attempt is counted before the code is checked, so guesses sent in
+	// parallel are all counted against the same lockout
+	now := time.Now()
+	reserved, err := models.ReserveMFAAttempt(ctx, DB, user.ID, MaxMFAAttempts, now)
+	if err != nil {
+		return err
+	}
+	if !reserved {
+		return ErrTooManyMFAAttempts
+	}
+
+	ok, err := checkMFACode(ctx, DB, user, strings.TrimSpace(code))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		failures, locked, err := models.RecordMFAFailure(ctx, DB, user.ID, MaxMFAAttempts, now, now.Add(MFALockDuration))
+		if err != nil {
+			return err
+		}
+		user.MFAFailures = failures
+		if locked != nil {
+			user.MFAFailures = 0
+			user.MFALockedUntil = locked
+			return ErrTooManyMFAAttempts
+		}
+		return ErrInvalidMFACode
+	}
+
+	user.MFAFailures = 0
+	return models.ResetMFAFailures(ctx, DB, user.ID, now)
+}
+
+// StepUp asks for a verification code when the payment amount is above the
+// step-up threshold of its currency. Users without two-factor authentication
+// cannot make such payments.
+func StepUp(ctx context.Context, DB *gorm.DB, userID, currency string, amount decimal.Decimal, code string) error {
+	threshold, ok := StepUpAmounts[currency]
+	if !ok || amount.LessThanOrEqual(threshold) {
+		return nil
+	}
+
+	user, err := Caller(ctx, DB, userID)
+	if err != nil {
+		return err
+	}
+	if !user.MFAEnabled {
+		return ErrMFAEnrollmentRequired
+	}
+	if code == "" {
+		return ErrStepUpRequired
+	}
+	return VerifyMFA(ctx, DB, user, code)
+}
+
+func checkMFACode(ctx context.Context, DB *gorm.DB, user *models.User, code string) (bool, error) {
+	if len(code) == totp.Digits {
+		step, ok := totp.Verify(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+		if !ok {
+			return false, nil
+		}
+		// a second request with the same code loses the race
+		return models.AdvanceTOTPStep(ctx, DB, user.ID, step)
+	}
+	return models.UseRecoveryCode(ctx, DB, user.ID, hashRecoveryCode(code))
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCode returns ten base32 characters split in two groups of five.
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 7)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(recoveryEncoding.EncodeToString(buf))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode ignores case and separators so codes can be typed loosely.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
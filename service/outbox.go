@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/grey/models"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// EventSink receives the events published through the outbox. Delivery is at
+// least once, so sinks must tolerate seeing the same EventID again.
+type EventSink interface {
+	Name() string
+	Handle(ctx context.Context, event models.OutboxEvent) error
+}
+
+// LogSink writes every event to the application log.
+type LogSink struct{}
+
+func (LogSink) Name() string {
+	return "log"
+}
+
+func (LogSink) Handle(ctx context.Context, event models.OutboxEvent) error {
+	logrus.WithFields(logrus.Fields{"event_id": event.EventID, "event_type": event.EventType, "aggregate_id": event.AggregateID}).Info("Outbox event")
+	return nil
+}
+
+// OutboxDispatcher polls the outbox and hands each event to every sink. An
+// event is marked dispatched once all sinks accept it; otherwise it is retried
+// with exponential backoff until MaxAttempts, after which it is marked dead.
+type OutboxDispatcher struct {
+	DB           *gorm.DB
+	Sinks        []EventSink
+	BatchSize    int
+	PollInterval time.Duration
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	// Lease is how long a claimed event is hidden from other dispatchers.
+	Lease time.Duration
+}
+
+func NewOutboxDispatcher(db *gorm.DB, sinks ...EventSink) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		DB:           db,
+		Sinks:        sinks,
+		BatchSize:    100,
+		PollInterval: time.Second,
+		MaxAttempts:  10,
+		BaseBackoff:  time.Second,
+		MaxBackoff:   time.Hour,
+		Lease:        time.Minute,
+	}
+}
+
+func (d *OutboxDispatcher) Register(sink EventSink) {
+	d.Sinks = append(d.Sinks, sink)
+}
+
+// Run dispatches events until ctx is done.
+func (d *OutboxDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := d.DispatchOnce(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				logrus.WithError(err).Error("Error dispatching outbox events")
+			}
+		}
+	}
+}
+
+// DispatchOnce delivers one batch of due events and returns how many were
+// dispatched successfully.
+func (d *OutboxDispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	events, err := models.DueOutboxEvents(ctx, d.DB, d.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	dispatched := 0
+	for i := range events {
+		event := &events[i]
+
+		claimed, err := models.ClaimOutboxEvent(ctx, d.DB, event, d.Lease)
+		if err != nil {
+			return dispatched, err
+		}
+		if !claimed {
+			continue
+		}
+
+		err = d.deliver(ctx, *event)
+		if err == nil {
+			err = models.MarkOutboxDispatched(ctx, d.DB, event)
+			if err != nil {
+				return dispatched, err
+			}
+			dispatched++
+			continue
+		}
+
+		attempts := event.Attempts + 1
+		dead := attempts >= d.MaxAttempts
+		if dead {
+			logrus.WithField("event_id", event.EventID).WithError(err).Error("Giving up on outbox event")
+		}
+		err = models.MarkOutboxFailed(ctx, d.DB, event, err, time.Now().Add(d.backoff(attempts)), dead)
+		if err != nil {
+			return dispatched, err
+		}
+	}
+	return dispatched, nil
+}
+
+func (d *OutboxDispatcher) deliver(ctx context.Context, event models.OutboxEvent) error {
+	for _, sink := range d.Sinks {
+		err := sink.Handle(ctx, event)
+		if err != nil {
+			return errors.New(sink.Name() + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+func (d *OutboxDispatcher) backoff(attempts int) time.Duration {
+	delay := d.BaseBackoff
+	for i := 1; i < attempts && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxBackoff {
+		delay = d.MaxBackoff
+	}
+	return delay
+}
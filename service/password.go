@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/grey/models"
+	"github.com/grey/notify"
+	"github.com/grey/throttle"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	RevokedByPasswordChange = "password_change"
+	RevokedByPasswordReset  = "password_reset"
+)
+
+// MinPasswordLength applies to passwords set by change or reset.
+const MinPasswordLength = 8
+
+var (
+	ErrWeakPassword      = fmt.Errorf("the password must be at least %d characters", MinPasswordLength)
+	ErrInvalidResetToken = errors.New("password reset token is invalid or expired")
+)
+
+// PasswordResetTTL is how long a reset token works. Set from
+// PASSWORD_RESET_TTL at startup.
+var PasswordResetTTL = time.Hour
+
+// Reset emails are limited like verification resends: one a minute and
+// MaxPasswordResetEmails an hour for an account, and MaxPasswordResetIPEmails
+// an hour asked for from one client IP.
+const (
+	PasswordResetInterval    = time.Minute
+	MaxPasswordResetEmails   = 5
+	MaxPasswordResetIPEmails = 20
+)
+
+// PasswordResetURL is the page of the app that takes a reset token, which is
+// appended to it. Without it the email carries the bare token. Set from
+// PASSWORD_RESET_URL at startup.
+var PasswordResetURL = ""
+
+// ChangePassword replaces the password after checking the current one. Wrong
+// current passwords count as failed logins, so a stolen session cannot be
+// used to guess the password. Every other session of the user is logged out;
+// the one making the change stays.
+func ChangePassword(ctx context.Context, DB *gorm.DB, user *models.User, current, password, sessionID string, client LoginClient) error {
+	decision, err := throttle.Default.Check(ctx, user.Email, client.IP)
+	if err != nil {
+		return err
+	}
+	if !decision.Allowed {
+		return &ThrottleError{RetryAfter: decision.RetryAfter, Locked: decision.Locked}
+	}
+	if models.PasswordCompare(current, user.Password) != nil {
+		auditLogin(ctx, DB, user.Email, &user.ID, client, models.LoginWrongPassword)
+		err = throttle.Default.Fail(ctx, user.Email, client.IP)
+		if err != nil {
+			logrus.WithField("email", user.Email).WithError(err).Error("Error counting failed login")
+		}
+		return ErrWrongPassword
+	}
+	err = throttle.Default.Release(ctx, user.Email, client.IP)
+	if err != nil {
+		logrus.WithField("email", user.Email).WithError(err).Error("Error releasing login attempt")
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := models.UpdatePassword(ctx, tx, user.ID, hash)
+		if err != nil {
+			return err
+		}
+		return models.RevokeUserSessions(ctx, tx, user.ID, sessionID, RevokedByPasswordChange)
+	})
+}
+
+// RequestPasswordReset queues a reset email for the address. It does the
+// same work whether or not the email belongs to an account, so neither its
+// answer nor how long it takes tells which emails are registered; the
+// PasswordResetSink decides later whether anything is sent.
+func RequestPasswordReset(ctx context.Context, DB *gorm.DB, email, ip string) error {
+	payload := models.PasswordResetRequestedPayload{Email: email, RequestIP: ip}
+	// the outbox is logged, so the event is keyed by a digest of the email
+	return models.EnqueueEvent(ctx, DB, models.PasswordResetRequestedEvent, hashOpaqueToken(email), payload)
+}
+
+// PasswordResetSink is the outbox sink that sends the reset emails queued by
+// RequestPasswordReset.
+type PasswordResetSink struct {
+	DB *gorm.DB
+}
+
+func NewPasswordResetSink(db *gorm.DB) *PasswordResetSink {
+	return &PasswordResetSink{DB: db}
+}
+
+func (s *PasswordResetSink) Name() string {
+	return "password_reset"
+}
+
+func (s *PasswordResetSink) Handle(ctx context.Context, event models.OutboxEvent) error {
+	if event.EventType != models.PasswordResetRequestedEvent {
+		return nil
+	}
+	var payload models.PasswordResetRequestedPayload
+	err := json.Unmarshal([]byte(event.Payload), &payload)
+	if err != nil {
+		return err
+	}
+	return sendPasswordReset(ctx, s.DB, payload.Email, payload.RequestIP)
+}
+
+// sendPasswordReset emails a reset token when the email belongs to an account
+// and neither the account nor the client IP is over the rate limit. A new
+// token replaces any earlier one.
+func sendPasswordReset(ctx context.Context, DB *gorm.DB, email, ip string) error {
+	user, err := models.IsEmailExists(ctx, DB, email)
+	if err != nil {
+		return err
+	}
+	if user.ID == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	sent, err := models.RecentUserTokens(ctx, DB, user.ID, models.PasswordResetToken, now.Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	if resendWait(sent, PasswordResetInterval, MaxPasswordResetEmails, now) > 0 {
+		return nil
+	}
+	if ip != "" {
+		sent, err = models.RecentUserTokensFromIP(ctx, DB, ip, models.PasswordResetToken, now.Add(-time.Hour))
+		if err != nil {
+			return err
+		}
+		if resendWait(sent, 0, MaxPasswordResetIPEmails, now) > 0 {
+			return nil
+		}
+	}
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+	record := models.UserToken{
+		UserID:    user.ID,
+		Purpose:   models.PasswordResetToken,
+		TokenHash: hashOpaqueToken(token),
+		RequestIP: ip,
+		ExpiresAt: now.Add(PasswordResetTTL),
+	}
+	err = models.CreateUserToken(ctx, DB, &record)
+	if err != nil {
+		return err
+	}
+
+	link := token
+	if PasswordResetURL != "" {
+		link = PasswordResetURL + token
+	}
+	err = notify.Default.Send(ctx, notify.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account. If it was you, use this to choose a new one within %s:\n\n%s\n\nIf it was not you, ignore this email; your password has not changed.",
+			PasswordResetTTL, link),
+	})
+	if err != nil {
+		// a token nobody received must not hold back the retry
+		deleteErr := models.DeleteUserToken(ctx, DB, &record)
+		if deleteErr != nil {
+			logrus.WithField("user_id", user.ID).WithError(deleteErr).Error("Error deleting unsent reset token")
+		}
+		return err
+	}
+	return nil
+}
+
+// ResetPassword sets a new password with a reset token. The token is spent,
+// every session of the user is logged out and failed logins of the email are
+// forgiven.
+func ResetPassword(ctx context.Context, DB *gorm.DB, token, password string) error {
+	if token == "" {
+		return ErrInvalidResetToken
+	}
+	record, err := models.FindUserToken(ctx, DB, models.PasswordResetToken, hashOpaqueToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	user, err := models.FindUser(ctx, DB, record.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	err = DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		spent, err := models.SpendUserToken(ctx, tx, &record)
+		if err != nil {
+			return err
+		}
+		if !spent {
+			return ErrInvalidResetToken
+		}
+		err = models.UpdatePassword(ctx, tx, user.ID, hash)
+		if err != nil {
+			return err
+		}
+		return models.RevokeUserSessions(ctx, tx, user.ID, "", RevokedByPasswordReset)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = throttle.Default.Succeed(ctx, user.Email, "")
+	if err != nil {
+		logrus.WithField("email", user.Email).WithError(err).Error("Error clearing failed logins")
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	return string(hash), err
+}
@@ -5,15 +5,24 @@ import (
 	"errors"
 
 	"github.com/grey/models"
+	"github.com/grey/providers"
 	"github.com/grey/structs"
 	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrInvalidAmount       = errors.New("invalid amount")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrAccountNotFound     = errors.New("account not found")
+	ErrPayoutFailed        = errors.New("payout failed")
+)
+
 func ProcessInternalPayment(ctx context.Context, DB *gorm.DB, fromAccount, toAccount string, amount decimal.Decimal, currency string) (Payment models.Payment, err error) {
 
 	if amount.LessThanOrEqual(decimal.Zero) {
-		return Payment, errors.New("invalid amount")
+		return Payment, ErrInvalidAmount
 	}
 
 	tx := DB.WithContext(ctx).Begin()
@@ -23,26 +32,19 @@ func ProcessInternalPayment(ctx context.Context, DB *gorm.DB, fromAccount, toAcc
 	// Defer rollback in case of error
 	defer tx.Rollback()
 
-	// 2. Deduct from source (Lock row)
-	// fees
-	// amount
-	// amount + fees = total
-	// service charges deduct
-	// 3 charges
-	// 30 amount
-	// 3 / 100 * 30
-	result := tx.Exec("UPDATE accounts SET balance = balance - $1 WHERE account_id = $2 AND balance >= $1", amount, fromAccount)
-	if result.Error != nil {
-		return Payment, result.Error // Likely insufficient funds or database error
+	err = checkCurrency(ctx, tx, currency, amount, fromAccount, toAccount)
+	if err != nil {
+		return Payment, err
 	}
-	if result.RowsAffected == 0 {
-		return Payment, errors.New("insufficient balance")
+
+	err = checkLimits(ctx, tx, fromAccount, models.InternalTransfer, amount, currency)
+	if err != nil {
+		return Payment, err
 	}
 
-	// 3. Add to target
-	tx.Exec("UPDATE accounts SET balance = balance + $1 WHERE account_id = $2", amount, toAccount)
-	if tx.Error != nil {
-		return Payment, tx.Error
+	fee, err := paymentFee(models.InternalTransfer, amount, currency)
+	if err != nil {
+		return Payment, err
 	}
 
 	response := models.Payment{
@@ -50,33 +52,76 @@ func ProcessInternalPayment(ctx context.Context, DB *gorm.DB, fromAccount, toAcc
 		ToAccount:   toAccount,
 		Currency:    currency,
 		Amount:      amount,
-		Status:      models.Pending,
+		Fee:         fee,
+		Type:        models.InternalTransfer,
 		Description: "Internal Payment",
 	}
 
-	ledger := models.LedgerEntry{
-		AccountID: fromAccount,
-		Payment:   response,
-		Amount:    amount,
+	err = startPayment(ctx, tx, &response)
+	if err != nil {
+		return Payment, err
+	}
+
+	// 2. Deduct the amount and the fee from source (Lock row)
+	total := amount.Add(fee)
+	err = debitAccount(tx, fromAccount, total)
+	if errors.Is(err, ErrInsufficientBalance) {
+		return Payment, failPayment(ctx, tx, &response, err)
+	}
+	if err != nil {
+		return Payment, err
+	}
+
+	// 3. Add to target
+	err = creditAccount(tx, toAccount, amount)
+	if err != nil {
+		return Payment, err
 	}
 
-	err = tx.Create(&ledger).Error
+	feeEntries, err := collectFee(ctx, tx, &response)
 	if err != nil {
 		return Payment, err
 	}
 
-	err = tx.Create(&response).Error
+	err = postJournal(ctx, tx, &response, append([]models.LedgerEntry{
+		models.DebitEntry(fromAccount, total, currency),
+		models.CreditEntry(toAccount, amount, currency),
+	}, feeEntries...)...)
 	if err != nil {
 		return Payment, err
 	}
 
-	tx.Commit()
+	err = transitionPayment(ctx, tx, &response, models.Completed, "funds transferred")
+	if err != nil {
+		return Payment, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return Payment, err
+	}
 	return response, nil
 }
 
-func ProcessExternalPayment(ctx context.Context, DB *gorm.DB, recipient structs.RecipientDetails, fromAccount string, amount decimal.Decimal, currency string) (response structs.ExternalPaymentResponse, err error) {
+// ProcessExternalPayment holds the funds in the clearing account and hands the
+// payout to the provider once that is committed. The payment stays processing
+// until the provider reports the outcome through SettleExternalPayment; a
+// payout the provider declines straight away releases the funds back to the
+// sender. When the provider cannot be reached the outcome is unknown, so the
+// payment stays processing until ReconcilePayouts finds out.
+func ProcessExternalPayment(ctx context.Context, DB *gorm.DB, provider providers.PayoutProvider, transactionType models.TransactionType, recipient structs.RecipientDetails, fromAccount string, amount decimal.Decimal, currency string) (response structs.ExternalPaymentResponse, err error) {
+	return externalPayment(ctx, DB, provider, transactionType, recipient, fromAccount, amount, currency, decimal.Zero)
+}
+
+// externalPayment is ProcessExternalPayment for funds that may already be
+// reserved: the reserved amount is released from the held balance in the same
+// transaction as the debit, so nothing else can spend it in between. The
+// release is committed together with the payment. The response carries the
+// payment id whenever a payment was committed, whatever the error; without
+// one nothing was committed and the reservation is left in place.
+func externalPayment(ctx context.Context, DB *gorm.DB, provider providers.PayoutProvider, transactionType models.TransactionType, recipient structs.RecipientDetails, fromAccount string, amount decimal.Decimal, currency string, reserved decimal.Decimal) (response structs.ExternalPaymentResponse, err error) {
 	if amount.LessThanOrEqual(decimal.Zero) {
-		return structs.ExternalPaymentResponse{}, errors.New("invalid amount")
+		return structs.ExternalPaymentResponse{}, ErrInvalidAmount
 	}
 
 	tx := DB.WithContext(ctx).Begin()
@@ -86,13 +131,19 @@ func ProcessExternalPayment(ctx context.Context, DB *gorm.DB, recipient structs.
 	// Defer rollback in case of error
 	defer tx.Rollback()
 
-	// 2. Deduct from source (Lock row)
-	result := tx.Exec("UPDATE accounts SET balance = balance - $1 WHERE account_id = $2 AND balance >= $1", amount, fromAccount)
-	if result.Error != nil {
-		return structs.ExternalPaymentResponse{}, result.Error // Likely insufficient funds or database error
+	err = checkCurrency(ctx, tx, currency, amount, fromAccount)
+	if err != nil {
+		return structs.ExternalPaymentResponse{}, err
 	}
-	if result.RowsAffected == 0 {
-		return structs.ExternalPaymentResponse{}, errors.New("insufficient balance")
+
+	err = checkLimits(ctx, tx, fromAccount, transactionType, amount, currency)
+	if err != nil {
+		return structs.ExternalPaymentResponse{}, err
+	}
+
+	fee, err := paymentFee(transactionType, amount, currency)
+	if err != nil {
+		return structs.ExternalPaymentResponse{}, err
 	}
 
 	payment := models.Payment{
@@ -100,27 +151,193 @@ func ProcessExternalPayment(ctx context.Context, DB *gorm.DB, recipient structs.
 		ToAccount:   fromAccount,
 		Currency:    currency,
 		Amount:      amount,
-		Status:      models.Completed,
+		Fee:         fee,
+		Type:        transactionType,
+		Provider:    provider.Name(),
 		Description: "External Payment",
 	}
 
-	err = tx.Create(&payment).Error
+	err = startPayment(ctx, tx, &payment)
 	if err != nil {
 		return structs.ExternalPaymentResponse{}, err
 	}
 
-	tx.Commit()
-	return structs.ExternalPaymentResponse{
-		PaymentID:      payment.PaymentID,
-		Recipient:      recipient,
-		Status:         "success",
-		ProviderStatus: "success",
-	}, nil
+	// 2. Deduct the amount and the fee from source (Lock row)
+	if reserved.IsPositive() {
+		err = releaseFunds(tx, fromAccount, reserved)
+		if err != nil {
+			return structs.ExternalPaymentResponse{}, err
+		}
+	}
+	total := amount.Add(fee)
+	err = debitAccount(tx, fromAccount, total)
+	if errors.Is(err, ErrInsufficientBalance) {
+		err = failPayment(ctx, tx, &payment, err)
+		if errors.Is(err, ErrInsufficientBalance) {
+			response.PaymentID = payment.PaymentID
+		}
+		return response, err
+	}
+	if err != nil {
+		return structs.ExternalPaymentResponse{}, err
+	}
+
+	// 3. Park the funds in the clearing account until the provider settles
+	clearingAccount, err := models.EnsureSystemAccount(ctx, tx, models.ExternalClearing, currency)
+	if err != nil {
+		return structs.ExternalPaymentResponse{}, err
+	}
+	err = creditAccount(tx, clearingAccount, amount)
+	if err != nil {
+		return structs.ExternalPaymentResponse{}, err
+	}
+
+	feeEntries, err := collectFee(ctx, tx, &payment)
+	if err != nil {
+		return structs.ExternalPaymentResponse{}, err
+	}
+
+	err = postJournal(ctx, tx, &payment, append([]models.LedgerEntry{
+		models.DebitEntry(fromAccount, total, currency),
+		models.CreditEntry(clearingAccount, amount, currency),
+	}, feeEntries...)...)
+	if err != nil {
+		return structs.ExternalPaymentResponse{}, err
+	}
+
+	// 4. Commit the processing payment before the provider sees it, so a
+	// payout the provider accepts always has a record
+	err = tx.Commit().Error
+	if err != nil {
+		return structs.ExternalPaymentResponse{}, err
+	}
+
+	// 5. Hand the payout to the provider. The call is made outside the
+	// transaction so a slow provider holds no locks on the account, and is
+	// not cancelled with the request: once the payout may have left, its
+	// result has to be recorded.
+	ctx = context.WithoutCancel(ctx)
+	response = structs.ExternalPaymentResponse{
+		PaymentID: payment.PaymentID,
+		Recipient: recipient,
+		Fee:       payment.Fee,
+	}
+	result, err := provider.Submit(ctx, providers.PayoutRequest{
+		Reference: payment.PaymentID,
+		Amount:    amount,
+		Currency:  currency,
+		Recipient: recipient,
+	})
+	if err != nil {
+		// The provider may or may not have the payout, so the payment stays
+		// processing until ReconcilePayouts looks it up by its reference.
+		logrus.WithField("payment_id", payment.PaymentID).WithError(err).Error("Error submitting payout")
+		response.Status = externalPaymentStatus(payment.Status)
+		response.ProviderStatus = string(providers.PayoutPending)
+		return response, nil
+	}
+
+	err = recordSubmission(ctx, DB, &payment, result)
+	if err != nil {
+		// the payment is committed; ReconcilePayouts records the result later
+		logrus.WithField("payment_id", payment.PaymentID).WithError(err).Error("Error recording payout submission")
+	}
+
+	response.Status = externalPaymentStatus(payment.Status)
+	response.ProviderStatus = string(result.Status)
+	if payment.Status == models.Failed {
+		return response, ErrPayoutFailed
+	}
+	return response, nil
+}
+
+// recordSubmission stores the provider's reference for the payout and
+// releases the funds if the provider declined it. Payouts whose submission is
+// already recorded, or that are no longer processing, are left alone.
+func recordSubmission(ctx context.Context, DB *gorm.DB, payment *models.Payment, result providers.PayoutResult) error {
+	tx := DB.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	// Defer rollback in case of error
+	defer tx.Rollback()
+
+	current, err := models.LockPayment(ctx, tx, payment.PaymentID)
+	if err != nil {
+		return err
+	}
+	if current.Status != models.Processing || current.ProviderReference != "" {
+		*payment = *current
+		return nil
+	}
+
+	err = tx.Model(current).Update("provider_reference", result.ProviderReference).Error
+	if err != nil {
+		return err
+	}
+
+	if result.Status == providers.PayoutFailed || result.Status == providers.PayoutCancelled {
+		err = releasePayout(ctx, tx, current, "payout "+string(result.Status)+": "+result.Message)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return err
+	}
+	*payment = *current
+	return nil
+}
+
+// releasePayout gives the funds held in the clearing account, and the fee,
+// back to the sender and marks the payment failed.
+func releasePayout(ctx context.Context, tx *gorm.DB, payment *models.Payment, reason string) error {
+	clearingAccount, err := models.EnsureSystemAccount(ctx, tx, models.ExternalClearing, payment.Currency)
+	if err != nil {
+		return err
+	}
+
+	err = debitSystemAccount(tx, clearingAccount, payment.Amount)
+	if err != nil {
+		return err
+	}
+	feeEntries, err := returnFee(ctx, tx, payment)
+	if err != nil {
+		return err
+	}
+	total := payment.Amount.Add(payment.Fee)
+	err = creditAccount(tx, payment.FromAccount, total)
+	if err != nil {
+		return err
+	}
+
+	err = postJournal(ctx, tx, payment, append([]models.LedgerEntry{
+		models.DebitEntry(clearingAccount, payment.Amount, payment.Currency),
+		models.CreditEntry(payment.FromAccount, total, payment.Currency),
+	}, feeEntries...)...)
+	if err != nil {
+		return err
+	}
+
+	return transitionPayment(ctx, tx, payment, models.Failed, reason)
+}
+
+func externalPaymentStatus(status models.PaymentStatus) string {
+	switch status {
+	case models.Completed:
+		return "success"
+	case models.Failed:
+		return "failed"
+	default:
+		return "pending"
+	}
 }
 
 func TopUpProcess(ctx context.Context, DB *gorm.DB, fromAccount string, amount decimal.Decimal, currency string) (response structs.TopUpResponse, err error) {
 	if amount.LessThanOrEqual(decimal.Zero) {
-		return structs.TopUpResponse{}, errors.New("invalid amount")
+		return structs.TopUpResponse{}, ErrInvalidAmount
 	}
 
 	tx := DB.WithContext(ctx).Begin()
@@ -130,40 +347,213 @@ func TopUpProcess(ctx context.Context, DB *gorm.DB, fromAccount string, amount d
 	// Defer rollback in case of error
 	defer tx.Rollback()
 
-	// 2. Add funds to account
-	tx.Exec("UPDATE accounts SET balance = balance + $1 WHERE account_id = $2", amount, fromAccount)
-	if tx.Error != nil {
-		return structs.TopUpResponse{}, tx.Error // Likely insufficient funds or database error
+	err = checkCurrency(ctx, tx, currency, amount, fromAccount)
+	if err != nil {
+		return structs.TopUpResponse{}, err
+	}
+
+	err = checkLimits(ctx, tx, fromAccount, models.TopUpTransaction, amount, currency)
+	if err != nil {
+		return structs.TopUpResponse{}, err
+	}
+
+	// the fee of a top-up comes out of the amount being added
+	fee, err := paymentFee(models.TopUpTransaction, amount, currency)
+	if err != nil {
+		return structs.TopUpResponse{}, err
+	}
+	if fee.GreaterThanOrEqual(amount) {
+		return structs.TopUpResponse{}, ErrFeeExceedsAmount
 	}
 
 	payment := models.Payment{
 		FromAccount: fromAccount,
 		ToAccount:   fromAccount,
 		Amount:      amount,
+		Fee:         fee,
 		Currency:    currency,
-		Status:      models.Completed,
+		Type:        models.TopUpTransaction,
 		Description: "Top up",
 	}
 
-	err = tx.Create(&payment).Error
+	err = startPayment(ctx, tx, &payment)
 	if err != nil {
 		return structs.TopUpResponse{}, err
 	}
 
-	// Create ledger entry
-	ledgerEntry := models.LedgerEntry{
-		AccountID: fromAccount,
-		PaymentID: payment.PaymentID,
-		Amount:    amount,
+	// 2. Draw the funds from the top-up funding account
+	fundingAccount, err := models.EnsureSystemAccount(ctx, tx, models.TopUpFunding, currency)
+	if err != nil {
+		return structs.TopUpResponse{}, err
+	}
+	err = debitSystemAccount(tx, fundingAccount, amount)
+	if err != nil {
+		return structs.TopUpResponse{}, err
+	}
+
+	// 3. Add funds, less the fee, to account
+	credited := amount.Sub(fee)
+	err = creditAccount(tx, fromAccount, credited)
+	if err != nil {
+		return structs.TopUpResponse{}, err
+	}
+
+	feeEntries, err := collectFee(ctx, tx, &payment)
+	if err != nil {
+		return structs.TopUpResponse{}, err
 	}
-	err = tx.Create(&ledgerEntry).Error
+
+	err = postJournal(ctx, tx, &payment, append([]models.LedgerEntry{
+		models.DebitEntry(fundingAccount, amount, currency),
+		models.CreditEntry(fromAccount, credited, currency),
+	}, feeEntries...)...)
 	if err != nil {
 		return structs.TopUpResponse{}, err
 	}
 
-	tx.Commit()
+	err = transitionPayment(ctx, tx, &payment, models.Completed, "account credited")
+	if err != nil {
+		return structs.TopUpResponse{}, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return structs.TopUpResponse{}, err
+	}
 	return structs.TopUpResponse{
 		PaymentID: payment.PaymentID,
 		Status:    "success",
+		Fee:       fee,
 	}, nil
 }
+
+// startPayment creates the payment as pending and moves it to processing
+// before any balance is touched.
+func startPayment(ctx context.Context, tx *gorm.DB, payment *models.Payment) error {
+	err := models.CreatePayment(ctx, tx, payment)
+	if err != nil {
+		return err
+	}
+	err = models.EnqueueEvent(ctx, tx, models.PaymentCreatedEvent, payment.PaymentID, payment)
+	if err != nil {
+		return err
+	}
+	return transitionPayment(ctx, tx, payment, models.Processing, "processing started")
+}
+
+// transitionPayment moves the payment to a new status and publishes the
+// matching outbox event for final outcomes.
+func transitionPayment(ctx context.Context, tx *gorm.DB, payment *models.Payment, to models.PaymentStatus, reason string) error {
+	err := payment.TransitionTo(ctx, tx, to, reason)
+	if err != nil {
+		return err
+	}
+
+	switch to {
+	case models.Completed:
+		return models.EnqueueEvent(ctx, tx, models.PaymentCompletedEvent, payment.PaymentID, payment)
+	case models.Failed:
+		return models.EnqueueEvent(ctx, tx, models.PaymentFailedEvent, payment.PaymentID, payment)
+	}
+	return nil
+}
+
+// postJournal posts the payment's ledger entries and publishes an
+// AccountCredited event for every customer account they credit.
+func postJournal(ctx context.Context, tx *gorm.DB, payment *models.Payment, entries ...models.LedgerEntry) error {
+	_, err := models.PostJournal(ctx, tx, payment.PaymentID, entries...)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Direction != models.Credit || models.IsSystemAccount(entry.AccountID, entry.Currency) {
+			continue
+		}
+		err = models.EnqueueEvent(ctx, tx, models.AccountCreditedEvent, entry.AccountID, models.AccountCreditedPayload{
+			AccountID: entry.AccountID,
+			PaymentID: payment.PaymentID,
+			Amount:    entry.Amount,
+			Currency:  entry.Currency,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// failPayment marks the payment failed and commits, so the attempt stays on
+// record even though no money moved. It returns the original cause.
+func failPayment(ctx context.Context, tx *gorm.DB, payment *models.Payment, cause error) error {
+	err := transitionPayment(ctx, tx, payment, models.Failed, cause.Error())
+	if err != nil {
+		return err
+	}
+	err = tx.Commit().Error
+	if err != nil {
+		return err
+	}
+	return cause
+}
+
+// debitAccount takes funds from a customer account. The balance check and the
+// update happen in one statement so concurrent debits cannot overdraw it, and
+// funds reserved by holds cannot be spent.
+func debitAccount(tx *gorm.DB, accountID string, amount decimal.Decimal) error {
+	result := tx.Exec("UPDATE accounts SET balance = balance - $1 WHERE account_id = $2 AND balance - held_balance >= CAST($1 AS NUMERIC)", amount, accountID)
+	if result.Error != nil {
+		return result.Error // Likely insufficient funds or database error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInsufficientBalance
+	}
+	return nil
+}
+
+// debitSystemAccount takes funds from a system account, which may go negative.
+func debitSystemAccount(tx *gorm.DB, accountID string, amount decimal.Decimal) error {
+	result := tx.Exec("UPDATE accounts SET balance = balance - $1 WHERE account_id = $2", amount, accountID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAccountNotFound
+	}
+	return nil
+}
+
+func creditAccount(tx *gorm.DB, accountID string, amount decimal.Decimal) error {
+	result := tx.Exec("UPDATE accounts SET balance = balance + $1 WHERE account_id = $2", amount, accountID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAccountNotFound
+	}
+	return nil
+}
+
+// reserveFunds moves available funds of a customer account into its held
+// balance, with the same single statement check as debitAccount.
+func reserveFunds(tx *gorm.DB, accountID string, amount decimal.Decimal) error {
+	result := tx.Exec("UPDATE accounts SET held_balance = held_balance + $1 WHERE account_id = $2 AND balance - held_balance >= CAST($1 AS NUMERIC)", amount, accountID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInsufficientBalance
+	}
+	return nil
+}
+
+func releaseFunds(tx *gorm.DB, accountID string, amount decimal.Decimal) error {
+	result := tx.Exec("UPDATE accounts SET held_balance = held_balance - $1 WHERE account_id = $2 AND held_balance >= $1", amount, accountID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAccountNotFound
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/grey/models"
+	"github.com/grey/providers"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// SettleExternalPayment applies the final outcome of a payout reported by its
+// provider. Success completes the payment, failure or cancellation releases
+// the held funds back to the sender. Outcomes for payments that are already
+// settled are ignored, so providers can safely deliver a webhook twice.
+func SettleExternalPayment(ctx context.Context, DB *gorm.DB, provider providers.PayoutProvider, result providers.PayoutResult) (payment models.Payment, err error) {
+	tx := DB.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return payment, tx.Error
+	}
+	// Defer rollback in case of error
+	defer tx.Rollback()
+
+	found, err := models.FindPaymentByProviderReference(ctx, tx, provider.Name(), result.ProviderReference)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return payment, ErrPaymentNotFound
+	}
+	if err != nil {
+		return payment, err
+	}
+	payment = *found
+
+	if payment.Status != models.Processing {
+		return payment, nil
+	}
+
+	switch result.Status {
+	case providers.PayoutSucceeded:
+		err = transitionPayment(ctx, tx, &payment, models.Completed, "payout settled")
+	case providers.PayoutFailed, providers.PayoutCancelled:
+		err = releasePayout(ctx, tx, &payment, "payout "+string(result.Status)+": "+result.Message)
+	default:
+		return payment, nil
+	}
+	if err != nil {
+		return payment, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return payment, err
+	}
+	return payment, nil
+}
+
+// ReconcilePayouts asks the providers about payouts that have been processing
+// for longer than the given age, in case their webhook never arrived. Payouts
+// without a provider reference, whose submission was never answered or never
+// recorded, are looked up by their payment id.
+func ReconcilePayouts(ctx context.Context, DB *gorm.DB, olderThan time.Duration) error {
+	var payments []models.Payment
+	err := DB.WithContext(ctx).
+		Where("status = ? AND provider <> '' AND updated_at < ?", models.Processing, time.Now().Add(-olderThan)).
+		Limit(100).
+		Find(&payments).Error
+	if err != nil {
+		return err
+	}
+
+	for _, payment := range payments {
+		provider, err := providers.Lookup(payment.Type)
+		if err != nil {
+			continue
+		}
+
+		if payment.ProviderReference == "" {
+			err = reconcileSubmission(ctx, DB, provider, &payment)
+			if err != nil {
+				logrus.WithField("payment_id", payment.PaymentID).WithError(err).Error("Error reconciling payout submission")
+			}
+			continue
+		}
+
+		result, err := provider.QueryStatus(ctx, payment.ProviderReference)
+		if err != nil {
+			logrus.WithField("payment_id", payment.PaymentID).WithError(err).Error("Error querying payout status")
+			continue
+		}
+
+		_, err = SettleExternalPayment(ctx, DB, provider, result)
+		if err != nil {
+			logrus.WithField("payment_id", payment.PaymentID).WithError(err).Error("Error settling payout")
+		}
+	}
+	return nil
+}
+
+// reconcileSubmission records the submission of a payout whose provider
+// reference is missing. A payout the provider never received is released.
+func reconcileSubmission(ctx context.Context, DB *gorm.DB, provider providers.PayoutProvider, payment *models.Payment) error {
+	result, err := provider.QueryReference(ctx, payment.PaymentID)
+	if errors.Is(err, providers.ErrPayoutNotFound) {
+		result = providers.PayoutResult{Status: providers.PayoutFailed, Message: "the provider never received the payout"}
+	} else if err != nil {
+		return err
+	}
+
+	err = recordSubmission(ctx, DB, payment, result)
+	if err != nil || result.Status != providers.PayoutSucceeded {
+		return err
+	}
+	_, err = SettleExternalPayment(ctx, DB, provider, result)
+	return err
+}
+
+// RunPayoutReconciler calls ReconcilePayouts on every tick until ctx is done.
+func RunPayoutReconciler(ctx context.Context, DB *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := ReconcilePayouts(ctx, DB, interval)
+			if err != nil {
+				logrus.WithError(err).Error("Error reconciling payouts")
+			}
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grey/models"
+	"github.com/grey/providers"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrBatchEmpty              = errors.New("payout batch has no items")
+	ErrBatchTooLarge           = errors.New("payout batch has too many items")
+	ErrBatchNotFound           = errors.New("payout batch not found")
+	ErrUnsupportedPayoutFormat = errors.New("unsupported payout file format")
+	ErrMalformedPayoutFile     = errors.New("malformed payout file")
+)
+
+// MaxBatchItems caps the rows of one batch.
+const MaxBatchItems = 5000
+
+// PayoutBatchWorkers is how many payouts of a batch are made at once,
+// configured from PAYOUT_BATCH_WORKERS at startup.
+var PayoutBatchWorkers = 4
+
+type PayoutFileFormat string
+
+const (
+	PayoutFileCSV  PayoutFileFormat = "csv"
+	PayoutFileJSON PayoutFileFormat = "json"
+)
+
+// PayoutRow is one recipient of a batch as read from the request. Amount is
+// kept as text so a malformed value is reported with its line.
+type PayoutRow struct {
+	Line            int
+	TransactionType models.TransactionType
+	Recipient       structs.RecipientDetails
+	Amount          string
+	Reference       string
+}
+
+// RowError is a problem with one line of a batch.
+type RowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// BatchValidationError lists every invalid line of a rejected batch.
+type BatchValidationError struct {
+	Rows []RowError
+}
+
+func (err *BatchValidationError) Error() string {
+	return fmt.Sprintf("payout batch has %d invalid rows", len(err.Rows))
+}
+
+// PayoutRowsFromRequest numbers the items of a JSON batch from 1.
+func PayoutRowsFromRequest(items []structs.PayoutItemRequest) []PayoutRow {
+	rows := make([]PayoutRow, 0, len(items))
+	for i, item := range items {
+		rows = append(rows, PayoutRow{
+			Line:            i + 1,
+			TransactionType: models.TransactionType(item.TransactionType),
+			Recipient:       item.Recipient,
+			Amount:          decimal.NewFromFloat(item.Amount).String(),
+			Reference:       item.Reference,
+		})
+	}
+	return rows
+}
+
+// PayoutRowsTotal adds up the amounts of the rows, skipping amounts that do
+// not parse; CreatePayoutBatch rejects those rows anyway.
+func PayoutRowsTotal(rows []PayoutRow) decimal.Decimal {
+	total := decimal.Zero
+	for _, row := range rows {
+		amount, err := decimal.NewFromString(row.Amount)
+		if err == nil && amount.IsPositive() {
+			total = total.Add(amount)
+		}
+	}
+	return total
+}
+
+// ReadPayoutFile reads an uploaded batch. A JSON file is an array of items
+// like the items of a JSON batch. A CSV file starts with a header naming
+// recipient_number and amount, and optionally recipient_name,
+// transaction_type and reference; lines are numbered from the first data row.
+func ReadPayoutFile(r io.Reader, format PayoutFileFormat) ([]PayoutRow, error) {
+	switch format {
+	case PayoutFileJSON:
+		var items []structs.PayoutItemRequest
+		err := json.NewDecoder(io.LimitReader(r, 10<<20)).Decode(&items)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedPayoutFile, err)
+		}
+		return PayoutRowsFromRequest(items), nil
+	case PayoutFileCSV:
+		return readPayoutCSV(r)
+	default:
+		return nil, ErrUnsupportedPayoutFormat
+	}
+}
+
+func readPayoutCSV(r io.Reader) ([]PayoutRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedPayoutFile, err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"recipient_number", "amount"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("%w: missing %s column", ErrMalformedPayoutFile, required)
+		}
+	}
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []PayoutRow
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedPayoutFile, err)
+		}
+		if len(rows) == MaxBatchItems {
+			return nil, ErrBatchTooLarge
+		}
+		rows = append(rows, PayoutRow{
+			Line:            line,
+			TransactionType: models.TransactionType(field(record, "transaction_type")),
+			Recipient: structs.RecipientDetails{
+				RecipientNumber: field(record, "recipient_number"),
+				RecipientName:   field(record, "recipient_name"),
+			},
+			Amount:    field(record, "amount"),
+			Reference: field(record, "reference"),
+		})
+	}
+	return rows, nil
+}
+
+// CreatePayoutBatch validates every row, then reserves the amounts and fees
+// of the whole batch on the account and stores it for the batch workers. A
+// batch with any invalid row is rejected as a whole with a
+// BatchValidationError. Rows without a transaction type use defaultType.
+func CreatePayoutBatch(ctx context.Context, DB *gorm.DB, userID int, fromAccount, currency string, defaultType models.TransactionType, rows []PayoutRow) (models.PayoutBatch, error) {
+	if len(rows) == 0 {
+		return models.PayoutBatch{}, ErrBatchEmpty
+	}
+	if len(rows) > MaxBatchItems {
+		return models.PayoutBatch{}, ErrBatchTooLarge
+	}
+
+	registered, err := models.LookupCurrency(currency)
+	if err != nil {
+		return models.PayoutBatch{}, err
+	}
+
+	batch := models.PayoutBatch{
+		UserID:      userID,
+		FromAccount: fromAccount,
+		Currency:    currency,
+		TotalAmount: decimal.Zero,
+		TotalFees:   decimal.Zero,
+	}
+	items := make([]models.PayoutBatchItem, 0, len(rows))
+	var invalid []RowError
+	for _, row := range rows {
+		item, err := payoutItem(row, defaultType, registered)
+		if err != nil {
+			invalid = append(invalid, RowError{Line: row.Line, Error: err.Error()})
+			continue
+		}
+		batch.TotalAmount = batch.TotalAmount.Add(item.Amount)
+		batch.TotalFees = batch.TotalFees.Add(item.Fee)
+		items = append(items, item)
+	}
+	if len(invalid) > 0 {
+		return models.PayoutBatch{}, &BatchValidationError{Rows: invalid}
+	}
+
+	tx := DB.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return models.PayoutBatch{}, tx.Error
+	}
+	// Defer rollback in case of error
+	defer tx.Rollback()
+
+	err = checkCurrency(ctx, tx, currency, decimal.Zero, fromAccount)
+	if err != nil {
+		return models.PayoutBatch{}, err
+	}
+
+	err = reserveFunds(tx, fromAccount, batch.TotalAmount.Add(batch.TotalFees))
+	if err != nil {
+		return models.PayoutBatch{}, err
+	}
+
+	err = models.CreatePayoutBatch(ctx, tx, &batch, items)
+	if err != nil {
+		return models.PayoutBatch{}, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return models.PayoutBatch{}, err
+	}
+	return batch, nil
+}
+
+// payoutItem checks one row the way ProcessExternalPayment would and prices
+// its fee.
+func payoutItem(row PayoutRow, defaultType models.TransactionType, currency models.Currency) (models.PayoutBatchItem, error) {
+	transactionType := row.TransactionType
+	if transactionType == "" {
+		transactionType = defaultType
+	}
+	if transactionType != models.BankTransfer && transactionType != models.MobileMoney {
+		return models.PayoutBatchItem{}, errors.New("transaction type must be BANK_TRANSFER or MOBILE_MONEY")
+	}
+	if _, err := providers.Lookup(transactionType); err != nil {
+		return models.PayoutBatchItem{}, err
+	}
+	if row.Recipient.RecipientNumber == "" {
+		return models.PayoutBatchItem{}, errors.New("recipient number is required")
+	}
+
+	amount, err := decimal.NewFromString(row.Amount)
+	if err != nil || !amount.IsPositive() || !currency.ValidAmount(amount) {
+		return models.PayoutBatchItem{}, ErrInvalidAmount
+	}
+	fee, err := paymentFee(transactionType, amount, currency.Code)
+	if err != nil {
+		return models.PayoutBatchItem{}, err
+	}
+
+	return models.PayoutBatchItem{
+		Line:            row.Line,
+		Type:            transactionType,
+		RecipientNumber: row.Recipient.RecipientNumber,
+		RecipientName:   row.Recipient.RecipientName,
+		Reference:       row.Reference,
+		Amount:          amount,
+		Fee:             fee,
+		Reserved:        amount.Add(fee),
+	}, nil
+}
+
+// ProcessPayoutBatch pays the pending items of the batch with at most
+// workers payouts in flight, then closes the batch. Items a crash left in
+// processing are not retried, since their payout may have been made; the
+// batch stays open until they are settled by hand.
+func ProcessPayoutBatch(ctx context.Context, DB *gorm.DB, batch *models.PayoutBatch, workers int) error {
+	items, err := models.PayoutBatchItems(ctx, DB, batch.BatchID, models.PayoutItemPending)
+	if err != nil {
+		return err
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	queue := make(chan models.PayoutBatchItem)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				err := processPayoutItem(ctx, DB, batch, &item)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{"batch_id": batch.BatchID, "line": item.Line}).WithError(err).Error("Error processing payout batch item")
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, item := range items {
+		select {
+		case queue <- item:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	open, err := models.PayoutBatchItems(ctx, DB, batch.BatchID, models.PayoutItemProcessing)
+	if err != nil {
+		return err
+	}
+	if len(open) > 0 {
+		return nil
+	}
+	return models.CompletePayoutBatch(ctx, DB, batch)
+}
+
+// processPayoutItem makes one payout. Declined payouts fail only their item;
+// the error returned is for problems that leave the item unfinished.
+func processPayoutItem(ctx context.Context, DB *gorm.DB, batch *models.PayoutBatch, item *models.PayoutBatchItem) error {
+	claimed, err := models.ClaimPayoutItem(ctx, DB, item)
+	if err != nil || !claimed {
+		return err
+	}
+
+	recipient := structs.RecipientDetails{
+		RecipientNumber: item.RecipientNumber,
+		RecipientName:   item.RecipientName,
+	}
+	var response structs.ExternalPaymentResponse
+	provider, err := providers.Lookup(item.Type)
+	if err == nil {
+		response, err = externalPayment(ctx, DB, provider, item.Type, recipient, batch.FromAccount, item.Amount, batch.Currency, item.Reserved)
+	}
+	if err == nil {
+		return models.FinishPayoutItem(ctx, DB, item, models.PayoutItemSucceeded, response.PaymentID, "")
+	}
+
+	if response.PaymentID == "" {
+		// no payment was committed, so the item's reservation is still held
+		releaseErr := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return releaseFunds(tx, batch.FromAccount, item.Reserved)
+		})
+		if releaseErr != nil {
+			return releaseErr
+		}
+	}
+	return models.FinishPayoutItem(ctx, DB, item, models.PayoutItemFailed, response.PaymentID, err.Error())
+}
+
+// ProcessPayoutBatches works through every open batch.
+func ProcessPayoutBatches(ctx context.Context, DB *gorm.DB, workers int) error {
+	batches, err := models.ProcessingPayoutBatches(ctx, DB, 10)
+	if err != nil {
+		return err
+	}
+	for _, batch := range batches {
+		err = ProcessPayoutBatch(ctx, DB, &batch, workers)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunPayoutBatcher processes open batches every interval until the context
+// is cancelled.
+func RunPayoutBatcher(ctx context.Context, DB *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ProcessPayoutBatches(ctx, DB, PayoutBatchWorkers); err != nil {
+				logrus.WithError(err).Error("Error processing payout batches")
+			}
+		}
+	}
+}
+
+// WritePayoutResults writes the outcome of every item as CSV, in file order.
+func WritePayoutResults(w io.Writer, items []models.PayoutBatchItem) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"line", "transaction_type", "recipient_number", "recipient_name", "reference", "amount", "fee", "status", "payment_id", "error"})
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		err = writer.Write([]string{
+			strconv.Itoa(item.Line),
+			string(item.Type),
+			item.RecipientNumber,
+			item.RecipientName,
+			item.Reference,
+			item.Amount.String(),
+			item.Fee.String(),
+			string(item.Status),
+			item.PaymentID,
+			item.Error,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/grey/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPINNotSet     = errors.New("no transaction PIN is set")
+	ErrPINAlreadySet = errors.New("a transaction PIN is already set")
+	ErrWeakPIN       = errors.New("the PIN must be 4 to 6 digits and not a repeated or sequential pattern")
+	ErrIncorrectPIN  = errors.New("incorrect PIN")
+	ErrPINLocked     = errors.New("payments are locked after too many incorrect PINs")
+	ErrWrongPassword = errors.New("incorrect password")
+)
+
+// MaxPINAttempts is how many wrong PINs in a row lock payments.
+const MaxPINAttempts = 5
+
+// PINLockDuration is how long payments stay locked. Set from
+// PIN_LOCK_DURATION at startup.
+var PINLockDuration = 30 * time.Minute
+
+// PINError is a wrong or locked PIN, with what the client should show.
+type PINError struct {
+	Err               error
+	AttemptsRemaining int
+	LockedUntil       *time.Time
+}
+
+func (err *PINError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *PINError) Unwrap() error {
+	return err.Err
+}
+
+// SetPIN sets the first transaction PIN. It takes the login password, so a
+// stolen session alone cannot set one.
+func SetPIN(ctx context.Context, DB *gorm.DB, user *models.User, password, pin string) error {
+	if user.PinHash != "" {
+		return ErrPINAlreadySet
+	}
+	if models.PasswordCompare(password, user.Password) != nil {
+		return ErrWrongPassword
+	}
+	return storePIN(ctx, DB, user, pin)
+}
+
+// ChangePIN replaces the PIN after checking the current one, which counts
+// towards the lockout like a payment would.
+func ChangePIN(ctx context.Context, DB *gorm.DB, user *models.User, current, pin string) error {
+	err := checkPIN(ctx, DB, user, current)
+	if err != nil {
+		return err
+	}
+	return storePIN(ctx, DB, user, pin)
+}
+
+// VerifyPIN checks the PIN that confirms a payment.
+func VerifyPIN(ctx context.Context, DB *gorm.DB, userID, pin string) error {
+	user, err := Caller(ctx, DB, userID)
+	if err != nil {
+		return err
+	}
+	return checkPIN(ctx, DB, user, pin)
+}
+
+func checkPIN(ctx context.Context, DB *gorm.DB, user *models.User, pin string) error {
+	if user.PinHash == "" {
+		return ErrPINNotSet
+	}
+	// the attempt is counted before the compare, so guesses sent in parallel
+	// are all counted against the same lockout
+	now := time.Now()
+	reserved, locked, err := models.ReservePINAttempt(ctx, DB, user.ID, MaxPINAttempts, now)
+	if err != nil {
+		return err
+	}
+	if !reserved {
+		return &PINError{Err: ErrPINLocked, LockedUntil: locked}
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PinHash), []byte(pin)) != nil {
+		failures, locked, err := models.RecordPINFailure(ctx, DB, user.ID, MaxPINAttempts, now, now.Add(PINLockDuration))
+		if err != nil {
+			return err
+		}
+		if locked != nil {
+			return &PINError{Err: ErrPINLocked, LockedUntil: locked}
+		}
+		return &PINError{Err: ErrIncorrectPIN, AttemptsRemaining: max(MaxPINAttempts-failures, 0)}
+	}
+
+	return models.ResetPINFailures(ctx, DB, user.ID, now)
+}
+
+func storePIN(ctx context.Context, DB *gorm.DB, user *models.User, pin string) error {
+	if !validPIN(pin) {
+		return ErrWeakPIN
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(pin), 10)
+	if err != nil {
+		return err
+	}
+	return models.SetPIN(ctx, DB, user.ID, string(hash))
+}
+
+// validPIN accepts 4 to 6 digits, except one digit repeated and straight runs
+// such as 1234 or 9876, which are the first guesses.
+func validPIN(pin string) bool {
+	if len(pin) < 4 || len(pin) > 6 || strings.Trim(pin, "0123456789") != "" {
+		return false
+	}
+	repeated, ascending, descending := true, true, true
+	for i := 1; i < len(pin); i++ {
+		step := int(pin[i]) - int(pin[i-1])
+		repeated = repeated && step == 0
+		ascending = ascending && step == 1
+		descending = descending && step == -1
+	}
+	return !repeated && !ascending && !descending
+}
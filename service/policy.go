@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/grey/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownCaller   = errors.New("session does not belong to a known user")
+	ErrNotAccountOwner = errors.New("account does not belong to the caller")
+)
+
+// Caller resolves the user id carried by a session token to the user.
+func Caller(ctx context.Context, DB *gorm.DB, userID string) (*models.User, error) {
+	if userID == "" {
+		return nil, ErrUnknownCaller
+	}
+	user, err := models.UserProfile(ctx, DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, ErrUnknownCaller
+	}
+	return user, nil
+}
+
+// AuthorizeAccount returns the account when it belongs to the session user.
+// Every operation that moves money out of an account, or shows its activity,
+// must pass through this check.
+func AuthorizeAccount(ctx context.Context, DB *gorm.DB, userID, accountID string) (*models.Account, error) {
+	user, err := Caller(ctx, DB, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := models.IsAccountExists(ctx, DB, accountID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if account.UserID != user.ID {
+		return nil, ErrNotAccountOwner
+	}
+	return account, nil
+}
+
+// AuthorizeRefund allows a refund only to the owner of the account that was
+// paid, since that is the account the refund takes the money from. The payee
+// is read from the journal: the ToAccount of a payout is the sender.
+func AuthorizeRefund(ctx context.Context, DB *gorm.DB, userID, paymentID string) error {
+	_, err := models.FindPayment(ctx, DB, paymentID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrPaymentNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	_, payee, _, err := refundParties(ctx, DB, paymentID)
+	if errors.Is(err, ErrNotRefundable) {
+		// the money went to a system account, nobody can refund on its behalf
+		return ErrNotAccountOwner
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = AuthorizeAccount(ctx, DB, userID, payee)
+	return err
+}
+
+// AuthorizeHold returns the hold when the session user owns the held account
+// or the payee account. Only the payee may capture, so payeeOnly restricts the
+// check to the payee side.
+func AuthorizeHold(ctx context.Context, DB *gorm.DB, userID, holdID string, payeeOnly bool) (models.Hold, error) {
+	hold, err := models.FindHold(ctx, DB, holdID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return hold, ErrHoldNotFound
+	}
+	if err != nil {
+		return hold, err
+	}
+
+	_, err = AuthorizeAccount(ctx, DB, userID, hold.PayeeAccount)
+	if err == nil || payeeOnly {
+		return hold, err
+	}
+	_, err = AuthorizeAccount(ctx, DB, userID, hold.AccountID)
+	return hold, err
+}
+
+// AuthorizeSchedule returns the schedule when the session user created it.
+func AuthorizeSchedule(ctx context.Context, DB *gorm.DB, userID, scheduleID string) (models.PaymentSchedule, error) {
+	user, err := Caller(ctx, DB, userID)
+	if err != nil {
+		return models.PaymentSchedule{}, err
+	}
+
+	schedule, err := models.FindSchedule(ctx, DB, scheduleID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return schedule, ErrScheduleNotFound
+	}
+	if err != nil {
+		return schedule, err
+	}
+
+	if schedule.UserID != user.ID {
+		return schedule, ErrNotAccountOwner
+	}
+	return schedule, nil
+}
+
+// AuthorizePayoutBatch returns the batch when the session user created it.
+func AuthorizePayoutBatch(ctx context.Context, DB *gorm.DB, userID, batchID string) (models.PayoutBatch, error) {
+	user, err := Caller(ctx, DB, userID)
+	if err != nil {
+		return models.PayoutBatch{}, err
+	}
+
+	batch, err := models.FindPayoutBatch(ctx, DB, batchID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return batch, ErrBatchNotFound
+	}
+	if err != nil {
+		return batch, err
+	}
+
+	if batch.UserID != user.ID {
+		return batch, ErrNotAccountOwner
+	}
+	return batch, nil
+}
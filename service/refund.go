@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/grey/models"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPaymentNotFound     = errors.New("payment not found")
+	ErrNotRefundable       = errors.New("payment cannot be refunded")
+	ErrRefundExceedsAmount = errors.New("refund exceeds the refundable amount")
+)
+
+// RefundPayment gives all or part of a completed payment back to the payer.
+// A zero amount refunds whatever has not been refunded yet. The refund is a
+// payment of its own that reverses the original journal lines and links back
+// to the original through OriginalPaymentID.
+func RefundPayment(ctx context.Context, DB *gorm.DB, paymentID string, amount decimal.Decimal, reason string) (refund models.Payment, err error) {
+	if amount.LessThan(decimal.Zero) {
+		return refund, ErrInvalidAmount
+	}
+
+	tx := DB.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return refund, tx.Error
+	}
+	// Defer rollback in case of error
+	defer tx.Rollback()
+
+	original, err := models.FindPayment(ctx, tx, paymentID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return refund, ErrPaymentNotFound
+	}
+	if err != nil {
+		return refund, err
+	}
+
+	if original.OriginalPaymentID != "" {
+		return refund, ErrNotRefundable
+	}
+	if original.Status != models.Completed && original.Status != models.PartiallyRefunded {
+		return refund, ErrNotRefundable
+	}
+	// a payout that left the platform is only reversed when the provider
+	// reports it failed, see releasePayout; an opening balance has no payer
+	if original.Type == models.BankTransfer || original.Type == models.MobileMoney || original.Type == models.OpeningBalance {
+		return refund, ErrNotRefundable
+	}
+
+	payer, payee, credited, err := refundParties(ctx, tx, original.PaymentID)
+	if err != nil {
+		return refund, err
+	}
+
+	// only what reached the payee can be given back, a fee taken out of the
+	// amount stays with the platform
+	remaining := credited.Sub(original.RefundedAmount)
+	if amount.IsZero() {
+		amount = remaining
+	}
+	if amount.GreaterThan(remaining) {
+		return refund, ErrRefundExceedsAmount
+	}
+
+	if reason == "" {
+		reason = "refund requested"
+	}
+
+	refund = models.Payment{
+		FromAccount:       payee,
+		ToAccount:         payer,
+		Currency:          original.Currency,
+		Amount:            amount,
+		Type:              models.RefundTransaction,
+		Description:       "Refund",
+		OriginalPaymentID: original.PaymentID,
+	}
+
+	err = startPayment(ctx, tx, &refund)
+	if err != nil {
+		return refund, err
+	}
+
+	// take the money back from whoever received it
+	err = debitAccount(tx, payee, amount)
+	if errors.Is(err, ErrInsufficientBalance) {
+		return refund, failPayment(ctx, tx, &refund, err)
+	}
+	if err != nil {
+		return refund, err
+	}
+
+	err = creditAccount(tx, payer, amount)
+	if err != nil {
+		return refund, err
+	}
+
+	err = postJournal(ctx, tx, &refund,
+		models.DebitEntry(payee, amount, original.Currency),
+		models.CreditEntry(payer, amount, original.Currency),
+	)
+	if err != nil {
+		return refund, err
+	}
+
+	err = transitionPayment(ctx, tx, &refund, models.Completed, reason)
+	if err != nil {
+		return refund, err
+	}
+
+	// guard against concurrent refunds pushing the total past the credited amount
+	result := tx.Exec("UPDATE payments SET refunded_amount = refunded_amount + $1 WHERE payment_id = $2 AND refunded_amount + $1 <= CAST($3 AS NUMERIC)", amount, original.PaymentID, credited)
+	if result.Error != nil {
+		return refund, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return refund, ErrRefundExceedsAmount
+	}
+
+	next := models.PartiallyRefunded
+	if original.RefundedAmount.Add(amount).Equal(credited) {
+		next = models.Reversed
+	}
+	err = transitionPayment(ctx, tx, original, next, reason)
+	if err != nil {
+		return refund, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return refund, err
+	}
+	return refund, nil
+}
+
+// refundParties reads the original journal to find who paid, who was paid and
+// how much the payee was credited. Only journals with a single payer and a
+// single customer payee can be reversed. Fees are not refundable, so the fee
+// revenue line is left out.
+func refundParties(ctx context.Context, tx *gorm.DB, paymentID string) (payer, payee string, credited decimal.Decimal, err error) {
+	entries, err := models.JournalEntries(ctx, tx, paymentID)
+	if err != nil {
+		return "", "", decimal.Zero, err
+	}
+
+	for _, entry := range entries {
+		if entry.AccountID == models.SystemAccountID(models.FeeRevenue, entry.Currency) {
+			continue
+		}
+		switch entry.Direction {
+		case models.Debit:
+			if payer != "" {
+				return "", "", decimal.Zero, ErrNotRefundable
+			}
+			payer = entry.AccountID
+		case models.Credit:
+			// money held in a system account, such as a payout in clearing,
+			// is not the caller's to give back
+			if payee != "" || models.IsSystemAccount(entry.AccountID, entry.Currency) {
+				return "", "", decimal.Zero, ErrNotRefundable
+			}
+			payee = entry.AccountID
+			credited = entry.Amount
+		}
+	}
+
+	if payer == "" || payee == "" {
+		return "", "", decimal.Zero, ErrNotRefundable
+	}
+	return payer, payee, credited, nil
+}
@@ -0,0 +1,327 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/grey/cron"
+	"github.com/grey/models"
+	"github.com/grey/providers"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrScheduleNotFound  = errors.New("schedule not found")
+	ErrInvalidSchedule   = errors.New("invalid schedule")
+	ErrScheduleNotActive = errors.New("schedule is not active")
+	ErrScheduleNotPaused = errors.New("schedule is not paused")
+)
+
+// CreateSchedule validates the schedule and stores it with its first
+// occurrence at or after StartAt, which defaults to now. Times are kept in
+// UTC, so daily and monthly schedules run at the same UTC time of day.
+func CreateSchedule(ctx context.Context, DB *gorm.DB, schedule *models.PaymentSchedule) error {
+	if schedule.Amount.LessThanOrEqual(decimal.Zero) {
+		return ErrInvalidAmount
+	}
+
+	accounts := []string{schedule.FromAccount}
+	switch schedule.Type {
+	case models.InternalTransfer:
+		if schedule.ToAccount == "" {
+			return fmt.Errorf("%w: to_account is required for internal payments", ErrInvalidSchedule)
+		}
+		accounts = append(accounts, schedule.ToAccount)
+	case models.BankTransfer, models.MobileMoney:
+		if _, err := providers.Lookup(schedule.Type); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+		}
+		if schedule.RecipientNumber == "" {
+			return fmt.Errorf("%w: recipient is required for external payments", ErrInvalidSchedule)
+		}
+	default:
+		return fmt.Errorf("%w: transaction type must be INTERNAL, BANK_TRANSFER or MOBILE_MONEY", ErrInvalidSchedule)
+	}
+
+	switch schedule.Frequency {
+	case models.ScheduleOnce, models.ScheduleDaily, models.ScheduleWeekly, models.ScheduleMonthly:
+		schedule.CronExpr = ""
+	case models.ScheduleCron:
+		if _, err := cron.Parse(schedule.CronExpr); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+		}
+	default:
+		return fmt.Errorf("%w: frequency must be once, daily, weekly, monthly or cron", ErrInvalidSchedule)
+	}
+
+	now := time.Now().UTC()
+	if schedule.StartAt.IsZero() {
+		schedule.StartAt = now
+	}
+	schedule.StartAt = schedule.StartAt.UTC()
+	if schedule.StartAt.Before(now.Add(-time.Minute)) {
+		return fmt.Errorf("%w: start_at is in the past", ErrInvalidSchedule)
+	}
+	if schedule.EndAt != nil {
+		end := schedule.EndAt.UTC()
+		if end.Before(schedule.StartAt) {
+			return fmt.Errorf("%w: end_at is before start_at", ErrInvalidSchedule)
+		}
+		schedule.EndAt = &end
+	}
+
+	err := checkCurrency(ctx, DB, schedule.Currency, schedule.Amount, accounts...)
+	if err != nil {
+		return err
+	}
+
+	next, ok := NextOccurrence(schedule, schedule.StartAt.Add(-time.Nanosecond))
+	if !ok {
+		return fmt.Errorf("%w: the schedule never runs", ErrInvalidSchedule)
+	}
+	schedule.NextRunAt = next
+
+	return models.CreateSchedule(ctx, DB, schedule)
+}
+
+// NextOccurrence returns the first occurrence of the schedule after the given
+// time, and false when the schedule has none left. Monthly schedules keep the
+// day of StartAt and run on the last day of shorter months.
+func NextOccurrence(schedule *models.PaymentSchedule, after time.Time) (time.Time, bool) {
+	start := schedule.StartAt.UTC()
+	after = after.UTC()
+
+	var next time.Time
+	switch schedule.Frequency {
+	case models.ScheduleOnce:
+		if !start.After(after) {
+			return time.Time{}, false
+		}
+		next = start
+	case models.ScheduleCron:
+		expr, err := cron.Parse(schedule.CronExpr)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if start.After(after) {
+			after = start.Add(-time.Nanosecond)
+		}
+		next = expr.Next(after)
+		if next.IsZero() {
+			return time.Time{}, false
+		}
+	case models.ScheduleDaily, models.ScheduleWeekly, models.ScheduleMonthly:
+		occurrence, period := intervalOccurrence(schedule.Frequency, start)
+		k := 0
+		if after.After(start) {
+			// the estimate never overshoots, the loop walks the rest
+			k = max(int(after.Sub(start)/period)-1, 0)
+		}
+		for !occurrence(k).After(after) {
+			k++
+		}
+		next = occurrence(k)
+	default:
+		return time.Time{}, false
+	}
+
+	if schedule.EndAt != nil && next.After(*schedule.EndAt) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// intervalOccurrence returns the k-th occurrence of a fixed interval schedule
+// and a period no longer than the interval.
+func intervalOccurrence(frequency models.ScheduleFrequency, start time.Time) (func(k int) time.Time, time.Duration) {
+	switch frequency {
+	case models.ScheduleDaily:
+		return func(k int) time.Time { return start.AddDate(0, 0, k) }, 24 * time.Hour
+	case models.ScheduleWeekly:
+		return func(k int) time.Time { return start.AddDate(0, 0, 7*k) }, 7 * 24 * time.Hour
+	default:
+		return func(k int) time.Time {
+			month := start.Month() + time.Month(k)
+			// day 0 of the following month is the last day of this one
+			lastDay := time.Date(start.Year(), month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+			return time.Date(start.Year(), month, min(start.Day(), lastDay), start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), time.UTC)
+		}, 28 * 24 * time.Hour
+	}
+}
+
+// ExecuteDueSchedules pays every occurrence that is due and records the
+// outcome. Occurrences missed while the scheduler was down are paid once, not
+// once per missed occurrence. It returns how many payments it attempted.
+func ExecuteDueSchedules(ctx context.Context, DB *gorm.DB) (int, error) {
+	now := time.Now().UTC()
+	schedules, err := models.DueSchedules(ctx, DB, now, 100)
+	if err != nil {
+		return 0, err
+	}
+
+	executed := 0
+	for _, schedule := range schedules {
+		due := schedule.NextRunAt
+
+		status := models.ScheduleActive
+		next, ok := NextOccurrence(&schedule, now)
+		if !ok {
+			status = models.ScheduleCompleted
+			next = due
+		}
+
+		// the schedule moves on before the payment is made: a crash in
+		// between loses the occurrence rather than paying it twice
+		claimed, err := models.ClaimScheduleRun(ctx, DB, &schedule, now, next, status)
+		if err != nil {
+			return executed, err
+		}
+		if !claimed {
+			continue
+		}
+
+		run := models.ScheduleRun{
+			ScheduleID:   schedule.ScheduleID,
+			ScheduledFor: due,
+			Status:       models.ScheduleRunSucceeded,
+		}
+		run.PaymentID, err = executeSchedule(ctx, DB, &schedule)
+		if err != nil {
+			run.Status = models.ScheduleRunFailed
+			run.Error = err.Error()
+		}
+		executed++
+
+		err = models.RecordScheduleRun(ctx, DB, &run)
+		if err != nil {
+			return executed, err
+		}
+	}
+	return executed, nil
+}
+
+// RunScheduler executes due schedules every interval until the context is
+// cancelled.
+func RunScheduler(ctx context.Context, DB *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := ExecuteDueSchedules(ctx, DB); err != nil {
+				logrus.WithError(err).Error("Error executing scheduled payments")
+			}
+		}
+	}
+}
+
+// executeSchedule makes the payment of one occurrence through the same
+// service functions as the payment endpoints.
+func executeSchedule(ctx context.Context, DB *gorm.DB, schedule *models.PaymentSchedule) (string, error) {
+	if schedule.Type == models.InternalTransfer {
+		payment, err := ProcessInternalPayment(ctx, DB, schedule.FromAccount, schedule.ToAccount, schedule.Amount, schedule.Currency)
+		return payment.PaymentID, err
+	}
+
+	provider, err := providers.Lookup(schedule.Type)
+	if err != nil {
+		return "", err
+	}
+	recipient := structs.RecipientDetails{
+		RecipientNumber: schedule.RecipientNumber,
+		RecipientName:   schedule.RecipientName,
+	}
+	response, err := ProcessExternalPayment(ctx, DB, provider, schedule.Type, recipient, schedule.FromAccount, schedule.Amount, schedule.Currency)
+	return response.PaymentID, err
+}
+
+// SkipSchedule drops the next occurrence of an active or paused schedule and
+// records it as skipped.
+func SkipSchedule(ctx context.Context, DB *gorm.DB, schedule *models.PaymentSchedule) error {
+	if schedule.Status != models.ScheduleActive && schedule.Status != models.SchedulePaused {
+		return ErrScheduleNotActive
+	}
+
+	skipped := schedule.NextRunAt
+	status := schedule.Status
+	next, ok := NextOccurrence(schedule, skipped)
+	if !ok {
+		status = models.ScheduleCompleted
+		next = skipped
+	}
+
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		updated, err := models.UpdateSchedule(ctx, tx, schedule, schedule.Status, status, next)
+		if err != nil {
+			return err
+		}
+		if !updated {
+			return ErrScheduleNotActive
+		}
+		return models.RecordScheduleRun(ctx, tx, &models.ScheduleRun{
+			ScheduleID:   schedule.ScheduleID,
+			ScheduledFor: skipped,
+			Status:       models.ScheduleRunSkipped,
+		})
+	})
+}
+
+// PauseSchedule stops an active schedule from running until it is resumed.
+func PauseSchedule(ctx context.Context, DB *gorm.DB, schedule *models.PaymentSchedule) error {
+	updated, err := models.UpdateSchedule(ctx, DB, schedule, models.ScheduleActive, models.SchedulePaused, schedule.NextRunAt)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrScheduleNotActive
+	}
+	return nil
+}
+
+// ResumeSchedule restarts a paused schedule. Occurrences that fell in the
+// pause are not made up.
+func ResumeSchedule(ctx context.Context, DB *gorm.DB, schedule *models.PaymentSchedule) error {
+	status := models.ScheduleActive
+	next := schedule.NextRunAt
+	now := time.Now().UTC()
+	if next.Before(now) {
+		var ok bool
+		next, ok = NextOccurrence(schedule, now)
+		if !ok {
+			status = models.ScheduleCompleted
+			next = schedule.NextRunAt
+		}
+	}
+
+	updated, err := models.UpdateSchedule(ctx, DB, schedule, models.SchedulePaused, status, next)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrScheduleNotPaused
+	}
+	return nil
+}
+
+// CancelSchedule ends an active or paused schedule for good.
+func CancelSchedule(ctx context.Context, DB *gorm.DB, schedule *models.PaymentSchedule) error {
+	if schedule.Status != models.ScheduleActive && schedule.Status != models.SchedulePaused {
+		return ErrScheduleNotActive
+	}
+	updated, err := models.UpdateSchedule(ctx, DB, schedule, schedule.Status, models.ScheduleCancelled, schedule.NextRunAt)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrScheduleNotActive
+	}
+	return nil
+}
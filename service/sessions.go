@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/grey/models"
+	"github.com/grey/utils"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	RevokedByLogout = "logout"
+	RevokedByReuse  = "refresh_token_reuse"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used, the session has been revoked")
+)
+
+// RefreshTokenTTL bounds how long a session can go without being refreshed.
+// Set from REFRESH_TOKEN_TTL at startup.
+var RefreshTokenTTL = 30 * 24 * time.Hour
+
+// SessionTokens is what a client holds for a session: a short-lived access
+// token for API calls and the refresh token that replaces it.
+type SessionTokens struct {
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	SessionID        string    `json:"session_id"`
+}
+
+// StartSession opens a new session for the user after a successful login.
+func StartSession(ctx context.Context, DB *gorm.DB, user *models.User) (SessionTokens, error) {
+	var tokens SessionTokens
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		session := models.Session{UserID: user.ID}
+		err := models.CreateSession(ctx, tx, &session)
+		if err != nil {
+			return err
+		}
+		tokens, err = issueSessionTokens(ctx, tx, user, session.SessionID)
+		return err
+	})
+	return tokens, err
+}
+
+// RefreshSession exchanges a refresh token for a new access and refresh token
+// in the same session. Each refresh token works once: presenting a spent one
+// means it leaked, so the whole session is revoked, including the tokens the
+// legitimate holder got from the last rotation.
+func RefreshSession(ctx context.Context, DB *gorm.DB, refreshToken string) (SessionTokens, error) {
+	if refreshToken == "" {
+		return SessionTokens{}, ErrInvalidRefreshToken
+	}
+
+	token, err := models.FindRefreshToken(ctx, DB, hashOpaqueToken(refreshToken))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return SessionTokens{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return SessionTokens{}, err
+	}
+
+	session, err := models.FindSession(ctx, DB, token.SessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return SessionTokens{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	if session.RevokedAt != nil {
+		return SessionTokens{}, ErrInvalidRefreshToken
+	}
+	if token.UsedAt != nil {
+		return SessionTokens{}, revokeReusedSession(ctx, DB, session.SessionID)
+	}
+	if !time.Now().Before(token.ExpiresAt) {
+		return SessionTokens{}, ErrInvalidRefreshToken
+	}
+
+	user, err := models.FindUser(ctx, DB, session.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return SessionTokens{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return SessionTokens{}, err
+	}
+
+	var tokens SessionTokens
+	spent := true
+	err = DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		spent, err = models.SpendRefreshToken(ctx, tx, &token)
+		if err != nil || !spent {
+			return err
+		}
+		tokens, err = issueSessionTokens(ctx, tx, &user, session.SessionID)
+		return err
+	})
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	if !spent {
+		// another request exchanged the same token first
+		return SessionTokens{}, revokeReusedSession(ctx, DB, session.SessionID)
+	}
+	return tokens, nil
+}
+
+// EndSession logs out the session an access token was issued under. Tokens
+// issued outside a session are revoked on their own.
+func EndSession(ctx context.Context, DB *gorm.DB, sessionID, jti string, expiresAt time.Time) error {
+	if sessionID == "" {
+		return models.RevokeToken(ctx, DB, jti, expiresAt)
+	}
+	return models.RevokeSession(ctx, DB, sessionID, RevokedByLogout)
+}
+
+// PruneRevokedTokens drops revocation entries of tokens that have expired.
+func PruneRevokedTokens(ctx context.Context, DB *gorm.DB) (int64, error) {
+	return models.PruneRevokedTokens(ctx, DB, time.Now())
+}
+
+// RunRevocationPruner prunes the revocation list every interval until the
+// context is cancelled.
+func RunRevocationPruner(ctx context.Context, DB *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := PruneRevokedTokens(ctx, DB); err != nil {
+				logrus.WithError(err).Error("Error pruning revoked tokens")
+			}
+		}
+	}
+}
+
+func revokeReusedSession(ctx context.Context, DB *gorm.DB, sessionID string) error {
+	err := models.RevokeSession(ctx, DB, sessionID, RevokedByReuse)
+	if err != nil {
+		return err
+	}
+	logrus.WithField("session_id", sessionID).Error("Refresh token reused, session revoked")
+	return ErrRefreshTokenReused
+}
+
+// issueSessionTokens signs an access token and stores a fresh refresh token
+// for the session.
+func issueSessionTokens(ctx context.Context, tx *gorm.DB, user *models.User, sessionID string) (SessionTokens, error) {
+	access, err := utils.IssueAccessToken(user.UserId, user.Email, sessionID)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+
+	refreshToken, err := newOpaqueToken()
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	record := models.RefreshToken{
+		TokenHash:       hashOpaqueToken(refreshToken),
+		SessionID:       sessionID,
+		AccessJTI:       access.JTI,
+		AccessExpiresAt: access.ExpiresAt,
+		ExpiresAt:       time.Now().Add(RefreshTokenTTL),
+	}
+	err = models.CreateRefreshToken(ctx, tx, &record)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+
+	return SessionTokens{
+		Token:            access.Token,
+		ExpiresAt:        access.ExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: record.ExpiresAt,
+		SessionID:        sessionID,
+	}, nil
+}
+
+// newOpaqueToken makes the random bearer secrets handed to clients, such as
+// refresh and password reset tokens. Only hashOpaqueToken of it is stored.
+func newOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,404 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grey/models"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+var ErrUnsupportedStatementFormat = errors.New("unsupported statement format")
+
+type StatementFormat string
+
+const (
+	StatementCSV     StatementFormat = "csv"
+	StatementOFX     StatementFormat = "ofx"
+	StatementCamt053 StatementFormat = "camt053"
+)
+
+func (format StatementFormat) ContentType() string {
+	switch format {
+	case StatementOFX:
+		return "application/x-ofx"
+	case StatementCamt053:
+		return "application/xml"
+	default:
+		return "text/csv"
+	}
+}
+
+func (format StatementFormat) FileExtension() string {
+	switch format {
+	case StatementOFX:
+		return "ofx"
+	case StatementCamt053:
+		return "xml"
+	default:
+		return "csv"
+	}
+}
+
+// Statement describes the period being exported. Lines are booked in
+// [From, To), so the closing balance of one statement is the opening balance
+// of the next.
+type Statement struct {
+	Account        models.Account
+	From           time.Time
+	To             time.Time
+	OpeningBalance decimal.Decimal
+	ClosingBalance decimal.Decimal
+	GeneratedAt    time.Time
+}
+
+// StatementWriter renders a statement as it is read from the ledger. Line is
+// called once per entry, oldest first, with the balance right after it.
+type StatementWriter interface {
+	Begin(statement Statement) error
+	Line(line models.LedgerLine, balance decimal.Decimal) error
+	End(statement Statement) error
+}
+
+func NewStatementWriter(format StatementFormat, w io.Writer) (StatementWriter, error) {
+	switch format {
+	case StatementCSV:
+		return &csvStatementWriter{w: csv.NewWriter(w)}, nil
+	case StatementOFX:
+		return &ofxStatementWriter{stream: xmlStream{enc: xml.NewEncoder(w)}}, nil
+	case StatementCamt053:
+		return &camtStatementWriter{stream: xmlStream{enc: xml.NewEncoder(w)}}, nil
+	default:
+		return nil, ErrUnsupportedStatementFormat
+	}
+}
+
+// WriteStatement streams the account's ledger lines booked in [from, to) to w
+// in the given format.
+func WriteStatement(ctx context.Context, DB *gorm.DB, w io.Writer, format StatementFormat, account models.Account, from, to time.Time) error {
+	writer, err := NewStatementWriter(format, w)
+	if err != nil {
+		return err
+	}
+
+	statement := Statement{Account: account, From: from, To: to, GeneratedAt: time.Now().UTC()}
+	statement.OpeningBalance, err = models.LedgerBalanceBefore(ctx, DB, account.AccountID, from)
+	if err != nil {
+		return err
+	}
+	statement.ClosingBalance, err = models.LedgerBalanceBefore(ctx, DB, account.AccountID, to)
+	if err != nil {
+		return err
+	}
+
+	if err := writer.Begin(statement); err != nil {
+		return err
+	}
+	balance := statement.OpeningBalance
+	filter := models.LedgerFilter{AccountID: account.AccountID, From: from, To: to}
+	err = models.EachLedgerLine(ctx, DB, filter, func(line models.LedgerLine) error {
+		balance = balance.Add(line.Amount)
+		return writer.Line(line, balance)
+	})
+	if err != nil {
+		return err
+	}
+	return writer.End(statement)
+}
+
+func formatAmount(amount decimal.Decimal) string {
+	return amount.StringFixed(2)
+}
+
+type csvStatementWriter struct {
+	w *csv.Writer
+}
+
+var csvStatementHeader = []string{"entry_id", "booked_at", "payment_id", "type", "description", "direction", "amount", "currency", "balance"}
+
+func (writer *csvStatementWriter) Begin(statement Statement) error {
+	if err := writer.w.Write(csvStatementHeader); err != nil {
+		return err
+	}
+	return writer.balanceRow("Opening balance", statement.From, statement.OpeningBalance, statement.Account.Currency)
+}
+
+func (writer *csvStatementWriter) Line(line models.LedgerLine, balance decimal.Decimal) error {
+	return writer.w.Write([]string{
+		strconv.Itoa(line.ID),
+		line.CreatedAt.UTC().Format(time.RFC3339),
+		line.PaymentID,
+		string(line.PaymentType),
+		line.Description,
+		string(line.Direction),
+		formatAmount(line.Amount),
+		line.Currency,
+		formatAmount(balance),
+	})
+}
+
+func (writer *csvStatementWriter) End(statement Statement) error {
+	if err := writer.balanceRow("Closing balance", statement.To, statement.ClosingBalance, statement.Account.Currency); err != nil {
+		return err
+	}
+	writer.w.Flush()
+	return writer.w.Error()
+}
+
+// balanceRow writes the opening or closing balance as a row without an entry
+// so spreadsheets keep a single column layout.
+func (writer *csvStatementWriter) balanceRow(label string, at time.Time, balance decimal.Decimal, currency string) error {
+	return writer.w.Write([]string{"", at.UTC().Format(time.RFC3339), "", "", label, "", "", currency, formatAmount(balance)})
+}
+
+// ofxStatementWriter renders an OFX 2.1.1 bank statement response.
+type ofxStatementWriter struct {
+	stream xmlStream
+}
+
+const ofxTimeLayout = "20060102150405"
+
+type ofxStatus struct {
+	Code     int    `xml:"CODE"`
+	Severity string `xml:"SEVERITY"`
+}
+
+type ofxTransaction struct {
+	XMLName   xml.Name `xml:"STMTTRN"`
+	Type      string   `xml:"TRNTYPE"`
+	Posted    string   `xml:"DTPOSTED"`
+	Amount    string   `xml:"TRNAMT"`
+	FITID     string   `xml:"FITID"`
+	Name      string   `xml:"NAME,omitempty"`
+	Memo      string   `xml:"MEMO,omitempty"`
+	PaymentID string   `xml:"REFNUM"`
+}
+
+func (writer *ofxStatementWriter) Begin(statement Statement) error {
+	stream := &writer.stream
+	stream.token(xml.ProcInst{Target: "xml", Inst: []byte(`version="1.0" encoding="UTF-8" standalone="no"`)})
+	stream.token(xml.ProcInst{Target: "OFX", Inst: []byte(`OFXHEADER="200" VERSION="211" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"`)})
+	stream.open("OFX")
+
+	stream.open("SIGNONMSGSRSV1")
+	stream.open("SONRS")
+	stream.element("STATUS", ofxStatus{Code: 0, Severity: "INFO"})
+	stream.element("DTSERVER", statement.GeneratedAt.Format(ofxTimeLayout))
+	stream.element("LANGUAGE", "ENG")
+	stream.close("SONRS", "SIGNONMSGSRSV1")
+
+	stream.open("BANKMSGSRSV1")
+	stream.open("STMTTRNRS")
+	stream.element("TRNUID", "0")
+	stream.element("STATUS", ofxStatus{Code: 0, Severity: "INFO"})
+	stream.open("STMTRS")
+	stream.element("CURDEF", statement.Account.Currency)
+	stream.open("BANKACCTFROM")
+	stream.element("BANKID", "GREY")
+	stream.element("ACCTID", statementID(statement.Account.AccountID))
+	stream.element("ACCTTYPE", "CHECKING")
+	stream.close("BANKACCTFROM")
+
+	stream.open("BANKTRANLIST")
+	stream.element("DTSTART", statement.From.UTC().Format(ofxTimeLayout))
+	stream.element("DTEND", statement.To.UTC().Format(ofxTimeLayout))
+	return stream.flush()
+}
+
+func (writer *ofxStatementWriter) Line(line models.LedgerLine, balance decimal.Decimal) error {
+	transactionType := "CREDIT"
+	if line.Direction == models.Debit {
+		transactionType = "DEBIT"
+	}
+	return writer.stream.encode(ofxTransaction{
+		Type:      transactionType,
+		Posted:    line.CreatedAt.UTC().Format(ofxTimeLayout),
+		Amount:    formatAmount(line.Amount),
+		FITID:     strconv.Itoa(line.ID),
+		Name:      string(line.PaymentType),
+		Memo:      line.Description,
+		PaymentID: statementID(line.PaymentID),
+	})
+}
+
+func (writer *ofxStatementWriter) End(statement Statement) error {
+	stream := &writer.stream
+	stream.close("BANKTRANLIST")
+	stream.open("LEDGERBAL")
+	stream.element("BALAMT", formatAmount(statement.ClosingBalance))
+	stream.element("DTASOF", statement.To.UTC().Format(ofxTimeLayout))
+	stream.close("LEDGERBAL", "STMTRS", "STMTTRNRS", "BANKMSGSRSV1", "OFX")
+	return stream.flush()
+}
+
+// statementID shortens an account or payment id to 22 characters, the most
+// OFX allows for ACCTID and within camt.053's Max34Text and Max35Text. A UUID
+// becomes its 16 bytes in unpadded URL-safe base64, so it can be turned back;
+// anything else is hashed.
+func statementID(id string) string {
+	raw := []byte(id)
+	if parsed, err := uuid.Parse(id); err == nil {
+		raw = parsed[:]
+	} else {
+		sum := sha256.Sum256(raw)
+		raw = sum[:16]
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// camtStatementWriter renders an ISO 20022 camt.053.001.02 bank to customer
+// statement.
+type camtStatementWriter struct {
+	stream xmlStream
+}
+
+const camtNamespace = "urn:iso:std:iso:20022:tech:xsd:camt.053.001.02"
+
+type camtAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type camtBalance struct {
+	XMLName     xml.Name   `xml:"Bal"`
+	Code        string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amount      camtAmount `xml:"Amt"`
+	CreditDebit string     `xml:"CdtDbtInd"`
+	Date        string     `xml:"Dt>Dt"`
+}
+
+type camtEntry struct {
+	XMLName     xml.Name   `xml:"Ntry"`
+	Reference   string     `xml:"NtryRef"`
+	Amount      camtAmount `xml:"Amt"`
+	CreditDebit string     `xml:"CdtDbtInd"`
+	Status      string     `xml:"Sts"`
+	BookingDate string     `xml:"BookgDt>DtTm"`
+	ValueDate   string     `xml:"ValDt>DtTm"`
+	BankCode    string     `xml:"BkTxCd>Prtry>Cd"`
+	EndToEndID  string     `xml:"NtryDtls>TxDtls>Refs>EndToEndId"`
+	Information string     `xml:"NtryDtls>TxDtls>AddtlTxInf,omitempty"`
+}
+
+func creditDebitIndicator(amount decimal.Decimal) string {
+	if amount.IsNegative() {
+		return "DBIT"
+	}
+	return "CRDT"
+}
+
+func camtBalanceOf(code string, balance decimal.Decimal, currency string, at time.Time) camtBalance {
+	return camtBalance{
+		Code:        code,
+		Amount:      camtAmount{Currency: currency, Value: formatAmount(balance.Abs())},
+		CreditDebit: creditDebitIndicator(balance),
+		Date:        at.UTC().Format(time.DateOnly),
+	}
+}
+
+func (writer *camtStatementWriter) Begin(statement Statement) error {
+	stream := &writer.stream
+	accountID := statementID(statement.Account.AccountID)
+	messageID := accountID + "-" + statement.From.UTC().Format("20060102")
+	generatedAt := statement.GeneratedAt.Format(time.RFC3339)
+	currency := statement.Account.Currency
+
+	stream.token(xml.ProcInst{Target: "xml", Inst: []byte(`version="1.0" encoding="UTF-8"`)})
+	stream.open("Document", xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: camtNamespace})
+	stream.open("BkToCstmrStmt")
+
+	stream.open("GrpHdr")
+	stream.element("MsgId", messageID)
+	stream.element("CreDtTm", generatedAt)
+	stream.close("GrpHdr")
+
+	stream.open("Stmt")
+	stream.element("Id", messageID)
+	stream.element("CreDtTm", generatedAt)
+	stream.open("FrToDt")
+	stream.element("FrDtTm", statement.From.UTC().Format(time.RFC3339))
+	stream.element("ToDtTm", statement.To.UTC().Format(time.RFC3339))
+	stream.close("FrToDt")
+	stream.open("Acct")
+	stream.open("Id")
+	stream.open("Othr")
+	stream.element("Id", accountID)
+	stream.close("Othr", "Id")
+	stream.element("Ccy", currency)
+	stream.close("Acct")
+
+	stream.encode(camtBalanceOf("OPBD", statement.OpeningBalance, currency, statement.From))
+	return stream.encode(camtBalanceOf("CLBD", statement.ClosingBalance, currency, statement.To))
+}
+
+func (writer *camtStatementWriter) Line(line models.LedgerLine, balance decimal.Decimal) error {
+	bookedAt := line.CreatedAt.UTC().Format(time.RFC3339)
+	return writer.stream.encode(camtEntry{
+		Reference:   strconv.Itoa(line.ID),
+		Amount:      camtAmount{Currency: line.Currency, Value: formatAmount(line.Amount.Abs())},
+		CreditDebit: creditDebitIndicator(line.Amount),
+		Status:      "BOOK",
+		BookingDate: bookedAt,
+		ValueDate:   bookedAt,
+		BankCode:    string(line.PaymentType),
+		EndToEndID:  statementID(line.PaymentID),
+		Information: line.Description,
+	})
+}
+
+func (writer *camtStatementWriter) End(statement Statement) error {
+	writer.stream.close("Stmt", "BkToCstmrStmt", "Document")
+	return writer.stream.flush()
+}
+
+// xmlStream writes an XML document piece by piece. The first error sticks and
+// every later call becomes a no-op, so writers check it once per call.
+type xmlStream struct {
+	enc *xml.Encoder
+	err error
+}
+
+func (stream *xmlStream) token(token xml.Token) {
+	if stream.err == nil {
+		stream.err = stream.enc.EncodeToken(token)
+	}
+}
+
+func (stream *xmlStream) open(name string, attrs ...xml.Attr) {
+	stream.token(xml.StartElement{Name: xml.Name{Local: name}, Attr: attrs})
+}
+
+func (stream *xmlStream) close(names ...string) {
+	for _, name := range names {
+		stream.token(xml.EndElement{Name: xml.Name{Local: name}})
+	}
+}
+
+func (stream *xmlStream) element(name string, value interface{}) {
+	if stream.err == nil {
+		stream.err = stream.enc.EncodeElement(value, xml.StartElement{Name: xml.Name{Local: name}})
+	}
+}
+
+func (stream *xmlStream) encode(value interface{}) error {
+	if stream.err == nil {
+		stream.err = stream.enc.Encode(value)
+	}
+	return stream.err
+}
+
+func (stream *xmlStream) flush() error {
+	if stream.err == nil {
+		stream.err = stream.enc.Flush()
+	}
+	return stream.err
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+
+	"github.com/grey/models"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+func EncodeCursor(entryID int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(entryID)))
+}
+
+func DecodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	entryID, err := strconv.Atoi(string(raw))
+	if err != nil || entryID <= 0 {
+		return 0, ErrInvalidCursor
+	}
+	return entryID, nil
+}
+
+// AccountTransactions returns one page of the account's ledger lines, newest
+// first. The running balance on each line is the ledger balance right after
+// that entry and ignores the filters, so it matches the account statement.
+func AccountTransactions(ctx context.Context, DB *gorm.DB, filter models.LedgerFilter) (structs.TransactionPage, error) {
+	if filter.Limit <= 0 || filter.Limit > MaxPageSize {
+		filter.Limit = DefaultPageSize
+	}
+	pageSize := filter.Limit
+	// fetch one extra line to know whether there is a next page
+	filter.Limit++
+
+	lines, err := models.ListLedgerLines(ctx, DB, filter)
+	if err != nil {
+		return structs.TransactionPage{}, err
+	}
+
+	page := structs.TransactionPage{Data: []structs.TransactionLine{}}
+	if len(lines) > pageSize {
+		lines = lines[:pageSize]
+		page.NextCursor = EncodeCursor(lines[len(lines)-1].ID)
+	}
+	if len(lines) == 0 {
+		return page, nil
+	}
+
+	newest, oldest := lines[0].ID, lines[len(lines)-1].ID
+	balance, err := models.LedgerBalance(ctx, DB, filter.AccountID, newest)
+	if err != nil {
+		return structs.TransactionPage{}, err
+	}
+
+	// walk back through every entry in the page range, including the ones the
+	// filters left out, to know the balance after each line
+	entries, err := models.LedgerAmountsBetween(ctx, DB, filter.AccountID, oldest, newest)
+	if err != nil {
+		return structs.TransactionPage{}, err
+	}
+	balances := make(map[int]decimal.Decimal, len(lines))
+	for _, entry := range entries {
+		balances[entry.ID] = balance
+		balance = balance.Sub(entry.Amount)
+	}
+
+	for _, line := range lines {
+		page.Data = append(page.Data, structs.TransactionLine{
+			EntryID:        line.ID,
+			PaymentID:      line.PaymentID,
+			JournalID:      line.JournalID,
+			Type:           string(line.PaymentType),
+			Description:    line.Description,
+			Status:         string(line.PaymentStatus),
+			Direction:      string(line.Direction),
+			Amount:         line.Amount,
+			Currency:       line.Currency,
+			RunningBalance: balances[line.ID],
+			CreatedAt:      line.CreatedAt,
+		})
+	}
+	return page, nil
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/grey/models"
+	"github.com/grey/notify"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrEmailAlreadyVerified      = errors.New("the email address is already verified")
+	ErrEmailNotVerified          = errors.New("the email address must be verified first")
+	ErrInvalidVerificationToken  = errors.New("verification token is invalid or expired")
+	ErrVerificationResendTooSoon = errors.New("a verification email was sent too recently")
+)
+
+// A verification email can be resent once VerificationResendInterval has
+// passed since the last one, and at most MaxVerificationEmails times an hour,
+// counting the one sent at registration.
+const (
+	VerificationResendInterval = time.Minute
+	MaxVerificationEmails      = 5
+)
+
+// EmailVerificationTTL is how long a verification token works. Set from
+// EMAIL_VERIFICATION_TTL at startup.
+var EmailVerificationTTL = 24 * time.Hour
+
+// EmailVerificationURL is the page of the app that takes a verification
+// token, which is appended to it. Without it the email carries the bare
+// token. Set from EMAIL_VERIFICATION_URL at startup.
+var EmailVerificationURL = ""
+
+// ResendError is a resend refused by the rate limit.
+type ResendError struct {
+	RetryAfter time.Duration
+}
+
+func (err *ResendError) Error() string {
+	return ErrVerificationResendTooSoon.Error()
+}
+
+func (err *ResendError) Unwrap() error {
+	return ErrVerificationResendTooSoon
+}
+
+// SendVerificationEmail emails the user a token that verifies their address.
+// Earlier tokens stop working.
+func SendVerificationEmail(ctx context.Context, DB *gorm.DB, user *models.User) error {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+	record := models.UserToken{
+		UserID:    user.ID,
+		Purpose:   models.EmailVerificationToken,
+		TokenHash: hashOpaqueToken(token),
+		ExpiresAt: time.Now().Add(EmailVerificationTTL),
+	}
+	err = models.CreateUserToken(ctx, DB, &record)
+	if err != nil {
+		return err
+	}
+
+	link := token
+	if EmailVerificationURL != "" {
+		link = EmailVerificationURL + token
+	}
+	return notify.Default.Send(ctx, notify.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Welcome! Use this within %s to verify your email address and unlock payouts:\n\n%s\n\nIf you did not create an account, ignore this email.",
+			EmailVerificationTTL, link),
+	})
+}
+
+// ResendVerificationEmail sends a new verification email, within the rate
+// limit.
+func ResendVerificationEmail(ctx context.Context, DB *gorm.DB, user *models.User) error {
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+
+	now := time.Now()
+	sent, err := models.RecentUserTokens(ctx, DB, user.ID, models.EmailVerificationToken, now.Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	wait := resendWait(sent, VerificationResendInterval, MaxVerificationEmails, now)
+	if wait > 0 {
+		return &ResendError{RetryAfter: wait}
+	}
+
+	return SendVerificationEmail(ctx, DB, user)
+}
+
+// resendWait is how long until another email may be sent, given the tokens
+// sent in the last hour, newest first: interval after the latest one and at
+// most perHour an hour.
+func resendWait(sent []models.UserToken, interval time.Duration, perHour int, now time.Time) time.Duration {
+	var wait time.Duration
+	if len(sent) >= perHour {
+		wait = sent[perHour-1].CreatedAt.Add(time.Hour).Sub(now)
+	}
+	if len(sent) > 0 {
+		wait = max(wait, sent[0].CreatedAt.Add(interval).Sub(now))
+	}
+	return wait
+}
+
+// VerifyEmail spends a verification token and marks the email verified.
+func VerifyEmail(ctx context.Context, DB *gorm.DB, token string) error {
+	if token == "" {
+		return ErrInvalidVerificationToken
+	}
+	record, err := models.FindUserToken(ctx, DB, models.EmailVerificationToken, hashOpaqueToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return err
+	}
+
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		spent, err := models.SpendUserToken(ctx, tx, &record)
+		if err != nil {
+			return err
+		}
+		if !spent {
+			return ErrInvalidVerificationToken
+		}
+		return models.MarkEmailVerified(ctx, tx, record.UserID, time.Now())
+	})
+}
+
+// RequireVerifiedEmail refuses callers whose email is not verified. Payouts
+// to banks and mobile money wallets are only made for verified users.
+func RequireVerifiedEmail(ctx context.Context, DB *gorm.DB, userID string) error {
+	user, err := Caller(ctx, DB, userID)
+	if err != nil {
+		return err
+	}
+	if !user.EmailVerified {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
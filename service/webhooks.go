@@ -0,0 +1,321 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/grey/models"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	WebhookSignatureHeader = "X-Grey-Signature"
+	WebhookEventHeader     = "X-Grey-Event"
+	WebhookDeliveryHeader  = "X-Grey-Delivery"
+)
+
+var (
+	ErrInvalidWebhookURL = errors.New("webhook url must be an absolute https url on a public host")
+	errBlockedWebhookIP  = errors.New("webhook endpoint resolves to a non-public address")
+)
+
+// AllowPrivateWebhooks lets webhooks reach loopback and private addresses.
+// Only for local development; set from WEBHOOK_ALLOW_PRIVATE_NETWORKS at
+// startup.
+var AllowPrivateWebhooks = false
+
+// WebhookEventTypes are the events users can subscribe to.
+var WebhookEventTypes = []string{
+	models.PaymentCreatedEvent,
+	models.PaymentCompletedEvent,
+	models.PaymentFailedEvent,
+	models.AccountCreditedEvent,
+}
+
+// WebhookEnvelope is the JSON body posted to subscribers.
+type WebhookEnvelope struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// SignWebhook returns the signature header value for a body sent at the given
+// time: the hex HMAC-SHA256 of "<unix timestamp>.<body>" keyed with the
+// subscription secret. Receivers should reject stale timestamps.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix + "."))
+	mac.Write(body)
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookSink is the outbox sink that fans payment events out to the webhook
+// subscriptions of the users whose accounts they touch.
+type WebhookSink struct {
+	DB *gorm.DB
+}
+
+func NewWebhookSink(db *gorm.DB) *WebhookSink {
+	return &WebhookSink{DB: db}
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhooks"
+}
+
+func (s *WebhookSink) Handle(ctx context.Context, event models.OutboxEvent) error {
+	var accounts struct {
+		FromAccount string `json:"from_account"`
+		ToAccount   string `json:"to_account"`
+		AccountID   string `json:"account_id"`
+	}
+	err := json.Unmarshal([]byte(event.Payload), &accounts)
+	if err != nil {
+		return err
+	}
+
+	var accountIDs []string
+	for _, accountID := range []string{accounts.FromAccount, accounts.ToAccount, accounts.AccountID} {
+		if accountID != "" {
+			accountIDs = append(accountIDs, accountID)
+		}
+	}
+	if len(accountIDs) == 0 {
+		return nil
+	}
+
+	subscriptions, err := models.SubscriptionsForAccounts(ctx, s.DB, accountIDs)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(WebhookEnvelope{
+		ID:        event.EventID,
+		Type:      event.EventType,
+		CreatedAt: event.CreatedAt,
+		Data:      json.RawMessage(event.Payload),
+	})
+	if err != nil {
+		return err
+	}
+
+	var deliveries []models.WebhookDelivery
+	for _, subscription := range subscriptions {
+		if !subscription.Subscribes(event.EventType) {
+			continue
+		}
+		deliveries = append(deliveries, models.WebhookDelivery{
+			SubscriptionID: subscription.SubscriptionID,
+			EventID:        event.EventID,
+			EventType:      event.EventType,
+			Payload:        string(body),
+			Status:         models.DeliveryPending,
+			NextAttemptAt:  time.Now(),
+		})
+	}
+	return models.CreateWebhookDeliveries(ctx, s.DB, deliveries)
+}
+
+// WebhookDeliverer posts queued deliveries to subscriber endpoints. A failed
+// delivery is retried with exponential backoff starting at BaseBackoff until
+// MaxAttempts calls have been made.
+type WebhookDeliverer struct {
+	DB           *gorm.DB
+	Client       *http.Client
+	BatchSize    int
+	PollInterval time.Duration
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	Lease        time.Duration
+}
+
+func NewWebhookDeliverer(db *gorm.DB) *WebhookDeliverer {
+	return &WebhookDeliverer{
+		DB:           db,
+		Client:       NewWebhookClient(),
+		BatchSize:    50,
+		PollInterval: time.Second,
+		MaxAttempts:  8,
+		BaseBackoff:  30 * time.Second,
+		Lease:        time.Minute,
+	}
+}
+
+// Run delivers webhooks until ctx is done.
+func (d *WebhookDeliverer) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := d.DeliverOnce(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				logrus.WithError(err).Error("Error delivering webhooks")
+			}
+		}
+	}
+}
+
+// DeliverOnce sends one batch of due deliveries and returns how many succeeded.
+func (d *WebhookDeliverer) DeliverOnce(ctx context.Context) (int, error) {
+	deliveries, err := models.DueWebhookDeliveries(ctx, d.DB, d.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	succeeded := 0
+	for i := range deliveries {
+		delivery := &deliveries[i]
+
+		claimed, err := models.ClaimWebhookDelivery(ctx, d.DB, delivery, d.Lease)
+		if err != nil {
+			return succeeded, err
+		}
+		if !claimed {
+			continue
+		}
+
+		attempt, final := d.send(ctx, delivery)
+
+		status := models.DeliverySucceeded
+		nextAttempt := time.Now()
+		if attempt.Error != "" {
+			status = models.DeliveryPending
+			if final || delivery.Attempts+1 >= d.MaxAttempts {
+				status = models.DeliveryFailed
+			}
+			nextAttempt = nextAttempt.Add(d.BaseBackoff << delivery.Attempts)
+		}
+
+		err = models.RecordWebhookAttempt(ctx, d.DB, delivery, attempt, status, nextAttempt)
+		if err != nil {
+			return succeeded, err
+		}
+		if status == models.DeliverySucceeded {
+			succeeded++
+		}
+	}
+	return succeeded, nil
+}
+
+// send makes one delivery attempt. final is set when retrying cannot help,
+// such as when the subscription has been deleted.
+func (d *WebhookDeliverer) send(ctx context.Context, delivery *models.WebhookDelivery) (attempt models.WebhookDeliveryAttempt, final bool) {
+	started := time.Now()
+
+	var subscription models.WebhookSubscription
+	err := d.DB.WithContext(ctx).Where("subscription_id = ?", delivery.SubscriptionID).First(&subscription).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		attempt.Error = "subscription was deleted"
+		return attempt, true
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+
+	body := []byte(delivery.Payload)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, true
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "Grey-Webhooks/1.0")
+	request.Header.Set(WebhookEventHeader, delivery.EventType)
+	request.Header.Set(WebhookDeliveryHeader, delivery.DeliveryID)
+	request.Header.Set(WebhookSignatureHeader, SignWebhook(subscription.Secret, started, body))
+
+	response, err := d.Client.Do(request)
+	attempt.DurationMs = time.Since(started).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+	defer response.Body.Close()
+
+	attempt.ResponseStatus = response.StatusCode
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("endpoint responded with status %d", response.StatusCode)
+	}
+	return attempt, false
+}
+
+// ValidateWebhookURL accepts only https urls whose host resolves to public
+// addresses, so subscriptions cannot point the deliverer at internal
+// services.
+func ValidateWebhookURL(ctx context.Context, rawURL string) error {
+	endpoint, err := url.Parse(rawURL)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Hostname() == "" || endpoint.User != nil {
+		return ErrInvalidWebhookURL
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, endpoint.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return ErrInvalidWebhookURL
+	}
+	for _, addr := range addrs {
+		if !webhookAddressAllowed(addr.IP) {
+			return ErrInvalidWebhookURL
+		}
+	}
+	return nil
+}
+
+// NewWebhookClient returns the client deliveries are sent with. The address
+// is checked again when connecting, since a host can resolve to a different
+// address than it did when the subscription was created. Proxies and
+// redirects are not followed.
+func NewWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !webhookAddressAllowed(ip) {
+				return errBlockedWebhookIP
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func webhookAddressAllowed(ip net.IP) bool {
+	if AllowPrivateWebhooks {
+		return true
+	}
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
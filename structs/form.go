@@ -1,15 +1,111 @@
 package structs
 
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
 type User struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	Password        string `json:"password" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type SetPINRequest struct {
+	Password string `json:"password" binding:"required"`
+	PIN      string `json:"pin" binding:"required"`
+}
+
+type ChangePINRequest struct {
+	CurrentPIN string `json:"current_pin" binding:"required"`
+	PIN        string `json:"pin" binding:"required"`
+}
+
+type OpenAccountRequest struct {
+	Currency string `json:"currency" binding:"required,len=3"`
+}
+
 type InternalPaymentRequest struct {
 	FromAccount string  `json:"from_account"`
 	ToAccount   string  `json:"to_account"`
 	Amount      float64 `json:"amount"`
 	Currency    string  `json:"currency"`
+	// QuoteID converts between accounts in different currencies at the rate
+	// of an FX quote. Amount and currency are then taken from the quote.
+	QuoteID string `json:"quote_id,omitempty"`
+}
+
+type FXQuoteRequest struct {
+	FromAccount string  `json:"from_account" binding:"required"`
+	ToAccount   string  `json:"to_account" binding:"required"`
+	Amount      float64 `json:"amount" binding:"required"`
+}
+
+type HoldRequest struct {
+	Account      string  `json:"account" binding:"required"`
+	PayeeAccount string  `json:"payee_account" binding:"required"`
+	Amount       float64 `json:"amount" binding:"required"`
+	Currency     string  `json:"currency"`
+	ExpiresIn    int     `json:"expires_in"`
+	Description  string  `json:"description"`
+}
+
+type CaptureHoldRequest struct {
+	Amount float64 `json:"amount"`
+}
+
+type ScheduleRequest struct {
+	TransactionType string           `json:"transaction_type" binding:"required"`
+	FromAccount     string           `json:"from_account" binding:"required"`
+	ToAccount       string           `json:"to_account"`
+	Recipient       RecipientDetails `json:"recipient"`
+	Amount          float64          `json:"amount" binding:"required"`
+	Currency        string           `json:"currency"`
+	Description     string           `json:"description"`
+	Frequency       string           `json:"frequency" binding:"required"`
+	Cron            string           `json:"cron"`
+	StartAt         *time.Time       `json:"start_at"`
+	EndAt           *time.Time       `json:"end_at"`
+}
+
+type PayoutItemRequest struct {
+	TransactionType string           `json:"transaction_type"`
+	Recipient       RecipientDetails `json:"recipient"`
+	Amount          float64          `json:"amount"`
+	Reference       string           `json:"reference"`
+}
+
+type PayoutBatchRequest struct {
+	FromAccount     string              `json:"from_account" binding:"required"`
+	Currency        string              `json:"currency"`
+	TransactionType string              `json:"transaction_type"`
+	Items           []PayoutItemRequest `json:"items" binding:"required"`
 }
 
 type RecipientDetails struct {
@@ -30,6 +126,7 @@ type ExternalPaymentResponse struct {
 	Recipient      RecipientDetails `json:"recipient"`
 	Status         string           `json:"status"`
 	ProviderStatus string           `json:"provider_status"`
+	Fee            decimal.Decimal  `json:"fee"`
 }
 
 type TopUp struct {
@@ -39,6 +136,74 @@ type TopUp struct {
 }
 
 type TopUpResponse struct {
-	PaymentID string `json:"payment_id"`
-	Status    string `json:"status"`
+	PaymentID string          `json:"payment_id"`
+	Status    string          `json:"status"`
+	Fee       decimal.Decimal `json:"fee"`
+}
+
+type RefundRequest struct {
+	Amount float64 `json:"amount"`
+	Reason string  `json:"reason"`
+}
+
+type WebhookSubscriptionRequest struct {
+	URL        string   `json:"url" binding:"required,url"`
+	EventTypes []string `json:"event_types" binding:"required,min=1"`
+	Secret     string   `json:"secret"`
+}
+
+type WebhookSubscriptionResponse struct {
+	SubscriptionID string    `json:"subscription_id"`
+	URL            string    `json:"url"`
+	EventTypes     []string  `json:"event_types"`
+	Secret         string    `json:"secret,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type TransactionLine struct {
+	EntryID        int             `json:"entry_id"`
+	PaymentID      string          `json:"payment_id"`
+	JournalID      string          `json:"journal_id"`
+	Type           string          `json:"type"`
+	Description    string          `json:"description"`
+	Status         string          `json:"status"`
+	Direction      string          `json:"direction"`
+	Amount         decimal.Decimal `json:"amount"`
+	Currency       string          `json:"currency"`
+	RunningBalance decimal.Decimal `json:"running_balance"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+type TransactionPage struct {
+	Data       []TransactionLine `json:"data"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// Allowance is an amount limit with what was used of it so far.
+type Allowance struct {
+	Limit     decimal.Decimal `json:"limit"`
+	Used      decimal.Decimal `json:"used"`
+	Remaining decimal.Decimal `json:"remaining"`
+}
+
+// AmountLimitStatus shows the amount limits of one currency; a null limit is
+// not capped.
+type AmountLimitStatus struct {
+	Currency       string           `json:"currency"`
+	PerTransaction *decimal.Decimal `json:"per_transaction"`
+	Daily          *Allowance       `json:"daily"`
+	Monthly        *Allowance       `json:"monthly"`
+}
+
+type VelocityStatus struct {
+	Count     int    `json:"count"`
+	Window    string `json:"window"`
+	Used      int64  `json:"used"`
+	Remaining int64  `json:"remaining"`
+}
+
+type LimitStatus struct {
+	TransactionType string              `json:"transaction_type"`
+	Amounts         []AmountLimitStatus `json:"amounts"`
+	Velocity        []VelocityStatus    `json:"velocity"`
 }
@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grey/controllers"
+	"github.com/grey/models"
+	"github.com/grey/providers"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/grey/utils"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountOwnership(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test data
+	owner := CreateTestUser(t, db)
+	ownerAccount := CreateTestAccount(t, db, owner.ID, 1000.0)
+
+	intruder := &models.User{Email: "intruder@example.com", Password: "hashedpassword"}
+	assert.NoError(t, db.Create(intruder).Error)
+	intruderAccount := CreateTestAccount(t, db, intruder.ID, 0.0)
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+	ownerToken := CreateTestJWT(t, owner)
+	intruderToken := CreateTestJWT(t, intruder)
+
+	request := func(token, path string, payload interface{}) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	balance := func(accountID string) decimal.Decimal {
+		account, err := models.IsAccountExists(t.Context(), db, accountID)
+		assert.NoError(t, err)
+		return account.Balance
+	}
+
+	// Test case 1: Debiting someone else's account
+	t.Run("Internal Payment From Foreign Account", func(t *testing.T) {
+		w := request(intruderToken, "/payment/api/internal_payment", structs.InternalPaymentRequest{
+			FromAccount: ownerAccount.AccountID,
+			ToAccount:   intruderAccount.AccountID,
+			Amount:      100.0,
+			Currency:    "USD",
+		})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.True(t, balance(ownerAccount.AccountID).Equal(decimal.NewFromInt(1000)))
+	})
+
+	// Test case 2: Paying out of someone else's account
+	t.Run("External Payment From Foreign Account", func(t *testing.T) {
+		w := request(intruderToken, "/payment/api/external_payment", structs.ExternalPaymentRequest{
+			Account:         ownerAccount.AccountID,
+			Recipient:       structs.RecipientDetails{RecipientNumber: "1234567890", RecipientName: "Jane Doe"},
+			Amount:          100.0,
+			Currency:        "USD",
+			TransactionType: "BANK_TRANSFER",
+		})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.True(t, balance(ownerAccount.AccountID).Equal(decimal.NewFromInt(1000)))
+	})
+
+	// Test case 3: Topping up someone else's account
+	t.Run("Top Up Foreign Account", func(t *testing.T) {
+		w := request(intruderToken, "/payment/api/topup", structs.TopUp{
+			Account:  ownerAccount.AccountID,
+			Amount:   100.0,
+			Currency: "USD",
+		})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	// Test case 4: Only the payee can refund
+	t.Run("Refund By Payer", func(t *testing.T) {
+		payment, err := service.ProcessInternalPayment(t.Context(), db, ownerAccount.AccountID, intruderAccount.AccountID, decimal.NewFromInt(100), "USD")
+		assert.NoError(t, err)
+
+		w := request(ownerToken, "/payment/api/payments/"+payment.PaymentID+"/refund", structs.RefundRequest{Amount: 100.0})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.True(t, balance(intruderAccount.AccountID).Equal(decimal.NewFromInt(100)))
+
+		w = request(intruderToken, "/payment/api/payments/"+payment.PaymentID+"/refund", structs.RefundRequest{Amount: 100.0})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, balance(ownerAccount.AccountID).Equal(decimal.NewFromInt(1000)))
+	})
+
+	// Test case 5: The sender of a payout is not its payee
+	t.Run("Refund Own Payout", func(t *testing.T) {
+		payout, err := service.ProcessExternalPayment(t.Context(), db, BankSimulator, models.BankTransfer, structs.RecipientDetails{RecipientNumber: "1234567890", RecipientName: "Jane Doe"}, ownerAccount.AccountID, decimal.NewFromInt(500), "USD")
+		assert.NoError(t, err)
+		payment, err := models.FindPayment(t.Context(), db, payout.PaymentID)
+		assert.NoError(t, err)
+		_, err = service.SettleExternalPayment(t.Context(), db, BankSimulator, providers.PayoutResult{ProviderReference: payment.ProviderReference, Status: providers.PayoutSucceeded})
+		assert.NoError(t, err)
+
+		w := request(ownerToken, "/payment/api/payments/"+payout.PaymentID+"/refund", structs.RefundRequest{Amount: 500.0})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.True(t, balance(ownerAccount.AccountID).Equal(decimal.NewFromInt(500)))
+	})
+
+	// Test case 6: A valid token for a user that does not exist
+	t.Run("Unknown User", func(t *testing.T) {
+		token, err := utils.GenerateToken("00000000-0000-0000-0000-000000000000", "ghost@example.com")
+		assert.NoError(t, err)
+
+		w := request(token, "/payment/api/topup", structs.TopUp{
+			Account:  ownerAccount.AccountID,
+			Amount:   100.0,
+			Currency: "USD",
+		})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/grey/controllers"
 	"github.com/grey/structs"
 	"github.com/stretchr/testify/assert"
 )
@@ -25,7 +26,7 @@ func TestExternalPayment(t *testing.T) {
 
 	// Create test router
 	router := SetupTestRouterWithDB(db)
-	token := CreateTestJWT(t, user.Email)
+	token := CreateTestJWT(t, user)
 
 	// Test case 1: Successful bank transfer
 	t.Run("Successful Bank Transfer", func(t *testing.T) {
@@ -44,6 +45,7 @@ func TestExternalPayment(t *testing.T) {
 		req, _ := http.NewRequest("POST", "/payment/api/external_payment", bytes.NewBuffer(jsonPayload))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
 
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
@@ -73,6 +75,7 @@ func TestExternalPayment(t *testing.T) {
 		req, _ := http.NewRequest("POST", "/payment/api/external_payment", bytes.NewBuffer(jsonPayload))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
 
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
@@ -102,6 +105,7 @@ func TestExternalPayment(t *testing.T) {
 		req, _ := http.NewRequest("POST", "/payment/api/external_payment", bytes.NewBuffer(jsonPayload))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
 
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
@@ -131,6 +135,7 @@ func TestExternalPayment(t *testing.T) {
 		req, _ := http.NewRequest("POST", "/payment/api/external_payment", bytes.NewBuffer(jsonPayload))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
 
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
@@ -160,6 +165,7 @@ func TestExternalPayment(t *testing.T) {
 		req, _ := http.NewRequest("POST", "/payment/api/external_payment", bytes.NewBuffer(jsonPayload))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
 
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grey/fees"
+	"github.com/grey/models"
+	"github.com/grey/providers"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeeRules(t *testing.T) {
+	d := decimal.RequireFromString
+
+	// Test case 1: Percentage plus fixed, held between min and max
+	t.Run("Percentage And Fixed", func(t *testing.T) {
+		rule := fees.Rule{Percentage: d("1.5"), Fixed: d("0.30"), Min: d("1"), Max: d("20")}
+		assert.Equal(t, "1.8", rule.Fee(d("100")).String())
+		assert.Equal(t, "1", rule.Fee(d("10")).String())
+		assert.Equal(t, "20", rule.Fee(d("5000")).String())
+	})
+
+	// Test case 2: The first tier the amount fits in prices it
+	t.Run("Tiers", func(t *testing.T) {
+		rule := fees.Rule{Tiers: []fees.Tier{
+			{UpTo: d("100"), Fixed: d("1")},
+			{UpTo: d("1000"), Percentage: d("1")},
+			{Percentage: d("0.5")},
+		}}
+		assert.Equal(t, "1", rule.Fee(d("100")).String())
+		assert.Equal(t, "5", rule.Fee(d("500")).String())
+		assert.Equal(t, "10", rule.Fee(d("2000")).String())
+	})
+
+	// Test case 3: Quotes round to the currency and price types without a rule as free
+	t.Run("Quote", func(t *testing.T) {
+		engine := fees.NewEngine(fees.Schedule{
+			models.BankTransfer: {Percentage: d("1.25")},
+		})
+		quote, err := engine.Quote(models.BankTransfer, d("10.10"), "USD")
+		assert.NoError(t, err)
+		assert.Equal(t, "0.13", quote.Fee.String())
+		assert.Equal(t, "10.23", quote.Total.String())
+
+		quote, err = engine.Quote(models.BankTransfer, d("1001"), "JPY")
+		assert.NoError(t, err)
+		assert.Equal(t, "13", quote.Fee.String())
+
+		quote, err = engine.Quote(models.MobileMoney, d("100"), "USD")
+		assert.NoError(t, err)
+		assert.True(t, quote.Fee.IsZero())
+	})
+}
+
+func TestPaymentFees(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	fees.Default.SetSchedule(fees.Schedule{
+		models.InternalTransfer: {Percentage: decimal.NewFromInt(1), Fixed: decimal.RequireFromString("0.50")},
+		models.BankTransfer:     {Fixed: decimal.NewFromInt(2)},
+		models.TopUpTransaction: {Percentage: decimal.NewFromInt(2)},
+	})
+	defer fees.Default.SetSchedule(fees.Schedule{})
+
+	// Create test data
+	user := CreateTestUser(t, db)
+	fromAccount := CreateTestAccount(t, db, user.ID, 1000.0)
+	payee := CreateTestUserWithEmail(t, db, "payee@example.com")
+	toAccount := CreateTestAccount(t, db, payee.ID, 0.0)
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+	token := CreateTestJWT(t, user)
+
+	balance := func(accountID string) decimal.Decimal {
+		account, err := models.IsAccountExists(t.Context(), db, accountID)
+		assert.NoError(t, err)
+		return account.Balance
+	}
+	revenue := models.SystemAccountID(models.FeeRevenue, "USD")
+
+	// Test case 1: Preview matches what is charged
+	t.Run("Preview", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/payment/api/fees/preview?transaction_type=INTERNAL&amount=200&currency=USD", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		data := response["data"].(map[string]interface{})
+		assert.Equal(t, "2.5", data["fee"])
+		assert.Equal(t, "202.5", data["total"])
+
+		req, _ = http.NewRequest("GET", "/payment/api/fees/preview?transaction_type=WIRE&amount=200", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	// Test case 2: Internal payment charges the fee on top and books it as revenue
+	t.Run("Internal Payment Fee", func(t *testing.T) {
+		payment, err := service.ProcessInternalPayment(t.Context(), db, fromAccount.AccountID, toAccount.AccountID, decimal.NewFromInt(200), "USD")
+		assert.NoError(t, err)
+		assert.Equal(t, "2.5", payment.Fee.String())
+
+		assert.True(t, balance(fromAccount.AccountID).Equal(decimal.RequireFromString("797.5")))
+		assert.True(t, balance(toAccount.AccountID).Equal(decimal.NewFromInt(200)))
+		assert.True(t, balance(revenue).Equal(decimal.RequireFromString("2.5")))
+
+		entries := assertBalancedJournal(t, db, payment.PaymentID)
+		assertEntry(t, entries, fromAccount.AccountID, models.Debit, "-202.5")
+		assertEntry(t, entries, revenue, models.Credit, "2.5")
+
+		// Test case 3: Refunds give back the amount but keep the fee
+		_, err = service.RefundPayment(t.Context(), db, payment.PaymentID, decimal.Zero, "")
+		assert.NoError(t, err)
+		assert.True(t, balance(fromAccount.AccountID).Equal(decimal.RequireFromString("997.5")))
+		assert.True(t, balance(toAccount.AccountID).IsZero())
+		assert.True(t, balance(revenue).Equal(decimal.RequireFromString("2.5")))
+	})
+
+	// Test case 4: The fee must be covered by the balance
+	t.Run("Fee Exceeds Balance", func(t *testing.T) {
+		_, err := service.ProcessInternalPayment(t.Context(), db, fromAccount.AccountID, toAccount.AccountID, decimal.RequireFromString("997.5"), "USD")
+		assert.ErrorIs(t, err, service.ErrInsufficientBalance)
+		assert.True(t, balance(fromAccount.AccountID).Equal(decimal.RequireFromString("997.5")))
+	})
+
+	// Test case 5: A declined payout returns the fee too
+	t.Run("Declined Payout Returns Fee", func(t *testing.T) {
+		BankSimulator.SetOutcome(providers.PayoutFailed)
+		defer BankSimulator.SetOutcome(providers.PayoutSucceeded)
+
+		_, err := service.ProcessExternalPayment(t.Context(), db, BankSimulator, models.BankTransfer, structs.RecipientDetails{RecipientNumber: "1234567890", RecipientName: "Jane Doe"}, fromAccount.AccountID, decimal.NewFromInt(100), "USD")
+		assert.ErrorIs(t, err, service.ErrPayoutFailed)
+		assert.True(t, balance(fromAccount.AccountID).Equal(decimal.RequireFromString("997.5")))
+		assert.True(t, balance(revenue).Equal(decimal.RequireFromString("2.5")))
+	})
+
+	// Test case 6: Top-ups take the fee out of the amount added
+	t.Run("Top Up Fee", func(t *testing.T) {
+		response, err := service.TopUpProcess(t.Context(), db, toAccount.AccountID, decimal.NewFromInt(100), "USD")
+		assert.NoError(t, err)
+		assert.Equal(t, "2", response.Fee.String())
+		assert.True(t, balance(toAccount.AccountID).Equal(decimal.NewFromInt(98)))
+		assertBalancedJournal(t, db, response.PaymentID)
+
+		// Test case 7: Refunding the top-up gives back only what was credited
+		_, err = service.RefundPayment(t.Context(), db, response.PaymentID, decimal.NewFromInt(100), "")
+		assert.ErrorIs(t, err, service.ErrRefundExceedsAmount)
+
+		refund, err := service.RefundPayment(t.Context(), db, response.PaymentID, decimal.Zero, "")
+		assert.NoError(t, err)
+		assert.Equal(t, "98", refund.Amount.String())
+		assert.True(t, balance(toAccount.AccountID).IsZero())
+		assert.True(t, balance(revenue).Equal(decimal.RequireFromString("4.5")))
+
+		original, err := models.FindPayment(t.Context(), db, response.PaymentID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Reversed, original.Status)
+	})
+}
@@ -0,0 +1,175 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grey/controllers"
+	"github.com/grey/fx"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFXTransfers(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	defaultPricer := fx.Default
+	fx.Default = fx.NewPricer(fx.NewStaticRates("USD", map[string]decimal.Decimal{
+		"GHS": decimal.NewFromInt(15),
+		"EUR": decimal.RequireFromString("0.9"),
+	}), 100, time.Minute)
+	defer func() { fx.Default = defaultPricer }()
+
+	// Create test data
+	user := CreateTestUser(t, db)
+	usdAccount := CreateTestAccount(t, db, user.ID, 1000.0)
+	ghsAccount, err := service.OpenAccount(t.Context(), db, user.ID, "GHS")
+	assert.NoError(t, err)
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+	token := CreateTestJWT(t, user)
+
+	request := func(path string, payload interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+		jsonPayload, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	balance := func(accountID string) decimal.Decimal {
+		account, err := models.IsAccountExists(t.Context(), db, accountID)
+		assert.NoError(t, err)
+		return account.Balance
+	}
+
+	quote := func(amount float64) (*httptest.ResponseRecorder, string) {
+		w, response := request("/payment/api/fx/quotes", structs.FXQuoteRequest{
+			FromAccount: usdAccount.AccountID,
+			ToAccount:   ghsAccount.AccountID,
+			Amount:      amount,
+		})
+		data, _ := response["data"].(map[string]interface{})
+		quoteID, _ := data["quote_id"].(string)
+		return w, quoteID
+	}
+
+	// Test case 1: Quote applies the spread to the mid rate
+	t.Run("Quote", func(t *testing.T) {
+		w, response := request("/payment/api/fx/quotes", structs.FXQuoteRequest{
+			FromAccount: usdAccount.AccountID,
+			ToAccount:   ghsAccount.AccountID,
+			Amount:      100.0,
+		})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		data := response["data"].(map[string]interface{})
+		assert.Equal(t, "15", data["mid_rate"])
+		assert.Equal(t, "14.85", data["rate"])
+		assert.Equal(t, "1485", data["to_amount"])
+		assert.Equal(t, "GHS", data["to_currency"])
+	})
+
+	// Test case 2: Converted transfer posts both currencies through the FX position
+	t.Run("Converted Transfer", func(t *testing.T) {
+		w, quoteID := quote(100.0)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		w, response := request("/payment/api/internal_payment", structs.InternalPaymentRequest{
+			FromAccount: usdAccount.AccountID,
+			ToAccount:   ghsAccount.AccountID,
+			QuoteID:     quoteID,
+		})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		assert.True(t, balance(usdAccount.AccountID).Equal(decimal.NewFromInt(900)))
+		assert.True(t, balance(ghsAccount.AccountID).Equal(decimal.NewFromInt(1485)))
+		assert.True(t, balance(models.SystemAccountID(models.FXPosition, "USD")).Equal(decimal.NewFromInt(100)))
+		assert.True(t, balance(models.SystemAccountID(models.FXPosition, "GHS")).Equal(decimal.NewFromInt(-1485)))
+
+		paymentID := response["response"].(map[string]interface{})["payment_id"].(string)
+		entries := assertBalancedJournal(t, db, paymentID)
+		assert.Len(t, entries, 4)
+		assertEntry(t, entries, usdAccount.AccountID, models.Debit, "-100")
+		assertEntry(t, entries, ghsAccount.AccountID, models.Credit, "1485")
+
+		// Test case 3: A quote pays for one payment only
+		w, _ = request("/payment/api/internal_payment", structs.InternalPaymentRequest{
+			FromAccount: usdAccount.AccountID,
+			ToAccount:   ghsAccount.AccountID,
+			QuoteID:     quoteID,
+		})
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.True(t, balance(usdAccount.AccountID).Equal(decimal.NewFromInt(900)))
+	})
+
+	// Test case 4: Expired quotes are refused
+	t.Run("Expired Quote", func(t *testing.T) {
+		_, quoteID := quote(50.0)
+		assert.NoError(t, db.Model(&models.FXQuote{}).Where("quote_id = ?", quoteID).
+			Update("expires_at", time.Now().Add(-time.Second)).Error)
+
+		w, _ := request("/payment/api/internal_payment", structs.InternalPaymentRequest{
+			FromAccount: usdAccount.AccountID,
+			ToAccount:   ghsAccount.AccountID,
+			QuoteID:     quoteID,
+		})
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.True(t, balance(usdAccount.AccountID).Equal(decimal.NewFromInt(900)))
+	})
+
+	// Test case 5: Missing rates and same-currency quotes
+	t.Run("Quote Errors", func(t *testing.T) {
+		kesAccount, err := service.OpenAccount(t.Context(), db, user.ID, "KES")
+		assert.NoError(t, err)
+
+		w, _ := request("/payment/api/fx/quotes", structs.FXQuoteRequest{
+			FromAccount: usdAccount.AccountID,
+			ToAccount:   kesAccount.AccountID,
+			Amount:      10.0,
+		})
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		w, _ = request("/payment/api/fx/quotes", structs.FXQuoteRequest{
+			FromAccount: usdAccount.AccountID,
+			ToAccount:   usdAccount.AccountID,
+			Amount:      10.0,
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	// Test case 6: Rates read from a feed file, cross rate through the base
+	t.Run("File Rates", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "rates.json")
+		assert.NoError(t, os.WriteFile(path, []byte(`{"base":"USD","rates":{"GHS":"15","EUR":"0.9"}}`), 0o600))
+
+		source := fx.NewFileRates(path, time.Hour)
+		rate, err := source.Rate(t.Context(), "EUR", "GHS")
+		assert.NoError(t, err)
+		assert.Equal(t, "16.6666666667", rate.String())
+
+		_, err = source.Rate(t.Context(), "USD", "KES")
+		assert.ErrorIs(t, err, fx.ErrRateUnavailable)
+	})
+}
@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grey/controllers"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHolds(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test data
+	customer := CreateTestUser(t, db)
+	customerAccount := CreateTestAccount(t, db, customer.ID, 1000.0)
+
+	merchant := &models.User{Email: "merchant@example.com", Password: "hashedpassword", PinHash: customer.PinHash}
+	assert.NoError(t, db.Create(merchant).Error)
+	merchantAccount := CreateTestAccount(t, db, merchant.ID, 0.0)
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+	customerToken := CreateTestJWT(t, customer)
+	merchantToken := CreateTestJWT(t, merchant)
+
+	request := func(token, method, path string, payload interface{}) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	account := func(accountID string) *models.Account {
+		account, err := models.IsAccountExists(t.Context(), db, accountID)
+		assert.NoError(t, err)
+		return account
+	}
+
+	d := decimal.RequireFromString
+
+	// Test case 1: A hold reduces the available balance but not the ledger balance
+	t.Run("Place Hold", func(t *testing.T) {
+		w := request(customerToken, "POST", "/payment/api/holds", structs.HoldRequest{
+			Account:      customerAccount.AccountID,
+			PayeeAccount: merchantAccount.AccountID,
+			Amount:       300,
+			Currency:     "USD",
+		})
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		data := response["data"].(map[string]interface{})
+		assert.Equal(t, string(models.HoldActive), data["status"])
+
+		held := account(customerAccount.AccountID)
+		assert.True(t, held.Balance.Equal(d("1000")))
+		assert.True(t, held.AvailableBalance.Equal(d("700")))
+
+		// the payee can see the hold, strangers cannot
+		w = request(merchantToken, "GET", "/payment/api/holds/"+data["hold_id"].(string), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// only the payee can capture
+		w = request(customerToken, "POST", "/payment/api/holds/"+data["hold_id"].(string)+"/capture", nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	// Test case 2: Held funds cannot be spent
+	t.Run("Debits Respect Holds", func(t *testing.T) {
+		_, err := service.ProcessInternalPayment(t.Context(), db, customerAccount.AccountID, merchantAccount.AccountID, d("800"), "USD")
+		assert.ErrorIs(t, err, service.ErrInsufficientBalance)
+
+		_, err = service.PlaceHold(t.Context(), db, customerAccount.AccountID, merchantAccount.AccountID, d("800"), "USD", 0, "")
+		assert.ErrorIs(t, err, service.ErrInsufficientBalance)
+	})
+
+	// Test case 3: A partial capture pays the payee and releases the rest
+	t.Run("Partial Capture", func(t *testing.T) {
+		hold, err := service.PlaceHold(t.Context(), db, customerAccount.AccountID, merchantAccount.AccountID, d("200"), "USD", time.Hour, "hotel deposit")
+		assert.NoError(t, err)
+
+		w := request(merchantToken, "POST", "/payment/api/holds/"+hold.HoldID+"/capture", structs.CaptureHoldRequest{Amount: 250})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = request(merchantToken, "POST", "/payment/api/holds/"+hold.HoldID+"/capture", structs.CaptureHoldRequest{Amount: 150})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		payment := response["response"].(map[string]interface{})
+		assert.Equal(t, string(models.HoldCapture), payment["type"])
+
+		customer := account(customerAccount.AccountID)
+		assert.True(t, customer.Balance.Equal(d("850")))
+		// the first hold of 300 is still active
+		assert.True(t, customer.AvailableBalance.Equal(d("550")))
+		assert.True(t, account(merchantAccount.AccountID).Balance.Equal(d("150")))
+		assertBalancedJournal(t, db, payment["payment_id"].(string))
+
+		// a hold is captured once
+		w = request(merchantToken, "POST", "/payment/api/holds/"+hold.HoldID+"/capture", nil)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	// Test case 4: Voiding releases the whole hold
+	t.Run("Void", func(t *testing.T) {
+		hold, err := service.PlaceHold(t.Context(), db, customerAccount.AccountID, merchantAccount.AccountID, d("100"), "USD", time.Hour, "")
+		assert.NoError(t, err)
+		assert.True(t, account(customerAccount.AccountID).AvailableBalance.Equal(d("450")))
+
+		w := request(customerToken, "POST", "/payment/api/holds/"+hold.HoldID+"/void", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, account(customerAccount.AccountID).AvailableBalance.Equal(d("550")))
+
+		_, _, err = service.CaptureHold(t.Context(), db, hold.HoldID, decimal.Zero)
+		assert.ErrorIs(t, err, models.ErrHoldNotActive)
+	})
+
+	// Test case 5: Holds past their expiry are released by the expirer
+	t.Run("Expiry", func(t *testing.T) {
+		hold, err := service.PlaceHold(t.Context(), db, customerAccount.AccountID, merchantAccount.AccountID, d("50"), "USD", time.Hour, "")
+		assert.NoError(t, err)
+		assert.NoError(t, db.Model(&models.Hold{}).Where("hold_id = ?", hold.HoldID).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+
+		_, _, err = service.CaptureHold(t.Context(), db, hold.HoldID, decimal.Zero)
+		assert.ErrorIs(t, err, service.ErrHoldExpired)
+
+		expired, err := service.ExpireHolds(t.Context(), db)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, expired)
+
+		expiredHold, err := models.FindHold(t.Context(), db, hold.HoldID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.HoldExpired, expiredHold.Status)
+		assert.True(t, account(customerAccount.AccountID).AvailableBalance.Equal(d("550")))
+	})
+}
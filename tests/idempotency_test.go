@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grey/controllers"
+	"github.com/grey/middlewares"
+	"github.com/grey/models"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test data
+	user := CreateTestUser(t, db)
+	fromAccount := CreateTestAccount(t, db, user.ID, 1000.0)
+	payee := CreateTestUserWithEmail(t, db, "payee@example.com")
+	toAccount := CreateTestAccount(t, db, payee.ID, 500.0)
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+	token := CreateTestJWT(t, user)
+
+	send := func(key string, amount float64) *httptest.ResponseRecorder {
+		payload := structs.InternalPaymentRequest{
+			FromAccount: fromAccount.AccountID,
+			ToAccount:   toAccount.AccountID,
+			Amount:      amount,
+			Currency:    "USD",
+		}
+
+		jsonPayload, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", "/payment/api/internal_payment", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
+		req.Header.Set("Idempotency-Key", key)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Replay Returns Original Response", func(t *testing.T) {
+		first := send("retry-key-1", 100.0)
+		assert.Equal(t, http.StatusOK, first.Code)
+
+		second := send("retry-key-1", 100.0)
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+		assert.JSONEq(t, first.Body.String(), second.Body.String())
+
+		// the money only moved once
+		account, err := models.IsAccountExists(t.Context(), db, fromAccount.AccountID)
+		assert.NoError(t, err)
+		assert.True(t, account.Balance.Equal(decimal.NewFromInt(900)), "balance is %s", account.Balance)
+	})
+
+	t.Run("Mismatched Body Is Rejected", func(t *testing.T) {
+		w := send("retry-key-1", 250.0)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+
+	t.Run("Different Keys Create Different Payments", func(t *testing.T) {
+		w := send("retry-key-2", 100.0)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	})
+
+	t.Run("Stale Reservation Is Reclaimed", func(t *testing.T) {
+		// a request that never finished, as when its instance died
+		first := send("retry-key-3", 100.0)
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.NoError(t, db.Model(&models.IdempotencyKey{}).Where("idempotency_key = ?", "retry-key-3").
+			Updates(map[string]interface{}{"response_status": 0, "response_body": ""}).Error)
+
+		w := send("retry-key-3", 100.0)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		assert.NoError(t, db.Model(&models.IdempotencyKey{}).Where("idempotency_key = ?", "retry-key-3").
+			UpdateColumn("updated_at", time.Now().Add(-2*middlewares.IdempotencyReservationTTL)).Error)
+		w = send("retry-key-3", 250.0)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		w = send("retry-key-3", 100.0)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+		w = send("retry-key-3", 100.0)
+		assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	})
+}
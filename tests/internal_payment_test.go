@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/grey/controllers"
 	"github.com/grey/structs"
 	"github.com/stretchr/testify/assert"
 )
@@ -22,11 +23,12 @@ func TestInternalPayment(t *testing.T) {
 	// Create test data
 	user := CreateTestUser(t, db)
 	fromAccount := CreateTestAccount(t, db, user.ID, 1000.0)
-	toAccount := CreateTestAccount(t, db, user.ID, 500.0)
+	payee := CreateTestUserWithEmail(t, db, "payee@example.com")
+	toAccount := CreateTestAccount(t, db, payee.ID, 500.0)
 
 	// Create test router
 	router := SetupTestRouterWithDB(db)
-	token := CreateTestJWT(t, user.Email)
+	token := CreateTestJWT(t, user)
 
 	// Test case 1: Successful internal payment
 	t.Run("Successful Internal Payment", func(t *testing.T) {
@@ -41,6 +43,7 @@ func TestInternalPayment(t *testing.T) {
 		req, _ := http.NewRequest("POST", "/payment/api/internal_payment", bytes.NewBuffer(jsonPayload))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
 
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
@@ -66,6 +69,7 @@ func TestInternalPayment(t *testing.T) {
 		req, _ := http.NewRequest("POST", "/payment/api/internal_payment", bytes.NewBuffer(jsonPayload))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
 
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
@@ -91,6 +95,7 @@ func TestInternalPayment(t *testing.T) {
 		req, _ := http.NewRequest("POST", "/payment/api/internal_payment", bytes.NewBuffer(jsonPayload))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
 
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
@@ -0,0 +1,199 @@
+package tests
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/grey/keys"
+	"github.com/grey/middlewares"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigningKeys(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	user := CreateTestUser(t, db)
+	router := SetupTestRouterWithDB(db)
+
+	original := keys.Default
+	defer func() { keys.Default = original }()
+
+	profile := func(token string) int {
+		req, _ := http.NewRequest("GET", "/user/api/profile", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	jwks := func() keys.JWKSet {
+		req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var set keys.JWKSet
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &set))
+		return set
+	}
+	kids := func(set keys.JWKSet) []string {
+		ids := []string{}
+		for _, key := range set.Keys {
+			ids = append(ids, key.ID)
+		}
+		return ids
+	}
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"authorized": true,
+			"user_id":    user.UserId,
+			"jti":        "00000000-0000-0000-0000-000000000001",
+			"exp":        time.Now().Add(time.Minute).Unix(),
+		}
+	}
+	generate := func(kid, algorithm string, activateAt time.Time) keys.Key {
+		key, err := keys.Generate(kid, algorithm, activateAt)
+		assert.NoError(t, err)
+		return key
+	}
+
+	// Test case 1: Tokens name their key and verify against the published set
+	t.Run("Sign And Publish", func(t *testing.T) {
+		rsaKey := generate("rsa-1", keys.RS256, time.Now().Add(-time.Hour))
+		keys.Default = keys.NewKeyring([]keys.Key{rsaKey}, time.Hour)
+
+		token := CreateTestJWT(t, user)
+		parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+		assert.NoError(t, err)
+		assert.Equal(t, "rsa-1", parsed.Header["kid"])
+		assert.Equal(t, keys.RS256, parsed.Header["alg"])
+		assert.Equal(t, http.StatusOK, profile(token))
+
+		set := jwks()
+		assert.Len(t, set.Keys, 1)
+		assert.Equal(t, "RSA", set.Keys[0].KeyType)
+		assert.Equal(t, "AQAB", set.Keys[0].Exponent)
+		assert.NotEmpty(t, set.Keys[0].Modulus)
+	})
+
+	// Test case 2: Only the algorithms of the keyring are accepted
+	t.Run("Strict Algorithms", func(t *testing.T) {
+		edKey := generate("ed-1", keys.EdDSA, time.Now().Add(-time.Hour))
+		rsaKey := generate("rsa-1", keys.RS256, time.Now().Add(-2*time.Hour))
+		keys.Default = keys.NewKeyring([]keys.Key{rsaKey, edKey}, time.Hour)
+
+		// HMAC signed with the public key bytes, the classic confusion attack
+		hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+		hmac.Header["kid"] = "ed-1"
+		token, err := hmac.SignedString([]byte(edKey.Public.(ed25519.PublicKey)))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, profile(token))
+
+		// unsigned tokens
+		none := jwt.NewWithClaims(jwt.SigningMethodNone, claims())
+		none.Header["kid"] = "ed-1"
+		token, err = none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, profile(token))
+
+		// RS256 token claiming the EdDSA key
+		mismatch := jwt.NewWithClaims(jwt.SigningMethodRS256, claims())
+		mismatch.Header["kid"] = "ed-1"
+		token, err = mismatch.SignedString(rsaKey.Private)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, profile(token))
+
+		// unknown kid
+		unknown := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims())
+		unknown.Header["kid"] = "ed-2"
+		token, err = unknown.SignedString(edKey.Private)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, profile(token))
+
+		// tokens without an expiry
+		eternal := claims()
+		delete(eternal, "exp")
+		token, err = keys.Default.Sign(eternal)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, profile(token))
+	})
+
+	// Test case 3: A replaced key verifies for the overlap window only
+	t.Run("Rotation Overlap", func(t *testing.T) {
+		oldKey := generate("old", keys.EdDSA, time.Now().Add(-48*time.Hour))
+		token, err := keys.NewKeyring([]keys.Key{oldKey}, time.Hour).Sign(claims())
+		assert.NoError(t, err)
+
+		// the new key took over ten minutes ago
+		newKey := generate("new", keys.EdDSA, time.Now().Add(-10*time.Minute))
+		nextKey := generate("next", keys.RS256, time.Now().Add(24*time.Hour))
+		keys.Default = keys.NewKeyring([]keys.Key{oldKey, newKey, nextKey}, 30*time.Minute)
+		assert.Equal(t, http.StatusOK, profile(token))
+
+		// tokens are signed by the new key, the next key is only published
+		issued := CreateTestJWT(t, user)
+		parsed, _, err := new(jwt.Parser).ParseUnverified(issued, jwt.MapClaims{})
+		assert.NoError(t, err)
+		assert.Equal(t, "new", parsed.Header["kid"])
+		assert.ElementsMatch(t, []string{"old", "new", "next"}, kids(jwks()))
+
+		// once the overlap has passed the old key is gone
+		newKey.ActivateAt = time.Now().Add(-time.Hour)
+		keys.Default = keys.NewKeyring([]keys.Key{oldKey, newKey, nextKey}, 30*time.Minute)
+		assert.Equal(t, http.StatusUnauthorized, profile(token))
+		assert.ElementsMatch(t, []string{"new", "next"}, kids(jwks()))
+	})
+
+	// Test case 4: Keys are loaded from a manifest of PEM files
+	t.Run("Keyring File", func(t *testing.T) {
+		dir := t.TempDir()
+		writeKey := func(name string, key keys.Key) {
+			der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+			assert.NoError(t, err)
+			raw := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, name), raw, 0o600))
+		}
+		writeKey("rsa.pem", generate("rsa", keys.RS256, time.Time{}))
+		writeKey("ed.pem", generate("ed", keys.EdDSA, time.Time{}))
+
+		manifest := `{
+			"overlap": "30m",
+			"keys": [
+				{"kid": "2026-01", "alg": "RS256", "private_key": "rsa.pem", "activate_at": "2026-01-01T00:00:00Z"},
+				{"kid": "2026-02", "alg": "EdDSA", "private_key": "ed.pem", "activate_at": "2026-02-01T00:00:00Z"}
+			]
+		}`
+		path := filepath.Join(dir, "keys.json")
+		assert.NoError(t, os.WriteFile(path, []byte(manifest), 0o600))
+
+		keyring, err := keys.NewFileKeyring(path)
+		assert.NoError(t, err)
+		keys.Default = keyring
+
+		token := CreateTestJWT(t, user)
+		payload, err := middlewares.ValidateSessionToken(token)
+		assert.NoError(t, err)
+		assert.Equal(t, user.UserId, payload.UserID)
+		assert.Equal(t, []string{"2026-02"}, kids(jwks()))
+
+		// a key declared with the wrong algorithm is refused
+		bad := `{"keys": [{"kid": "bad", "alg": "RS256", "private_key": "ed.pem", "activate_at": "2026-01-01T00:00:00Z"}]}`
+		badPath := filepath.Join(dir, "bad.json")
+		assert.NoError(t, os.WriteFile(badPath, []byte(bad), 0o600))
+		_, err = keys.NewFileKeyring(badPath)
+		assert.ErrorIs(t, err, keys.ErrAlgorithmMismatch)
+	})
+}
@@ -0,0 +1,359 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grey/controllers"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestLedgerJournals(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test data
+	user := CreateTestUser(t, db)
+	fromAccount := CreateTestAccount(t, db, user.ID, 1000.0)
+	payee := CreateTestUserWithEmail(t, db, "payee@example.com")
+	toAccount := CreateTestAccount(t, db, payee.ID, 500.0)
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+	token := CreateTestJWT(t, user)
+
+	post := func(path string, payload interface{}) map[string]interface{} {
+		jsonPayload, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		return response["response"].(map[string]interface{})
+	}
+
+	t.Run("Internal Payment Posts Balanced Journal", func(t *testing.T) {
+		response := post("/payment/api/internal_payment", structs.InternalPaymentRequest{
+			FromAccount: fromAccount.AccountID,
+			ToAccount:   toAccount.AccountID,
+			Amount:      100.0,
+			Currency:    "USD",
+		})
+
+		entries := assertBalancedJournal(t, db, response["payment_id"].(string))
+		assertEntry(t, entries, fromAccount.AccountID, models.Debit, "-100")
+		assertEntry(t, entries, toAccount.AccountID, models.Credit, "100")
+	})
+
+	t.Run("External Payment Posts Balanced Journal", func(t *testing.T) {
+		response := post("/payment/api/external_payment", structs.ExternalPaymentRequest{
+			Account:         fromAccount.AccountID,
+			Amount:          50.0,
+			Currency:        "USD",
+			TransactionType: "BANK_TRANSFER",
+			Recipient: structs.RecipientDetails{
+				RecipientNumber: "1234567890",
+				RecipientName:   "John Doe",
+			},
+		})
+
+		entries := assertBalancedJournal(t, db, response["payment_id"].(string))
+		assertEntry(t, entries, fromAccount.AccountID, models.Debit, "-50")
+		assertEntry(t, entries, models.SystemAccountID(models.ExternalClearing, "USD"), models.Credit, "50")
+	})
+
+	t.Run("Top Up Posts Balanced Journal", func(t *testing.T) {
+		response := post("/payment/api/topup", structs.TopUp{
+			Account:  fromAccount.AccountID,
+			Amount:   75.0,
+			Currency: "USD",
+		})
+
+		entries := assertBalancedJournal(t, db, response["payment_id"].(string))
+		assertEntry(t, entries, models.SystemAccountID(models.TopUpFunding, "USD"), models.Debit, "-75")
+		assertEntry(t, entries, fromAccount.AccountID, models.Credit, "75")
+	})
+
+	t.Run("Unbalanced Journal Is Rejected", func(t *testing.T) {
+		_, err := models.PostJournal(t.Context(), db, "payment-unbalanced",
+			models.DebitEntry(fromAccount.AccountID, decimal.NewFromInt(10), "USD"),
+			models.CreditEntry(toAccount.AccountID, decimal.NewFromInt(9), "USD"),
+		)
+		assert.ErrorIs(t, err, models.ErrUnbalancedJournal)
+	})
+}
+
+func TestOpeningBalanceBackfill(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Accounts made directly hold balances the ledger has never seen
+	user := CreateTestUser(t, db)
+	fromAccount := CreateTestAccount(t, db, user.ID, 1000.0)
+	payee := CreateTestUserWithEmail(t, db, "payee@example.com")
+	toAccount := CreateTestAccount(t, db, payee.ID, 250.0)
+	idle := CreateTestUserWithEmail(t, db, "idle@example.com")
+	emptyAccount := CreateTestAccount(t, db, idle.ID, 0.0)
+
+	payment, err := service.ProcessInternalPayment(t.Context(), db, fromAccount.AccountID, toAccount.AccountID, decimal.NewFromInt(300), "USD")
+	assert.NoError(t, err)
+
+	ledgerMatchesBalance := func(t *testing.T, accountID string) {
+		account, err := models.IsAccountExists(t.Context(), db, accountID)
+		assert.NoError(t, err)
+		total, err := models.LedgerTotal(t.Context(), db, accountID)
+		assert.NoError(t, err)
+		assert.True(t, total.Equal(account.Balance), "ledger %s, balance %s", total, account.Balance)
+	}
+
+	// Test case 1: Every account's ledger adds up to its balance afterwards
+	t.Run("Ledger Sum Equals Balance", func(t *testing.T) {
+		assert.NoError(t, service.MigrateLegacyLedger(t.Context(), db))
+
+		for _, accountID := range []string{fromAccount.AccountID, toAccount.AccountID, emptyAccount.AccountID} {
+			ledgerMatchesBalance(t, accountID)
+		}
+		ledgerMatchesBalance(t, models.SystemAccountID(models.OpeningEquity, "USD"))
+
+		var count int64
+		assert.NoError(t, db.Model(&models.Payment{}).Where("type = ?", models.OpeningBalance).Count(&count).Error)
+		assert.Equal(t, int64(2), count)
+	})
+
+	// Test case 2: The opening balance comes before the payments on the statement
+	t.Run("Statement Opening Balance", func(t *testing.T) {
+		entries, err := models.JournalEntries(t.Context(), db, payment.PaymentID)
+		assert.NoError(t, err)
+		opening, err := models.LedgerBalanceBefore(t.Context(), db, fromAccount.AccountID, entries[0].CreatedAt)
+		assert.NoError(t, err)
+		assert.Equal(t, "1000", opening.String())
+	})
+
+	// Test case 3: Running it again posts nothing
+	t.Run("Idempotent", func(t *testing.T) {
+		assert.NoError(t, service.MigrateLegacyLedger(t.Context(), db))
+
+		var count int64
+		assert.NoError(t, db.Model(&models.Payment{}).Where("type = ?", models.OpeningBalance).Count(&count).Error)
+		assert.Equal(t, int64(2), count)
+		ledgerMatchesBalance(t, fromAccount.AccountID)
+	})
+}
+
+func TestLegacyLedgerMigration(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Balances and payments as they were written before the ledger: the
+	// payer already paid 500 in, 200 to the payee and 100 out, the payee
+	// held 50 before any payment
+	user := CreateTestUser(t, db)
+	payer := CreateTestAccount(t, db, user.ID, 200.0)
+	payee := CreateTestAccount(t, db, CreateTestUserWithEmail(t, db, "payee@example.com").ID, 250.0)
+	opened := time.Now().Add(-time.Hour).Round(time.Second)
+	assert.NoError(t, db.Model(&models.Account{}).Where("account_id IN ?", []string{payer.AccountID, payee.AccountID}).Update("created_at", opened).Error)
+
+	legacyPayment := func(from, to string, amount int64, description string, at time.Duration, entryAmount int64) models.Payment {
+		payment := models.Payment{
+			FromAccount: from,
+			ToAccount:   to,
+			Currency:    "USD",
+			Amount:      decimal.NewFromInt(amount),
+			Status:      models.Completed,
+			Description: description,
+			CreatedAt:   opened.Add(at),
+		}
+		assert.NoError(t, db.Create(&payment).Error)
+		if entryAmount != 0 {
+			entry := models.LedgerEntry{
+				AccountID: from,
+				PaymentID: payment.PaymentID,
+				Amount:    decimal.NewFromInt(entryAmount),
+				CreatedAt: payment.CreatedAt,
+			}
+			assert.NoError(t, db.Omit("JournalID", "Direction", "Currency").Create(&entry).Error)
+		}
+		return payment
+	}
+	topUp := legacyPayment(payer.AccountID, payer.AccountID, 500, "Top up", time.Minute, 500)
+	transfer := legacyPayment(payer.AccountID, payee.AccountID, 200, "Internal Payment", 2*time.Minute, 200)
+	payout := legacyPayment(payer.AccountID, payer.AccountID, 100, "External Payment", 3*time.Minute, 0)
+
+	ledgerMatchesBalance := func(t *testing.T, accountID, want string) {
+		account, err := models.IsAccountExists(t.Context(), db, accountID)
+		assert.NoError(t, err)
+		total, err := models.LedgerTotal(t.Context(), db, accountID)
+		assert.NoError(t, err)
+		assert.Equal(t, want, account.Balance.String())
+		assert.True(t, total.Equal(account.Balance), "ledger %s, balance %s", total, account.Balance)
+	}
+
+	assert.NoError(t, service.MigrateLegacyLedger(t.Context(), db))
+
+	// Test case 1: Every legacy payment is a balanced journal
+	t.Run("Legacy Payments Are Balanced", func(t *testing.T) {
+		entries := assertBalancedJournal(t, db, topUp.PaymentID)
+		assertEntry(t, entries, models.SystemAccountID(models.TopUpFunding, "USD"), models.Debit, "-500")
+		assertEntry(t, entries, payer.AccountID, models.Credit, "500")
+
+		entries = assertBalancedJournal(t, db, transfer.PaymentID)
+		assertEntry(t, entries, payer.AccountID, models.Debit, "-200")
+		assertEntry(t, entries, payee.AccountID, models.Credit, "200")
+
+		entries = assertBalancedJournal(t, db, payout.PaymentID)
+		assertEntry(t, entries, payer.AccountID, models.Debit, "-100")
+		assertEntry(t, entries, models.SystemAccountID(models.ExternalClearing, "USD"), models.Credit, "100")
+
+		var legacy int64
+		assert.NoError(t, db.Model(&models.LedgerEntry{}).Where("journal_id IS NULL OR direction = ''").Count(&legacy).Error)
+		assert.Equal(t, int64(0), legacy)
+	})
+
+	// Test case 2: Ledgers add up to balances, only the payee needs an opening balance
+	t.Run("Ledger Sum Equals Balance", func(t *testing.T) {
+		ledgerMatchesBalance(t, payer.AccountID, "200")
+		ledgerMatchesBalance(t, payee.AccountID, "250")
+		ledgerMatchesBalance(t, models.SystemAccountID(models.TopUpFunding, "USD"), "-500")
+		ledgerMatchesBalance(t, models.SystemAccountID(models.ExternalClearing, "USD"), "100")
+		ledgerMatchesBalance(t, models.SystemAccountID(models.OpeningEquity, "USD"), "-50")
+
+		var count int64
+		assert.NoError(t, db.Model(&models.Payment{}).Where("type = ? AND to_account = ?", models.OpeningBalance, payee.AccountID).Count(&count).Error)
+		assert.Equal(t, int64(1), count)
+	})
+
+	// Test case 3: Entry ids follow entry dates, so running balances and
+	// statements agree
+	t.Run("Ids Follow Dates", func(t *testing.T) {
+		var entries []models.LedgerEntry
+		assert.NoError(t, db.Order("id").Find(&entries).Error)
+		for i := 1; i < len(entries); i++ {
+			assert.False(t, entries[i].CreatedAt.Before(entries[i-1].CreatedAt), "entry %d is dated before entry %d", entries[i].ID, entries[i-1].ID)
+		}
+
+		for _, entry := range entries {
+			if entry.AccountID != payee.AccountID {
+				continue
+			}
+			running, err := models.LedgerBalance(t.Context(), db, payee.AccountID, entry.ID)
+			assert.NoError(t, err)
+			statement, err := models.LedgerBalanceBefore(t.Context(), db, payee.AccountID, entry.CreatedAt.Add(time.Second))
+			assert.NoError(t, err)
+			assert.True(t, running.Equal(statement), "running %s, statement %s", running, statement)
+		}
+	})
+
+	// Test case 4: Running it again posts nothing
+	t.Run("Idempotent", func(t *testing.T) {
+		var before, after int64
+		assert.NoError(t, db.Model(&models.LedgerEntry{}).Count(&before).Error)
+		assert.NoError(t, service.MigrateLegacyLedger(t.Context(), db))
+		assert.NoError(t, db.Model(&models.LedgerEntry{}).Count(&after).Error)
+		assert.Equal(t, before, after)
+		ledgerMatchesBalance(t, payer.AccountID, "200")
+	})
+}
+
+func TestSystemAccountCreation(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Test case 1: Concurrent first uses all resolve the one account
+	t.Run("Concurrent First Use", func(t *testing.T) {
+		sqlDB, _ := db.DB()
+		sqlDB.SetMaxOpenConns(1)
+
+		results := make(chan error, 10)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				accountID, err := models.EnsureSystemAccount(t.Context(), db, models.FeeRevenue, "KES")
+				if err == nil && accountID != models.SystemAccountID(models.FeeRevenue, "KES") {
+					err = fmt.Errorf("resolved %s", accountID)
+				}
+				results <- err
+			}()
+		}
+		wg.Wait()
+		close(results)
+		for err := range results {
+			assert.NoError(t, err)
+		}
+
+		var count int64
+		assert.NoError(t, db.Model(&models.Account{}).Where("account_id = ?", models.SystemAccountID(models.FeeRevenue, "KES")).Count(&count).Error)
+		assert.Equal(t, int64(1), count)
+	})
+
+	// Test case 2: A second account with the same id is refused
+	t.Run("Account Id Is Unique", func(t *testing.T) {
+		duplicate := models.Account{
+			AccountID: models.SystemAccountID(models.FeeRevenue, "KES"),
+			Currency:  "KES",
+		}
+		assert.Error(t, db.Create(&duplicate).Error)
+	})
+}
+
+func assertBalancedJournal(t *testing.T, db *gorm.DB, paymentID string) []models.LedgerEntry {
+	entries, err := models.JournalEntries(t.Context(), db, paymentID)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, len(entries), 2)
+
+	totals := map[string]decimal.Decimal{}
+	for _, entry := range entries {
+		assert.Equal(t, entries[0].JournalID, entry.JournalID)
+		totals[entry.Currency] = totals[entry.Currency].Add(entry.Amount)
+	}
+	for currency, total := range totals {
+		assert.True(t, total.IsZero(), "journal for %s sums to %s %s", paymentID, total, currency)
+	}
+	return entries
+}
+
+func assertEntry(t *testing.T, entries []models.LedgerEntry, accountID string, direction models.LedgerDirection, amount string) {
+	for _, entry := range entries {
+		if entry.AccountID == accountID {
+			assert.Equal(t, direction, entry.Direction)
+			assert.True(t, entry.Amount.Equal(decimal.RequireFromString(amount)), "got %s want %s", entry.Amount, amount)
+			return
+		}
+	}
+	t.Errorf("no ledger entry for account %s", accountID)
+}
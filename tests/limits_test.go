@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grey/controllers"
+	"github.com/grey/limits"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{
+		"1": {
+			"INTERNAL": {
+				"amounts": {"USD": {"per_transaction": "500", "daily": "800", "monthly": "1000"}},
+				"velocity": [{"count": 3, "window": "1h"}]
+			}
+		}
+	}`), 0o600))
+
+	policy, err := limits.LoadPolicy(path)
+	assert.NoError(t, err)
+	rule := policy[1][models.InternalTransfer]
+	assert.Equal(t, "800", rule.Amounts["USD"].Daily.String())
+	assert.Equal(t, time.Hour, time.Duration(rule.Velocity[0].Window))
+
+	usage := limits.Usage{Daily: decimal.NewFromInt(700), Monthly: decimal.NewFromInt(700), Counts: map[time.Duration]int64{}}
+	var limitErr *limits.Error
+
+	err = rule.Check(models.InternalTransfer, decimal.NewFromInt(501), "USD", usage)
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, limits.PerTransactionCode, limitErr.Code)
+
+	err = rule.Check(models.InternalTransfer, decimal.NewFromInt(150), "USD", usage)
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, limits.DailyCode, limitErr.Code)
+	assert.Equal(t, "100", limitErr.Remaining.String())
+
+	// other currencies are not capped, but still count for velocity
+	usage.Counts[time.Hour] = 3
+	err = rule.Check(models.InternalTransfer, decimal.NewFromInt(5000), "EUR", usage)
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, limits.VelocityCode, limitErr.Code)
+}
+
+func TestTransactionLimits(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	limits.Default.SetPolicy(limits.Policy{
+		1: {
+			models.InternalTransfer: {
+				Amounts:  map[string]limits.AmountLimit{"USD": {PerTransaction: decimal.NewFromInt(500), Daily: decimal.NewFromInt(800)}},
+				Velocity: []limits.Velocity{{Count: 3, Window: limits.Duration(time.Hour)}},
+			},
+			models.TopUpTransaction: {
+				Amounts: map[string]limits.AmountLimit{"USD": {Monthly: decimal.NewFromInt(100)}},
+			},
+		},
+	})
+	defer limits.Default.SetPolicy(nil)
+
+	// Create test data
+	user := CreateTestUser(t, db)
+	fromAccount := CreateTestAccount(t, db, user.ID, 2000.0)
+	payee := CreateTestUserWithEmail(t, db, "payee@example.com")
+	toAccount := CreateTestAccount(t, db, payee.ID, 0.0)
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+	token := CreateTestJWT(t, user)
+
+	pay := func(amount float64) (*httptest.ResponseRecorder, map[string]interface{}) {
+		jsonPayload, _ := json.Marshal(structs.InternalPaymentRequest{
+			FromAccount: fromAccount.AccountID,
+			ToAccount:   toAccount.AccountID,
+			Amount:      amount,
+			Currency:    "USD",
+		})
+		req, _ := http.NewRequest("POST", "/payment/api/internal_payment", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	// Test case 1: Per-transaction and daily limits answer with their code
+	t.Run("Amount Limits", func(t *testing.T) {
+		w, response := pay(600)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, string(limits.PerTransactionCode), response["code"])
+
+		w, _ = pay(500)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w, response = pay(350)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, string(limits.DailyCode), response["code"])
+		assert.Equal(t, "300", response["remaining"])
+
+		w, _ = pay(300)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	// Test case 2: Remaining limits reflect today's payments
+	t.Run("Remaining Limits", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/payment/api/limits", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		data := response["data"].(map[string]interface{})
+		assert.Equal(t, float64(1), data["kyc_tier"])
+
+		statuses := data["limits"].([]interface{})
+		assert.Len(t, statuses, 2)
+		internal := statuses[0].(map[string]interface{})
+		assert.Equal(t, "INTERNAL", internal["transaction_type"])
+		amounts := internal["amounts"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, "500", amounts["per_transaction"])
+		daily := amounts["daily"].(map[string]interface{})
+		assert.Equal(t, "800", daily["used"])
+		assert.Equal(t, "0", daily["remaining"])
+		assert.Nil(t, amounts["monthly"])
+		velocity := internal["velocity"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, float64(1), velocity["remaining"])
+	})
+
+	// Test case 3: Velocity counts payments of any amount
+	t.Run("Velocity", func(t *testing.T) {
+		limits.Default.SetPolicy(limits.Policy{
+			1: {
+				models.InternalTransfer: {Velocity: []limits.Velocity{{Count: 3, Window: limits.Duration(time.Hour)}}},
+				models.TopUpTransaction: {Amounts: map[string]limits.AmountLimit{"USD": {Monthly: decimal.NewFromInt(100)}}},
+			},
+		})
+
+		w, _ := pay(1)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w, response := pay(1)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, string(limits.VelocityCode), response["code"])
+	})
+
+	// Test case 4: Limits follow the KYC tier and apply to every payment path
+	t.Run("Tiers", func(t *testing.T) {
+		_, err := service.TopUpProcess(t.Context(), db, fromAccount.AccountID, decimal.NewFromInt(150), "USD")
+		assert.ErrorIs(t, err, limits.ErrLimitExceeded)
+
+		assert.NoError(t, db.Model(&models.User{}).Where("id = ?", user.ID).Update("kyc_tier", 2).Error)
+		_, err = service.TopUpProcess(t.Context(), db, fromAccount.AccountID, decimal.NewFromInt(150), "USD")
+		assert.NoError(t, err)
+		w, _ := pay(1)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	// Test case 5: Open holds count towards the capture limits
+	t.Run("Open Holds", func(t *testing.T) {
+		limits.Default.SetPolicy(limits.Policy{
+			2: {
+				models.HoldCapture: {
+					Amounts:  map[string]limits.AmountLimit{"USD": {Daily: decimal.NewFromInt(300)}},
+					Velocity: []limits.Velocity{{Count: 3, Window: limits.Duration(time.Hour)}},
+				},
+			},
+		})
+
+		_, err := service.PlaceHold(t.Context(), db, fromAccount.AccountID, toAccount.AccountID, decimal.NewFromInt(200), "USD", 0, "")
+		assert.NoError(t, err)
+		_, err = service.PlaceHold(t.Context(), db, fromAccount.AccountID, toAccount.AccountID, decimal.NewFromInt(200), "USD", 0, "")
+		var limitErr *limits.Error
+		assert.ErrorAs(t, err, &limitErr)
+		assert.Equal(t, limits.DailyCode, limitErr.Code)
+
+		_, err = service.PlaceHold(t.Context(), db, fromAccount.AccountID, toAccount.AccountID, decimal.NewFromInt(50), "USD", 0, "")
+		assert.NoError(t, err)
+		_, err = service.PlaceHold(t.Context(), db, fromAccount.AccountID, toAccount.AccountID, decimal.NewFromInt(10), "USD", 0, "")
+		assert.NoError(t, err)
+		_, err = service.PlaceHold(t.Context(), db, fromAccount.AccountID, toAccount.AccountID, decimal.NewFromInt(10), "USD", 0, "")
+		assert.ErrorAs(t, err, &limitErr)
+		assert.Equal(t, limits.VelocityCode, limitErr.Code)
+	})
+}
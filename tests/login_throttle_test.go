@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grey/models"
+	"github.com/grey/structs"
+	"github.com/grey/throttle"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+
+	// a guard on a clock the test moves by hand
+	now := time.Now()
+	ipPolicy := throttle.DefaultIPPolicy
+	ipPolicy.MaxAttempts = 8
+	guard := throttle.NewGuard(throttle.NewMemoryStore(), throttle.DefaultEmailPolicy, ipPolicy)
+	guard.Now = func() time.Time { return now }
+	throttle.Default = guard
+
+	login := func(ip string, credentials structs.User) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(credentials)
+		req, _ := http.NewRequest("POST", "/user/api/login", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = ip + ":1234"
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	credentials := structs.User{Email: "throttle@example.com", Password: "correct-horse"}
+	jsonPayload, _ := json.Marshal(credentials)
+	req, _ := http.NewRequest("POST", "/user/api/register", bytes.NewBuffer(jsonPayload))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	wrong := structs.User{Email: credentials.Email, Password: "wrong-horse"}
+
+	// Test case 1: An unknown email and a wrong password get the same answer
+	t.Run("Uniform Error", func(t *testing.T) {
+		unknown := login("198.51.100.1", structs.User{Email: "nobody@example.com", Password: "correct-horse"})
+		invalid := login("198.51.100.1", wrong)
+		assert.Equal(t, http.StatusBadRequest, unknown.Code)
+		assert.Equal(t, unknown.Code, invalid.Code)
+		assert.Equal(t, unknown.Body.String(), invalid.Body.String())
+
+		// both are in the audit, the wrong password with its user
+		var attempts []models.LoginAttempt
+		assert.NoError(t, db.Order("id").Find(&attempts).Error)
+		assert.Len(t, attempts, 2)
+		assert.Equal(t, models.LoginUnknownEmail, attempts[0].Reason)
+		assert.Nil(t, attempts[0].UserID)
+		assert.Equal(t, models.LoginWrongPassword, attempts[1].Reason)
+		assert.NotNil(t, attempts[1].UserID)
+		assert.Equal(t, "198.51.100.1", attempts[1].IP)
+	})
+
+	// Test case 2: Each failure makes the next attempt wait longer
+	t.Run("Progressive Delay", func(t *testing.T) {
+		// one failure so far, so a second has to pass
+		w := login("198.51.100.2", credentials)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+		now = now.Add(time.Second)
+		w = login("198.51.100.2", wrong)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = login("198.51.100.2", wrong)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+		// a successful login clears the count
+		now = now.Add(2 * time.Second)
+		w = login("198.51.100.2", credentials)
+		assert.Equal(t, http.StatusOK, w.Code)
+		w = login("198.51.100.2", wrong)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = login("198.51.100.2", credentials)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	})
+
+	// Test case 3: Too many failures lock the email, even for the right password
+	t.Run("Lockout", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		w := login("198.51.100.3", credentials)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		for i := 0; i < throttle.DefaultEmailPolicy.MaxAttempts; i++ {
+			now = now.Add(time.Minute)
+			w = login("198.51.100.3", wrong)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		}
+
+		w = login("198.51.100.3", credentials)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "900", w.Header().Get("Retry-After"))
+		w = login("198.51.100.4", credentials)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		var throttled int64
+		assert.NoError(t, db.Model(&models.LoginAttempt{}).Where("reason = ?", models.LoginThrottled).Count(&throttled).Error)
+		assert.NotZero(t, throttled)
+
+		now = now.Add(throttle.DefaultEmailPolicy.Lockout)
+		w = login("198.51.100.4", credentials)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	// Test case 4: One address guessing over many emails is locked out too
+	t.Run("Per IP", func(t *testing.T) {
+		for i := 0; i < ipPolicy.MaxAttempts; i++ {
+			w := login("203.0.113.9", structs.User{Email: "guess" + string(rune('a'+i)) + "@example.com", Password: "password"})
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		}
+
+		w := login("203.0.113.9", credentials)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		// other addresses are not affected
+		w = login("203.0.113.10", credentials)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	// Test case 5: Attempts still in flight count before they are reported
+	t.Run("Concurrent Attempts", func(t *testing.T) {
+		ctx := context.Background()
+		now = now.Add(time.Hour)
+
+		first, err := guard.Check(ctx, credentials.Email, "192.0.2.50")
+		assert.NoError(t, err)
+		assert.True(t, first.Allowed)
+		second, err := guard.Check(ctx, credentials.Email, "192.0.2.51")
+		assert.NoError(t, err)
+		assert.False(t, second.Allowed)
+		assert.Equal(t, time.Second, second.RetryAfter)
+
+		// once reported the failure delays the next attempt as usual
+		assert.NoError(t, guard.Fail(ctx, credentials.Email, "192.0.2.50"))
+		second, err = guard.Check(ctx, credentials.Email, "192.0.2.51")
+		assert.NoError(t, err)
+		assert.False(t, second.Allowed)
+		now = now.Add(time.Second)
+		second, err = guard.Check(ctx, credentials.Email, "192.0.2.51")
+		assert.NoError(t, err)
+		assert.True(t, second.Allowed)
+		assert.NoError(t, guard.Succeed(ctx, credentials.Email, "192.0.2.51"))
+
+		// pending guesses over many emails reach the limit of the address
+		for i := 0; i < ipPolicy.MaxAttempts; i++ {
+			decision, err := guard.Check(ctx, "pending"+string(rune('a'+i))+"@example.com", "192.0.2.52")
+			assert.NoError(t, err)
+			assert.True(t, decision.Allowed)
+		}
+		decision, err := guard.Check(ctx, credentials.Email, "192.0.2.52")
+		assert.NoError(t, err)
+		assert.False(t, decision.Allowed)
+
+		// the email was not reserved by the refused attempt
+		decision, err = guard.Check(ctx, credentials.Email, "192.0.2.53")
+		assert.NoError(t, err)
+		assert.True(t, decision.Allowed)
+		assert.NoError(t, guard.Release(ctx, credentials.Email, "192.0.2.53"))
+	})
+}
@@ -0,0 +1,314 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grey/controllers"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/grey/totp"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTwoFactorAuthentication(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+
+	request := func(token, method, path string, payload interface{}, headers ...string) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	data := func(w *httptest.ResponseRecorder) map[string]interface{} {
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		payload, _ := response["data"].(map[string]interface{})
+		return payload
+	}
+
+	credentials := structs.User{Email: "mfa@example.com", Password: "correct-horse"}
+	w := request("", "POST", "/user/api/register", credentials)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = request("", "POST", "/user/api/login", credentials)
+	assert.Equal(t, http.StatusOK, w.Code)
+	token := data(w)["token"].(string)
+
+	// codes for the steps around now; each step can be used once
+	var secret string
+	code := func(offset int) string {
+		value, err := totp.Code(secret, time.Now().Add(time.Duration(offset)*totp.Period))
+		assert.NoError(t, err)
+		return value
+	}
+	var recoveryCodes []interface{}
+
+	// Test case 1: Enrollment is only enforced once confirmed with a code
+	t.Run("Enroll", func(t *testing.T) {
+		w := request(token, "POST", "/user/api/mfa/enroll", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		enrollment := data(w)
+		secret = enrollment["secret"].(string)
+		assert.Contains(t, enrollment["uri"], "otpauth://totp/")
+
+		w = request(token, "POST", "/user/api/mfa/confirm", structs.MFACodeRequest{Code: "000000"})
+		if code(-1) != "000000" {
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+
+		w = request(token, "POST", "/user/api/mfa/confirm", structs.MFACodeRequest{Code: code(-1)})
+		assert.Equal(t, http.StatusOK, w.Code)
+		recoveryCodes = data(w)["recovery_codes"].([]interface{})
+		assert.Len(t, recoveryCodes, service.RecoveryCodeCount)
+
+		w = request(token, "POST", "/user/api/mfa/enroll", nil)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	login := func() string {
+		w := request("", "POST", "/user/api/login", credentials)
+		assert.Equal(t, http.StatusOK, w.Code)
+		pending := data(w)
+		assert.Equal(t, true, pending["mfa_required"])
+		assert.Nil(t, pending["token"])
+		return pending["mfa_token"].(string)
+	}
+
+	// Test case 2: Login issues an mfa_pending token until the code is verified
+	t.Run("Two Step Login", func(t *testing.T) {
+		pending := login()
+
+		// the pending token is not a session, and a session is not a pending token
+		w := request(pending, "GET", "/user/api/profile", nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		w = request(token, "POST", "/user/api/login/mfa", structs.MFACodeRequest{Code: code(0)})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		// the code used to confirm the enrollment cannot be replayed
+		w = request(pending, "POST", "/user/api/login/mfa", structs.MFACodeRequest{Code: code(-1)})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = request(pending, "POST", "/user/api/login/mfa", structs.MFACodeRequest{Code: code(0)})
+		assert.Equal(t, http.StatusOK, w.Code)
+		session := data(w)["token"].(string)
+
+		w = request(session, "GET", "/user/api/profile", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// the pending token was spent
+		w = request(pending, "POST", "/user/api/login/mfa", structs.MFACodeRequest{Code: code(1)})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	// Test case 3: Recovery codes stand in for the authenticator once each
+	t.Run("Recovery Code", func(t *testing.T) {
+		recovery := recoveryCodes[0].(string)
+
+		w := request(login(), "POST", "/user/api/login/mfa", structs.MFACodeRequest{Code: recovery})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = request(login(), "POST", "/user/api/login/mfa", structs.MFACodeRequest{Code: recovery})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	// Test case 4: Too many wrong codes lock the second factor
+	t.Run("Too Many Attempts", func(t *testing.T) {
+		// the reused recovery code above was the first wrong code, a new
+		// password login does not clear it
+		pending := login()
+		for i := 2; i < service.MaxMFAAttempts; i++ {
+			w := request(pending, "POST", "/user/api/login/mfa", structs.MFACodeRequest{Code: "wrong-code"})
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+		w := request(pending, "POST", "/user/api/login/mfa", structs.MFACodeRequest{Code: "wrong-code"})
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		w = request(pending, "POST", "/user/api/login/mfa", structs.MFACodeRequest{Code: recoveryCodes[1].(string)})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		// logging in again with the password does not lift the lock
+		w = request(login(), "POST", "/user/api/login/mfa", structs.MFACodeRequest{Code: recoveryCodes[1].(string)})
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		// nor does it clear wrong codes made before the lock
+		past := time.Now().Add(-time.Second)
+		assert.NoError(t, db.Model(&models.User{}).Where("email = ?", credentials.Email).Updates(map[string]interface{}{"mfa_locked_until": past, "mfa_failures": service.MaxMFAAttempts - 1}).Error)
+		w = request(login(), "POST", "/user/api/login/mfa", structs.MFACodeRequest{Code: "wrong-code"})
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		// once the lock has run out a correct code works again
+		assert.NoError(t, db.Model(&models.User{}).Where("email = ?", credentials.Email).Update("mfa_locked_until", past).Error)
+		w = request(login(), "POST", "/user/api/login/mfa", structs.MFACodeRequest{Code: recoveryCodes[1].(string)})
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	// Test case 5: Payments above the step-up amount need a code
+	t.Run("Step Up", func(t *testing.T) {
+		service.StepUpAmounts = map[string]decimal.Decimal{"USD": decimal.NewFromInt(100)}
+		defer func() { service.StepUpAmounts = map[string]decimal.Decimal{} }()
+
+		user, err := models.IsEmailExists(t.Context(), db, credentials.Email)
+		assert.NoError(t, err)
+		// registration opened the user's account
+		fromAccount, err := models.FindUserAccount(t.Context(), db, user.ID, "USD")
+		assert.NoError(t, err)
+		assert.NoError(t, models.UpdateAccountBalance(t.Context(), db, fromAccount.AccountID, decimal.NewFromInt(1000)))
+		payee := CreateTestUserWithEmail(t, db, "payee@example.com")
+		toAccount := CreateTestAccount(t, db, payee.ID, 0.0)
+		payment := func(amount float64) structs.InternalPaymentRequest {
+			return structs.InternalPaymentRequest{
+				FromAccount: fromAccount.AccountID,
+				ToAccount:   toAccount.AccountID,
+				Amount:      amount,
+				Currency:    "USD",
+			}
+		}
+
+		w := request(token, "POST", "/user/api/pin", structs.SetPINRequest{Password: credentials.Password, PIN: TestPIN})
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		w = request(token, "POST", "/payment/api/internal_payment", payment(100), controllers.PINHeader, TestPIN)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = request(token, "POST", "/payment/api/internal_payment", payment(500), controllers.PINHeader, TestPIN)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), "MFA_REQUIRED")
+
+		w = request(token, "POST", "/payment/api/internal_payment", payment(500), controllers.PINHeader, TestPIN, controllers.StepUpHeader, recoveryCodes[2].(string))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// schedules and holds are approved the same way
+		w = request(token, "POST", "/payment/api/schedules", structs.ScheduleRequest{
+			TransactionType: "INTERNAL",
+			FromAccount:     fromAccount.AccountID,
+			ToAccount:       toAccount.AccountID,
+			Amount:          500,
+			Frequency:       "daily",
+		}, controllers.PINHeader, TestPIN)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), "MFA_REQUIRED")
+
+		w = request(token, "POST", "/payment/api/holds", structs.HoldRequest{
+			Account:      fromAccount.AccountID,
+			PayeeAccount: toAccount.AccountID,
+			Amount:       500,
+		}, controllers.PINHeader, TestPIN)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		// users without a second factor cannot make such payments
+		other := CreateTestUser(t, db)
+		otherAccount := CreateTestAccount(t, db, other.ID, 1000.0)
+		w = request(CreateTestJWT(t, other), "POST", "/payment/api/internal_payment", structs.InternalPaymentRequest{
+			FromAccount: otherAccount.AccountID,
+			ToAccount:   toAccount.AccountID,
+			Amount:      500,
+			Currency:    "USD",
+		}, controllers.PINHeader, TestPIN)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	// Test case 6: Codes guessed in parallel are all counted against the same lockout
+	t.Run("Parallel Guesses", func(t *testing.T) {
+		// the in-memory database locks out concurrent writers, so the
+		// requests take turns on one connection between their statements
+		sqlDB, _ := db.DB()
+		sqlDB.SetMaxOpenConns(1)
+
+		// every request loaded the user before any guess was counted
+		loaded, err := models.IsEmailExists(t.Context(), db, credentials.Email)
+		assert.NoError(t, err)
+
+		guesses := 3 * service.MaxMFAAttempts
+		results := make(chan error, guesses)
+		var wg sync.WaitGroup
+		for i := 0; i < guesses; i++ {
+			wg.Add(1)
+			user := *loaded
+			go func() {
+				defer wg.Done()
+				results <- service.VerifyMFA(t.Context(), db, &user, "000000")
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		invalid := 0
+		for err := range results {
+			if errors.Is(err, service.ErrInvalidMFACode) {
+				invalid++
+			} else {
+				assert.ErrorIs(t, err, service.ErrTooManyMFAAttempts)
+			}
+		}
+		assert.Less(t, invalid, service.MaxMFAAttempts)
+
+		// a correct code does not lift the lock the guesses set
+		loaded, err = models.IsEmailExists(t.Context(), db, credentials.Email)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, service.VerifyMFA(t.Context(), db, loaded, recoveryCodes[3].(string)), service.ErrTooManyMFAAttempts)
+
+		past := time.Now().Add(-time.Second)
+		assert.NoError(t, db.Model(&models.User{}).Where("email = ?", credentials.Email).Update("mfa_locked_until", past).Error)
+	})
+
+	// Test case 7: Disabling needs a code
+	t.Run("Disable", func(t *testing.T) {
+		w := request(token, "POST", "/user/api/mfa/disable", structs.MFACodeRequest{Code: recoveryCodes[0].(string)})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = request(token, "POST", "/user/api/mfa/disable", structs.MFACodeRequest{Code: recoveryCodes[3].(string)})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = request("", "POST", "/user/api/login", credentials)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotEmpty(t, data(w)["token"])
+	})
+}
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1 seed "12345678901234567890"
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range cases {
+		code, err := totp.Code(secret, time.Unix(unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, want, code)
+	}
+
+	now := time.Unix(1234567890, 0)
+	step, ok := totp.Verify(secret, "005924", now, 0)
+	assert.True(t, ok)
+	_, ok = totp.Verify(secret, "005924", now, step)
+	assert.False(t, ok)
+}
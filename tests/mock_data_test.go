@@ -41,6 +41,8 @@ func (md *MockDataGenerator) GenerateTestUsers(t *testing.T, count int) []*model
 	return users
 }
 
+var mockCurrencies = []string{"USD", "EUR", "GBP"}
+
 // GenerateTestAccounts creates multiple test accounts with different balances
 func (md *MockDataGenerator) GenerateTestAccounts(t *testing.T, userID int, count int, balances []float64) []*models.Account {
 	accounts := make([]*models.Account, count)
@@ -51,9 +53,10 @@ func (md *MockDataGenerator) GenerateTestAccounts(t *testing.T, userID int, coun
 			balance = balances[i]
 		}
 
+		// a user holds one account per currency
 		account := &models.Account{
 			UserID:   userID,
-			Currency: "USD",
+			Currency: mockCurrencies[i%len(mockCurrencies)],
 			Balance:  decimal.NewFromFloat(balance),
 		}
 
@@ -145,7 +148,7 @@ func TestMockDataGeneration(t *testing.T) {
 		for i, account := range accounts {
 			assert.NotZero(t, account.ID)
 			assert.Equal(t, user.ID, account.UserID)
-			assert.Equal(t, "USD", account.Currency)
+			assert.Equal(t, mockCurrencies[i], account.Currency)
 			assert.True(t, account.Balance.Equal(decimal.NewFromFloat(balances[i])))
 		}
 	})
@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/grey/controllers"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiCurrencyAccounts(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test data
+	user := CreateTestUser(t, db)
+	usdAccount := CreateTestAccount(t, db, user.ID, 1000.0)
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+	token := CreateTestJWT(t, user)
+
+	request := func(method, path string, payload interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+		jsonPayload, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	var eurAccountID string
+
+	// Test case 1: Open an account in another currency
+	t.Run("Open Account", func(t *testing.T) {
+		w, response := request("POST", "/payment/api/accounts", structs.OpenAccountRequest{Currency: "EUR"})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		data := response["data"].(map[string]interface{})
+		assert.Equal(t, "EUR", data["currency"])
+		eurAccountID = data["account_id"].(string)
+
+		w, _ = request("POST", "/payment/api/accounts", structs.OpenAccountRequest{Currency: "EUR"})
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w, _ = request("POST", "/payment/api/accounts", structs.OpenAccountRequest{Currency: "XYZ"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w, response = request("GET", "/payment/api/accounts", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Len(t, response["data"], 2)
+	})
+
+	// Test case 2: Payment currency must match both accounts
+	t.Run("Currency Mismatch", func(t *testing.T) {
+		w, _ := request("POST", "/payment/api/internal_payment", structs.InternalPaymentRequest{
+			FromAccount: usdAccount.AccountID,
+			ToAccount:   eurAccountID,
+			Amount:      100.0,
+			Currency:    "USD",
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w, _ = request("POST", "/payment/api/topup", structs.TopUp{
+			Account:  eurAccountID,
+			Amount:   100.0,
+			Currency: "USD",
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		account, err := models.IsAccountExists(t.Context(), db, usdAccount.AccountID)
+		assert.NoError(t, err)
+		assert.True(t, account.Balance.Equal(decimal.NewFromInt(1000)))
+	})
+
+	// Test case 3: Top up in the account's own currency
+	t.Run("Top Up In Account Currency", func(t *testing.T) {
+		w, _ := request("POST", "/payment/api/topup", structs.TopUp{
+			Account:  eurAccountID,
+			Amount:   250.0,
+			Currency: "EUR",
+		})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		account, err := models.IsAccountExists(t.Context(), db, eurAccountID)
+		assert.NoError(t, err)
+		assert.True(t, account.Balance.Equal(decimal.NewFromInt(250)))
+	})
+
+	// Test case 4: Amounts are limited to the currency's minor units
+	t.Run("Minor Units", func(t *testing.T) {
+		jpyAccount, err := service.OpenAccount(t.Context(), db, user.ID, "JPY")
+		assert.NoError(t, err)
+
+		_, err = service.TopUpProcess(t.Context(), db, jpyAccount.AccountID, decimal.RequireFromString("100.50"), "JPY")
+		assert.ErrorIs(t, err, service.ErrInvalidAmount)
+
+		_, err = service.TopUpProcess(t.Context(), db, usdAccount.AccountID, decimal.RequireFromString("0.001"), "USD")
+		assert.ErrorIs(t, err, service.ErrInvalidAmount)
+
+		_, err = service.TopUpProcess(t.Context(), db, jpyAccount.AccountID, decimal.NewFromInt(500), "JPY")
+		assert.NoError(t, err)
+	})
+
+	// Test case 5: Concurrent requests open one account per currency
+	t.Run("Concurrent Open", func(t *testing.T) {
+		sqlDB, _ := db.DB()
+		sqlDB.SetMaxOpenConns(1)
+
+		results := make(chan error, 5)
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := service.OpenAccount(t.Context(), db, user.ID, "GBP")
+				results <- err
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		opened := 0
+		for err := range results {
+			if err == nil {
+				opened++
+			} else {
+				assert.ErrorIs(t, err, service.ErrAccountExists)
+			}
+		}
+		assert.Equal(t, 1, opened)
+
+		// the index refuses a second account even without the service
+		duplicate := models.Account{UserID: user.ID, Currency: "GBP"}
+		assert.Error(t, db.Create(&duplicate).Error)
+	})
+}
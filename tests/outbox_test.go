@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSink struct {
+	events []models.OutboxEvent
+	err    error
+}
+
+func (s *recordingSink) Name() string {
+	return "recording"
+}
+
+func (s *recordingSink) Handle(ctx context.Context, event models.OutboxEvent) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestOutbox(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test data
+	user := CreateTestUser(t, db)
+	fromAccount := CreateTestAccount(t, db, user.ID, 1000.0)
+	payee := CreateTestUserWithEmail(t, db, "payee@example.com")
+	toAccount := CreateTestAccount(t, db, payee.ID, 0.0)
+
+	payment, err := service.ProcessInternalPayment(t.Context(), db, fromAccount.AccountID, toAccount.AccountID, decimal.NewFromInt(100), "USD")
+	assert.NoError(t, err)
+
+	t.Run("Payment Writes Events", func(t *testing.T) {
+		var events []models.OutboxEvent
+		err := db.Order("id").Find(&events).Error
+		assert.NoError(t, err)
+
+		var types []string
+		for _, event := range events {
+			types = append(types, event.EventType)
+			assert.Equal(t, models.OutboxPending, event.Status)
+		}
+		assert.Equal(t, []string{models.PaymentCreatedEvent, models.AccountCreditedEvent, models.PaymentCompletedEvent}, types)
+		assert.Equal(t, payment.PaymentID, events[0].AggregateID)
+		assert.Equal(t, toAccount.AccountID, events[1].AggregateID)
+	})
+
+	t.Run("Failing Sink Schedules Retry", func(t *testing.T) {
+		sink := &recordingSink{err: errors.New("sink unavailable")}
+		dispatcher := service.NewOutboxDispatcher(db, sink)
+
+		dispatched, err := dispatcher.DispatchOnce(t.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, dispatched)
+
+		var event models.OutboxEvent
+		err = db.Order("id").First(&event).Error
+		assert.NoError(t, err)
+		assert.Equal(t, models.OutboxPending, event.Status)
+		assert.Equal(t, 1, event.Attempts)
+		assert.Contains(t, event.LastError, "sink unavailable")
+
+		// events are not due again until the backoff has passed
+		dispatched, err = dispatcher.DispatchOnce(t.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, dispatched)
+	})
+
+	t.Run("Dispatch Delivers Due Events", func(t *testing.T) {
+		err := db.Model(&models.OutboxEvent{}).Where("1 = 1").Update("next_attempt_at", payment.CreatedAt).Error
+		assert.NoError(t, err)
+
+		sink := &recordingSink{}
+		dispatcher := service.NewOutboxDispatcher(db, sink)
+
+		dispatched, err := dispatcher.DispatchOnce(t.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, 3, dispatched)
+		assert.Len(t, sink.events, 3)
+
+		var pending int64
+		db.Model(&models.OutboxEvent{}).Where("status = ?", models.OutboxPending).Count(&pending)
+		assert.Zero(t, pending)
+	})
+
+	t.Run("Failed Payment Publishes PaymentFailed", func(t *testing.T) {
+		_, err := service.ProcessInternalPayment(t.Context(), db, fromAccount.AccountID, toAccount.AccountID, decimal.NewFromInt(5000), "USD")
+		assert.ErrorIs(t, err, service.ErrInsufficientBalance)
+
+		var count int64
+		db.Model(&models.OutboxEvent{}).Where("event_type = ?", models.PaymentFailedEvent).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+}
@@ -0,0 +1,213 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/grey/throttle"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordChangeAndReset(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+
+	// failed attempts delay the next one, so the test moves the clock
+	now := time.Now()
+	throttle.Default.Now = func() time.Time { return now }
+
+	request := func(token, method, path string, payload interface{}) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "192.0.2.10:1234"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	credentials := structs.User{Email: "password@example.com", Password: "correct-horse"}
+	w := request("", "POST", "/user/api/register", credentials)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	type tokens struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	login := func() tokens {
+		w := request("", "POST", "/user/api/login", credentials)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Data tokens `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Data
+	}
+	// the token is the paragraph after the greeting of the reset email
+	resetToken := func() string {
+		message, ok := Mailbox.Last(credentials.Email)
+		assert.True(t, ok)
+		paragraphs := strings.Split(message.Body, "\n\n")
+		assert.Len(t, paragraphs, 3)
+		return paragraphs[1]
+	}
+	// moves the reset emails sent so far back in time
+	backdate := func(age time.Duration) {
+		assert.NoError(t, db.Model(&models.UserToken{}).Where("purpose = ?", models.PasswordResetToken).
+			Update("created_at", time.Now().Add(-age)).Error)
+	}
+
+	// reset emails go out through the outbox
+	dispatcher := service.NewOutboxDispatcher(db, service.NewPasswordResetSink(db))
+	dispatch := func() {
+		_, err := dispatcher.DispatchOnce(t.Context())
+		assert.NoError(t, err)
+	}
+	forgot := func(email string) *httptest.ResponseRecorder {
+		w := request("", "POST", "/user/api/password/forgot", structs.ForgotPasswordRequest{Email: email})
+		dispatch()
+		return w
+	}
+
+	// Test case 1: Changing the password takes the current one and logs out other sessions
+	t.Run("Change Password", func(t *testing.T) {
+		current, other := login(), login()
+
+		// wrong passwords count as failed logins and delay the next try
+		w := request(current.Token, "POST", "/user/api/password/change", structs.ChangePasswordRequest{CurrentPassword: "wrong-horse", Password: "battery-staple"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		w = request(current.Token, "POST", "/user/api/password/change", structs.ChangePasswordRequest{CurrentPassword: credentials.Password, Password: "battery-staple"})
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		now = now.Add(time.Second)
+
+		w = request(current.Token, "POST", "/user/api/password/change", structs.ChangePasswordRequest{CurrentPassword: credentials.Password, Password: "short"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = request(current.Token, "POST", "/user/api/password/change", structs.ChangePasswordRequest{CurrentPassword: credentials.Password, Password: "battery-staple"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		credentials.Password = "battery-staple"
+
+		w = request(current.Token, "GET", "/user/api/profile", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		w = request(other.Token, "GET", "/user/api/profile", nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		w = request("", "POST", "/user/api/refresh", structs.RefreshRequest{RefreshToken: other.RefreshToken})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		login()
+	})
+
+	// Test case 2: Asking for a reset does not tell whether the email is registered
+	t.Run("Forgot Password", func(t *testing.T) {
+		unknown := request("", "POST", "/user/api/password/forgot", structs.ForgotPasswordRequest{Email: "nobody@example.com"})
+		known := request("", "POST", "/user/api/password/forgot", structs.ForgotPasswordRequest{Email: credentials.Email})
+		assert.Equal(t, http.StatusOK, unknown.Code)
+		assert.Equal(t, unknown.Body.String(), known.Body.String())
+
+		// both requests only queue the email, the same work either way
+		var queued, issued int64
+		assert.NoError(t, db.Model(&models.OutboxEvent{}).Where("event_type = ?", models.PasswordResetRequestedEvent).Count(&queued).Error)
+		assert.Equal(t, int64(2), queued)
+		assert.NoError(t, db.Model(&models.UserToken{}).Where("purpose = ?", models.PasswordResetToken).Count(&issued).Error)
+		assert.Equal(t, int64(0), issued)
+		dispatch()
+
+		_, sent := Mailbox.Last("nobody@example.com")
+		assert.False(t, sent)
+		assert.NotEmpty(t, resetToken())
+	})
+
+	// Test case 3: A reset token works once and logs out every session
+	t.Run("Reset Password", func(t *testing.T) {
+		session := login()
+		first := resetToken()
+		backdate(2 * service.PasswordResetInterval)
+		w := forgot(credentials.Email)
+		assert.Equal(t, http.StatusOK, w.Code)
+		token := resetToken()
+
+		// only the latest token sent works
+		w = request("", "POST", "/user/api/password/reset", structs.ResetPasswordRequest{Token: first, Password: "new-password"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = request("", "POST", "/user/api/password/reset", structs.ResetPasswordRequest{Token: "not-a-token", Password: "new-password"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = request("", "POST", "/user/api/password/reset", structs.ResetPasswordRequest{Token: token, Password: "new-password"})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = request(session.Token, "GET", "/user/api/profile", nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		w = request("", "POST", "/user/api/login", credentials)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = request("", "POST", "/user/api/password/reset", structs.ResetPasswordRequest{Token: token, Password: "another-password"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		credentials.Password = "new-password"
+		now = now.Add(time.Second)
+		login()
+	})
+
+	// Test case 4: Reset tokens expire
+	t.Run("Expired Token", func(t *testing.T) {
+		backdate(2 * service.PasswordResetInterval)
+		w := forgot(credentials.Email)
+		assert.Equal(t, http.StatusOK, w.Code)
+		token := resetToken()
+
+		past := time.Now().Add(-time.Minute)
+		assert.NoError(t, db.Model(&models.UserToken{}).Where("used_at IS NULL").Update("expires_at", past).Error)
+
+		w = request("", "POST", "/user/api/password/reset", structs.ResetPasswordRequest{Token: token, Password: "expired-password"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	// Test case 5: Reset emails are rate limited per account and per client IP without telling
+	t.Run("Rate Limited", func(t *testing.T) {
+		backdate(2 * service.PasswordResetInterval)
+		w := forgot(credentials.Email)
+		assert.Equal(t, http.StatusOK, w.Code)
+		token := resetToken()
+
+		// a second request within the interval answers the same but sends nothing
+		limited := forgot(credentials.Email)
+		assert.Equal(t, http.StatusOK, limited.Code)
+		assert.Equal(t, w.Body.String(), limited.Body.String())
+		assert.Equal(t, token, resetToken())
+
+		// the client IP asked for too many resets of other accounts
+		backdate(2 * service.PasswordResetInterval)
+		for i := 0; i < service.MaxPasswordResetIPEmails; i++ {
+			assert.NoError(t, db.Create(&models.UserToken{
+				UserID:    1000 + i,
+				Purpose:   models.PasswordResetToken,
+				TokenHash: fmt.Sprintf("ip-limit-%d", i),
+				RequestIP: "192.0.2.10",
+				ExpiresAt: time.Now().Add(time.Hour),
+			}).Error)
+		}
+		limited = forgot(credentials.Email)
+		assert.Equal(t, http.StatusOK, limited.Code)
+		assert.Equal(t, token, resetToken())
+	})
+}
@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentStatusTransitions(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test data
+	user := CreateTestUser(t, db)
+	fromAccount := CreateTestAccount(t, db, user.ID, 100.0)
+	payee := CreateTestUserWithEmail(t, db, "payee@example.com")
+	toAccount := CreateTestAccount(t, db, payee.ID, 0.0)
+
+	t.Run("Allowed Transitions", func(t *testing.T) {
+		assert.True(t, models.CanTransition(models.Pending, models.Processing))
+		assert.True(t, models.CanTransition(models.Processing, models.Completed))
+		assert.True(t, models.CanTransition(models.Processing, models.Failed))
+		assert.True(t, models.CanTransition(models.Completed, models.Reversed))
+	})
+
+	t.Run("Illegal Transitions", func(t *testing.T) {
+		assert.False(t, models.CanTransition(models.Pending, models.Completed))
+		assert.False(t, models.CanTransition(models.Failed, models.Completed))
+		assert.False(t, models.CanTransition(models.Completed, models.Pending))
+		assert.False(t, models.CanTransition(models.Reversed, models.Completed))
+
+		payment := &models.Payment{
+			FromAccount: fromAccount.AccountID,
+			ToAccount:   toAccount.AccountID,
+			Currency:    "USD",
+			Amount:      decimal.NewFromInt(1),
+		}
+		assert.NoError(t, models.CreatePayment(t.Context(), db, payment))
+
+		err := payment.TransitionTo(t.Context(), db, models.Completed, "skipping processing")
+		assert.ErrorIs(t, err, models.ErrInvalidTransition)
+		assert.Equal(t, models.Pending, payment.Status)
+	})
+
+	t.Run("Completed Payment Records History", func(t *testing.T) {
+		payment, err := service.ProcessInternalPayment(t.Context(), db, fromAccount.AccountID, toAccount.AccountID, decimal.NewFromInt(40), "USD")
+		assert.NoError(t, err)
+		assert.Equal(t, models.Completed, payment.Status)
+
+		history, err := models.PaymentHistory(t.Context(), db, payment.PaymentID)
+		assert.NoError(t, err)
+		if assert.Len(t, history, 3) {
+			assert.Equal(t, models.Pending, history[0].ToStatus)
+			assert.Equal(t, models.Processing, history[1].ToStatus)
+			assert.Equal(t, models.Completed, history[2].ToStatus)
+			assert.Equal(t, models.Processing, history[2].FromStatus)
+		}
+	})
+
+	t.Run("Insufficient Balance Records Failure", func(t *testing.T) {
+		_, err := service.ProcessInternalPayment(t.Context(), db, fromAccount.AccountID, toAccount.AccountID, decimal.NewFromInt(1000), "USD")
+		assert.ErrorIs(t, err, service.ErrInsufficientBalance)
+
+		var payment models.Payment
+		err = db.Where("from_account = ? AND amount = ?", fromAccount.AccountID, decimal.NewFromInt(1000)).First(&payment).Error
+		assert.NoError(t, err)
+		assert.Equal(t, models.Failed, payment.Status)
+	})
+}
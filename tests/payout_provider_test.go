@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grey/controllers"
+	"github.com/grey/models"
+	"github.com/grey/providers"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayoutProviders(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test data
+	user := CreateTestUser(t, db)
+	fromAccount := CreateTestAccount(t, db, user.ID, 1000.0)
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+	token := CreateTestJWT(t, user)
+
+	send := func(transactionType string, amount float64) (*httptest.ResponseRecorder, map[string]interface{}) {
+		payload := structs.ExternalPaymentRequest{
+			Account:         fromAccount.AccountID,
+			Amount:          amount,
+			Currency:        "USD",
+			TransactionType: transactionType,
+			Recipient: structs.RecipientDetails{
+				RecipientNumber: "0771234567",
+				RecipientName:   "Jane Smith",
+			},
+		}
+
+		jsonPayload, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", "/payment/api/external_payment", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		return w, response
+	}
+
+	balance := func() decimal.Decimal {
+		account, err := models.IsAccountExists(t.Context(), db, fromAccount.AccountID)
+		assert.NoError(t, err)
+		return account.Balance
+	}
+
+	t.Run("Registry Resolves Transaction Types", func(t *testing.T) {
+		provider, err := providers.Lookup(models.MobileMoney)
+		assert.NoError(t, err)
+		assert.Equal(t, "mobile_money_simulator", provider.Name())
+
+		_, err = providers.Lookup("CARD")
+		assert.ErrorIs(t, err, providers.ErrUnknownProvider)
+	})
+
+	t.Run("Declined Payout Releases Funds", func(t *testing.T) {
+		BankSimulator.SetOutcome(providers.PayoutFailed)
+		defer BankSimulator.SetOutcome(providers.PayoutSucceeded)
+
+		w, response := send("BANK_TRANSFER", 200.0)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.True(t, balance().Equal(decimal.NewFromInt(1000)))
+
+		responseData := response["response"].(map[string]interface{})
+		payment, err := models.FindPayment(t.Context(), db, responseData["payment_id"].(string))
+		assert.NoError(t, err)
+		assert.Equal(t, models.Failed, payment.Status)
+		assert.Equal(t, "bank_simulator", payment.Provider)
+	})
+
+	t.Run("Pending Payout Keeps Payment Processing", func(t *testing.T) {
+		MobileMoneySimulator.SetOutcome(providers.PayoutPending)
+		defer MobileMoneySimulator.SetOutcome(providers.PayoutSucceeded)
+
+		w, response := send("MOBILE_MONEY", 150.0)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, balance().Equal(decimal.NewFromInt(850)))
+
+		responseData := response["response"].(map[string]interface{})
+		assert.Equal(t, "pending", responseData["provider_status"])
+
+		payment, err := models.FindPayment(t.Context(), db, responseData["payment_id"].(string))
+		assert.NoError(t, err)
+		assert.Equal(t, models.Processing, payment.Status)
+		assert.Equal(t, models.MobileMoney, payment.Type)
+
+		result, err := MobileMoneySimulator.QueryStatus(t.Context(), payment.ProviderReference)
+		assert.NoError(t, err)
+		assert.Equal(t, providers.PayoutPending, result.Status)
+
+		result, err = MobileMoneySimulator.Cancel(t.Context(), payment.ProviderReference)
+		assert.NoError(t, err)
+		assert.Equal(t, providers.PayoutCancelled, result.Status)
+	})
+
+	t.Run("Lost Submission Is Reconciled By Reference", func(t *testing.T) {
+		// as if the reconciler runs a while after the payment
+		reconcile := func(paymentID string) *models.Payment {
+			assert.NoError(t, db.Model(&models.Payment{}).Where("payment_id = ?", paymentID).
+				Update("updated_at", time.Now().Add(-time.Hour)).Error)
+			assert.NoError(t, service.ReconcilePayouts(t.Context(), db, time.Minute))
+			payment, err := models.FindPayment(t.Context(), db, paymentID)
+			assert.NoError(t, err)
+			return payment
+		}
+		before := balance()
+
+		// the provider never got the payout: the funds are held until it says so
+		BankSimulator.FailSubmit(errors.New("connection reset"), false)
+		w, response := send("BANK_TRANSFER", 100.0)
+		assert.Equal(t, http.StatusOK, w.Code)
+		responseData := response["response"].(map[string]interface{})
+		assert.Equal(t, "pending", responseData["provider_status"])
+		paymentID := responseData["payment_id"].(string)
+
+		payment, err := models.FindPayment(t.Context(), db, paymentID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Processing, payment.Status)
+		assert.Empty(t, payment.ProviderReference)
+		assert.True(t, balance().Equal(before.Sub(decimal.NewFromInt(100))))
+
+		payment = reconcile(paymentID)
+		assert.Equal(t, models.Failed, payment.Status)
+		assert.True(t, balance().Equal(before))
+
+		// the provider took the payout but the answer was lost
+		BankSimulator.FailSubmit(context.DeadlineExceeded, true)
+		w, response = send("BANK_TRANSFER", 100.0)
+		assert.Equal(t, http.StatusOK, w.Code)
+		paymentID = response["response"].(map[string]interface{})["payment_id"].(string)
+
+		payment = reconcile(paymentID)
+		assert.Equal(t, models.Completed, payment.Status)
+		assert.NotEmpty(t, payment.ProviderReference)
+		assert.True(t, balance().Equal(before.Sub(decimal.NewFromInt(100))))
+	})
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grey/models"
+	"github.com/grey/providers"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayoutWebhooks(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test data
+	user := CreateTestUser(t, db)
+	fromAccount := CreateTestAccount(t, db, user.ID, 1000.0)
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+
+	recipient := structs.RecipientDetails{RecipientNumber: "1234567890", RecipientName: "John Doe"}
+
+	payout := func(amount int64) models.Payment {
+		response, err := service.ProcessExternalPayment(t.Context(), db, BankSimulator, models.BankTransfer, recipient, fromAccount.AccountID, decimal.NewFromInt(amount), "USD")
+		assert.NoError(t, err)
+		assert.Equal(t, "pending", response.Status)
+
+		payment, err := models.FindPayment(t.Context(), db, response.PaymentID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Processing, payment.Status)
+		return *payment
+	}
+
+	webhook := func(payment models.Payment, status providers.PayoutStatus, signature string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(providers.SimulatorWebhook{
+			ProviderReference: payment.ProviderReference,
+			Status:            status,
+		})
+		if signature == "" {
+			signature = BankSimulator.SignWebhook(body)
+		}
+
+		req, _ := http.NewRequest("POST", "/payment/api/webhooks/bank_simulator", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(providers.SimulatorSignatureHeader, signature)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	balance := func() decimal.Decimal {
+		account, err := models.IsAccountExists(t.Context(), db, fromAccount.AccountID)
+		assert.NoError(t, err)
+		return account.Balance
+	}
+
+	// Test case 1: Funds are held until the provider reports success
+	t.Run("Successful Payout Completes Payment", func(t *testing.T) {
+		payment := payout(200)
+		assert.True(t, balance().Equal(decimal.NewFromInt(800)))
+
+		w := webhook(payment, providers.PayoutSucceeded, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		settled, err := models.FindPayment(t.Context(), db, payment.PaymentID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Completed, settled.Status)
+		assert.True(t, balance().Equal(decimal.NewFromInt(800)))
+
+		// a duplicate delivery changes nothing
+		w = webhook(payment, providers.PayoutFailed, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, balance().Equal(decimal.NewFromInt(800)))
+	})
+
+	// Test case 2: Failed payout gives the money back
+	t.Run("Failed Payout Releases Funds", func(t *testing.T) {
+		payment := payout(300)
+		assert.True(t, balance().Equal(decimal.NewFromInt(500)))
+
+		w := webhook(payment, providers.PayoutFailed, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		settled, err := models.FindPayment(t.Context(), db, payment.PaymentID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Failed, settled.Status)
+		assert.True(t, balance().Equal(decimal.NewFromInt(800)))
+
+		entries, err := models.JournalEntries(t.Context(), db, payment.PaymentID)
+		assert.NoError(t, err)
+		total := decimal.Zero
+		for _, entry := range entries {
+			total = total.Add(entry.Amount)
+		}
+		assert.Len(t, entries, 4)
+		assert.True(t, total.IsZero())
+	})
+
+	// Test case 3: Forged webhooks are rejected
+	t.Run("Invalid Signature", func(t *testing.T) {
+		payment := payout(100)
+
+		w := webhook(payment, providers.PayoutFailed, "deadbeef")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		unchanged, err := models.FindPayment(t.Context(), db, payment.PaymentID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Processing, unchanged.Status)
+	})
+
+	// Test case 4: Reconciliation settles payouts without a webhook
+	t.Run("Reconcile Payouts", func(t *testing.T) {
+		payment := payout(50)
+
+		err := service.ReconcilePayouts(t.Context(), db, -1)
+		assert.NoError(t, err)
+
+		settled, err := models.FindPayment(t.Context(), db, payment.PaymentID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Completed, settled.Status)
+	})
+}
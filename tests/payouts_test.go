@@ -0,0 +1,207 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grey/controllers"
+	"github.com/grey/models"
+	"github.com/grey/providers"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestPayoutBatches(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// the in-memory database locks out concurrent writers, so the workers
+	// take turns on one connection
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+
+	// Create test data
+	user := CreateTestUser(t, db)
+	account := CreateTestAccount(t, db, user.ID, 1000.0)
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+	token := CreateTestJWT(t, user)
+
+	upload := func(filename, content string, fields map[string]string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for name, value := range fields {
+			writer.WriteField(name, value)
+		}
+		part, _ := writer.CreateFormFile("file", filename)
+		part.Write([]byte(content))
+		writer.Close()
+
+		req, _ := http.NewRequest("POST", "/payment/api/payouts/batches", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	available := func() decimal.Decimal {
+		account, err := models.IsAccountExists(t.Context(), db, account.AccountID)
+		assert.NoError(t, err)
+		return account.AvailableBalance
+	}
+
+	fields := map[string]string{"from_account": account.AccountID, "transaction_type": "MOBILE_MONEY"}
+
+	// Test case 1: Every invalid row is reported and nothing is reserved
+	t.Run("Validation", func(t *testing.T) {
+		w, response := upload("payroll.csv", "recipient_number,recipient_name,amount\n0241234567,Ama,100\n,Kofi,50\n0201234567,Esi,abc\n", fields)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		errors := response["errors"].([]interface{})
+		assert.Len(t, errors, 2)
+		assert.Equal(t, float64(2), errors[0].(map[string]interface{})["line"])
+		assert.Equal(t, float64(3), errors[1].(map[string]interface{})["line"])
+		assert.True(t, available().Equal(decimal.NewFromInt(1000)))
+
+		w, _ = upload("payroll.csv", "name,amount\nAma,100\n", fields)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		// the batch total must be covered up front
+		w, _ = upload("payroll.csv", "recipient_number,amount\n0241234567,600\n0201234567,600\n", fields)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	// Test case 2: A CSV batch reserves its total and pays every row
+	t.Run("CSV Batch", func(t *testing.T) {
+		BankSimulator.SetOutcome(providers.PayoutFailed)
+		defer BankSimulator.SetOutcome(providers.PayoutSucceeded)
+
+		content := "recipient_number,recipient_name,amount,transaction_type,reference\n" +
+			"0241234567,Ama,100,,salary-1\n" +
+			"0201234567,Kofi,150,,salary-2\n" +
+			"1234567890,Esi,50,BANK_TRANSFER,salary-3\n" +
+			"0551234567,Yaw,200,,salary-4\n"
+		w, response := upload("payroll.csv", content, fields)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		data := response["data"].(map[string]interface{})
+		batchID := data["batch_id"].(string)
+		assert.Equal(t, "500", data["total_amount"])
+		assert.True(t, available().Equal(decimal.NewFromInt(500)))
+
+		batch, err := models.FindPayoutBatch(t.Context(), db, batchID)
+		assert.NoError(t, err)
+		assert.NoError(t, service.ProcessPayoutBatch(t.Context(), db, &batch, 3))
+		assert.Equal(t, models.BatchCompletedWithErrors, batch.Status)
+		assert.Equal(t, 3, batch.Succeeded)
+		assert.Equal(t, 1, batch.Failed)
+
+		// the declined bank transfer is given back and nothing stays held
+		paid, err := models.IsAccountExists(t.Context(), db, account.AccountID)
+		assert.NoError(t, err)
+		assert.True(t, paid.Balance.Equal(decimal.NewFromInt(550)))
+		assert.True(t, paid.HeldBalance.IsZero())
+
+		w = get("/payment/api/payouts/batches/" + batchID)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var status map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		items := status["items"].([]interface{})
+		assert.Len(t, items, 4)
+		declined := items[2].(map[string]interface{})
+		assert.Equal(t, string(models.PayoutItemFailed), declined["status"])
+		assert.Equal(t, service.ErrPayoutFailed.Error(), declined["error"])
+
+		w = get("/payment/api/payouts/batches/" + batchID + "/results")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+		records, err := csv.NewReader(w.Body).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, records, 5)
+		assert.Equal(t, "salary-1", records[1][4])
+		assert.Equal(t, string(models.PayoutItemSucceeded), records[1][7])
+		assert.NotEmpty(t, records[1][8])
+	})
+
+	// Test case 3: JSON batches go through the same path
+	t.Run("JSON Batch", func(t *testing.T) {
+		jsonPayload, _ := json.Marshal(structs.PayoutBatchRequest{
+			FromAccount:     account.AccountID,
+			TransactionType: "MOBILE_MONEY",
+			Items: []structs.PayoutItemRequest{
+				{Recipient: structs.RecipientDetails{RecipientNumber: "0241234567", RecipientName: "Ama"}, Amount: 25.5},
+			},
+		})
+		req, _ := http.NewRequest("POST", "/payment/api/payouts/batches", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+
+		assert.NoError(t, service.ProcessPayoutBatches(t.Context(), db, 2))
+		paid, err := models.IsAccountExists(t.Context(), db, account.AccountID)
+		assert.NoError(t, err)
+		assert.True(t, paid.Balance.Equal(decimal.RequireFromString("524.5")))
+		assert.True(t, paid.HeldBalance.IsZero())
+	})
+
+	// Test case 4: A payout whose submission is not recorded keeps its funds in clearing only once
+	t.Run("Unrecorded Submission", func(t *testing.T) {
+		// another hold on the account must survive the batch
+		assert.NoError(t, db.Model(&models.Account{}).Where("account_id = ?", account.AccountID).
+			Update("held_balance", gorm.Expr("held_balance + ?", 100)).Error)
+		failRecording := func(tx *gorm.DB) {
+			if update, ok := tx.Statement.Dest.(map[string]interface{}); ok {
+				if _, ok := update["provider_reference"]; ok {
+					tx.AddError(errors.New("connection lost"))
+				}
+			}
+		}
+		assert.NoError(t, db.Callback().Update().Before("gorm:update").Register("test:fail_recording", failRecording))
+
+		w, response := upload("payroll.csv", "recipient_number,amount\n0241234567,40\n", fields)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		batch, err := models.FindPayoutBatch(t.Context(), db, response["data"].(map[string]interface{})["batch_id"].(string))
+		assert.NoError(t, err)
+		assert.NoError(t, service.ProcessPayoutBatch(t.Context(), db, &batch, 1))
+		assert.NoError(t, db.Callback().Update().Remove("test:fail_recording"))
+		assert.Equal(t, 1, batch.Succeeded)
+
+		paid, err := models.IsAccountExists(t.Context(), db, account.AccountID)
+		assert.NoError(t, err)
+		assert.True(t, paid.Balance.Equal(decimal.RequireFromString("484.5")))
+		assert.True(t, paid.HeldBalance.Equal(decimal.NewFromInt(100)))
+
+		var payment models.Payment
+		assert.NoError(t, db.Where("from_account = ?", account.AccountID).Order("id DESC").First(&payment).Error)
+		assert.Equal(t, models.Processing, payment.Status)
+		assert.Empty(t, payment.ProviderReference)
+	})
+}
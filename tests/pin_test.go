@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grey/controllers"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionPIN(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+
+	request := func(token, pin, method, path string, payload interface{}) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		if pin != "" {
+			req.Header.Set(controllers.PINHeader, pin)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	body := func(w *httptest.ResponseRecorder) map[string]interface{} {
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	credentials := structs.User{Email: "pin@example.com", Password: "correct-horse"}
+	w := request("", "", "POST", "/user/api/register", credentials)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	user, err := models.IsEmailExists(t.Context(), db, credentials.Email)
+	assert.NoError(t, err)
+	token := CreateTestJWT(t, user)
+
+	// registration opened the user's account
+	fromAccount, err := models.FindUserAccount(t.Context(), db, user.ID, "USD")
+	assert.NoError(t, err)
+	assert.NoError(t, models.UpdateAccountBalance(t.Context(), db, fromAccount.AccountID, decimal.NewFromInt(1000)))
+	payee := CreateTestUserWithEmail(t, db, "payee@example.com")
+	toAccount := CreateTestAccount(t, db, payee.ID, 0.0)
+	pay := func(pin string) *httptest.ResponseRecorder {
+		return request(token, pin, "POST", "/payment/api/internal_payment", structs.InternalPaymentRequest{
+			FromAccount: fromAccount.AccountID,
+			ToAccount:   toAccount.AccountID,
+			Amount:      10,
+			Currency:    "USD",
+		})
+	}
+
+	// Test case 1: Payments need a PIN to be set first
+	t.Run("PIN Not Set", func(t *testing.T) {
+		w := pay("2580")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, "PIN_NOT_SET", body(w)["code"])
+	})
+
+	// Test case 2: Setting the PIN takes the password and refuses weak PINs
+	t.Run("Set PIN", func(t *testing.T) {
+		for _, weak := range []string{"123", "1234567", "12a4", "1111", "1234", "98765"} {
+			w := request(token, "", "POST", "/user/api/pin", structs.SetPINRequest{Password: credentials.Password, PIN: weak})
+			assert.Equal(t, http.StatusBadRequest, w.Code, weak)
+		}
+
+		w := request(token, "", "POST", "/user/api/pin", structs.SetPINRequest{Password: "wrong", PIN: "2580"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = request(token, "", "POST", "/user/api/pin", structs.SetPINRequest{Password: credentials.Password, PIN: "2580"})
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		w = request(token, "", "POST", "/user/api/pin", structs.SetPINRequest{Password: credentials.Password, PIN: "4826"})
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w = pay("2580")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	// Test case 3: Wrong PINs lock payments until the lock runs out
+	t.Run("Lockout", func(t *testing.T) {
+		w := pay("")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, "PIN_INCORRECT", body(w)["code"])
+		assert.Equal(t, float64(service.MaxPINAttempts-1), body(w)["attempts_remaining"])
+
+		// a correct PIN resets the count
+		w = pay("2580")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		for i := 1; i < service.MaxPINAttempts; i++ {
+			w = pay("0000")
+			assert.Equal(t, http.StatusForbidden, w.Code)
+		}
+		w = pay("0000")
+		assert.Equal(t, http.StatusLocked, w.Code)
+		assert.NotEmpty(t, body(w)["locked_until"])
+
+		// the right PIN does not help while locked, for top-ups either
+		w = pay("2580")
+		assert.Equal(t, http.StatusLocked, w.Code)
+		w = request(token, "2580", "POST", "/payment/api/topup", structs.TopUp{Account: fromAccount.AccountID, Amount: 10, Currency: "USD"})
+		assert.Equal(t, http.StatusLocked, w.Code)
+
+		// once the lock has run out the PIN works again
+		past := time.Now().Add(-time.Second)
+		assert.NoError(t, db.Model(&models.User{}).Where("id = ?", user.ID).Update("pin_locked_until", past).Error)
+		w = pay("2580")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	// Test case 4: Changing the PIN takes the current one
+	t.Run("Change PIN", func(t *testing.T) {
+		w := request(token, "", "PUT", "/user/api/pin", structs.ChangePINRequest{CurrentPIN: "0000", PIN: "4826"})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = request(token, "", "PUT", "/user/api/pin", structs.ChangePINRequest{CurrentPIN: "2580", PIN: "4826"})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = pay("2580")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		w = pay("4826")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	// Test case 5: Schedules and holds move money too
+	t.Run("Schedules And Holds", func(t *testing.T) {
+		schedule := structs.ScheduleRequest{
+			TransactionType: "INTERNAL",
+			FromAccount:     fromAccount.AccountID,
+			ToAccount:       toAccount.AccountID,
+			Amount:          10,
+			Frequency:       "daily",
+		}
+		w := request(token, "", "POST", "/payment/api/schedules", schedule)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, "PIN_INCORRECT", body(w)["code"])
+		w = request(token, "4826", "POST", "/payment/api/schedules", schedule)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		hold := structs.HoldRequest{Account: fromAccount.AccountID, PayeeAccount: toAccount.AccountID, Amount: 10}
+		w = request(token, "", "POST", "/payment/api/holds", hold)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		w = request(token, "4826", "POST", "/payment/api/holds", hold)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		holdID := body(w)["data"].(map[string]interface{})["hold_id"].(string)
+
+		// the payee captures with their own PIN
+		payeeToken := CreateTestJWT(t, payee)
+		w = request(payeeToken, "", "POST", "/payment/api/holds/"+holdID+"/capture", nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		w = request(payeeToken, TestPIN, "POST", "/payment/api/holds/"+holdID+"/capture", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	// Test case 6: Guesses sent in parallel are all counted against the same lockout
+	t.Run("Parallel Guesses", func(t *testing.T) {
+		// the in-memory database locks out concurrent writers, so the
+		// requests take turns on one connection between their statements
+		sqlDB, _ := db.DB()
+		sqlDB.SetMaxOpenConns(1)
+
+		guesses := 3 * service.MaxPINAttempts
+		results := make(chan error, guesses)
+		var wg sync.WaitGroup
+		for i := 0; i < guesses; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results <- service.VerifyPIN(t.Context(), db, user.UserId, "0000")
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		incorrect := 0
+		for err := range results {
+			if errors.Is(err, service.ErrIncorrectPIN) {
+				incorrect++
+			} else {
+				assert.ErrorIs(t, err, service.ErrPINLocked)
+			}
+		}
+		assert.Less(t, incorrect, service.MaxPINAttempts)
+
+		// a correct PIN does not lift the lock the guesses set
+		assert.ErrorIs(t, service.VerifyPIN(t.Context(), db, user.UserId, "4826"), service.ErrPINLocked)
+	})
+}
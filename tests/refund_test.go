@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grey/models"
+	"github.com/grey/providers"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefund(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test data
+	user := CreateTestUser(t, db)
+	fromAccount := CreateTestAccount(t, db, user.ID, 1000.0)
+	payee := CreateTestUserWithEmail(t, db, "payee@example.com")
+	toAccount := CreateTestAccount(t, db, payee.ID, 0.0)
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+	// refunds are given by the owner of the account the payment went to
+	token := CreateTestJWT(t, payee)
+
+	payment, err := service.ProcessInternalPayment(t.Context(), db, fromAccount.AccountID, toAccount.AccountID, decimal.NewFromInt(300), "USD")
+	assert.NoError(t, err)
+
+	refund := func(paymentID string, amount float64) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(structs.RefundRequest{Amount: amount, Reason: "customer request"})
+		req, _ := http.NewRequest("POST", "/payment/api/payments/"+paymentID+"/refund", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	balance := func(accountID string) decimal.Decimal {
+		account, err := models.IsAccountExists(t.Context(), db, accountID)
+		assert.NoError(t, err)
+		return account.Balance
+	}
+
+	// Test case 1: Partial refund
+	t.Run("Partial Refund", func(t *testing.T) {
+		w := refund(payment.PaymentID, 100.0)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		responseData := response["response"].(map[string]interface{})
+		assert.Equal(t, payment.PaymentID, responseData["original_payment_id"])
+
+		assert.True(t, balance(fromAccount.AccountID).Equal(decimal.NewFromInt(800)))
+		assert.True(t, balance(toAccount.AccountID).Equal(decimal.NewFromInt(200)))
+
+		original, err := models.FindPayment(t.Context(), db, payment.PaymentID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.PartiallyRefunded, original.Status)
+		assertBalancedJournal(t, db, responseData["payment_id"].(string))
+	})
+
+	// Test case 2: Refund more than what is left
+	t.Run("Refund Exceeding Original Amount", func(t *testing.T) {
+		w := refund(payment.PaymentID, 250.0)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.True(t, balance(toAccount.AccountID).Equal(decimal.NewFromInt(200)))
+	})
+
+	// Test case 3: Refund the remainder
+	t.Run("Full Refund Of Remainder", func(t *testing.T) {
+		w := refund(payment.PaymentID, 0)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		assert.True(t, balance(fromAccount.AccountID).Equal(decimal.NewFromInt(1000)))
+		assert.True(t, balance(toAccount.AccountID).IsZero())
+
+		original, err := models.FindPayment(t.Context(), db, payment.PaymentID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Reversed, original.Status)
+		assert.True(t, original.RefundedAmount.Equal(original.Amount))
+	})
+
+	// Test case 4: Reversed payment cannot be refunded again
+	t.Run("Refund Reversed Payment", func(t *testing.T) {
+		w := refund(payment.PaymentID, 10.0)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	// Test case 5: Refund a top-up
+	t.Run("Refund Top Up", func(t *testing.T) {
+		topUp, err := service.TopUpProcess(t.Context(), db, toAccount.AccountID, decimal.NewFromInt(50), "USD")
+		assert.NoError(t, err)
+
+		w := refund(topUp.PaymentID, 0)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, balance(toAccount.AccountID).IsZero())
+		assert.True(t, balance(models.SystemAccountID(models.TopUpFunding, "USD")).IsZero())
+	})
+
+	// Test case 6: A settled payout cannot be refunded back to the sender
+	t.Run("Refund Settled Payout", func(t *testing.T) {
+		payout, err := service.ProcessExternalPayment(t.Context(), db, BankSimulator, models.BankTransfer, structs.RecipientDetails{RecipientNumber: "1234567890", RecipientName: "John Doe"}, fromAccount.AccountID, decimal.NewFromInt(500), "USD")
+		assert.NoError(t, err)
+		payment, err := models.FindPayment(t.Context(), db, payout.PaymentID)
+		assert.NoError(t, err)
+		_, err = service.SettleExternalPayment(t.Context(), db, BankSimulator, providers.PayoutResult{ProviderReference: payment.ProviderReference, Status: providers.PayoutSucceeded})
+		assert.NoError(t, err)
+
+		_, err = service.RefundPayment(t.Context(), db, payout.PaymentID, decimal.Zero, "")
+		assert.ErrorIs(t, err, service.ErrNotRefundable)
+		assert.True(t, balance(fromAccount.AccountID).Equal(decimal.NewFromInt(500)))
+	})
+
+	// Test case 7: Unknown payment
+	t.Run("Payment Not Found", func(t *testing.T) {
+		w := refund("00000000-0000-0000-0000-000000000000", 10.0)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,257 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grey/controllers"
+	"github.com/grey/cron"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleOccurrences(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, value)
+		assert.NoError(t, err)
+		return parsed
+	}
+
+	// Test case 1: Cron expressions with ranges, steps and lists
+	t.Run("Cron", func(t *testing.T) {
+		expr, err := cron.Parse("30 9 * * 1-5")
+		assert.NoError(t, err)
+		// Friday evening runs next on Monday morning
+		assert.Equal(t, at("2024-02-05T09:30:00Z"), expr.Next(at("2024-02-02T18:00:00Z")))
+
+		expr, err = cron.Parse("*/15 0 1,15 * *")
+		assert.NoError(t, err)
+		assert.Equal(t, at("2024-02-15T00:00:00Z"), expr.Next(at("2024-02-01T00:45:00Z")))
+
+		_, err = cron.Parse("60 * * * *")
+		assert.ErrorIs(t, err, cron.ErrInvalidExpression)
+		_, err = cron.Parse("* * *")
+		assert.ErrorIs(t, err, cron.ErrInvalidExpression)
+	})
+
+	// Test case 2: Monthly schedules keep their day and fall back to month end
+	t.Run("Monthly", func(t *testing.T) {
+		schedule := &models.PaymentSchedule{Frequency: models.ScheduleMonthly, StartAt: at("2024-01-31T08:00:00Z")}
+		next, ok := service.NextOccurrence(schedule, at("2024-01-31T08:00:00Z"))
+		assert.True(t, ok)
+		assert.Equal(t, at("2024-02-29T08:00:00Z"), next)
+
+		next, ok = service.NextOccurrence(schedule, next)
+		assert.True(t, ok)
+		assert.Equal(t, at("2024-03-31T08:00:00Z"), next)
+	})
+
+	// Test case 3: Nothing runs past the end date or after a one-off
+	t.Run("End", func(t *testing.T) {
+		end := at("2024-01-10T00:00:00Z")
+		schedule := &models.PaymentSchedule{Frequency: models.ScheduleWeekly, StartAt: at("2024-01-01T08:00:00Z"), EndAt: &end}
+		next, ok := service.NextOccurrence(schedule, at("2024-01-01T08:00:00Z"))
+		assert.True(t, ok)
+		assert.Equal(t, at("2024-01-08T08:00:00Z"), next)
+		_, ok = service.NextOccurrence(schedule, next)
+		assert.False(t, ok)
+
+		once := &models.PaymentSchedule{Frequency: models.ScheduleOnce, StartAt: at("2024-01-01T08:00:00Z")}
+		_, ok = service.NextOccurrence(once, at("2024-01-01T08:00:00Z"))
+		assert.False(t, ok)
+	})
+}
+
+func TestScheduledPayments(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test data
+	user := CreateTestUser(t, db)
+	fromAccount := CreateTestAccount(t, db, user.ID, 150.0)
+	payee := CreateTestUserWithEmail(t, db, "payee@example.com")
+	toAccount := CreateTestAccount(t, db, payee.ID, 0.0)
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+	token := CreateTestJWT(t, user)
+
+	request := func(method, path string, payload interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+		jsonPayload, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	balance := func(accountID string) decimal.Decimal {
+		account, err := models.IsAccountExists(t.Context(), db, accountID)
+		assert.NoError(t, err)
+		return account.Balance
+	}
+
+	var scheduleID string
+
+	// Test case 1: Invalid schedules are rejected
+	t.Run("Validation", func(t *testing.T) {
+		w, _ := request("POST", "/payment/api/schedules", structs.ScheduleRequest{
+			TransactionType: "INTERNAL",
+			FromAccount:     fromAccount.AccountID,
+			ToAccount:       toAccount.AccountID,
+			Amount:          100,
+			Frequency:       "cron",
+			Cron:            "every day",
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		past := time.Now().Add(-time.Hour)
+		w, _ = request("POST", "/payment/api/schedules", structs.ScheduleRequest{
+			TransactionType: "INTERNAL",
+			FromAccount:     fromAccount.AccountID,
+			ToAccount:       toAccount.AccountID,
+			Amount:          100,
+			Frequency:       "daily",
+			StartAt:         &past,
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	// Test case 2: A due daily payment is executed and moves a day on
+	t.Run("Daily Payment", func(t *testing.T) {
+		w, response := request("POST", "/payment/api/schedules", structs.ScheduleRequest{
+			TransactionType: "INTERNAL",
+			FromAccount:     fromAccount.AccountID,
+			ToAccount:       toAccount.AccountID,
+			Amount:          100,
+			Frequency:       "daily",
+			Description:     "rent",
+		})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		data := response["data"].(map[string]interface{})
+		scheduleID = data["schedule_id"].(string)
+
+		executed, err := service.ExecuteDueSchedules(t.Context(), db)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, executed)
+		assert.True(t, balance(toAccount.AccountID).Equal(decimal.NewFromInt(100)))
+
+		// nothing is due until tomorrow
+		executed, err = service.ExecuteDueSchedules(t.Context(), db)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, executed)
+
+		schedule, err := models.FindSchedule(t.Context(), db, scheduleID)
+		assert.NoError(t, err)
+		assert.True(t, schedule.NextRunAt.After(time.Now().Add(23*time.Hour)))
+	})
+
+	// Test case 3: A run without funds is recorded as failed
+	t.Run("Insufficient Balance Run", func(t *testing.T) {
+		assert.NoError(t, db.Model(&models.PaymentSchedule{}).Where("schedule_id = ?", scheduleID).Update("next_run_at", time.Now().Add(-time.Minute)).Error)
+
+		executed, err := service.ExecuteDueSchedules(t.Context(), db)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, executed)
+		assert.True(t, balance(fromAccount.AccountID).Equal(decimal.NewFromInt(50)))
+
+		w, response := request("GET", "/payment/api/schedules/"+scheduleID, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		runs := response["runs"].([]interface{})
+		assert.Len(t, runs, 2)
+		latest := runs[0].(map[string]interface{})
+		assert.Equal(t, string(models.ScheduleRunFailed), latest["status"])
+		assert.Equal(t, service.ErrInsufficientBalance.Error(), latest["error"])
+		assert.Equal(t, string(models.ScheduleRunSucceeded), runs[1].(map[string]interface{})["status"])
+	})
+
+	// Test case 4: Skip, pause, resume and cancel
+	t.Run("Lifecycle", func(t *testing.T) {
+		before, err := models.FindSchedule(t.Context(), db, scheduleID)
+		assert.NoError(t, err)
+
+		w, response := request("POST", "/payment/api/schedules/"+scheduleID+"/skip", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		data := response["data"].(map[string]interface{})
+		skippedTo, _ := time.Parse(time.RFC3339Nano, data["next_run_at"].(string))
+		assert.True(t, skippedTo.Sub(before.NextRunAt) >= 24*time.Hour-time.Second)
+
+		w, _ = request("POST", "/payment/api/schedules/"+scheduleID+"/resume", nil)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w, response = request("POST", "/payment/api/schedules/"+scheduleID+"/pause", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, string(models.SchedulePaused), response["data"].(map[string]interface{})["status"])
+
+		// paused schedules do not run even when due
+		assert.NoError(t, db.Model(&models.PaymentSchedule{}).Where("schedule_id = ?", scheduleID).Update("next_run_at", time.Now().Add(-time.Minute)).Error)
+		executed, err := service.ExecuteDueSchedules(t.Context(), db)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, executed)
+
+		// the missed occurrence is not made up
+		w, response = request("POST", "/payment/api/schedules/"+scheduleID+"/resume", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		resumedTo, _ := time.Parse(time.RFC3339Nano, response["data"].(map[string]interface{})["next_run_at"].(string))
+		assert.True(t, resumedTo.After(time.Now()))
+
+		w, _ = request("POST", "/payment/api/schedules/"+scheduleID+"/cancel", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		w, _ = request("POST", "/payment/api/schedules/"+scheduleID+"/skip", nil)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w, response = request("GET", "/payment/api/schedules", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		schedules := response["data"].([]interface{})
+		assert.Len(t, schedules, 1)
+		assert.Equal(t, string(models.ScheduleCancelled), schedules[0].(map[string]interface{})["status"])
+	})
+
+	// Test case 5: A future-dated one-off payment completes after its run
+	t.Run("Once", func(t *testing.T) {
+		schedule := models.PaymentSchedule{
+			UserID:      user.ID,
+			Type:        models.InternalTransfer,
+			FromAccount: fromAccount.AccountID,
+			ToAccount:   toAccount.AccountID,
+			Amount:      decimal.NewFromInt(20),
+			Currency:    "USD",
+			Frequency:   models.ScheduleOnce,
+			StartAt:     time.Now().Add(time.Hour),
+		}
+		assert.NoError(t, service.CreateSchedule(t.Context(), db, &schedule))
+
+		executed, err := service.ExecuteDueSchedules(t.Context(), db)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, executed)
+
+		// an hour later
+		due := time.Now().Add(-time.Minute)
+		assert.NoError(t, db.Model(&models.PaymentSchedule{}).Where("schedule_id = ?", schedule.ScheduleID).Updates(map[string]interface{}{"start_at": due, "next_run_at": due}).Error)
+		executed, err = service.ExecuteDueSchedules(t.Context(), db)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, executed)
+
+		done, err := models.FindSchedule(t.Context(), db, schedule.ScheduleID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.ScheduleCompleted, done.Status)
+		assert.True(t, balance(fromAccount.AccountID).Equal(decimal.NewFromInt(30)))
+	})
+}
@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/grey/keys"
+	"github.com/grey/models"
+	"github.com/grey/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessions(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+
+	request := func(token, method, path string, payload interface{}) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	credentials := structs.User{Email: "session@example.com", Password: "correct-horse"}
+	w := request("", "POST", "/user/api/register", credentials)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	type tokens struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+		SessionID    string `json:"session_id"`
+	}
+	parse := func(w *httptest.ResponseRecorder) tokens {
+		var response struct {
+			Data tokens `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Data
+	}
+	login := func() tokens {
+		w := request("", "POST", "/user/api/login", credentials)
+		assert.Equal(t, http.StatusOK, w.Code)
+		session := parse(w)
+		assert.NotEmpty(t, session.Token)
+		assert.NotEmpty(t, session.RefreshToken)
+		return session
+	}
+	refresh := func(refreshToken string) *httptest.ResponseRecorder {
+		return request("", "POST", "/user/api/refresh", structs.RefreshRequest{RefreshToken: refreshToken})
+	}
+
+	// Test case 1: A refresh token is exchanged for a new pair exactly once
+	t.Run("Refresh Rotates", func(t *testing.T) {
+		session := login()
+
+		w := refresh(session.RefreshToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+		rotated := parse(w)
+		assert.Equal(t, session.SessionID, rotated.SessionID)
+		assert.NotEqual(t, session.RefreshToken, rotated.RefreshToken)
+
+		w = request(rotated.Token, "GET", "/user/api/profile", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = refresh("not-a-refresh-token")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	// Test case 2: Logout revokes the access token and the refresh token
+	t.Run("Logout", func(t *testing.T) {
+		session := login()
+
+		w := request(session.Token, "POST", "/user/api/logout", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = request(session.Token, "GET", "/user/api/profile", nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = refresh(session.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	// Test case 3: Replaying a spent refresh token revokes the whole family
+	t.Run("Reuse Detection", func(t *testing.T) {
+		session := login()
+
+		w := refresh(session.RefreshToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+		rotated := parse(w)
+
+		// the stolen copy of the first token is presented again
+		w = refresh(session.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		// the legitimate holder is logged out too
+		w = request(rotated.Token, "GET", "/user/api/profile", nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		w = refresh(rotated.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		revoked, err := models.FindSession(t.Context(), db, session.SessionID)
+		assert.NoError(t, err)
+		assert.NotNil(t, revoked.RevokedAt)
+		assert.Equal(t, "refresh_token_reuse", revoked.RevokedReason)
+	})
+
+	// Test case 4: Other sessions of the same user are untouched
+	t.Run("Sessions Are Independent", func(t *testing.T) {
+		first := login()
+		second := login()
+
+		w := request(first.Token, "POST", "/user/api/logout", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = request(second.Token, "GET", "/user/api/profile", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	// Test case 5: Tokens without a jti are refused
+	t.Run("Missing JTI", func(t *testing.T) {
+		user, err := models.IsEmailExists(t.Context(), db, credentials.Email)
+		assert.NoError(t, err)
+
+		claims := jwt.MapClaims{
+			"authorized": true,
+			"user_id":    user.UserId,
+			"exp":        time.Now().Add(time.Minute).Unix(),
+		}
+		token, err := keys.Default.Sign(claims)
+		assert.NoError(t, err)
+
+		w := request(token, "GET", "/user/api/profile", nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
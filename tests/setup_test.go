@@ -1,7 +1,10 @@
 package tests
 
 import (
+	"context"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -9,13 +12,56 @@ import (
 	"github.com/grey/database"
 	"github.com/grey/middlewares"
 	"github.com/grey/models"
+	"github.com/grey/notify"
+	"github.com/grey/providers"
 	"github.com/grey/routers"
+	"github.com/grey/throttle"
 	"github.com/grey/utils"
 	"github.com/shopspring/decimal"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
+// Payout simulators shared by the tests, reset to succeed by SetupTestRouterWithDB
+var (
+	BankSimulator        = providers.NewSimulator("bank_simulator", providers.PayoutSucceeded, "bank-webhook-secret")
+	MobileMoneySimulator = providers.NewSimulator("mobile_money_simulator", providers.PayoutSucceeded, "mobile-money-webhook-secret")
+)
+
+// Mailbox collects the emails sent during a test, emptied by SetupTestRouterWithDB
+var Mailbox = &TestMailer{}
+
+type TestMailer struct {
+	mu       sync.Mutex
+	messages []notify.Message
+}
+
+func (mailer *TestMailer) Send(ctx context.Context, message notify.Message) error {
+	mailer.mu.Lock()
+	defer mailer.mu.Unlock()
+	mailer.messages = append(mailer.messages, message)
+	return nil
+}
+
+// Last returns the latest email sent to the address.
+func (mailer *TestMailer) Last(to string) (notify.Message, bool) {
+	mailer.mu.Lock()
+	defer mailer.mu.Unlock()
+	for i := len(mailer.messages) - 1; i >= 0; i-- {
+		if mailer.messages[i].To == to {
+			return mailer.messages[i], true
+		}
+	}
+	return notify.Message{}, false
+}
+
+func (mailer *TestMailer) Reset() {
+	mailer.mu.Lock()
+	defer mailer.mu.Unlock()
+	mailer.messages = nil
+}
+
 // SetupTestEnvironment initializes the test environment
 func SetupTestEnvironment(t *testing.T) *gorm.DB {
 	// Use in-memory SQLite for testing
@@ -32,6 +78,24 @@ func SetupTestEnvironment(t *testing.T) *gorm.DB {
 			&models.Account{},
 			&models.Payment{},
 			&models.LedgerEntry{},
+			&models.PaymentStatusHistory{},
+			&models.IdempotencyKey{},
+			&models.OutboxEvent{},
+			&models.WebhookSubscription{},
+			&models.WebhookDelivery{},
+			&models.WebhookDeliveryAttempt{},
+			&models.FXQuote{},
+			&models.Hold{},
+			&models.PaymentSchedule{},
+			&models.ScheduleRun{},
+			&models.PayoutBatch{},
+			&models.PayoutBatchItem{},
+			&models.Session{},
+			&models.RefreshToken{},
+			&models.RevokedToken{},
+			&models.RecoveryCode{},
+			&models.LoginAttempt{},
+			&models.UserToken{},
 		},
 	}
 	database.RunMigrations(migrations)
@@ -39,14 +103,30 @@ func SetupTestEnvironment(t *testing.T) *gorm.DB {
 	return db
 }
 
+// TestPIN is the transaction PIN of users made by CreateTestUser
+const TestPIN = "2580"
+
 // CreateTestUser creates a test user for testing
 func CreateTestUser(t *testing.T, db *gorm.DB) *models.User {
+	return CreateTestUserWithEmail(t, db, "test@example.com")
+}
+
+// CreateTestUserWithEmail creates a test user for tests that need more than
+// one user, such as the owner of the account a payment goes to
+func CreateTestUserWithEmail(t *testing.T, db *gorm.DB, email string) *models.User {
+	pinHash, err := bcrypt.GenerateFromPassword([]byte(TestPIN), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash test PIN: %v", err)
+	}
+	verifiedAt := time.Now()
 	user := &models.User{
-		Email:    "test@example.com",
-		Password: "hashedpassword",
+		Email:           email,
+		Password:        "hashedpassword",
+		PinHash:         string(pinHash),
+		EmailVerifiedAt: &verifiedAt,
 	}
 
-	err := db.Create(user).Error
+	err = db.Create(user).Error
 	if err != nil {
 		t.Fatalf("Failed to create test user: %v", err)
 	}
@@ -70,9 +150,9 @@ func CreateTestAccount(t *testing.T, db *gorm.DB, userID int, balance float64) *
 	return account
 }
 
-// CreateTestJWT creates a mock JWT token for testing
-func CreateTestJWT(t *testing.T, email string) string {
-	token, err := utils.GenerateToken("1", email)
+// CreateTestJWT creates a session token for the test user
+func CreateTestJWT(t *testing.T, user *models.User) string {
+	token, err := utils.GenerateToken(user.UserId, user.Email)
 	if err != nil {
 		t.Fatalf("Failed to generate test token: %v", err)
 	}
@@ -96,29 +176,89 @@ func SetupTestRouterWithDB(db *gorm.DB) *gin.Engine {
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "Idempotency-Key", "X-MFA-Code", "X-Transaction-PIN"}
 	router.Use(cors.New(config))
 
 	// Initialize repositories with test database
 	userRepo := &controllers.UserGroup{}
 	paymentRepo := &controllers.PaymentGroup{}
+	webhookRepo := &controllers.WebhookGroup{}
 
 	// Override the global database for tests
 	database.Db = db
 
+	// Simulated payout providers that accept every payout
+	BankSimulator.SetOutcome(providers.PayoutSucceeded)
+	MobileMoneySimulator.SetOutcome(providers.PayoutSucceeded)
+	providers.Register(models.BankTransfer, BankSimulator)
+	providers.Register(models.MobileMoney, MobileMoneySimulator)
+
+	// Emails are kept for the test to read
+	Mailbox.Reset()
+	notify.Default = Mailbox
+
+	// Failed logins from earlier tests do not carry over
+	throttle.Default = throttle.NewGuard(throttle.NewMemoryStore(), throttle.DefaultEmailPolicy, throttle.DefaultIPPolicy)
+
 	// Stripe API endpoints
 	paymentGroup := router.Group("/payment/api")
 	{
-		paymentGroup.POST("/internal_payment", middlewares.SessionMiddleware(), paymentRepo.InternalPayment)
-		paymentGroup.POST("/external_payment", middlewares.SessionMiddleware(), paymentRepo.ExternalPayment)
-		paymentGroup.POST("/topup", middlewares.SessionMiddleware(), paymentRepo.TopUp)
+		paymentGroup.POST("/internal_payment", middlewares.SessionMiddleware(), middlewares.IdempotencyMiddleware(), paymentRepo.InternalPayment)
+		paymentGroup.POST("/external_payment", middlewares.SessionMiddleware(), middlewares.IdempotencyMiddleware(), paymentRepo.ExternalPayment)
+		paymentGroup.POST("/topup", middlewares.SessionMiddleware(), middlewares.IdempotencyMiddleware(), paymentRepo.TopUp)
+		paymentGroup.POST("/payments/:id/refund", middlewares.SessionMiddleware(), middlewares.IdempotencyMiddleware(), paymentRepo.Refund)
+		paymentGroup.POST("/webhooks/:provider", paymentRepo.ProviderWebhook)
+		paymentGroup.GET("/currencies", paymentRepo.Currencies)
+		paymentGroup.POST("/fx/quotes", middlewares.SessionMiddleware(), paymentRepo.CreateFXQuote)
+		paymentGroup.GET("/fees/preview", middlewares.SessionMiddleware(), paymentRepo.PreviewFee)
+		paymentGroup.GET("/limits", middlewares.SessionMiddleware(), paymentRepo.RemainingLimits)
+		paymentGroup.POST("/holds", middlewares.SessionMiddleware(), middlewares.IdempotencyMiddleware(), paymentRepo.PlaceHold)
+		paymentGroup.GET("/holds/:hold_id", middlewares.SessionMiddleware(), paymentRepo.GetHold)
+		paymentGroup.POST("/holds/:hold_id/capture", middlewares.SessionMiddleware(), middlewares.IdempotencyMiddleware(), paymentRepo.CaptureHold)
+		paymentGroup.POST("/holds/:hold_id/void", middlewares.SessionMiddleware(), paymentRepo.VoidHold)
+		paymentGroup.POST("/schedules", middlewares.SessionMiddleware(), middlewares.IdempotencyMiddleware(), paymentRepo.CreateSchedule)
+		paymentGroup.GET("/schedules", middlewares.SessionMiddleware(), paymentRepo.ListSchedules)
+		paymentGroup.GET("/schedules/:schedule_id", middlewares.SessionMiddleware(), paymentRepo.GetSchedule)
+		paymentGroup.POST("/schedules/:schedule_id/skip", middlewares.SessionMiddleware(), paymentRepo.SkipSchedule)
+		paymentGroup.POST("/schedules/:schedule_id/pause", middlewares.SessionMiddleware(), paymentRepo.PauseSchedule)
+		paymentGroup.POST("/schedules/:schedule_id/resume", middlewares.SessionMiddleware(), paymentRepo.ResumeSchedule)
+		paymentGroup.POST("/schedules/:schedule_id/cancel", middlewares.SessionMiddleware(), paymentRepo.CancelSchedule)
+		paymentGroup.POST("/payouts/batches", middlewares.SessionMiddleware(), middlewares.IdempotencyMiddleware(), paymentRepo.CreatePayoutBatch)
+		paymentGroup.GET("/payouts/batches/:batch_id", middlewares.SessionMiddleware(), paymentRepo.GetPayoutBatch)
+		paymentGroup.GET("/payouts/batches/:batch_id/results", middlewares.SessionMiddleware(), paymentRepo.PayoutBatchResults)
+		paymentGroup.POST("/accounts", middlewares.SessionMiddleware(), paymentRepo.OpenAccount)
+		paymentGroup.GET("/accounts", middlewares.SessionMiddleware(), paymentRepo.ListAccounts)
+		paymentGroup.GET("/accounts/:account_id/transactions", middlewares.SessionMiddleware(), paymentRepo.AccountTransactions)
+		paymentGroup.GET("/accounts/:account_id/statement", middlewares.SessionMiddleware(), paymentRepo.AccountStatement)
 	}
 
+	// public keys for verifying session tokens
+	router.GET("/.well-known/jwks.json", userRepo.JWKS)
+
 	userGroup := router.Group("/user/api")
 	{
 		userGroup.POST("/register", userRepo.CreateUser)
 		userGroup.POST("/login", userRepo.Login)
+		userGroup.POST("/login/mfa", middlewares.MFAPendingMiddleware(), userRepo.VerifyLogin)
+		userGroup.POST("/refresh", userRepo.Refresh)
+		userGroup.POST("/logout", middlewares.SessionMiddleware(), userRepo.Logout)
 		userGroup.GET("/profile", middlewares.SessionMiddleware(), userRepo.UserProfile)
+		userGroup.POST("/pin", middlewares.SessionMiddleware(), userRepo.SetPIN)
+		userGroup.PUT("/pin", middlewares.SessionMiddleware(), userRepo.ChangePIN)
+		userGroup.POST("/password/change", middlewares.SessionMiddleware(), userRepo.ChangePassword)
+		userGroup.POST("/password/forgot", userRepo.ForgotPassword)
+		userGroup.POST("/password/reset", userRepo.ResetPassword)
+		userGroup.POST("/email/verify", userRepo.VerifyEmail)
+		userGroup.POST("/email/resend", middlewares.SessionMiddleware(), userRepo.ResendVerification)
+		userGroup.POST("/mfa/enroll", middlewares.SessionMiddleware(), userRepo.EnrollMFA)
+		userGroup.POST("/mfa/confirm", middlewares.SessionMiddleware(), userRepo.ConfirmMFA)
+		userGroup.POST("/mfa/disable", middlewares.SessionMiddleware(), userRepo.DisableMFA)
+
+		userGroup.POST("/webhooks", middlewares.SessionMiddleware(), webhookRepo.CreateSubscription)
+		userGroup.GET("/webhooks", middlewares.SessionMiddleware(), webhookRepo.ListSubscriptions)
+		userGroup.DELETE("/webhooks/:id", middlewares.SessionMiddleware(), webhookRepo.DeleteSubscription)
+		userGroup.GET("/webhooks/:id/deliveries", middlewares.SessionMiddleware(), webhookRepo.ListDeliveries)
+		userGroup.POST("/webhooks/deliveries/:delivery_id/redeliver", middlewares.SessionMiddleware(), webhookRepo.Redeliver)
 	}
 
 	return router
@@ -0,0 +1,164 @@
+package tests
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountStatement(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test data
+	user := CreateTestUser(t, db)
+	account := CreateTestAccount(t, db, user.ID, 0.0)
+	payee := CreateTestUserWithEmail(t, db, "payee@example.com")
+	otherAccount := CreateTestAccount(t, db, payee.ID, 1000.0)
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+	token := CreateTestJWT(t, user)
+
+	// a top-up booked before the statement period makes the opening balance
+	topUp, err := service.TopUpProcess(t.Context(), db, account.AccountID, decimal.NewFromInt(500), "USD")
+	assert.NoError(t, err)
+	assert.NoError(t, db.Model(&models.LedgerEntry{}).Where("payment_id = ?", topUp.PaymentID).
+		Update("created_at", time.Date(2020, 1, 15, 0, 0, 0, 0, time.UTC)).Error)
+
+	_, err = service.ProcessInternalPayment(t.Context(), db, account.AccountID, otherAccount.AccountID, decimal.NewFromInt(120), "USD")
+	assert.NoError(t, err)
+	_, err = service.ProcessInternalPayment(t.Context(), db, otherAccount.AccountID, account.AccountID, decimal.NewFromInt(30), "USD")
+	assert.NoError(t, err)
+
+	period := "&from=2020-02-01&to=" + time.Now().UTC().Format(time.DateOnly)
+	statement := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/payment/api/accounts/"+account.AccountID+"/statement"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Test case 1: CSV with opening and closing balance rows
+	t.Run("CSV", func(t *testing.T) {
+		w := statement("?format=csv" + period)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Header().Get("Content-Disposition"), ".csv")
+
+		rows, err := csv.NewReader(w.Body).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, rows, 5)
+		assert.Equal(t, "balance", rows[0][8])
+		assert.Equal(t, "Opening balance", rows[1][4])
+		assert.Equal(t, "500.00", rows[1][8])
+		assert.Equal(t, []string{"debit", "-120.00", "USD", "380.00"}, rows[2][5:])
+		assert.Equal(t, []string{"credit", "30.00", "USD", "410.00"}, rows[3][5:])
+		assert.Equal(t, "Closing balance", rows[4][4])
+		assert.Equal(t, "410.00", rows[4][8])
+	})
+
+	// Test case 2: OFX bank statement
+	t.Run("OFX", func(t *testing.T) {
+		w := statement("?format=ofx" + period)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, strings.Contains(w.Body.String(), `<?OFX OFXHEADER="200" VERSION="211"`))
+
+		var ofx struct {
+			Account      string `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKACCTFROM>ACCTID"`
+			Transactions []struct {
+				Type      string `xml:"TRNTYPE"`
+				Amount    string `xml:"TRNAMT"`
+				PaymentID string `xml:"REFNUM"`
+			} `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>BANKTRANLIST>STMTTRN"`
+			LedgerBalance string `xml:"BANKMSGSRSV1>STMTTRNRS>STMTRS>LEDGERBAL>BALAMT"`
+		}
+		assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &ofx))
+		assert.Equal(t, compactStatementID(t, account.AccountID), ofx.Account)
+		assert.LessOrEqual(t, len(ofx.Account), 22)
+		assert.Len(t, ofx.Transactions, 2)
+		for _, transaction := range ofx.Transactions {
+			assert.LessOrEqual(t, len(transaction.PaymentID), 32)
+		}
+		assert.Equal(t, "DEBIT", ofx.Transactions[0].Type)
+		assert.Equal(t, "-120.00", ofx.Transactions[0].Amount)
+		assert.Equal(t, "410.00", ofx.LedgerBalance)
+	})
+
+	// Test case 3: camt.053 statement
+	t.Run("camt.053", func(t *testing.T) {
+		w := statement("?format=camt053" + period)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var camt struct {
+			XMLName     xml.Name
+			MessageID   string `xml:"BkToCstmrStmt>GrpHdr>MsgId"`
+			StatementID string `xml:"BkToCstmrStmt>Stmt>Id"`
+			Account     string `xml:"BkToCstmrStmt>Stmt>Acct>Id>Othr>Id"`
+			Balances    []struct {
+				Code        string `xml:"Tp>CdOrPrtry>Cd"`
+				Amount      string `xml:"Amt"`
+				CreditDebit string `xml:"CdtDbtInd"`
+			} `xml:"BkToCstmrStmt>Stmt>Bal"`
+			Entries []struct {
+				Amount      string `xml:"Amt"`
+				CreditDebit string `xml:"CdtDbtInd"`
+				EndToEndID  string `xml:"NtryDtls>TxDtls>Refs>EndToEndId"`
+			} `xml:"BkToCstmrStmt>Stmt>Ntry"`
+		}
+		assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &camt))
+		assert.Equal(t, "urn:iso:std:iso:20022:tech:xsd:camt.053.001.02", camt.XMLName.Space)
+		assert.Len(t, camt.Balances, 2)
+		assert.Equal(t, "OPBD", camt.Balances[0].Code)
+		assert.Equal(t, "500.00", camt.Balances[0].Amount)
+		assert.Equal(t, "CLBD", camt.Balances[1].Code)
+		assert.Equal(t, "410.00", camt.Balances[1].Amount)
+		assert.Len(t, camt.Entries, 2)
+		assert.Equal(t, "120.00", camt.Entries[0].Amount)
+		assert.Equal(t, "DBIT", camt.Entries[0].CreditDebit)
+
+		// identifiers fit Max35Text, the account Max34Text
+		assert.Equal(t, compactStatementID(t, account.AccountID), camt.Account)
+		assert.LessOrEqual(t, len(camt.Account), 34)
+		assert.LessOrEqual(t, len(camt.MessageID), 35)
+		assert.LessOrEqual(t, len(camt.StatementID), 35)
+		for _, entry := range camt.Entries {
+			assert.NotEmpty(t, entry.EndToEndID)
+			assert.LessOrEqual(t, len(entry.EndToEndID), 35)
+		}
+	})
+
+	// Test case 4: Invalid requests
+	t.Run("Invalid Request", func(t *testing.T) {
+		w := statement("?format=pdf")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = statement("?from=2020-03-01&to=2020-02-01")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+// compactStatementID is the short form statements give a UUID: its bytes in
+// unpadded URL-safe base64.
+func compactStatementID(t *testing.T, id string) string {
+	parsed, err := uuid.Parse(id)
+	assert.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(parsed[:])
+}
@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/grey/controllers"
 	"github.com/grey/structs"
 	"github.com/stretchr/testify/assert"
 )
@@ -25,7 +26,7 @@ func TestTopUp(t *testing.T) {
 
 	// Create test router
 	router := SetupTestRouterWithDB(db)
-	token := CreateTestJWT(t, user.Email)
+	token := CreateTestJWT(t, user)
 
 	// Test case 1: Successful top-up
 	t.Run("Successful Top Up", func(t *testing.T) {
@@ -39,6 +40,7 @@ func TestTopUp(t *testing.T) {
 		req, _ := http.NewRequest("POST", "/payment/api/topup", bytes.NewBuffer(jsonPayload))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
 
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
@@ -68,6 +70,7 @@ func TestTopUp(t *testing.T) {
 		req, _ := http.NewRequest("POST", "/payment/api/topup", bytes.NewBuffer(jsonPayload))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
 
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
@@ -92,6 +95,7 @@ func TestTopUp(t *testing.T) {
 		req, _ := http.NewRequest("POST", "/payment/api/topup", bytes.NewBuffer(jsonPayload))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
 
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
@@ -116,6 +120,7 @@ func TestTopUp(t *testing.T) {
 		req, _ := http.NewRequest("POST", "/payment/api/topup", bytes.NewBuffer(jsonPayload))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
 
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
@@ -159,6 +164,7 @@ func TestTopUp(t *testing.T) {
 		req, _ := http.NewRequest("POST", "/payment/api/topup", bytes.NewBuffer(jsonPayload))
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(controllers.PINHeader, TestPIN)
 
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
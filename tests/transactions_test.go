@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountTransactions(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test data
+	user := CreateTestUser(t, db)
+	account := CreateTestAccount(t, db, user.ID, 0.0)
+	payee := CreateTestUserWithEmail(t, db, "payee@example.com")
+	otherAccount := CreateTestAccount(t, db, payee.ID, 1000.0)
+
+	stranger := &models.User{Email: "stranger@example.com", Password: "hashedpassword"}
+	assert.NoError(t, db.Create(stranger).Error)
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+	token := CreateTestJWT(t, user)
+
+	_, err := service.TopUpProcess(t.Context(), db, account.AccountID, decimal.NewFromInt(500), "USD")
+	assert.NoError(t, err)
+	_, err = service.ProcessInternalPayment(t.Context(), db, account.AccountID, otherAccount.AccountID, decimal.NewFromInt(120), "USD")
+	assert.NoError(t, err)
+	_, err = service.ProcessInternalPayment(t.Context(), db, otherAccount.AccountID, account.AccountID, decimal.NewFromInt(30), "USD")
+	assert.NoError(t, err)
+	_, err = service.ProcessInternalPayment(t.Context(), db, account.AccountID, otherAccount.AccountID, decimal.NewFromInt(10), "USD")
+	assert.NoError(t, err)
+
+	list := func(bearer, query string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest("GET", "/payment/api/accounts/"+account.AccountID+"/transactions"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	lines := func(response map[string]interface{}) []map[string]interface{} {
+		var result []map[string]interface{}
+		for _, line := range response["data"].([]interface{}) {
+			result = append(result, line.(map[string]interface{}))
+		}
+		return result
+	}
+
+	// Test case 1: Newest first with running balances
+	t.Run("Running Balance", func(t *testing.T) {
+		w, response := list(token, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		data := lines(response)
+		assert.Len(t, data, 4)
+		expected := []struct{ amount, balance string }{
+			{"-10", "400"},
+			{"30", "410"},
+			{"-120", "380"},
+			{"500", "500"},
+		}
+		for i, line := range data {
+			assert.Equal(t, expected[i].amount, line["amount"])
+			assert.Equal(t, expected[i].balance, line["running_balance"])
+		}
+		assert.Empty(t, response["next_cursor"])
+	})
+
+	// Test case 2: Cursor pagination walks every line exactly once
+	t.Run("Cursor Pagination", func(t *testing.T) {
+		w, response := list(token, "?limit=3")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Len(t, lines(response), 3)
+		cursor, ok := response["next_cursor"].(string)
+		assert.True(t, ok)
+
+		w, response = list(token, "?limit=3&cursor="+cursor)
+		assert.Equal(t, http.StatusOK, w.Code)
+		data := lines(response)
+		assert.Len(t, data, 1)
+		assert.Equal(t, "500", data[0]["amount"])
+		assert.Empty(t, response["next_cursor"])
+	})
+
+	// Test case 3: Filters keep the unfiltered running balance
+	t.Run("Filters", func(t *testing.T) {
+		w, response := list(token, "?direction=debit&min_amount=50")
+		assert.Equal(t, http.StatusOK, w.Code)
+		data := lines(response)
+		assert.Len(t, data, 1)
+		assert.Equal(t, "-120", data[0]["amount"])
+		assert.Equal(t, "380", data[0]["running_balance"])
+
+		w, response = list(token, "?from=2000-01-01&to=2000-01-31")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Len(t, response["data"], 0)
+
+		w, _ = list(token, "?direction=sideways")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w, _ = list(token, "?cursor=not-a-cursor")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	// Test case 4: Another user cannot read the account
+	t.Run("Not Account Owner", func(t *testing.T) {
+		strangerToken := CreateTestJWT(t, stranger)
+
+		w, _ := list(strangerToken, "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
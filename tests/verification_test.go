@@ -0,0 +1,214 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grey/controllers"
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailVerification(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+
+	request := func(token, method, path string, payload interface{}) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set(controllers.PINHeader, TestPIN)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	body := func(w *httptest.ResponseRecorder) map[string]interface{} {
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	credentials := structs.User{Email: "verify@example.com", Password: "correct-horse"}
+	w := request("", "POST", "/user/api/register", credentials)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	user, err := models.IsEmailExists(t.Context(), db, credentials.Email)
+	assert.NoError(t, err)
+	token := CreateTestJWT(t, user)
+
+	w = request(token, "POST", "/user/api/pin", structs.SetPINRequest{Password: credentials.Password, PIN: TestPIN})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	// registration opened the user's account
+	account, err := models.FindUserAccount(t.Context(), db, user.ID, "USD")
+	assert.NoError(t, err)
+	assert.NoError(t, models.UpdateAccountBalance(t.Context(), db, account.AccountID, decimal.NewFromInt(1000)))
+	payout := func() *httptest.ResponseRecorder {
+		return request(token, "POST", "/payment/api/external_payment", structs.ExternalPaymentRequest{
+			Account:         account.AccountID,
+			Amount:          10,
+			Currency:        "USD",
+			TransactionType: "BANK_TRANSFER",
+			Recipient: structs.RecipientDetails{
+				RecipientNumber: "1234567890",
+				RecipientName:   "John Doe",
+			},
+		})
+	}
+	// the token is the paragraph after the greeting of the verification email
+	verificationToken := func() string {
+		message, ok := Mailbox.Last(credentials.Email)
+		assert.True(t, ok)
+		paragraphs := strings.Split(message.Body, "\n\n")
+		assert.Len(t, paragraphs, 3)
+		return paragraphs[1]
+	}
+	// moves the emails sent so far back in time
+	backdate := func(age time.Duration) {
+		assert.NoError(t, db.Model(&models.UserToken{}).Where("user_id = ?", user.ID).
+			Update("created_at", time.Now().Add(-age)).Error)
+	}
+
+	// Test case 1: New users start unverified and cannot send payouts
+	t.Run("Unverified", func(t *testing.T) {
+		w := request(token, "GET", "/user/api/profile", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, false, body(w)["data"].(map[string]interface{})["email_verified"])
+
+		w = payout()
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, "EMAIL_NOT_VERIFIED", body(w)["code"])
+
+		w = request(token, "POST", "/payment/api/payouts/batches", structs.PayoutBatchRequest{
+			FromAccount:     account.AccountID,
+			TransactionType: "BANK_TRANSFER",
+			Items: []structs.PayoutItemRequest{
+				{Recipient: structs.RecipientDetails{RecipientNumber: "1234567890", RecipientName: "John Doe"}, Amount: 10},
+			},
+		})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		// moving money within the wallet is allowed
+		payee := CreateTestUserWithEmail(t, db, "payee@example.com")
+		other := CreateTestAccount(t, db, payee.ID, 0.0)
+		w = request(token, "POST", "/payment/api/internal_payment", structs.InternalPaymentRequest{
+			FromAccount: account.AccountID,
+			ToAccount:   other.AccountID,
+			Amount:      10,
+			Currency:    "USD",
+		})
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	// Test case 2: Resending is rate limited and replaces the earlier token
+	t.Run("Resend", func(t *testing.T) {
+		first := verificationToken()
+
+		w := request(token, "POST", "/user/api/email/resend", nil)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+		backdate(2 * service.VerificationResendInterval)
+		w = request(token, "POST", "/user/api/email/resend", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotEqual(t, first, verificationToken())
+
+		w = request("", "POST", "/user/api/email/verify", structs.VerifyEmailRequest{Token: first})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		// no more than the hourly allowance, however spaced out
+		for i := 2; i < service.MaxVerificationEmails; i++ {
+			backdate(2 * service.VerificationResendInterval)
+			w = request(token, "POST", "/user/api/email/resend", nil)
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+		backdate(2 * service.VerificationResendInterval)
+		w = request(token, "POST", "/user/api/email/resend", nil)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+
+	// Test case 3: The emailed token verifies the address once
+	t.Run("Verify", func(t *testing.T) {
+		w := request("", "POST", "/user/api/email/verify", structs.VerifyEmailRequest{Token: "not-a-token"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		verification := verificationToken()
+		w = request("", "POST", "/user/api/email/verify", structs.VerifyEmailRequest{Token: verification})
+		assert.Equal(t, http.StatusOK, w.Code)
+		w = request("", "POST", "/user/api/email/verify", structs.VerifyEmailRequest{Token: verification})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = request(token, "GET", "/user/api/profile", nil)
+		assert.Equal(t, true, body(w)["data"].(map[string]interface{})["email_verified"])
+		w = request(token, "POST", "/user/api/email/resend", nil)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w = payout()
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	// Test case 4: Verification tokens expire
+	t.Run("Expired Token", func(t *testing.T) {
+		other := structs.User{Email: "late@example.com", Password: "correct-horse"}
+		w := request("", "POST", "/user/api/register", other)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		message, ok := Mailbox.Last(other.Email)
+		assert.True(t, ok)
+		verification := strings.Split(message.Body, "\n\n")[1]
+
+		past := time.Now().Add(-time.Minute)
+		assert.NoError(t, db.Model(&models.UserToken{}).Where("used_at IS NULL").Update("expires_at", past).Error)
+
+		w = request("", "POST", "/user/api/email/verify", structs.VerifyEmailRequest{Token: verification})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestExistingUsersEmailVerification(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// a user from before emails were verified, and one who verified later
+	registered := time.Now().Add(-24 * time.Hour).Round(time.Second)
+	existing := &models.User{Email: "existing@example.com", Password: "hashedpassword", CreatedAt: registered}
+	assert.NoError(t, db.Create(existing).Error)
+	verifiedAt := time.Now().Round(time.Second)
+	verified := &models.User{Email: "verified@example.com", Password: "hashedpassword", EmailVerifiedAt: &verifiedAt}
+	assert.NoError(t, db.Create(verified).Error)
+
+	assert.NoError(t, models.VerifyExistingEmails(t.Context(), db))
+
+	user, err := models.FindUser(t.Context(), db, existing.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, user.EmailVerifiedAt) {
+		assert.True(t, user.EmailVerifiedAt.Equal(registered), "verified at %s", user.EmailVerifiedAt)
+	}
+	assert.True(t, user.EmailVerified)
+
+	user, err = models.FindUser(t.Context(), db, verified.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, user.EmailVerifiedAt) {
+		assert.True(t, user.EmailVerifiedAt.Equal(verifiedAt), "verified at %s", user.EmailVerifiedAt)
+	}
+}
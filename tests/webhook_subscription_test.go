@@ -0,0 +1,235 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grey/models"
+	"github.com/grey/service"
+	"github.com/grey/structs"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookSubscriptions(t *testing.T) {
+	// Setup test environment
+	db := SetupTestEnvironment(t)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+
+	// Create test data
+	user := CreateTestUser(t, db)
+	fromAccount := CreateTestAccount(t, db, user.ID, 1000.0)
+
+	// Create test router
+	router := SetupTestRouterWithDB(db)
+	token := CreateTestJWT(t, user)
+
+	// Subscriber endpoint that records what it receives
+	var mu sync.Mutex
+	var received []*http.Request
+	var bodies [][]byte
+	responseStatus := http.StatusOK
+	subscriber := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, r)
+		bodies = append(bodies, body)
+		w.WriteHeader(responseStatus)
+	}))
+	defer subscriber.Close()
+
+	request := func(method, path string, payload interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+		jsonPayload, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	// the test subscriber listens on loopback
+	service.AllowPrivateWebhooks = true
+	defer func() { service.AllowPrivateWebhooks = false }()
+
+	dispatcher := service.NewOutboxDispatcher(db, service.NewWebhookSink(db))
+	deliverer := service.NewWebhookDeliverer(db)
+	deliverer.Client.Transport.(*http.Transport).TLSClientConfig = subscriber.Client().Transport.(*http.Transport).TLSClientConfig
+
+	var subscriptionID, secret string
+
+	t.Run("Create Subscription", func(t *testing.T) {
+		w, response := request("POST", "/user/api/webhooks", structs.WebhookSubscriptionRequest{
+			URL:        subscriber.URL,
+			EventTypes: []string{models.PaymentCompletedEvent, models.AccountCreditedEvent},
+		})
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		data := response["data"].(map[string]interface{})
+		subscriptionID = data["subscription_id"].(string)
+		secret = data["secret"].(string)
+		assert.True(t, strings.HasPrefix(secret, "whsec_"))
+	})
+
+	t.Run("Unknown Event Type", func(t *testing.T) {
+		w, _ := request("POST", "/user/api/webhooks", structs.WebhookSubscriptionRequest{
+			URL:        subscriber.URL,
+			EventTypes: []string{"AccountClosed"},
+		})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Internal URLs Are Refused", func(t *testing.T) {
+		service.AllowPrivateWebhooks = false
+		defer func() { service.AllowPrivateWebhooks = true }()
+
+		for _, url := range []string{
+			"http://example.com/hooks",
+			subscriber.URL,
+			"https://localhost/hooks",
+			"https://10.0.0.8/hooks",
+			"https://169.254.169.254/latest/meta-data",
+			"https://[::1]/hooks",
+			"https://0.0.0.0/hooks",
+		} {
+			w, _ := request("POST", "/user/api/webhooks", structs.WebhookSubscriptionRequest{
+				URL:        url,
+				EventTypes: []string{models.PaymentCompletedEvent},
+			})
+			assert.Equal(t, http.StatusBadRequest, w.Code, url)
+		}
+	})
+
+	t.Run("List Hides Secret", func(t *testing.T) {
+		w, response := request("GET", "/user/api/webhooks", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		data := response["data"].([]interface{})
+		assert.Len(t, data, 1)
+		assert.NotContains(t, data[0], "secret")
+	})
+
+	t.Run("Payment Delivers Signed Webhooks", func(t *testing.T) {
+		// a top-up completes a payment and credits the user's account
+		_, err := service.TopUpProcess(t.Context(), db, fromAccount.AccountID, decimal.NewFromInt(100), "USD")
+		assert.NoError(t, err)
+
+		_, err = dispatcher.DispatchOnce(t.Context())
+		assert.NoError(t, err)
+
+		delivered, err := deliverer.DeliverOnce(t.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, 2, delivered)
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Len(t, received, 2)
+		for i, r := range received {
+			var envelope service.WebhookEnvelope
+			assert.NoError(t, json.Unmarshal(bodies[i], &envelope))
+			assert.Equal(t, envelope.Type, r.Header.Get(service.WebhookEventHeader))
+
+			// the receiver can recompute the signature from the timestamp and body
+			signature := r.Header.Get(service.WebhookSignatureHeader)
+			unix, err := strconv.ParseInt(strings.TrimPrefix(strings.Split(signature, ",")[0], "t="), 10, 64)
+			assert.NoError(t, err)
+			assert.Equal(t, service.SignWebhook(secret, time.Unix(unix, 0), bodies[i]), signature)
+		}
+	})
+
+	t.Run("Failed Delivery Is Retried And Logged", func(t *testing.T) {
+		mu.Lock()
+		responseStatus = http.StatusInternalServerError
+		mu.Unlock()
+
+		_, err := service.TopUpProcess(t.Context(), db, fromAccount.AccountID, decimal.NewFromInt(10), "USD")
+		assert.NoError(t, err)
+		_, err = dispatcher.DispatchOnce(t.Context())
+		assert.NoError(t, err)
+
+		delivered, err := deliverer.DeliverOnce(t.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, delivered)
+
+		w, response := request("GET", "/user/api/webhooks/"+subscriptionID+"/deliveries", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var failed map[string]interface{}
+		for _, item := range response["data"].([]interface{}) {
+			delivery := item.(map[string]interface{})
+			if delivery["status"] == string(models.DeliveryPending) {
+				failed = delivery
+			}
+		}
+		if assert.NotNil(t, failed) {
+			assert.Equal(t, float64(1), failed["attempts"])
+			assert.Equal(t, float64(http.StatusInternalServerError), failed["response_status"])
+			assert.Len(t, failed["attempt_log"], 1)
+
+			next, err := time.Parse(time.RFC3339Nano, failed["next_attempt_at"].(string))
+			assert.NoError(t, err)
+			assert.True(t, next.After(time.Now()))
+
+			// manual redelivery makes it due right away
+			mu.Lock()
+			responseStatus = http.StatusOK
+			mu.Unlock()
+
+			w, _ = request("POST", "/user/api/webhooks/deliveries/"+failed["delivery_id"].(string)+"/redeliver", nil)
+			assert.Equal(t, http.StatusAccepted, w.Code)
+
+			delivered, err = deliverer.DeliverOnce(t.Context())
+			assert.NoError(t, err)
+			assert.Equal(t, 1, delivered)
+		}
+	})
+
+	t.Run("Deliverer Refuses Internal Addresses", func(t *testing.T) {
+		// the host may resolve elsewhere by the time a delivery is sent, the
+		// address is checked on every new connection
+		service.AllowPrivateWebhooks = false
+		defer func() { service.AllowPrivateWebhooks = true }()
+		deliverer.Client.CloseIdleConnections()
+
+		_, err := service.TopUpProcess(t.Context(), db, fromAccount.AccountID, decimal.NewFromInt(10), "USD")
+		assert.NoError(t, err)
+		_, err = dispatcher.DispatchOnce(t.Context())
+		assert.NoError(t, err)
+
+		mu.Lock()
+		before := len(received)
+		mu.Unlock()
+
+		delivered, err := deliverer.DeliverOnce(t.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, delivered)
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Len(t, received, before)
+	})
+
+	t.Run("Delete Subscription", func(t *testing.T) {
+		w, _ := request("DELETE", "/user/api/webhooks/"+subscriptionID, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w, _ = request("DELETE", "/user/api/webhooks/"+subscriptionID, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,60 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// idleTTL is how long a counter without new failures, lock or pending
+// attempts is kept.
+const idleTTL = 24 * time.Hour
+
+// MemoryStore keeps counters in the process.
+type MemoryStore struct {
+	mu        sync.Mutex
+	counters  map[string]Counter
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: map[string]Counter{}}
+}
+
+func (store *MemoryStore) Get(ctx context.Context, key string) (Counter, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.counters[key], nil
+}
+
+func (store *MemoryStore) Update(ctx context.Context, key string, update func(*Counter)) (Counter, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	counter := store.counters[key]
+	update(&counter)
+	store.counters[key] = counter
+	store.sweep(time.Now())
+	return counter, nil
+}
+
+func (store *MemoryStore) Delete(ctx context.Context, key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.counters, key)
+	return nil
+}
+
+// sweep drops counters idle for idleTTL, at most once a minute. Callers hold
+// the lock.
+func (store *MemoryStore) sweep(now time.Time) {
+	if now.Sub(store.lastSweep) < time.Minute {
+		return
+	}
+	store.lastSweep = now
+	for key, counter := range store.counters {
+		if now.Sub(counter.LastFailure) > idleTTL && now.After(counter.LockedUntil) && now.Sub(counter.PendingSince) > pendingTTL {
+			delete(store.counters, key)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// Package throttle slows down and locks out repeated failed logins. Failures
+// are counted per email and per client IP in a pluggable Store.
+package throttle
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Counter is the failure history of one key. Pending counts the attempts
+// Check let through that have not been reported yet; PendingSince is when the
+// latest of them started.
+type Counter struct {
+	Failures     int
+	FirstFailure time.Time
+	LastFailure  time.Time
+	LockedUntil  time.Time
+	Pending      int
+	PendingSince time.Time
+}
+
+// pendingTTL is how long an attempt counts as pending when it is never
+// reported, such as when the instance checking it dies.
+const pendingTTL = time.Minute
+
+// Store keeps counters. Update must apply the function atomically, so that
+// concurrent failures are all counted; stores shared by several instances
+// give the same guarantee across them.
+type Store interface {
+	Get(ctx context.Context, key string) (Counter, error)
+	Update(ctx context.Context, key string, update func(*Counter)) (Counter, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Policy sets how failures of one kind of key are punished. After each
+// failure the next attempt has to wait BaseDelay, doubled for every earlier
+// failure up to MaxDelay. MaxAttempts failures within Window lock the key for
+// Lockout.
+type Policy struct {
+	MaxAttempts int
+	Window      time.Duration
+	Lockout     time.Duration
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func (policy Policy) delay(failures int) time.Duration {
+	if policy.BaseDelay <= 0 || failures <= 0 {
+		return 0
+	}
+	delay := policy.BaseDelay
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if policy.MaxDelay > 0 && delay >= policy.MaxDelay {
+			return policy.MaxDelay
+		}
+	}
+	return delay
+}
+
+var (
+	// DefaultEmailPolicy protects a single account from password guessing.
+	DefaultEmailPolicy = Policy{MaxAttempts: 5, Window: 15 * time.Minute, Lockout: 15 * time.Minute, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+	// DefaultIPPolicy stops one client from spraying guesses over many
+	// accounts. It has no delay, since many users can share an address.
+	DefaultIPPolicy = Policy{MaxAttempts: 50, Window: 15 * time.Minute, Lockout: 15 * time.Minute}
+)
+
+// Decision tells whether a login may be attempted now. RetryAfter is set
+// when it may not.
+type Decision struct {
+	Allowed    bool
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+type Guard struct {
+	Store Store
+	Email Policy
+	IP    Policy
+	// Now is the clock, time.Now when nil.
+	Now func() time.Time
+}
+
+func NewGuard(store Store, email, ip Policy) *Guard {
+	return &Guard{Store: store, Email: email, IP: ip}
+}
+
+// Check decides whether a login for the email from the ip may go ahead. An
+// allowed attempt is reserved in the same update that decides it, and counts
+// as a failure for the attempts checked after it until it is reported with
+// Fail, Succeed or Release. Concurrent guesses therefore wait their turn
+// instead of all getting through before the first failure is counted.
+func (guard *Guard) Check(ctx context.Context, email, ip string) (Decision, error) {
+	now := guard.now()
+	decision := Decision{Allowed: true}
+	var reserved []string
+	for _, check := range guard.keys(email, ip) {
+		policy := check.policy
+		var wait time.Duration
+		var locked bool
+		_, err := guard.Store.Update(ctx, check.key, func(counter *Counter) {
+			wait, locked = policy.wait(counter, now)
+			if wait <= 0 {
+				counter.Pending++
+				counter.PendingSince = now
+			}
+		})
+		if err != nil {
+			guard.release(ctx, reserved)
+			return Decision{}, err
+		}
+		if wait > 0 {
+			decision.Allowed = false
+			decision.Locked = decision.Locked || locked
+			decision.RetryAfter = max(decision.RetryAfter, wait)
+		} else {
+			reserved = append(reserved, check.key)
+		}
+	}
+	if !decision.Allowed {
+		return decision, guard.release(ctx, reserved)
+	}
+	return decision, nil
+}
+
+// wait is how long the key has to wait before another attempt, counting the
+// pending ones as failures. Callers hold the counter.
+func (policy Policy) wait(counter *Counter, now time.Time) (time.Duration, bool) {
+	if counter.Pending > 0 && now.Sub(counter.PendingSince) > pendingTTL {
+		counter.Pending = 0
+	}
+	if now.Before(counter.LockedUntil) {
+		return counter.LockedUntil.Sub(now), true
+	}
+
+	failures := counter.Failures
+	if failures > 0 && policy.Window > 0 && now.Sub(counter.FirstFailure) > policy.Window {
+		failures = 0
+	}
+	failures += counter.Pending
+	if failures == 0 {
+		return 0, false
+	}
+	if counter.Pending > 0 && policy.MaxAttempts > 0 && failures >= policy.MaxAttempts {
+		// the pending attempts may still lock the key
+		return counter.PendingSince.Add(pendingTTL).Sub(now), false
+	}
+	last := counter.LastFailure
+	if counter.Pending > 0 && counter.PendingSince.After(last) {
+		last = counter.PendingSince
+	}
+	return last.Add(policy.delay(failures)).Sub(now), false
+}
+
+// Fail counts a failed login against the email and the ip.
+func (guard *Guard) Fail(ctx context.Context, email, ip string) error {
+	now := guard.now()
+	for _, check := range guard.keys(email, ip) {
+		policy := check.policy
+		_, err := guard.Store.Update(ctx, check.key, func(counter *Counter) {
+			if counter.Pending > 0 {
+				counter.Pending--
+			}
+			if counter.Failures > 0 && policy.Window > 0 && now.Sub(counter.FirstFailure) > policy.Window {
+				counter.Failures = 0
+			}
+			if counter.Failures == 0 {
+				counter.FirstFailure = now
+			}
+			counter.Failures++
+			counter.LastFailure = now
+			if policy.MaxAttempts > 0 && counter.Failures >= policy.MaxAttempts {
+				counter.LockedUntil = now.Add(policy.Lockout)
+				counter.Failures = 0
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Succeed clears the failures of the email. The ip keeps its count, or a
+// client could wipe it by logging into an account of its own in between; only
+// its pending attempt is released.
+func (guard *Guard) Succeed(ctx context.Context, email, ip string) error {
+	err := guard.Store.Delete(ctx, emailKey(email))
+	if err != nil {
+		return err
+	}
+	if ip == "" {
+		return nil
+	}
+	return guard.release(ctx, []string{ipKey(ip)})
+}
+
+// Release gives up an attempt Check allowed without counting it, for when
+// the password was never compared or the caller does not clear failures on
+// success.
+func (guard *Guard) Release(ctx context.Context, email, ip string) error {
+	var keys []string
+	for _, check := range guard.keys(email, ip) {
+		keys = append(keys, check.key)
+	}
+	return guard.release(ctx, keys)
+}
+
+func (guard *Guard) release(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		_, err := guard.Store.Update(ctx, key, func(counter *Counter) {
+			if counter.Pending > 0 {
+				counter.Pending--
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type keyPolicy struct {
+	key    string
+	policy Policy
+}
+
+func (guard *Guard) keys(email, ip string) []keyPolicy {
+	keys := []keyPolicy{{emailKey(email), guard.Email}}
+	if ip != "" {
+		keys = append(keys, keyPolicy{ipKey(ip), guard.IP})
+	}
+	return keys
+}
+
+func (guard *Guard) now() time.Time {
+	if guard.Now != nil {
+		return guard.Now()
+	}
+	return time.Now()
+}
+
+func emailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Default guards the login endpoint. Counters live in memory, so each
+// instance counts on its own until a shared store is configured.
+var Default = NewGuard(NewMemoryStore(), DefaultEmailPolicy, DefaultIPPolicy)
@@ -1,12 +1,15 @@
 package utils
 
 import (
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/grey/keys"
 )
 
+// TokenExpiration is the lifetime of an access token. Sessions outlive it by
+// exchanging their refresh token for a new one.
 const TokenExpiration = 15 * time.Minute
 
 type Claims struct {
@@ -15,17 +18,59 @@ type Claims struct {
 	jwt.StandardClaims
 }
 
-func GenerateToken(user_id string, email string) (string, error) {
+// AccessToken is a signed session token. JTI identifies it on the revocation
+// list.
+type AccessToken struct {
+	Token     string
+	JTI       string
+	ExpiresAt time.Time
+}
 
-	token_lifespan := time.Now().Add(time.Minute * 30).Unix()
+// MFATokenExpiration is the lifetime of the token that stands between the
+// password check and the second factor at login.
+const MFATokenExpiration = 5 * time.Minute
 
+// IssueAccessToken signs an access token for the user. The sid claim ties it
+// to the session it was issued under so that revoking the session revokes it
+// too; it is empty for tokens issued outside a session.
+func IssueAccessToken(user_id string, email string, sessionID string) (AccessToken, error) {
 	claims := jwt.MapClaims{}
 	claims["authorized"] = true
+	if sessionID != "" {
+		claims["sid"] = sessionID
+	}
+	return issueToken(user_id, email, claims, TokenExpiration)
+}
+
+// IssueMFAToken signs the token a user gets after the password check when
+// two-factor authentication is on. It is only accepted by the endpoint that
+// verifies the code, which exchanges it for a session.
+func IssueMFAToken(user_id string, email string) (AccessToken, error) {
+	claims := jwt.MapClaims{}
+	claims["mfa_pending"] = true
+	return issueToken(user_id, email, claims, MFATokenExpiration)
+}
+
+func issueToken(user_id string, email string, claims jwt.MapClaims, lifetime time.Duration) (AccessToken, error) {
+	access := AccessToken{
+		JTI:       uuid.NewString(),
+		ExpiresAt: time.Now().Add(lifetime),
+	}
+
 	claims["user_id"] = user_id
 	claims["email"] = email
-	claims["exp"] = token_lifespan
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	claims["jti"] = access.JTI
+	claims["exp"] = access.ExpiresAt.Unix()
 
-	return token.SignedString([]byte(os.Getenv("SECRET_JWT")))
+	signed, err := keys.Default.Sign(claims)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	access.Token = signed
+	return access, nil
+}
 
+func GenerateToken(user_id string, email string) (string, error) {
+	access, err := IssueAccessToken(user_id, email, "")
+	return access.Token, err
 }